@@ -7,6 +7,7 @@ import (
 	"net/http"
 	"os"
 	"strconv"
+	"strings"
 	"time"
 
 	"chatty.mtran.io/internal/auth"
@@ -61,6 +62,7 @@ func (app *application) userSignup(w http.ResponseWriter, r *http.Request) {
 	signupForm.CheckField(validator.NotBlank(signupForm.Name), "name", "This field cannot be blank")
 	signupForm.CheckField(validator.NotBlank(signupForm.Email), "email", "This field cannot be blank")
 	signupForm.CheckField(validator.Matches(signupForm.Email, validator.EmailRX), "email", "This field must be a valid email address")
+	signupForm.CheckField(!strings.HasSuffix(strings.ToLower(signupForm.Email), botEmailSuffix), "email", "This email address is reserved for bots")
 	signupForm.CheckField(validator.NotBlank(signupForm.Password), "password", "This field cannot be blank")
 	passLen, _ := strconv.Atoi(os.Getenv("PASSWORD_MIN_LENGTH"))
 	signupForm.CheckField(validator.MinChars(signupForm.Password, passLen), "password", fmt.Sprintf("This field must be at least %d characters long", passLen))
@@ -0,0 +1,212 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"io"
+	"net/http"
+	"net/url"
+	"regexp"
+	"strings"
+
+	"chatty.mtran.io/internal/auth"
+	"chatty.mtran.io/internal/models"
+	"chatty.mtran.io/internal/response"
+	"chatty.mtran.io/internal/validator"
+	"chatty.mtran.io/internal/websocket"
+	"github.com/google/uuid"
+	"github.com/julienschmidt/httprouter"
+)
+
+const (
+	// botEmailSuffix turns the handle of a bot into its email, which is how
+	// bots are added to chats. Humans can't sign up with it.
+	botEmailSuffix = "@bot"
+	// maxBotRequestBytes bounds the body of a request posted by a bot
+	maxBotRequestBytes = 1 << 20
+)
+
+var botHandleRX = regexp.MustCompile(`^[a-z0-9][a-z0-9_-]{1,31}$`)
+
+type botCreateForm struct {
+	Name                string `form:"name"`
+	Handle              string `form:"handle"`
+	CallbackURL         string `form:"callbackUrl"`
+	validator.Validator `form:"-"`
+}
+
+type BotResponse struct {
+	validator.Validator
+	UserInfo *models.UserInfo `json:"userInfo,omitempty"`
+	// Token is only ever shown here, it can't be retrieved later
+	Token          string `json:"token,omitempty"`
+	CallbackSecret string `json:"callbackSecret,omitempty"`
+}
+
+func (app *application) botCreate(w http.ResponseWriter, r *http.Request) {
+	ownerID, err := uuid.Parse(r.Context().Value(auth.UserIDKey).(string))
+	if err != nil {
+		app.clientError(w, http.StatusUnauthorized)
+		return
+	}
+
+	var form botCreateForm
+	err = app.decodePostForm(r, &form)
+	if err != nil {
+		app.writeJSON(w, http.StatusBadRequest, response.APIResponse[any]{
+			Data:    nil,
+			Message: "Invalid form data",
+			Status:  response.StatusError,
+			Error:   response.ErrorBadRequest,
+		})
+		return
+	}
+	form.Handle = strings.ToLower(form.Handle)
+	form.CheckField(validator.NotBlank(form.Name), "name", "This field cannot be blank")
+	form.CheckField(validator.MaxChars(form.Name, 255), "name", "This field cannot be more than 255 characters long")
+	form.CheckField(validator.Matches(form.Handle, botHandleRX), "handle", "This field must be 2 to 32 letters, digits, '-' or '_'")
+	form.CheckField(form.CallbackURL == "" || isCallbackURL(form.CallbackURL), "callbackUrl", "This field must be an http or https URL")
+
+	botResponse := BotResponse{Validator: form.Validator}
+	if !form.Valid() {
+		res := response.APIResponse[BotResponse]{
+			Data:    botResponse,
+			Message: "Validation failed",
+			Status:  response.StatusError,
+		}
+		app.writeJSON(w, http.StatusBadRequest, res)
+		return
+	}
+
+	token, err := auth.GenerateBotToken()
+	if err != nil {
+		app.writeJSONServerError(w, http.StatusInternalServerError, err)
+		return
+	}
+	var callbackURL *string
+	var callbackSecret string
+	if form.CallbackURL != "" {
+		callbackURL = &form.CallbackURL
+		callbackSecret, err = auth.GenerateCallbackSecret()
+		if err != nil {
+			app.writeJSONServerError(w, http.StatusInternalServerError, err)
+			return
+		}
+	}
+
+	bot, err := app.users.InsertBot(ownerID, form.Name, form.Handle+botEmailSuffix, callbackURL, callbackSecret, auth.HashBotToken(token))
+	if err != nil {
+		if errors.Is(err, models.ErrDuplicateEmail) {
+			botResponse.AddFieldError("handle", "Handle is already in use")
+			res := response.APIResponse[BotResponse]{
+				Data:    botResponse,
+				Message: "Duplicate handle",
+				Status:  response.StatusError,
+			}
+			app.writeJSON(w, http.StatusUnprocessableEntity, res)
+		} else {
+			app.errorLog.Printf("Error inserting bot: %v", err)
+			app.writeJSONServerError(w, http.StatusInternalServerError, err)
+		}
+		return
+	}
+
+	if callbackURL != nil {
+		app.callbacks.AddBot(bot.ID)
+	}
+
+	botResponse.UserInfo = &bot.UserInfo
+	botResponse.Token = token
+	botResponse.CallbackSecret = callbackSecret
+	res := response.APIResponse[BotResponse]{
+		Data:    botResponse,
+		Message: "Bot created",
+		Status:  response.StatusSuccess,
+	}
+	app.writeJSON(w, http.StatusCreated, res)
+}
+
+// botRotateToken replaces the token of a bot. The old token can't be used for
+// new connections and requests, and the bot's open connections are closed.
+func (app *application) botRotateToken(w http.ResponseWriter, r *http.Request) {
+	ownerID, err := uuid.Parse(r.Context().Value(auth.UserIDKey).(string))
+	if err != nil {
+		app.clientError(w, http.StatusUnauthorized)
+		return
+	}
+	botID, err := uuid.Parse(httprouter.ParamsFromContext(r.Context()).ByName("id"))
+	if err != nil {
+		app.clientError(w, http.StatusNotFound)
+		return
+	}
+
+	token, err := auth.GenerateBotToken()
+	if err != nil {
+		app.writeJSONServerError(w, http.StatusInternalServerError, err)
+		return
+	}
+	err = app.users.RotateBotToken(botID, ownerID, auth.HashBotToken(token))
+	if err != nil {
+		if errors.Is(err, models.ErrNoRecord) {
+			app.clientError(w, http.StatusNotFound)
+		} else {
+			app.writeJSONServerError(w, http.StatusInternalServerError, err)
+		}
+		return
+	}
+	app.hub.DisconnectUser(botID)
+
+	res := response.APIResponse[BotResponse]{
+		Data:    BotResponse{Token: token},
+		Message: "Bot token rotated",
+		Status:  response.StatusSuccess,
+	}
+	app.writeJSON(w, http.StatusOK, res)
+}
+
+// botRequest lets bots without a WebSocket connection send the same requests
+// as connected clients, e.g. a SendMessageRequest. The response is delivered
+// like any other event: over the bot's connection or to its callback URL.
+func (app *application) botRequest(w http.ResponseWriter, r *http.Request) {
+	botID, err := uuid.Parse(r.Context().Value(auth.UserIDKey).(string))
+	if err != nil {
+		app.clientError(w, http.StatusUnauthorized)
+		return
+	}
+
+	body, err := io.ReadAll(http.MaxBytesReader(w, r.Body, maxBotRequestBytes))
+	if err != nil || !json.Valid(body) {
+		app.writeJSON(w, http.StatusBadRequest, response.APIResponse[any]{
+			Data:    nil,
+			Message: "Invalid request body",
+			Status:  response.StatusError,
+			Error:   response.ErrorBadRequest,
+		})
+		return
+	}
+
+	app.hub.Broadcast <- websocket.HubMessage{
+		Message:  body,
+		SenderID: botID,
+	}
+
+	app.writeJSON(w, http.StatusAccepted, response.APIResponse[any]{
+		Data:    nil,
+		Message: "Request accepted",
+		Status:  response.StatusSuccess,
+	})
+}
+
+// authenticateBot is the auth.BotAuthenticator of the bot routes
+func (app *application) authenticateBot(token string) (string, error) {
+	botID, err := app.users.AuthenticateBotToken(auth.HashBotToken(token))
+	if err != nil {
+		return "", err
+	}
+	return botID.String(), nil
+}
+
+func isCallbackURL(rawURL string) bool {
+	callbackURL, err := url.Parse(rawURL)
+	return err == nil && (callbackURL.Scheme == "http" || callbackURL.Scheme == "https") && callbackURL.Host != ""
+}
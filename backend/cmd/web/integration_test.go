@@ -4,14 +4,19 @@ import (
 	"context"
 	"encoding/json"
 	"fmt"
+	"io"
 	"net/http"
 	"net/http/httptest"
+	"net/url"
 	"strings"
 	"testing"
 	"time"
 
 	"chatty.mtran.io/internal/auth"
+	"chatty.mtran.io/internal/bots"
 	messageprocessor "chatty.mtran.io/internal/message_processor"
+	"chatty.mtran.io/internal/response"
+	"chatty.mtran.io/internal/unfurl"
 	"github.com/google/uuid"
 	gorilla "github.com/gorilla/websocket"
 	"github.com/stretchr/testify/assert"
@@ -179,6 +184,2182 @@ func TestSendMessageSuccess(t *testing.T) {
 	assert.Equal(t, message, responseData.Content)
 }
 
+func TestGetChatHistoryPagination(t *testing.T) {
+	cleanDB(t, db)
+
+	cleanup, server, _ := setupTestAppWithServerAndUsers(t)
+	defer cleanup()
+	defer server.Close()
+
+	accessToken := loginUserAndGetAccessToken(t, server, testUser1Email, testPassword)
+	conn := getWebSocketConnectionWithAccessToken(t, server, accessToken)
+	defer conn.Close()
+
+	time.Sleep(100 * time.Millisecond)
+
+	chatID := createChatSuccess(t, conn)
+
+	contents := []string{"one", "two", "three", "four", "five"}
+	for _, content := range contents {
+		sendMessageSuccess(t, conn, chatID, content)
+	}
+
+	// Page through the history two messages at a time, newest first
+	var received []string
+	cursor := ""
+	for page := 0; page < 3; page++ {
+		request := fmt.Sprintf(`{"type": "%s", "data": {"chatID": "%s", "limit": 2%s}}`,
+			messageprocessor.GET_CHAT_HISTORY_REQUEST, chatID, cursor)
+		writeMessage(t, conn, request)
+		response := readMessage(t, conn)
+
+		assert.Equal(t, messageprocessor.GET_CHAT_HISTORY_RESPONSE, response.Type)
+		assert.Empty(t, response.Error)
+
+		var responseData messageprocessor.GetChatHistoryResponse
+		err := json.Unmarshal(response.Data, &responseData)
+		if err != nil {
+			t.Fatalf("Failed to unmarshal response data: %v", err)
+		}
+		assert.Equal(t, chatID, responseData.ChatID)
+		assert.Equal(t, page < 2, responseData.HasMore)
+
+		for _, message := range responseData.Messages {
+			assert.Equal(t, testUser1Name, message.SenderName)
+			received = append(received, message.Content)
+		}
+		if len(responseData.Messages) > 0 {
+			last := responseData.Messages[len(responseData.Messages)-1]
+			cursor = fmt.Sprintf(`, "cursorCreatedAt": "%s", "cursorMessageID": "%s"`, last.Timestamp, last.MessageID)
+		}
+	}
+
+	assert.Equal(t, []string{"five", "four", "three", "two", "one"}, received)
+}
+
+func TestGetChatHistoryNotMember(t *testing.T) {
+	cleanDB(t, db)
+
+	cleanup, server, _ := setupTestAppWithServerAndUsers(t)
+	defer cleanup()
+	defer server.Close()
+
+	accessToken := loginUserAndGetAccessToken(t, server, testUser1Email, testPassword)
+	conn := getWebSocketConnectionWithAccessToken(t, server, accessToken)
+	defer conn.Close()
+
+	time.Sleep(100 * time.Millisecond)
+
+	request := fmt.Sprintf(`{"type": "%s", "data": {"chatID": "%s", "limit": 10}}`,
+		messageprocessor.GET_CHAT_HISTORY_REQUEST, uuid.New().String())
+	writeMessage(t, conn, request)
+	response := readMessage(t, conn)
+
+	assert.Equal(t, messageprocessor.GET_CHAT_HISTORY_RESPONSE, response.Type)
+	assert.Equal(t, messageprocessor.ErrNotChatMember.Error(), response.Error)
+}
+
+func TestEditMessage(t *testing.T) {
+	cleanDB(t, db)
+
+	cleanup, server, _ := setupTestAppWithServerAndUsers(t)
+	defer cleanup()
+	defer server.Close()
+
+	conn1 := getWebSocketConnectionWithAccessToken(t, server, loginUserAndGetAccessToken(t, server, testUser1Email, testPassword))
+	defer conn1.Close()
+	conn2 := getWebSocketConnectionWithAccessToken(t, server, loginUserAndGetAccessToken(t, server, testUser2Email, testPassword))
+	defer conn2.Close()
+
+	time.Sleep(100 * time.Millisecond)
+
+	chatID := createChatSuccess(t, conn1)
+	readMessage(t, conn2) // CreateChatResponse
+	sent := sendMessageSuccess(t, conn1, chatID, "helo")
+	readMessage(t, conn2) // SendMessageResponse
+
+	// Only the sender may edit the message
+	editRequest := `{"type": "%s", "data": {"messageId": "%s", "content": "%s"}}`
+	writeMessage(t, conn2, fmt.Sprintf(editRequest, messageprocessor.EDIT_MESSAGE_REQUEST, sent.MessageID, "hijacked"))
+	response := readMessage(t, conn2)
+	assert.Equal(t, messageprocessor.EDIT_MESSAGE_RESPONSE, response.Type)
+	assert.Equal(t, messageprocessor.ErrNotMessageSender.Error(), response.Error)
+
+	writeMessage(t, conn1, fmt.Sprintf(editRequest, messageprocessor.EDIT_MESSAGE_REQUEST, sent.MessageID, "hello"))
+	for _, conn := range []*gorilla.Conn{conn1, conn2} {
+		response := readMessage(t, conn)
+		assert.Equal(t, messageprocessor.EDIT_MESSAGE_RESPONSE, response.Type)
+		assert.Empty(t, response.Error)
+
+		var responseData messageprocessor.EditMessageResponse
+		err := json.Unmarshal(response.Data, &responseData)
+		if err != nil {
+			t.Fatalf("Failed to unmarshal response data: %v", err)
+		}
+		assert.Equal(t, chatID, responseData.ChatID)
+		assert.Equal(t, sent.MessageID, responseData.MessageID)
+		assert.Equal(t, "hello", responseData.Content)
+		assert.NotEmpty(t, responseData.EditedAt)
+	}
+
+	var editCount int
+	err := db.QueryRow(`SELECT COUNT(*) FROM message_edits WHERE message_id = $1`, sent.MessageID).Scan(&editCount)
+	if err != nil {
+		t.Fatalf("Failed to count message edits: %v", err)
+	}
+	assert.Equal(t, 1, editCount)
+}
+
+func TestDeleteMessage(t *testing.T) {
+	cleanDB(t, db)
+
+	cleanup, server, _ := setupTestAppWithServerAndUsers(t)
+	defer cleanup()
+	defer server.Close()
+
+	conn1 := getWebSocketConnectionWithAccessToken(t, server, loginUserAndGetAccessToken(t, server, testUser1Email, testPassword))
+	defer conn1.Close()
+	conn2 := getWebSocketConnectionWithAccessToken(t, server, loginUserAndGetAccessToken(t, server, testUser2Email, testPassword))
+	defer conn2.Close()
+
+	time.Sleep(100 * time.Millisecond)
+
+	chatID := createChatSuccess(t, conn1)
+	readMessage(t, conn2) // CreateChatResponse
+	hidden := sendMessageSuccess(t, conn1, chatID, "hide me")
+	readMessage(t, conn2) // SendMessageResponse
+	deleted := sendMessageSuccess(t, conn1, chatID, "delete me")
+	readMessage(t, conn2) // SendMessageResponse
+
+	deleteRequest := `{"type": "%s", "data": {"messageId": "%s", "scope": "%s"}}`
+
+	// Delete for me only reaches the requesting user
+	writeMessage(t, conn2, fmt.Sprintf(deleteRequest, messageprocessor.DELETE_MESSAGE_REQUEST, hidden.MessageID, messageprocessor.DELETE_SCOPE_ME))
+	response := readMessage(t, conn2)
+	assert.Equal(t, messageprocessor.DELETE_MESSAGE_RESPONSE, response.Type)
+	assert.Empty(t, response.Error)
+
+	// Only the sender can delete for everyone
+	writeMessage(t, conn2, fmt.Sprintf(deleteRequest, messageprocessor.DELETE_MESSAGE_REQUEST, deleted.MessageID, messageprocessor.DELETE_SCOPE_EVERYONE))
+	response = readMessage(t, conn2)
+	assert.Equal(t, messageprocessor.ErrNotMessageSender.Error(), response.Error)
+
+	writeMessage(t, conn1, fmt.Sprintf(deleteRequest, messageprocessor.DELETE_MESSAGE_REQUEST, deleted.MessageID, messageprocessor.DELETE_SCOPE_EVERYONE))
+	for _, conn := range []*gorilla.Conn{conn1, conn2} {
+		response := readMessage(t, conn)
+		assert.Equal(t, messageprocessor.DELETE_MESSAGE_RESPONSE, response.Type)
+		assert.Empty(t, response.Error)
+
+		var responseData messageprocessor.DeleteMessageResponse
+		err := json.Unmarshal(response.Data, &responseData)
+		if err != nil {
+			t.Fatalf("Failed to unmarshal response data: %v", err)
+		}
+		assert.Equal(t, deleted.MessageID, responseData.MessageID)
+		assert.Equal(t, messageprocessor.DELETE_SCOPE_EVERYONE, responseData.Scope)
+	}
+
+	// The sender still sees the hidden message, plus the tombstone
+	history := getChatHistorySuccess(t, conn1, chatID)
+	assert.Len(t, history.Messages, 2)
+	assert.Equal(t, deleted.MessageID, history.Messages[0].MessageID)
+	assert.Empty(t, history.Messages[0].Content)
+	assert.NotNil(t, history.Messages[0].DeletedAt)
+	assert.Equal(t, hidden.MessageID, history.Messages[1].MessageID)
+
+	// The other member only sees the tombstone
+	history = getChatHistorySuccess(t, conn2, chatID)
+	assert.Len(t, history.Messages, 1)
+	assert.Equal(t, deleted.MessageID, history.Messages[0].MessageID)
+	assert.Empty(t, history.Messages[0].Content)
+}
+
+func TestThreadReplies(t *testing.T) {
+	cleanDB(t, db)
+
+	cleanup, server, _ := setupTestAppWithServerAndUsers(t)
+	defer cleanup()
+	defer server.Close()
+
+	accessToken := loginUserAndGetAccessToken(t, server, testUser1Email, testPassword)
+	conn := getWebSocketConnectionWithAccessToken(t, server, accessToken)
+	defer conn.Close()
+
+	time.Sleep(100 * time.Millisecond)
+
+	chatID := createChatSuccess(t, conn)
+	root := sendMessageSuccess(t, conn, chatID, "root")
+
+	replyRequest := `{"type": "%s", "data": {"chatId": "%s", "content": "%s", "parentMessageId": "%s"}}`
+	var firstReplyID, secondReplyID string
+	for i, content := range []string{"reply 1", "reply 2"} {
+		parentID := root.MessageID
+		if i > 0 {
+			// replying to a reply continues the root's thread
+			parentID = firstReplyID
+		}
+		writeMessage(t, conn, fmt.Sprintf(replyRequest, messageprocessor.SEND_MESSAGE_REQUEST, chatID, content, parentID))
+		response := readMessage(t, conn)
+		assert.Empty(t, response.Error)
+
+		var responseData messageprocessor.SendMessageResponse
+		err := json.Unmarshal(response.Data, &responseData)
+		if err != nil {
+			t.Fatalf("Failed to unmarshal response data: %v", err)
+		}
+		if assert.NotNil(t, responseData.ParentMessageID) {
+			assert.Equal(t, root.MessageID, *responseData.ParentMessageID)
+		}
+		if i == 0 {
+			firstReplyID = responseData.MessageID
+		} else {
+			secondReplyID = responseData.MessageID
+		}
+	}
+
+	// Replies stay out of the main history, the root carries the statistics
+	history := getChatHistorySuccess(t, conn, chatID)
+	if assert.Len(t, history.Messages, 1) {
+		assert.Equal(t, root.MessageID, history.Messages[0].MessageID)
+		assert.Equal(t, 2, history.Messages[0].ReplyCount)
+		assert.NotNil(t, history.Messages[0].LastReplyAt)
+	}
+
+	writeMessage(t, conn, fmt.Sprintf(`{"type": "%s", "data": {"parentMessageId": "%s", "limit": 10}}`,
+		messageprocessor.GET_THREAD_REQUEST, root.MessageID))
+	response := readMessage(t, conn)
+	assert.Equal(t, messageprocessor.GET_THREAD_RESPONSE, response.Type)
+	assert.Empty(t, response.Error)
+
+	var thread messageprocessor.GetThreadResponse
+	err := json.Unmarshal(response.Data, &thread)
+	if err != nil {
+		t.Fatalf("Failed to unmarshal response data: %v", err)
+	}
+	assert.Equal(t, root.MessageID, thread.ParentMessageID)
+	assert.False(t, thread.HasMore)
+	if assert.Len(t, thread.Messages, 2) {
+		assert.Equal(t, "reply 2", thread.Messages[0].Content)
+		assert.Equal(t, "reply 1", thread.Messages[1].Content)
+	}
+
+	// Deleted replies no longer count, whether deleted for everyone or removed
+	writeMessage(t, conn, fmt.Sprintf(`{"type": "%s", "data": {"messageId": "%s", "scope": "%s"}}`,
+		messageprocessor.DELETE_MESSAGE_REQUEST, secondReplyID, messageprocessor.DELETE_SCOPE_EVERYONE))
+	response = readMessage(t, conn)
+	assert.Equal(t, messageprocessor.DELETE_MESSAGE_RESPONSE, response.Type)
+	assert.Empty(t, response.Error)
+	history = getChatHistorySuccess(t, conn, chatID)
+	if assert.Len(t, history.Messages, 1) {
+		assert.Equal(t, 1, history.Messages[0].ReplyCount)
+		assert.NotNil(t, history.Messages[0].LastReplyAt)
+	}
+
+	_, err = db.Exec(`DELETE FROM messages WHERE id = $1`, firstReplyID)
+	if err != nil {
+		t.Fatalf("Failed to delete reply: %v", err)
+	}
+	history = getChatHistorySuccess(t, conn, chatID)
+	if assert.Len(t, history.Messages, 1) {
+		assert.Equal(t, 0, history.Messages[0].ReplyCount)
+		assert.Nil(t, history.Messages[0].LastReplyAt)
+	}
+}
+
+func TestReactions(t *testing.T) {
+	cleanDB(t, db)
+
+	cleanup, server, _ := setupTestAppWithServerAndUsers(t)
+	defer cleanup()
+	defer server.Close()
+
+	conn1 := getWebSocketConnectionWithAccessToken(t, server, loginUserAndGetAccessToken(t, server, testUser1Email, testPassword))
+	defer conn1.Close()
+	conn2 := getWebSocketConnectionWithAccessToken(t, server, loginUserAndGetAccessToken(t, server, testUser2Email, testPassword))
+	defer conn2.Close()
+
+	time.Sleep(100 * time.Millisecond)
+
+	chatID := createChatSuccess(t, conn1)
+	readMessage(t, conn2) // CreateChatResponse
+	sent := sendMessageSuccess(t, conn1, chatID, "ship it?")
+	readMessage(t, conn2) // SendMessageResponse
+
+	reactionRequest := `{"type": "%s", "data": {"messageId": "%s", "emoji": "%s"}}`
+	expectReaction := func(responseType string, count int) {
+		t.Helper()
+		for _, conn := range []*gorilla.Conn{conn1, conn2} {
+			response := readMessage(t, conn)
+			assert.Equal(t, responseType, response.Type)
+			assert.Empty(t, response.Error)
+
+			var responseData messageprocessor.ReactionResponse
+			err := json.Unmarshal(response.Data, &responseData)
+			if err != nil {
+				t.Fatalf("Failed to unmarshal response data: %v", err)
+			}
+			assert.Equal(t, sent.MessageID, responseData.MessageID)
+			assert.Equal(t, "👍", responseData.Emoji)
+			assert.Equal(t, count, responseData.Count)
+		}
+	}
+
+	writeMessage(t, conn1, fmt.Sprintf(reactionRequest, messageprocessor.ADD_REACTION_REQUEST, sent.MessageID, "👍"))
+	expectReaction(messageprocessor.ADD_REACTION_RESPONSE, 1)
+	writeMessage(t, conn2, fmt.Sprintf(reactionRequest, messageprocessor.ADD_REACTION_REQUEST, sent.MessageID, "👍"))
+	expectReaction(messageprocessor.ADD_REACTION_RESPONSE, 2)
+
+	history := getChatHistorySuccess(t, conn2, chatID)
+	if assert.Len(t, history.Messages, 1) && assert.Len(t, history.Messages[0].Reactions, 1) {
+		assert.Equal(t, "👍", history.Messages[0].Reactions[0].Emoji)
+		assert.Equal(t, 2, history.Messages[0].Reactions[0].Count)
+		assert.True(t, history.Messages[0].Reactions[0].ReactedByMe)
+	}
+
+	writeMessage(t, conn1, fmt.Sprintf(reactionRequest, messageprocessor.REMOVE_REACTION_REQUEST, sent.MessageID, "👍"))
+	expectReaction(messageprocessor.REMOVE_REACTION_RESPONSE, 1)
+
+	history = getChatHistorySuccess(t, conn1, chatID)
+	if assert.Len(t, history.Messages, 1) && assert.Len(t, history.Messages[0].Reactions, 1) {
+		assert.Equal(t, 1, history.Messages[0].Reactions[0].Count)
+		assert.False(t, history.Messages[0].Reactions[0].ReactedByMe)
+	}
+}
+
+func TestMarkReadAndUnreadCounts(t *testing.T) {
+	cleanDB(t, db)
+
+	cleanup, server, _ := setupTestAppWithServerAndUsers(t)
+	defer cleanup()
+	defer server.Close()
+
+	conn1 := getWebSocketConnectionWithAccessToken(t, server, loginUserAndGetAccessToken(t, server, testUser1Email, testPassword))
+	defer conn1.Close()
+	conn2 := getWebSocketConnectionWithAccessToken(t, server, loginUserAndGetAccessToken(t, server, testUser2Email, testPassword))
+	defer conn2.Close()
+
+	time.Sleep(100 * time.Millisecond)
+
+	chatID := createChatSuccess(t, conn1)
+	readMessage(t, conn2) // CreateChatResponse
+	var sent []messageprocessor.SendMessageResponse
+	for _, content := range []string{"one", "two", "three"} {
+		sent = append(sent, sendMessageSuccess(t, conn1, chatID, content))
+		readMessage(t, conn2) // SendMessageResponse
+	}
+
+	getChats := func(conn *gorilla.Conn) messageprocessor.ChatInfo {
+		t.Helper()
+		writeMessage(t, conn, fmt.Sprintf(`{"type": "%s", "data": {"limit": 10}}`, messageprocessor.GET_CHATS_REQUEST))
+		response := readMessage(t, conn)
+		assert.Equal(t, messageprocessor.GET_CHATS_RESPONSE, response.Type)
+
+		var responseData messageprocessor.GetChatsResponse
+		err := json.Unmarshal(response.Data, &responseData)
+		if err != nil {
+			t.Fatalf("Failed to unmarshal response data: %v", err)
+		}
+		if len(responseData.Chats) != 1 {
+			t.Fatalf("Expected 1 chat, got %d", len(responseData.Chats))
+		}
+		return responseData.Chats[0]
+	}
+
+	assert.Equal(t, 3, getChats(conn2).UnreadCount)
+	assert.Equal(t, 0, getChats(conn1).UnreadCount)
+
+	markReadRequest := `{"type": "%s", "data": {"chatId": "%s", "messageId": "%s"}}`
+	writeMessage(t, conn2, fmt.Sprintf(markReadRequest, messageprocessor.MARK_READ_REQUEST, chatID, sent[1].MessageID))
+	for _, conn := range []*gorilla.Conn{conn1, conn2} {
+		response := readMessage(t, conn)
+		assert.Equal(t, messageprocessor.MARK_READ_RESPONSE, response.Type)
+		assert.Empty(t, response.Error)
+
+		var responseData messageprocessor.MarkReadResponse
+		err := json.Unmarshal(response.Data, &responseData)
+		if err != nil {
+			t.Fatalf("Failed to unmarshal response data: %v", err)
+		}
+		if assert.NotNil(t, responseData.LastReadMessageID) {
+			assert.Equal(t, sent[1].MessageID, *responseData.LastReadMessageID)
+		}
+	}
+
+	// Marking an older message doesn't move the marker back
+	writeMessage(t, conn2, fmt.Sprintf(markReadRequest, messageprocessor.MARK_READ_REQUEST, chatID, sent[0].MessageID))
+	response := readMessage(t, conn2)
+	var responseData messageprocessor.MarkReadResponse
+	err := json.Unmarshal(response.Data, &responseData)
+	if err != nil {
+		t.Fatalf("Failed to unmarshal response data: %v", err)
+	}
+	if assert.NotNil(t, responseData.LastReadMessageID) {
+		assert.Equal(t, sent[1].MessageID, *responseData.LastReadMessageID)
+	}
+
+	assert.Equal(t, 1, getChats(conn2).UnreadCount)
+
+	chat := getChats(conn1)
+	if assert.Len(t, chat.ReadMarkers, 1) && assert.NotNil(t, chat.ReadMarkers[0].LastReadMessageID) {
+		assert.Equal(t, sent[1].MessageID, *chat.ReadMarkers[0].LastReadMessageID)
+	}
+}
+
+func TestTypingIndicators(t *testing.T) {
+	cleanDB(t, db)
+
+	cleanup, server, _ := setupTestAppWithServerAndUsers(t)
+	defer cleanup()
+	defer server.Close()
+
+	conn1 := getWebSocketConnectionWithAccessToken(t, server, loginUserAndGetAccessToken(t, server, testUser1Email, testPassword))
+	defer conn1.Close()
+	conn2 := getWebSocketConnectionWithAccessToken(t, server, loginUserAndGetAccessToken(t, server, testUser2Email, testPassword))
+	defer conn2.Close()
+
+	time.Sleep(100 * time.Millisecond)
+
+	chatID := createChatSuccess(t, conn1)
+	readMessage(t, conn2) // CreateChatResponse
+
+	typingRequest := `{"type": "%s", "data": {"chatId": "%s"}}`
+	expectTyping := func(typing bool) {
+		t.Helper()
+		response := readMessage(t, conn2)
+		assert.Equal(t, messageprocessor.TYPING_EVENT, response.Type)
+
+		var responseData messageprocessor.TypingEvent
+		err := json.Unmarshal(response.Data, &responseData)
+		if err != nil {
+			t.Fatalf("Failed to unmarshal response data: %v", err)
+		}
+		assert.Equal(t, chatID, responseData.ChatID)
+		assert.Equal(t, typing, responseData.Typing)
+	}
+
+	writeMessage(t, conn1, fmt.Sprintf(typingRequest, messageprocessor.TYPING_START_REQUEST, chatID))
+	expectTyping(true)
+	writeMessage(t, conn1, fmt.Sprintf(typingRequest, messageprocessor.TYPING_STOP_REQUEST, chatID))
+	expectTyping(false)
+
+	// Sending a message stops the indicator before the message arrives
+	writeMessage(t, conn1, fmt.Sprintf(typingRequest, messageprocessor.TYPING_START_REQUEST, chatID))
+	expectTyping(true)
+	sendMessageSuccess(t, conn1, chatID, "done typing")
+	expectTyping(false)
+	response := readMessage(t, conn2)
+	assert.Equal(t, messageprocessor.SEND_MESSAGE_RESPONSE, response.Type)
+}
+
+func TestSendMessageIdempotentRetry(t *testing.T) {
+	cleanDB(t, db)
+
+	cleanup, server, _ := setupTestAppWithServerAndUsers(t)
+	defer cleanup()
+	defer server.Close()
+
+	conn1 := getWebSocketConnectionWithAccessToken(t, server, loginUserAndGetAccessToken(t, server, testUser1Email, testPassword))
+	defer conn1.Close()
+	conn2 := getWebSocketConnectionWithAccessToken(t, server, loginUserAndGetAccessToken(t, server, testUser2Email, testPassword))
+	defer conn2.Close()
+
+	time.Sleep(100 * time.Millisecond)
+
+	chatID := createChatSuccess(t, conn1)
+	readMessage(t, conn2) // CreateChatResponse
+
+	request := fmt.Sprintf(`{"type": "%s", "data": {"chatId": "%s", "content": "only once", "clientMessageId": "retry-1"}}`,
+		messageprocessor.SEND_MESSAGE_REQUEST, chatID)
+
+	var messageIDs []string
+	for i := 0; i < 2; i++ {
+		writeMessage(t, conn1, request)
+		response := readMessage(t, conn1)
+		assert.Equal(t, messageprocessor.SEND_MESSAGE_RESPONSE, response.Type)
+		assert.Empty(t, response.Error)
+
+		var responseData messageprocessor.SendMessageResponse
+		err := json.Unmarshal(response.Data, &responseData)
+		if err != nil {
+			t.Fatalf("Failed to unmarshal response data: %v", err)
+		}
+		if assert.NotNil(t, responseData.ClientMessageID) {
+			assert.Equal(t, "retry-1", *responseData.ClientMessageID)
+		}
+		messageIDs = append(messageIDs, responseData.MessageID)
+	}
+	assert.Equal(t, messageIDs[0], messageIDs[1])
+
+	// The other member only receives the message once
+	response := readMessage(t, conn2)
+	assert.Equal(t, messageprocessor.SEND_MESSAGE_RESPONSE, response.Type)
+	conn2.SetReadDeadline(time.Now().Add(300 * time.Millisecond))
+	_, _, err := conn2.ReadMessage()
+	assert.Error(t, err)
+
+	var count int
+	err = db.QueryRow(`SELECT COUNT(*) FROM messages WHERE chat_id = $1`, chatID).Scan(&count)
+	if err != nil {
+		t.Fatalf("Failed to count messages: %v", err)
+	}
+	assert.Equal(t, 1, count)
+}
+
+func TestRequestIDCorrelation(t *testing.T) {
+	cleanDB(t, db)
+
+	cleanup, server, _ := setupTestAppWithServerAndUsers(t)
+	defer cleanup()
+	defer server.Close()
+
+	conn1 := getWebSocketConnectionWithAccessToken(t, server, loginUserAndGetAccessToken(t, server, testUser1Email, testPassword))
+	defer conn1.Close()
+	conn2 := getWebSocketConnectionWithAccessToken(t, server, loginUserAndGetAccessToken(t, server, testUser2Email, testPassword))
+	defer conn2.Close()
+
+	time.Sleep(100 * time.Millisecond)
+
+	createChatRequest := `{"type": "%s", "requestId": "%s", "data": {"name": "%s", "participantEmails": ["%s", "%s"]}}`
+	writeMessage(t, conn1, fmt.Sprintf(createChatRequest, messageprocessor.CREATE_CHAT_REQUEST, "req-a", "Chat A", testUser1Email, testUser2Email))
+	writeMessage(t, conn1, fmt.Sprintf(createChatRequest, messageprocessor.CREATE_CHAT_REQUEST, "req-b", "Chat B", testUser1Email, testUser2Email))
+
+	// Each reply carries the id of the request it answers
+	for i := 0; i < 2; i++ {
+		response := readMessage(t, conn1)
+		assert.Equal(t, messageprocessor.CREATE_CHAT_RESPONSE, response.Type)
+		assert.False(t, response.Unsolicited)
+
+		var responseData messageprocessor.CreateChatResponse
+		err := json.Unmarshal(response.Data, &responseData)
+		if err != nil {
+			t.Fatalf("Failed to unmarshal response data: %v", err)
+		}
+		switch response.RequestID {
+		case "req-a":
+			assert.Equal(t, "Chat A", responseData.Name)
+		case "req-b":
+			assert.Equal(t, "Chat B", responseData.Name)
+		default:
+			t.Errorf("Unexpected request id %q", response.RequestID)
+		}
+	}
+
+	// The other member gets the same chats as unsolicited events
+	for i := 0; i < 2; i++ {
+		response := readMessage(t, conn2)
+		assert.Equal(t, messageprocessor.CREATE_CHAT_RESPONSE, response.Type)
+		assert.True(t, response.Unsolicited)
+		assert.Empty(t, response.RequestID)
+	}
+
+	// Errors echo the request id too
+	writeMessage(t, conn1, `{"type": "unknown_request_type", "requestId": "req-c", "data": {}}`)
+	response := readMessage(t, conn1)
+	assert.Equal(t, messageprocessor.ErrUnknownRequestType.Error(), response.Error)
+	assert.Equal(t, "req-c", response.RequestID)
+}
+
+func TestPinnedMessages(t *testing.T) {
+	cleanDB(t, db)
+
+	cleanup, server, _ := setupTestAppWithServerAndUsers(t)
+	defer cleanup()
+	defer server.Close()
+
+	conn1 := getWebSocketConnectionWithAccessToken(t, server, loginUserAndGetAccessToken(t, server, testUser1Email, testPassword))
+	defer conn1.Close()
+	conn2 := getWebSocketConnectionWithAccessToken(t, server, loginUserAndGetAccessToken(t, server, testUser2Email, testPassword))
+	defer conn2.Close()
+
+	time.Sleep(100 * time.Millisecond)
+
+	chatID := createChatSuccess(t, conn1)
+	readMessage(t, conn2) // CreateChatResponse
+	sent := sendMessageSuccess(t, conn1, chatID, "meeting notes")
+	readMessage(t, conn2) // SendMessageResponse
+
+	pinRequest := `{"type": "%s", "data": {"messageId": "%s"}}`
+	writeMessage(t, conn1, fmt.Sprintf(pinRequest, messageprocessor.PIN_MESSAGE_REQUEST, sent.MessageID))
+	for _, conn := range []*gorilla.Conn{conn1, conn2} {
+		response := readMessage(t, conn)
+		assert.Equal(t, messageprocessor.PIN_MESSAGE_RESPONSE, response.Type)
+		assert.Empty(t, response.Error)
+
+		var responseData messageprocessor.PinMessageResponse
+		err := json.Unmarshal(response.Data, &responseData)
+		if err != nil {
+			t.Fatalf("Failed to unmarshal response data: %v", err)
+		}
+		assert.Equal(t, chatID, responseData.ChatID)
+		assert.Equal(t, sent.MessageID, responseData.MessageID)
+		assert.Equal(t, sent.SenderID, responseData.PinnedBy)
+	}
+
+	getPinnedRequest := fmt.Sprintf(`{"type": "%s", "data": {"chatId": "%s"}}`, messageprocessor.GET_PINNED_MESSAGES_REQUEST, chatID)
+	writeMessage(t, conn1, getPinnedRequest)
+	response := readMessage(t, conn1)
+	assert.Equal(t, messageprocessor.GET_PINNED_MESSAGES_RESPONSE, response.Type)
+	assert.Empty(t, response.Error)
+	var pinned messageprocessor.GetPinnedMessagesResponse
+	err := json.Unmarshal(response.Data, &pinned)
+	if err != nil {
+		t.Fatalf("Failed to unmarshal response data: %v", err)
+	}
+	if assert.Len(t, pinned.Pins, 1) {
+		assert.Equal(t, sent.MessageID, pinned.Pins[0].Message.MessageID)
+		assert.Equal(t, "meeting notes", pinned.Pins[0].Message.Content)
+		assert.Equal(t, sent.SenderID, pinned.Pins[0].PinnedBy)
+	}
+
+	writeMessage(t, conn2, fmt.Sprintf(pinRequest, messageprocessor.UNPIN_MESSAGE_REQUEST, sent.MessageID))
+	for _, conn := range []*gorilla.Conn{conn1, conn2} {
+		response := readMessage(t, conn)
+		assert.Equal(t, messageprocessor.UNPIN_MESSAGE_RESPONSE, response.Type)
+		assert.Empty(t, response.Error)
+	}
+
+	writeMessage(t, conn1, fmt.Sprintf(pinRequest, messageprocessor.UNPIN_MESSAGE_REQUEST, sent.MessageID))
+	response = readMessage(t, conn1)
+	assert.Equal(t, messageprocessor.UNPIN_MESSAGE_RESPONSE, response.Type)
+	assert.Equal(t, messageprocessor.ErrMessageNotPinned.Error(), response.Error)
+
+	writeMessage(t, conn1, getPinnedRequest)
+	response = readMessage(t, conn1)
+	err = json.Unmarshal(response.Data, &pinned)
+	if err != nil {
+		t.Fatalf("Failed to unmarshal response data: %v", err)
+	}
+	assert.Empty(t, pinned.Pins)
+}
+
+func TestMentions(t *testing.T) {
+	cleanDB(t, db)
+
+	cleanup, server, _ := setupTestAppWithServerAndUsers(t)
+	defer cleanup()
+	defer server.Close()
+
+	conn1 := getWebSocketConnectionWithAccessToken(t, server, loginUserAndGetAccessToken(t, server, testUser1Email, testPassword))
+	defer conn1.Close()
+	conn2 := getWebSocketConnectionWithAccessToken(t, server, loginUserAndGetAccessToken(t, server, testUser2Email, testPassword))
+	defer conn2.Close()
+
+	time.Sleep(100 * time.Millisecond)
+
+	chatID := createChatSuccess(t, conn1)
+	readMessage(t, conn2) // CreateChatResponse
+
+	sendMessageSuccess(t, conn1, chatID, "no mentions here, mail test2@example.com")
+	readMessage(t, conn2) // SendMessageResponse
+	for _, content := range []string{"hey @test2, take a look", "ping @" + testUser2Email + "."} {
+		sent := sendMessageSuccess(t, conn1, chatID, content)
+		readMessage(t, conn2) // SendMessageResponse
+
+		response := readMessage(t, conn2)
+		assert.Equal(t, messageprocessor.MENTION_EVENT, response.Type)
+		assert.Empty(t, response.Error)
+		assert.True(t, response.Unsolicited)
+
+		var event messageprocessor.MentionEvent
+		err := json.Unmarshal(response.Data, &event)
+		if err != nil {
+			t.Fatalf("Failed to unmarshal response data: %v", err)
+		}
+		assert.Equal(t, chatID, event.ChatID)
+		assert.Equal(t, sent.MessageID, event.Message.MessageID)
+		assert.Equal(t, testUser1Name, event.Message.SenderName)
+	}
+
+	// the sender isn't notified about their own messages
+	sendMessageSuccess(t, conn1, chatID, "note to @test1")
+	readMessage(t, conn2) // SendMessageResponse
+
+	mentionsRequest := `{"type": "%s", "data": {"limit": %d}}`
+	writeMessage(t, conn2, fmt.Sprintf(mentionsRequest, messageprocessor.GET_MENTIONS_REQUEST, 1))
+	response := readMessage(t, conn2)
+	assert.Equal(t, messageprocessor.GET_MENTIONS_RESPONSE, response.Type)
+	assert.Empty(t, response.Error)
+	var mentions messageprocessor.GetMentionsResponse
+	err := json.Unmarshal(response.Data, &mentions)
+	if err != nil {
+		t.Fatalf("Failed to unmarshal response data: %v", err)
+	}
+	assert.True(t, mentions.HasMore)
+	if assert.Len(t, mentions.Mentions, 1) {
+		assert.Equal(t, chatID, mentions.Mentions[0].ChatID)
+		assert.Equal(t, "ping @"+testUser2Email+".", mentions.Mentions[0].Message.Content)
+	}
+
+	writeMessage(t, conn1, fmt.Sprintf(mentionsRequest, messageprocessor.GET_MENTIONS_REQUEST, 10))
+	response = readMessage(t, conn1)
+	err = json.Unmarshal(response.Data, &mentions)
+	if err != nil {
+		t.Fatalf("Failed to unmarshal response data: %v", err)
+	}
+	assert.Empty(t, mentions.Mentions)
+	assert.False(t, mentions.HasMore)
+}
+
+func TestMessageContentTypes(t *testing.T) {
+	cleanDB(t, db)
+
+	cleanup, server, _ := setupTestAppWithServerAndUsers(t)
+	defer cleanup()
+	defer server.Close()
+
+	conn1 := getWebSocketConnectionWithAccessToken(t, server, loginUserAndGetAccessToken(t, server, testUser1Email, testPassword))
+	defer conn1.Close()
+	conn2 := getWebSocketConnectionWithAccessToken(t, server, loginUserAndGetAccessToken(t, server, testUser2Email, testPassword))
+	defer conn2.Close()
+
+	time.Sleep(100 * time.Millisecond)
+
+	chatID := createChatSuccess(t, conn1)
+	readMessage(t, conn2) // CreateChatResponse
+
+	sendMessage := func(contentType, content string) messageprocessor.Response {
+		t.Helper()
+		data, err := json.Marshal(messageprocessor.SendMessageRequest{
+			ChatID:      chatID,
+			Content:     content,
+			ContentType: contentType,
+		})
+		if err != nil {
+			t.Fatalf("Failed to marshal request data: %v", err)
+		}
+		writeMessage(t, conn1, fmt.Sprintf(`{"type": "%s", "data": %s}`, messageprocessor.SEND_MESSAGE_REQUEST, data))
+		return readMessage(t, conn1)
+	}
+
+	tests := []struct {
+		contentType string
+		content     string
+		stored      string
+	}{
+		{"", "<b>plain</b>", "<b>plain</b>"},
+		{
+			messageprocessor.CONTENT_TYPE_MARKDOWN,
+			"**hi** <b class=\"x\">there</b><script>alert(1)</script> [a](javascript:alert) `<i>`",
+			"**hi** <b>there</b>&lt;script>alert(1)&lt;/script> [a]() `<i>`",
+		},
+		{
+			messageprocessor.CONTENT_TYPE_MARKDOWN,
+			"<img src=\"`x`\" onerror=alert(1)>\n```foo`bar\n<img src=x onerror=alert(1)>",
+			"&lt;img src=\"`x`\" onerror=alert(1)>\n```foo`bar\n&lt;img src=x onerror=alert(1)>",
+		},
+		{
+			messageprocessor.CONTENT_TYPE_CODE,
+			`{ "language": "go", "code": "fmt.Println(1)" }`,
+			`{"language":"go","code":"fmt.Println(1)"}`,
+		},
+	}
+	for _, test := range tests {
+		response := sendMessage(test.contentType, test.content)
+		readMessage(t, conn2) // SendMessageResponse
+		assert.Empty(t, response.Error)
+
+		var responseData messageprocessor.SendMessageResponse
+		err := json.Unmarshal(response.Data, &responseData)
+		if err != nil {
+			t.Fatalf("Failed to unmarshal response data: %v", err)
+		}
+		expectedType := test.contentType
+		if expectedType == "" {
+			expectedType = messageprocessor.CONTENT_TYPE_PLAIN
+		}
+		assert.Equal(t, expectedType, responseData.ContentType)
+		assert.Equal(t, test.stored, responseData.Content)
+	}
+
+	response := sendMessage("text/html", "<p>hi</p>")
+	assert.Equal(t, messageprocessor.ErrInvalidContentType.Error(), response.Error)
+	response = sendMessage(messageprocessor.CONTENT_TYPE_CODE, `{"language": "go"}`)
+	assert.Equal(t, messageprocessor.ErrInvalidCodeContent.Error(), response.Error)
+
+	history := getChatHistorySuccess(t, conn2, chatID)
+	if assert.Len(t, history.Messages, 4) {
+		assert.Equal(t, messageprocessor.CONTENT_TYPE_CODE, history.Messages[0].ContentType)
+		assert.Equal(t, messageprocessor.CONTENT_TYPE_MARKDOWN, history.Messages[1].ContentType)
+		assert.Equal(t, messageprocessor.CONTENT_TYPE_MARKDOWN, history.Messages[2].ContentType)
+		assert.Equal(t, messageprocessor.CONTENT_TYPE_PLAIN, history.Messages[3].ContentType)
+
+		// edits are sanitized according to the type of the message
+		editRequest := fmt.Sprintf(`{"type": "%s", "data": {"messageId": "%s", "content": "%s"}}`,
+			messageprocessor.EDIT_MESSAGE_REQUEST, history.Messages[1].MessageID, "<iframe></iframe>")
+		writeMessage(t, conn1, editRequest)
+		response = readMessage(t, conn1)
+		readMessage(t, conn2) // EditMessageResponse
+		var editData messageprocessor.EditMessageResponse
+		err := json.Unmarshal(response.Data, &editData)
+		if err != nil {
+			t.Fatalf("Failed to unmarshal response data: %v", err)
+		}
+		assert.Equal(t, messageprocessor.CONTENT_TYPE_MARKDOWN, editData.ContentType)
+		assert.Equal(t, "&lt;iframe>&lt;/iframe>", editData.Content)
+	}
+}
+
+func TestLinkPreviews(t *testing.T) {
+	cleanDB(t, db)
+
+	cleanup, server, app := setupTestAppWithServerAndUsers(t)
+	defer cleanup()
+	defer server.Close()
+
+	// stands in for a third party site
+	site := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "text/html; charset=utf-8")
+		fmt.Fprint(w, `<html><head>
+			<title>Fallback title</title>
+			<meta property="og:title" content="Release notes &amp; more">
+			<meta property="og:description" content="What changed in this release">
+			<meta property="og:image" content="/cover.png">
+		</head><body></body></html>`)
+	}))
+	defer site.Close()
+	app.hub.MessageProcessor.SetUnfurler(unfurl.NewHTTPUnfurler(unfurl.Options{AllowPrivateNetworks: true}))
+
+	conn1 := getWebSocketConnectionWithAccessToken(t, server, loginUserAndGetAccessToken(t, server, testUser1Email, testPassword))
+	defer conn1.Close()
+	conn2 := getWebSocketConnectionWithAccessToken(t, server, loginUserAndGetAccessToken(t, server, testUser2Email, testPassword))
+	defer conn2.Close()
+
+	time.Sleep(100 * time.Millisecond)
+
+	chatID := createChatSuccess(t, conn1)
+	readMessage(t, conn2) // CreateChatResponse
+
+	link := site.URL + "/releases/1"
+	sent := sendMessageSuccess(t, conn1, chatID, "see "+link+".")
+	readMessage(t, conn2) // SendMessageResponse
+
+	for _, conn := range []*gorilla.Conn{conn1, conn2} {
+		response := readMessage(t, conn)
+		assert.Equal(t, messageprocessor.MESSAGE_PREVIEW_UPDATED_EVENT, response.Type)
+		assert.Empty(t, response.Error)
+		assert.True(t, response.Unsolicited)
+
+		var event messageprocessor.MessagePreviewUpdatedEvent
+		err := json.Unmarshal(response.Data, &event)
+		if err != nil {
+			t.Fatalf("Failed to unmarshal response data: %v", err)
+		}
+		assert.Equal(t, sent.MessageID, event.MessageID)
+		if assert.Len(t, event.LinkPreviews, 1) {
+			assert.Equal(t, link, event.LinkPreviews[0].URL)
+			assert.Equal(t, "Release notes & more", event.LinkPreviews[0].Title)
+			assert.Equal(t, "What changed in this release", event.LinkPreviews[0].Description)
+			assert.Equal(t, site.URL+"/cover.png", event.LinkPreviews[0].ImageURL)
+		}
+	}
+
+	history := getChatHistorySuccess(t, conn2, chatID)
+	if assert.Len(t, history.Messages, 1) && assert.Len(t, history.Messages[0].LinkPreviews, 1) {
+		assert.Equal(t, "Release notes & more", history.Messages[0].LinkPreviews[0].Title)
+	}
+}
+
+func TestScheduledMessages(t *testing.T) {
+	cleanDB(t, db)
+
+	cleanup, server, _ := setupTestAppWithServerAndUsers(t)
+	defer cleanup()
+	defer server.Close()
+
+	conn1 := getWebSocketConnectionWithAccessToken(t, server, loginUserAndGetAccessToken(t, server, testUser1Email, testPassword))
+	defer conn1.Close()
+	conn2 := getWebSocketConnectionWithAccessToken(t, server, loginUserAndGetAccessToken(t, server, testUser2Email, testPassword))
+	defer conn2.Close()
+
+	time.Sleep(100 * time.Millisecond)
+
+	chatID := createChatSuccess(t, conn1)
+	readMessage(t, conn2) // CreateChatResponse
+
+	schedule := func(sendAt time.Time, recurrence string) messageprocessor.ScheduleMessageResponse {
+		t.Helper()
+		request := fmt.Sprintf(`{"type": "%s", "data": {"chatId": "%s", "content": "standup", "sendAt": "%s", "recurrence": %s}}`,
+			messageprocessor.SEND_MESSAGE_REQUEST, chatID, sendAt.Format(time.RFC3339Nano), recurrence)
+		writeMessage(t, conn1, request)
+		response := readMessage(t, conn1)
+		assert.Equal(t, messageprocessor.SCHEDULE_MESSAGE_RESPONSE, response.Type)
+		assert.Empty(t, response.Error)
+
+		var responseData messageprocessor.ScheduleMessageResponse
+		err := json.Unmarshal(response.Data, &responseData)
+		if err != nil {
+			t.Fatalf("Failed to unmarshal response data: %v", err)
+		}
+		return responseData
+	}
+	listScheduled := func() []messageprocessor.ScheduledMessage {
+		t.Helper()
+		writeMessage(t, conn1, fmt.Sprintf(`{"type": "%s", "data": {"chatId": "%s"}}`, messageprocessor.LIST_SCHEDULED_MESSAGES_REQUEST, chatID))
+		response := readMessage(t, conn1)
+		assert.Equal(t, messageprocessor.LIST_SCHEDULED_MESSAGES_RESPONSE, response.Type)
+		assert.Empty(t, response.Error)
+
+		var responseData messageprocessor.ListScheduledMessagesResponse
+		err := json.Unmarshal(response.Data, &responseData)
+		if err != nil {
+			t.Fatalf("Failed to unmarshal response data: %v", err)
+		}
+		return responseData.ScheduledMessages
+	}
+
+	// a recurring reminder that is cancelled before it is ever sent
+	recurring := schedule(time.Now().Add(time.Hour), `"weekdays"`)
+	if assert.NotNil(t, recurring.Recurrence) {
+		assert.Equal(t, "weekdays", *recurring.Recurrence)
+	}
+	writeMessage(t, conn1, fmt.Sprintf(`{"type": "%s", "data": {"scheduledMessageId": "%s"}}`,
+		messageprocessor.CANCEL_SCHEDULED_MESSAGE_REQUEST, recurring.ScheduledMessageID))
+	response := readMessage(t, conn1)
+	assert.Equal(t, messageprocessor.CANCEL_SCHEDULED_MESSAGE_RESPONSE, response.Type)
+	assert.Empty(t, response.Error)
+
+	// a retried request doesn't schedule the message twice
+	retriedRequest := fmt.Sprintf(`{"type": "%s", "data": {"chatId": "%s", "content": "daily", "sendAt": "%s", "recurrence": "daily", "clientMessageId": "daily-1"}}`,
+		messageprocessor.SEND_MESSAGE_REQUEST, chatID, time.Now().Add(time.Hour).Format(time.RFC3339Nano))
+	var retriedIDs []string
+	for range 2 {
+		writeMessage(t, conn1, retriedRequest)
+		response = readMessage(t, conn1)
+		assert.Equal(t, messageprocessor.SCHEDULE_MESSAGE_RESPONSE, response.Type)
+		assert.Empty(t, response.Error)
+		var responseData messageprocessor.ScheduleMessageResponse
+		json.Unmarshal(response.Data, &responseData)
+		if assert.NotNil(t, responseData.ClientMessageID) {
+			assert.Equal(t, "daily-1", *responseData.ClientMessageID)
+		}
+		retriedIDs = append(retriedIDs, responseData.ScheduledMessageID)
+	}
+	assert.Equal(t, retriedIDs[0], retriedIDs[1])
+	assert.Len(t, listScheduled(), 1)
+	writeMessage(t, conn1, fmt.Sprintf(`{"type": "%s", "data": {"scheduledMessageId": "%s"}}`,
+		messageprocessor.CANCEL_SCHEDULED_MESSAGE_REQUEST, retriedIDs[0]))
+	response = readMessage(t, conn1)
+	assert.Equal(t, messageprocessor.CANCEL_SCHEDULED_MESSAGE_RESPONSE, response.Type)
+
+	// a one-off message that is edited before it is sent
+	scheduled := schedule(time.Now().Add(time.Second), "null")
+	assert.Nil(t, scheduled.Recurrence)
+	writeMessage(t, conn1, fmt.Sprintf(`{"type": "%s", "data": {"scheduledMessageId": "%s", "content": "standup in 5"}}`,
+		messageprocessor.EDIT_SCHEDULED_MESSAGE_REQUEST, scheduled.ScheduledMessageID))
+	response = readMessage(t, conn1)
+	assert.Equal(t, messageprocessor.EDIT_SCHEDULED_MESSAGE_RESPONSE, response.Type)
+	assert.Empty(t, response.Error)
+
+	pending := listScheduled()
+	if assert.Len(t, pending, 1) {
+		assert.Equal(t, scheduled.ScheduledMessageID, pending[0].ScheduledMessageID)
+		assert.Equal(t, "standup in 5", pending[0].Content)
+	}
+
+	// the dispatcher sends it to every member once it is due
+	for _, conn := range []*gorilla.Conn{conn1, conn2} {
+		response := readMessage(t, conn)
+		assert.Equal(t, messageprocessor.SEND_MESSAGE_RESPONSE, response.Type)
+		assert.True(t, response.Unsolicited)
+
+		var responseData messageprocessor.SendMessageResponse
+		err := json.Unmarshal(response.Data, &responseData)
+		if err != nil {
+			t.Fatalf("Failed to unmarshal response data: %v", err)
+		}
+		assert.Equal(t, chatID, responseData.ChatID)
+		assert.Equal(t, "standup in 5", responseData.Content)
+	}
+
+	assert.Empty(t, listScheduled())
+	history := getChatHistorySuccess(t, conn2, chatID)
+	assert.Len(t, history.Messages, 1)
+
+	// a message that can't be sent is retried later instead of holding up
+	// the messages due after it
+	var failingID string
+	err := db.QueryRow(`INSERT INTO scheduled_messages (sender_id, chat_id, content, content_type, send_at)
+	SELECT id, $2, 'broken', 'application/unknown', now() AT TIME ZONE 'UTC' - interval '1 minute'
+	FROM users WHERE email = $1
+	RETURNING id`, testUser1Email, chatID).Scan(&failingID)
+	if err != nil {
+		t.Fatalf("Failed to insert scheduled message: %v", err)
+	}
+	schedule(time.Now().Add(time.Second), "null")
+	for _, conn := range []*gorilla.Conn{conn1, conn2} {
+		response := readMessage(t, conn)
+		assert.Equal(t, messageprocessor.SEND_MESSAGE_RESPONSE, response.Type)
+	}
+
+	pending = listScheduled()
+	if assert.Len(t, pending, 1) {
+		assert.Equal(t, failingID, pending[0].ScheduledMessageID)
+		assert.Equal(t, 1, pending[0].Attempts)
+		assert.Nil(t, pending[0].FailedAt)
+	}
+}
+
+func TestDisappearingMessages(t *testing.T) {
+	cleanDB(t, db)
+
+	cleanup, server, _ := setupTestAppWithServerAndUsers(t)
+	defer cleanup()
+	defer server.Close()
+
+	conn1 := getWebSocketConnectionWithAccessToken(t, server, loginUserAndGetAccessToken(t, server, testUser1Email, testPassword))
+	defer conn1.Close()
+	conn2 := getWebSocketConnectionWithAccessToken(t, server, loginUserAndGetAccessToken(t, server, testUser2Email, testPassword))
+	defer conn2.Close()
+
+	time.Sleep(100 * time.Millisecond)
+
+	chatID := createChatSuccess(t, conn1)
+	readMessage(t, conn2) // CreateChatResponse
+
+	writeMessage(t, conn1, fmt.Sprintf(`{"type": "%s", "data": {"chatId": "%s", "messageTtl": -5}}`,
+		messageprocessor.SET_MESSAGE_TTL_REQUEST, chatID))
+	response := readMessage(t, conn1)
+	assert.Equal(t, messageprocessor.SET_MESSAGE_TTL_RESPONSE, response.Type)
+	assert.Equal(t, messageprocessor.ErrInvalidMessageTTL.Error(), response.Error)
+
+	writeMessage(t, conn1, fmt.Sprintf(`{"type": "%s", "data": {"chatId": "%s", "messageTtl": 1}}`,
+		messageprocessor.SET_MESSAGE_TTL_REQUEST, chatID))
+	for _, conn := range []*gorilla.Conn{conn1, conn2} {
+		response := readMessage(t, conn)
+		assert.Equal(t, messageprocessor.SET_MESSAGE_TTL_RESPONSE, response.Type)
+		assert.Empty(t, response.Error)
+
+		var responseData messageprocessor.SetMessageTTLResponse
+		err := json.Unmarshal(response.Data, &responseData)
+		if err != nil {
+			t.Fatalf("Failed to unmarshal response data: %v", err)
+		}
+		if assert.NotNil(t, responseData.MessageTTL) {
+			assert.Equal(t, 1, *responseData.MessageTTL)
+		}
+	}
+
+	sent := sendMessageSuccess(t, conn1, chatID, "the password is hunter2")
+	readMessage(t, conn2) // SendMessageResponse
+
+	// the reaper deletes the message about a second later
+	for _, conn := range []*gorilla.Conn{conn1, conn2} {
+		response := readMessage(t, conn)
+		assert.Equal(t, messageprocessor.MESSAGES_EXPIRED_EVENT, response.Type)
+		assert.True(t, response.Unsolicited)
+
+		var event messageprocessor.MessagesExpiredEvent
+		err := json.Unmarshal(response.Data, &event)
+		if err != nil {
+			t.Fatalf("Failed to unmarshal response data: %v", err)
+		}
+		assert.Equal(t, chatID, event.ChatID)
+		assert.Equal(t, []string{sent.MessageID}, event.MessageIDs)
+	}
+
+	var count int
+	err := db.QueryRow(`SELECT COUNT(*) FROM messages WHERE chat_id = $1`, chatID).Scan(&count)
+	if err != nil {
+		t.Fatalf("Failed to count messages: %v", err)
+	}
+	assert.Zero(t, count)
+}
+
+func TestForwardMessages(t *testing.T) {
+	cleanDB(t, db)
+
+	cleanup, server, _ := setupTestAppWithServerAndUsers(t)
+	defer cleanup()
+	defer server.Close()
+
+	conn1 := getWebSocketConnectionWithAccessToken(t, server, loginUserAndGetAccessToken(t, server, testUser1Email, testPassword))
+	defer conn1.Close()
+	conn2 := getWebSocketConnectionWithAccessToken(t, server, loginUserAndGetAccessToken(t, server, testUser2Email, testPassword))
+	defer conn2.Close()
+
+	time.Sleep(100 * time.Millisecond)
+
+	sourceChatID := createChatSuccess(t, conn1)
+	readMessage(t, conn2) // CreateChatResponse
+	targetChatID := createChatSuccess(t, conn1)
+	readMessage(t, conn2) // CreateChatResponse
+
+	first := sendMessageSuccess(t, conn1, sourceChatID, "first")
+	readMessage(t, conn2) // SendMessageResponse
+	second := sendMessageSuccess(t, conn1, sourceChatID, "second")
+	readMessage(t, conn2) // SendMessageResponse
+
+	// user 2 forwards user 1's messages, listed out of order
+	forwardRequest := `{"type": "%s", "data": {"messageIds": ["%s", "%s"], "targetChatId": "%s"}}`
+	writeMessage(t, conn2, fmt.Sprintf(forwardRequest, messageprocessor.FORWARD_MESSAGE_REQUEST, second.MessageID, first.MessageID, targetChatID))
+	var forwarded messageprocessor.ForwardMessageResponse
+	for _, conn := range []*gorilla.Conn{conn2, conn1} {
+		response := readMessage(t, conn)
+		assert.Equal(t, messageprocessor.FORWARD_MESSAGE_RESPONSE, response.Type)
+		assert.Empty(t, response.Error)
+
+		err := json.Unmarshal(response.Data, &forwarded)
+		if err != nil {
+			t.Fatalf("Failed to unmarshal response data: %v", err)
+		}
+		assert.Equal(t, targetChatID, forwarded.ChatID)
+		if assert.Len(t, forwarded.Messages, 2) {
+			assert.Equal(t, "first", forwarded.Messages[0].Content)
+			assert.Equal(t, "second", forwarded.Messages[1].Content)
+			assert.Equal(t, testUser2Name, forwarded.Messages[0].SenderName)
+			if assert.NotNil(t, forwarded.Messages[0].ForwardedFrom) {
+				assert.Equal(t, first.MessageID, *forwarded.Messages[0].ForwardedFrom.MessageID)
+				assert.Equal(t, first.SenderID, *forwarded.Messages[0].ForwardedFrom.SenderID)
+				assert.Equal(t, testUser1Name, *forwarded.Messages[0].ForwardedFrom.SenderName)
+				assert.Equal(t, sourceChatID, *forwarded.Messages[0].ForwardedFrom.ChatID)
+				assert.Equal(t, first.Timestamp, forwarded.Messages[0].ForwardedFrom.Timestamp)
+			}
+		}
+	}
+
+	// forwarding a forwarded message keeps the original attribution
+	writeMessage(t, conn1, fmt.Sprintf(`{"type": "%s", "data": {"messageIds": ["%s"], "targetChatId": "%s"}}`,
+		messageprocessor.FORWARD_MESSAGE_REQUEST, forwarded.Messages[0].MessageID, sourceChatID))
+	response := readMessage(t, conn1)
+	assert.Equal(t, messageprocessor.FORWARD_MESSAGE_RESPONSE, response.Type)
+	assert.Empty(t, response.Error)
+	readMessage(t, conn2) // ForwardMessageResponse
+
+	history := getChatHistorySuccess(t, conn1, sourceChatID)
+	if assert.Len(t, history.Messages, 3) {
+		var refwarded *messageprocessor.ChatHistoryMessage
+		for i := range history.Messages {
+			if history.Messages[i].ForwardedFrom != nil {
+				refwarded = &history.Messages[i]
+			}
+		}
+		if assert.NotNil(t, refwarded) {
+			assert.Equal(t, first.MessageID, *refwarded.ForwardedFrom.MessageID)
+			assert.Equal(t, testUser1Name, *refwarded.ForwardedFrom.SenderName)
+		}
+	}
+
+	// the sender must be a member of the target chat
+	writeMessage(t, conn1, fmt.Sprintf(forwardRequest, messageprocessor.FORWARD_MESSAGE_REQUEST, first.MessageID, second.MessageID, uuid.New().String()))
+	response = readMessage(t, conn1)
+	assert.Equal(t, messageprocessor.FORWARD_MESSAGE_RESPONSE, response.Type)
+	assert.Equal(t, messageprocessor.ErrNotChatMember.Error(), response.Error)
+
+	// and able to read the messages
+	writeMessage(t, conn1, fmt.Sprintf(forwardRequest, messageprocessor.FORWARD_MESSAGE_REQUEST, first.MessageID, uuid.New().String(), targetChatID))
+	response = readMessage(t, conn1)
+	assert.Equal(t, messageprocessor.FORWARD_MESSAGE_RESPONSE, response.Type)
+	assert.Equal(t, messageprocessor.ErrMessageNotFound.Error(), response.Error)
+
+	// messages of chats with disappearing messages can't outlive them as copies
+	writeMessage(t, conn1, fmt.Sprintf(`{"type": "%s", "data": {"chatId": "%s", "messageTtl": 3600}}`,
+		messageprocessor.SET_MESSAGE_TTL_REQUEST, sourceChatID))
+	for _, conn := range []*gorilla.Conn{conn1, conn2} {
+		response = readMessage(t, conn)
+		assert.Equal(t, messageprocessor.SET_MESSAGE_TTL_RESPONSE, response.Type)
+		assert.Empty(t, response.Error)
+	}
+	writeMessage(t, conn2, fmt.Sprintf(forwardRequest, messageprocessor.FORWARD_MESSAGE_REQUEST, first.MessageID, second.MessageID, targetChatID))
+	response = readMessage(t, conn2)
+	assert.Equal(t, messageprocessor.FORWARD_MESSAGE_RESPONSE, response.Type)
+	assert.Equal(t, messageprocessor.ErrCannotForwardDisappearingMessage.Error(), response.Error)
+}
+
+func TestPolls(t *testing.T) {
+	cleanDB(t, db)
+
+	cleanup, server, _ := setupTestAppWithServerAndUsers(t)
+	defer cleanup()
+	defer server.Close()
+
+	conn1 := getWebSocketConnectionWithAccessToken(t, server, loginUserAndGetAccessToken(t, server, testUser1Email, testPassword))
+	defer conn1.Close()
+	conn2 := getWebSocketConnectionWithAccessToken(t, server, loginUserAndGetAccessToken(t, server, testUser2Email, testPassword))
+	defer conn2.Close()
+
+	time.Sleep(100 * time.Millisecond)
+
+	chatID := createChatSuccess(t, conn1)
+	readMessage(t, conn2) // CreateChatResponse
+
+	createPollRequest := fmt.Sprintf(`{"type": "%s", "data": {"chatId": "%s", "question": "Lunch?", "options": ["Pizza", "Sushi", "Tacos"]}}`,
+		messageprocessor.CREATE_POLL_REQUEST, chatID)
+	writeMessage(t, conn1, createPollRequest)
+	var created messageprocessor.CreatePollResponse
+	for _, conn := range []*gorilla.Conn{conn1, conn2} {
+		response := readMessage(t, conn)
+		assert.Equal(t, messageprocessor.CREATE_POLL_RESPONSE, response.Type)
+		assert.Empty(t, response.Error)
+
+		err := json.Unmarshal(response.Data, &created)
+		if err != nil {
+			t.Fatalf("Failed to unmarshal response data: %v", err)
+		}
+		assert.Equal(t, chatID, created.ChatID)
+		assert.Equal(t, "Lunch?", created.Message.Content)
+		assert.Equal(t, messageprocessor.CONTENT_TYPE_POLL, created.Message.ContentType)
+		if assert.NotNil(t, created.Message.Poll) {
+			assert.False(t, created.Message.Poll.MultipleChoice)
+			assert.Len(t, created.Message.Poll.Options, 3)
+		}
+	}
+	pizza := created.Message.Poll.Options[0].OptionID
+	sushi := created.Message.Poll.Options[1].OptionID
+
+	voteRequest := `{"type": "%s", "data": {"messageId": "%s", "optionIds": [%s]}}`
+	writeMessage(t, conn2, fmt.Sprintf(voteRequest, messageprocessor.VOTE_POLL_REQUEST, created.Message.MessageID, `"`+pizza+`"`))
+	for _, conn := range []*gorilla.Conn{conn2, conn1} {
+		response := readMessage(t, conn)
+		assert.Equal(t, messageprocessor.VOTE_POLL_RESPONSE, response.Type)
+		assert.Empty(t, response.Error)
+
+		var voted messageprocessor.VotePollResponse
+		err := json.Unmarshal(response.Data, &voted)
+		if err != nil {
+			t.Fatalf("Failed to unmarshal response data: %v", err)
+		}
+		assert.Equal(t, []string{pizza}, voted.OptionIDs)
+		assert.Equal(t, 1, voted.TotalVoters)
+		assert.Equal(t, 1, voted.Tallies[0].VoteCount)
+		assert.Equal(t, 0, voted.Tallies[1].VoteCount)
+	}
+
+	// a single choice poll takes one option
+	writeMessage(t, conn1, fmt.Sprintf(voteRequest, messageprocessor.VOTE_POLL_REQUEST, created.Message.MessageID, `"`+pizza+`", "`+sushi+`"`))
+	response := readMessage(t, conn1)
+	assert.Equal(t, messageprocessor.VOTE_POLL_RESPONSE, response.Type)
+	assert.Equal(t, messageprocessor.ErrInvalidPollVote.Error(), response.Error)
+
+	writeMessage(t, conn1, fmt.Sprintf(voteRequest, messageprocessor.VOTE_POLL_REQUEST, created.Message.MessageID, `"`+sushi+`"`))
+	readMessage(t, conn1) // VotePollResponse
+	readMessage(t, conn2) // VotePollResponse
+
+	history := getChatHistorySuccess(t, conn1, chatID)
+	if assert.Len(t, history.Messages, 1) && assert.NotNil(t, history.Messages[0].Poll) {
+		poll := history.Messages[0].Poll
+		assert.Equal(t, 2, poll.TotalVoters)
+		assert.Equal(t, 1, poll.Options[0].VoteCount)
+		assert.False(t, poll.Options[0].VotedByMe)
+		assert.Equal(t, 1, poll.Options[1].VoteCount)
+		assert.True(t, poll.Options[1].VotedByMe)
+	}
+
+	// closed polls take no more votes
+	closesAt := time.Now().Add(500 * time.Millisecond).UTC().Format(time.RFC3339Nano)
+	writeMessage(t, conn1, fmt.Sprintf(`{"type": "%s", "data": {"chatId": "%s", "question": "Quick?", "options": ["Yes", "No"], "multipleChoice": true, "closesAt": "%s"}}`,
+		messageprocessor.CREATE_POLL_REQUEST, chatID, closesAt))
+	response = readMessage(t, conn1)
+	assert.Empty(t, response.Error)
+	readMessage(t, conn2) // CreatePollResponse
+	err := json.Unmarshal(response.Data, &created)
+	if err != nil {
+		t.Fatalf("Failed to unmarshal response data: %v", err)
+	}
+
+	time.Sleep(time.Second)
+	writeMessage(t, conn2, fmt.Sprintf(voteRequest, messageprocessor.VOTE_POLL_REQUEST, created.Message.MessageID, `"`+created.Message.Poll.Options[0].OptionID+`"`))
+	response = readMessage(t, conn2)
+	assert.Equal(t, messageprocessor.VOTE_POLL_RESPONSE, response.Type)
+	assert.Equal(t, messageprocessor.ErrPollClosed.Error(), response.Error)
+
+	writeMessage(t, conn1, fmt.Sprintf(`{"type": "%s", "data": {"chatId": "%s", "question": "Only one?", "options": ["Yes"]}}`,
+		messageprocessor.CREATE_POLL_REQUEST, chatID))
+	response = readMessage(t, conn1)
+	assert.Equal(t, messageprocessor.ErrInvalidPollOptions.Error(), response.Error)
+}
+
+func TestSearchMessages(t *testing.T) {
+	cleanDB(t, db)
+
+	cleanup, server, _ := setupTestAppWithServerAndUsers(t)
+	defer cleanup()
+	defer server.Close()
+
+	conn1 := getWebSocketConnectionWithAccessToken(t, server, loginUserAndGetAccessToken(t, server, testUser1Email, testPassword))
+	defer conn1.Close()
+	conn2 := getWebSocketConnectionWithAccessToken(t, server, loginUserAndGetAccessToken(t, server, testUser2Email, testPassword))
+	defer conn2.Close()
+
+	time.Sleep(100 * time.Millisecond)
+
+	chatID := createChatSuccess(t, conn1)
+	readMessage(t, conn2) // CreateChatResponse
+	sendMessageSuccess(t, conn1, chatID, "we decided to postpone the budget review")
+	readMessage(t, conn2) // SendMessageResponse
+	sendMessageSuccess(t, conn1, chatID, "budget notes at https://example.com/budget")
+	readMessage(t, conn2) // SendMessageResponse
+	writeMessage(t, conn2, fmt.Sprintf(`{"type": "%s", "data": {"chatId": "%s", "content": "lunch <b>budget</b> is fine"}}`,
+		messageprocessor.SEND_MESSAGE_REQUEST, chatID))
+	readMessage(t, conn2) // SendMessageResponse
+	readMessage(t, conn1) // SendMessageResponse
+
+	search := func(conn *gorilla.Conn, data string) messageprocessor.SearchMessagesResponse {
+		t.Helper()
+		writeMessage(t, conn, fmt.Sprintf(`{"type": "%s", "data": %s}`, messageprocessor.SEARCH_MESSAGES_REQUEST, data))
+		response := readMessage(t, conn)
+		assert.Equal(t, messageprocessor.SEARCH_MESSAGES_RESPONSE, response.Type)
+		assert.Empty(t, response.Error)
+
+		var responseData messageprocessor.SearchMessagesResponse
+		err := json.Unmarshal(response.Data, &responseData)
+		if err != nil {
+			t.Fatalf("Failed to unmarshal response data: %v", err)
+		}
+		return responseData
+	}
+
+	results := search(conn1, `{"query": "budget review"}`)
+	if assert.Len(t, results.Results, 1) {
+		assert.Equal(t, chatID, results.Results[0].ChatID)
+		assert.Contains(t, results.Results[0].Snippet, "<mark>budget</mark>")
+	}
+
+	results = search(conn1, `{"query": "budget"}`)
+	assert.Len(t, results.Results, 3)
+	for _, result := range results.Results {
+		assert.NotContains(t, result.Snippet, "<b>")
+	}
+
+	results = search(conn1, fmt.Sprintf(`{"query": "budget from:%s"}`, testUser2Email))
+	if assert.Len(t, results.Results, 1) {
+		assert.Equal(t, testUser2Name, results.Results[0].Message.SenderName)
+	}
+
+	results = search(conn1, fmt.Sprintf(`{"query": "has:link in:%s"}`, chatID))
+	if assert.Len(t, results.Results, 1) {
+		assert.Equal(t, "budget notes at https://example.com/budget", results.Results[0].Message.Content)
+	}
+
+	results = search(conn1, `{"query": "budget before:2000-01-01"}`)
+	assert.Empty(t, results.Results)
+
+	// page through the results one at a time
+	seen := make(map[string]bool)
+	results = search(conn2, `{"query": "budget", "limit": 1}`)
+	for {
+		if !assert.Len(t, results.Results, 1) {
+			break
+		}
+		last := results.Results[0]
+		assert.False(t, seen[last.Message.MessageID])
+		seen[last.Message.MessageID] = true
+		if !results.HasMore {
+			break
+		}
+		results = search(conn2, fmt.Sprintf(`{"query": "budget", "limit": 1, "cursorRank": %v, "cursorCreatedAt": "%s", "cursorMessageID": "%s"}`,
+			last.Rank, last.Message.Timestamp, last.Message.MessageID))
+	}
+	assert.Len(t, seen, 3)
+
+	writeMessage(t, conn1, fmt.Sprintf(`{"type": "%s", "data": {"query": "has:image"}}`, messageprocessor.SEARCH_MESSAGES_REQUEST))
+	response := readMessage(t, conn1)
+	assert.Equal(t, messageprocessor.ErrInvalidSearchQuery.Error(), response.Error)
+}
+
+func TestSlashCommands(t *testing.T) {
+	cleanDB(t, db)
+
+	cleanup, server, _ := setupTestAppWithServerAndUsers(t)
+	defer cleanup()
+	defer server.Close()
+
+	conn1 := getWebSocketConnectionWithAccessToken(t, server, loginUserAndGetAccessToken(t, server, testUser1Email, testPassword))
+	defer conn1.Close()
+	conn2 := getWebSocketConnectionWithAccessToken(t, server, loginUserAndGetAccessToken(t, server, testUser2Email, testPassword))
+	defer conn2.Close()
+
+	time.Sleep(100 * time.Millisecond)
+
+	chatID := createChatSuccess(t, conn1)
+	readMessage(t, conn2) // CreateChatResponse
+
+	writeCommand := func(conn *gorilla.Conn, content string) {
+		t.Helper()
+		writeMessage(t, conn, fmt.Sprintf(`{"type": "%s", "data": {"chatId": "%s", "content": "%s"}}`,
+			messageprocessor.SEND_MESSAGE_REQUEST, chatID, content))
+	}
+	runCommand := func(conn *gorilla.Conn, content string) messageprocessor.Response {
+		t.Helper()
+		writeCommand(conn, content)
+		return readMessage(t, conn)
+	}
+	readChatUpdated := func(conn *gorilla.Conn) messageprocessor.ChatUpdatedEvent {
+		t.Helper()
+		response := readMessage(t, conn)
+		assert.Equal(t, messageprocessor.CHAT_UPDATED_EVENT, response.Type)
+		var event messageprocessor.ChatUpdatedEvent
+		err := json.Unmarshal(response.Data, &event)
+		if err != nil {
+			t.Fatalf("Failed to unmarshal response data: %v", err)
+		}
+		assert.Equal(t, chatID, event.ChatID)
+		return event
+	}
+
+	// replies are only sent to the sender
+	response := runCommand(conn1, "/help")
+	assert.Equal(t, messageprocessor.COMMAND_RESPONSE, response.Type)
+	assert.Empty(t, response.Error)
+	var commandResponse messageprocessor.CommandResponse
+	err := json.Unmarshal(response.Data, &commandResponse)
+	if err != nil {
+		t.Fatalf("Failed to unmarshal response data: %v", err)
+	}
+	assert.Equal(t, "help", commandResponse.Command)
+	assert.Contains(t, commandResponse.Text, "/rename <name>")
+
+	// changes to the chat are sent to every member before the reply
+	writeCommand(conn1, "/rename Release planning")
+	readChatUpdated(conn1)
+	response = readMessage(t, conn1)
+	assert.Equal(t, messageprocessor.COMMAND_RESPONSE, response.Type)
+	assert.Empty(t, response.Error)
+	event := readChatUpdated(conn2)
+	assert.Equal(t, "Release planning", event.Name)
+	assert.Len(t, event.UserInfos, 2)
+
+	writeCommand(conn2, "/topic Ship v2 on Friday")
+	readChatUpdated(conn2)
+	response = readMessage(t, conn2)
+	assert.Empty(t, response.Error)
+	event = readChatUpdated(conn1)
+	assert.Equal(t, "Ship v2 on Friday", event.Topic)
+
+	response = runCommand(conn1, "/mute 8h")
+	assert.Equal(t, messageprocessor.COMMAND_RESPONSE, response.Type)
+	assert.Empty(t, response.Error)
+	writeMessage(t, conn1, fmt.Sprintf(`{"type": "%s", "data": {"limit": 10}}`, messageprocessor.GET_CHATS_REQUEST))
+	response = readMessage(t, conn1)
+	var chats messageprocessor.GetChatsResponse
+	err = json.Unmarshal(response.Data, &chats)
+	if err != nil {
+		t.Fatalf("Failed to unmarshal response data: %v", err)
+	}
+	if assert.Len(t, chats.Chats, 1) {
+		assert.Equal(t, "Ship v2 on Friday", chats.Chats[0].Topic)
+		assert.True(t, chats.Chats[0].Muted)
+		assert.NotNil(t, chats.Chats[0].MutedUntil)
+	}
+
+	response = runCommand(conn1, "/bogus")
+	assert.Equal(t, messageprocessor.COMMAND_RESPONSE, response.Type)
+	assert.Equal(t, messageprocessor.ErrUnknownCommand.Error(), response.Error)
+
+	// a double slash sends the rest as a message
+	sendMessageSuccess(t, conn1, chatID, "//shrug")
+	readMessage(t, conn2) // SendMessageResponse
+	history := getChatHistorySuccess(t, conn1, chatID)
+	if assert.Len(t, history.Messages, 1) {
+		assert.Equal(t, "/shrug", history.Messages[0].Content)
+	}
+
+	// typing caches the members of the chat, leaving and joining update it
+	typingRequest := fmt.Sprintf(`{"type": "%s", "data": {"chatId": "%s"}}`, messageprocessor.TYPING_START_REQUEST, chatID)
+	writeMessage(t, conn2, typingRequest)
+	response = readMessage(t, conn1)
+	assert.Equal(t, messageprocessor.TYPING_EVENT, response.Type)
+
+	response = runCommand(conn2, "/leave")
+	assert.Equal(t, messageprocessor.COMMAND_RESPONSE, response.Type)
+	assert.Empty(t, response.Error)
+	response = readMessage(t, conn1)
+	assert.Equal(t, messageprocessor.TYPING_EVENT, response.Type)
+	event = readChatUpdated(conn1)
+	assert.Len(t, event.UserInfos, 1)
+
+	response = runCommand(conn2, "/topic still here?")
+	assert.Equal(t, messageprocessor.ErrNotChatMember.Error(), response.Error)
+	writeMessage(t, conn2, typingRequest)
+	response = readMessage(t, conn2)
+	assert.Equal(t, messageprocessor.TYPING_EVENT, response.Type)
+	assert.Equal(t, messageprocessor.ErrNotChatMember.Error(), response.Error)
+
+	writeCommand(conn1, "/invite "+testUser2Email)
+	readChatUpdated(conn1)
+	response = readMessage(t, conn1)
+	assert.Empty(t, response.Error)
+	event = readChatUpdated(conn2)
+	assert.Len(t, event.UserInfos, 2)
+
+	writeMessage(t, conn2, typingRequest)
+	response = readMessage(t, conn1)
+	assert.Equal(t, messageprocessor.TYPING_EVENT, response.Type)
+	assert.Empty(t, response.Error)
+}
+
+// TestBotAccounts tests creating a bot, and the bot receiving events through
+// its callback URL and posting over WebSocket and HTTP
+func TestBotAccounts(t *testing.T) {
+	cleanDB(t, db)
+
+	cleanup, server, _ := setupTestAppWithServerAndUsers(t)
+	defer cleanup()
+	defer server.Close()
+
+	accessToken := loginUserAndGetAccessToken(t, server, testUser1Email, testPassword)
+	conn1 := getWebSocketConnectionWithAccessToken(t, server, accessToken)
+	defer conn1.Close()
+
+	type callback struct {
+		response  messageprocessor.Response
+		signature string
+		timestamp string
+		body      []byte
+	}
+	callbacks := make(chan callback, 10)
+	callbackServer := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		body, _ := io.ReadAll(r.Body)
+		var response messageprocessor.Response
+		json.Unmarshal(body, &response)
+		callbacks <- callback{
+			response:  response,
+			signature: r.Header.Get(bots.SignatureHeader),
+			timestamp: r.Header.Get(bots.TimestampHeader),
+			body:      body,
+		}
+	}))
+	defer callbackServer.Close()
+	readCallback := func() callback {
+		t.Helper()
+		select {
+		case c := <-callbacks:
+			return c
+		case <-time.After(2 * time.Second):
+			t.Fatal("Timed out waiting for a bot callback")
+			return callback{}
+		}
+	}
+
+	createBot := func(handle, callbackURL string) *http.Response {
+		t.Helper()
+		formData := url.Values{}
+		formData.Set("name", "Deploy Bot")
+		formData.Set("handle", handle)
+		formData.Set("callbackUrl", callbackURL)
+		resp, err := http.PostForm(server.URL+"/bots?access_token="+accessToken, formData)
+		if err != nil {
+			t.Fatalf("Failed to send create bot request: %v", err)
+		}
+		return resp
+	}
+
+	// Create a bot
+	resp := createBot("deploy", callbackServer.URL)
+	var botResponse response.APIResponse[BotResponse]
+	err := json.NewDecoder(resp.Body).Decode(&botResponse)
+	resp.Body.Close()
+	assert.NoError(t, err)
+	assert.Equal(t, http.StatusCreated, resp.StatusCode)
+	bot := botResponse.Data
+	assert.Equal(t, "deploy@bot", bot.UserInfo.Email)
+	assert.True(t, strings.HasPrefix(bot.Token, "chatty_bot_"))
+	assert.Len(t, bot.CallbackSecret, 64)
+
+	// Handles are unique and validated
+	resp = createBot("deploy", "")
+	resp.Body.Close()
+	assert.Equal(t, http.StatusUnprocessableEntity, resp.StatusCode)
+	resp = createBot("Not a handle!", "")
+	resp.Body.Close()
+	assert.Equal(t, http.StatusBadRequest, resp.StatusCode)
+	resp = createBot("alerts", "ftp://example.com")
+	resp.Body.Close()
+	assert.Equal(t, http.StatusBadRequest, resp.StatusCode)
+
+	// Humans can't sign up with a bot email and bots can't log in
+	resp = signupUser(t, server, "Fake Bot", "fake@bot", testPassword)
+	resp.Body.Close()
+	assert.Equal(t, http.StatusBadRequest, resp.StatusCode)
+	resp = loginUser(t, server, "deploy@bot", "")
+	resp.Body.Close()
+	assert.NotEqual(t, http.StatusOK, resp.StatusCode)
+
+	// Adding the bot to a chat is delivered to its callback, signed
+	writeMessage(t, conn1, fmt.Sprintf(`{"type": "%s", "data": {"name": "Deploys", "participantEmails": ["%s", "deploy@bot"]}}`,
+		messageprocessor.CREATE_CHAT_REQUEST, testUser1Email))
+	createChatResponse := readMessage(t, conn1)
+	assert.Equal(t, messageprocessor.CREATE_CHAT_RESPONSE, createChatResponse.Type)
+	var chat messageprocessor.CreateChatResponse
+	json.Unmarshal(createChatResponse.Data, &chat)
+
+	received := readCallback()
+	assert.Equal(t, messageprocessor.CREATE_CHAT_RESPONSE, received.response.Type)
+	assert.Equal(t, bots.Sign(bot.CallbackSecret, received.timestamp, received.body), received.signature)
+
+	// Bot tokens aren't accepted in the URL
+	wsURL := strings.Replace(server.URL, "http://", "ws://", 1) + "/ws"
+	_, resp, err = gorilla.DefaultDialer.Dial(wsURL+"?bot_token="+url.QueryEscape(bot.Token), nil)
+	assert.Error(t, err)
+	if assert.NotNil(t, resp) {
+		assert.Equal(t, http.StatusUnauthorized, resp.StatusCode)
+	}
+
+	// The bot posts over WebSocket with its token and without an Origin
+	botConn, _, err := gorilla.DefaultDialer.Dial(wsURL, http.Header{
+		"Authorization": []string{"Bearer " + bot.Token},
+	})
+	if err != nil {
+		t.Fatalf("Failed to connect bot to WebSocket: %v", err)
+	}
+	defer botConn.Close()
+	time.Sleep(100 * time.Millisecond)
+
+	writeMessage(t, botConn, fmt.Sprintf(`{"type": "%s", "data": {"chatId": "%s", "content": "Deploy started"}}`,
+		messageprocessor.SEND_MESSAGE_REQUEST, chat.Id))
+	botReply := readMessage(t, botConn)
+	assert.Equal(t, messageprocessor.SEND_MESSAGE_RESPONSE, botReply.Type)
+	assert.Empty(t, botReply.Error)
+	assert.Equal(t, messageprocessor.SEND_MESSAGE_RESPONSE, readCallback().response.Type)
+
+	var sendMessageResponse messageprocessor.SendMessageResponse
+	userEvent := readMessage(t, conn1)
+	json.Unmarshal(userEvent.Data, &sendMessageResponse)
+	assert.Equal(t, "Deploy started", sendMessageResponse.Content)
+	assert.Equal(t, bot.UserInfo.ID.String(), sendMessageResponse.SenderID)
+
+	// The bot posts over HTTP
+	postBotRequest := func(token string) *http.Response {
+		t.Helper()
+		body := fmt.Sprintf(`{"type": "%s", "data": {"chatId": "%s", "content": "Deploy finished"}}`,
+			messageprocessor.SEND_MESSAGE_REQUEST, chat.Id)
+		req, _ := http.NewRequest(http.MethodPost, server.URL+"/bot/requests", strings.NewReader(body))
+		req.Header.Set("Authorization", "Bearer "+token)
+		resp, err := http.DefaultClient.Do(req)
+		if err != nil {
+			t.Fatalf("Failed to send bot request: %v", err)
+		}
+		resp.Body.Close()
+		return resp
+	}
+	assert.Equal(t, http.StatusAccepted, postBotRequest(bot.Token).StatusCode)
+	userEvent = readMessage(t, conn1)
+	json.Unmarshal(userEvent.Data, &sendMessageResponse)
+	assert.Equal(t, "Deploy finished", sendMessageResponse.Content)
+	readMessage(t, botConn) // SendMessageResponse
+	readCallback()          // SendMessageResponse
+
+	// Rotating the token revokes the old one
+	resp, err = http.Post(server.URL+"/bots/"+bot.UserInfo.ID.String()+"/token?access_token="+accessToken, "", nil)
+	assert.NoError(t, err)
+	var rotateResponse response.APIResponse[BotResponse]
+	json.NewDecoder(resp.Body).Decode(&rotateResponse)
+	resp.Body.Close()
+	assert.Equal(t, http.StatusOK, resp.StatusCode)
+	assert.NotEqual(t, bot.Token, rotateResponse.Data.Token)
+	// and closes the connections opened with it
+	botConn.SetReadDeadline(time.Now().Add(time.Second))
+	_, _, err = botConn.ReadMessage()
+	var closeErr *gorilla.CloseError
+	assert.ErrorAs(t, err, &closeErr)
+	assert.Equal(t, http.StatusUnauthorized, postBotRequest(bot.Token).StatusCode)
+	assert.Equal(t, http.StatusAccepted, postBotRequest(rotateResponse.Data.Token).StatusCode)
+	readMessage(t, conn1) // SendMessageResponse
+
+	// Only the owner can rotate the token
+	otherAccessToken := loginUserAndGetAccessToken(t, server, testUser2Email, testPassword)
+	resp, err = http.Post(server.URL+"/bots/"+bot.UserInfo.ID.String()+"/token?access_token="+otherAccessToken, "", nil)
+	assert.NoError(t, err)
+	resp.Body.Close()
+	assert.Equal(t, http.StatusNotFound, resp.StatusCode)
+}
+
+// TestDrafts tests saving drafts of a chat and a thread, and syncing them
+// between the sessions of a user
+func TestDrafts(t *testing.T) {
+	cleanDB(t, db)
+
+	cleanup, server, _ := setupTestAppWithServerAndUsers(t)
+	defer cleanup()
+	defer server.Close()
+
+	accessToken := loginUserAndGetAccessToken(t, server, testUser1Email, testPassword)
+	laptop := getWebSocketConnectionWithAccessToken(t, server, accessToken)
+	defer laptop.Close()
+	phone := getWebSocketConnectionWithAccessToken(t, server, accessToken)
+	defer phone.Close()
+	conn2 := getWebSocketConnectionWithAccessToken(t, server, loginUserAndGetAccessToken(t, server, testUser2Email, testPassword))
+	defer conn2.Close()
+
+	time.Sleep(100 * time.Millisecond)
+
+	// The other session of the creator is told about the chat too
+	chatID := createChatSuccess(t, laptop)
+	created := readMessage(t, phone)
+	assert.Equal(t, messageprocessor.CREATE_CHAT_RESPONSE, created.Type)
+	assert.True(t, created.Unsolicited)
+	readMessage(t, conn2) // CreateChatResponse
+
+	saveDraft := func(conn *gorilla.Conn, parentMessageID string, content string) messageprocessor.Response {
+		t.Helper()
+		parent := ""
+		if parentMessageID != "" {
+			parent = fmt.Sprintf(`, "parentMessageId": "%s"`, parentMessageID)
+		}
+		writeMessage(t, conn, fmt.Sprintf(`{"type": "%s", "requestId": "save", "data": {"chatId": "%s", "content": "%s"%s}}`,
+			messageprocessor.SAVE_DRAFT_REQUEST, chatID, content, parent))
+		return readMessage(t, conn)
+	}
+	readDraftUpdated := func(conn *gorilla.Conn) messageprocessor.DraftUpdatedEvent {
+		t.Helper()
+		response := readMessage(t, conn)
+		assert.Equal(t, messageprocessor.DRAFT_UPDATED_EVENT, response.Type)
+		assert.True(t, response.Unsolicited)
+		var event messageprocessor.DraftUpdatedEvent
+		json.Unmarshal(response.Data, &event)
+		return event
+	}
+	getDrafts := func(conn *gorilla.Conn) []messageprocessor.Draft {
+		t.Helper()
+		writeMessage(t, conn, fmt.Sprintf(`{"type": "%s", "data": {}}`, messageprocessor.GET_DRAFTS_REQUEST))
+		response := readMessage(t, conn)
+		assert.Equal(t, messageprocessor.GET_DRAFTS_RESPONSE, response.Type)
+		assert.Empty(t, response.Error)
+		var responseData messageprocessor.GetDraftsResponse
+		json.Unmarshal(response.Data, &responseData)
+		return responseData.Drafts
+	}
+
+	// A draft saved on the laptop shows up on the phone
+	saved := saveDraft(laptop, "", "Half typed")
+	assert.Equal(t, messageprocessor.SAVE_DRAFT_RESPONSE, saved.Type)
+	assert.Equal(t, "save", saved.RequestID)
+	assert.Empty(t, saved.Error)
+	event := readDraftUpdated(phone)
+	assert.Equal(t, chatID, event.ChatID)
+	assert.Equal(t, "Half typed", event.Content)
+	assert.Nil(t, event.ParentMessageID)
+
+	// Threads have their own draft, kept by their root
+	root := sendMessageSuccess(t, conn2, chatID, "Root")
+	readMessage(t, laptop) // SendMessageResponse
+	readMessage(t, phone)  // SendMessageResponse
+	saved = saveDraft(laptop, root.MessageID, "Thread reply")
+	assert.Empty(t, saved.Error)
+	event = readDraftUpdated(phone)
+	assert.Equal(t, root.MessageID, *event.ParentMessageID)
+
+	drafts := getDrafts(phone)
+	assert.Len(t, drafts, 2)
+	assert.Equal(t, "Thread reply", drafts[0].Content)
+	assert.Equal(t, "Half typed", drafts[1].Content)
+	assert.Empty(t, getDrafts(conn2))
+
+	// Only members can save drafts
+	writeMessage(t, conn2, fmt.Sprintf(`{"type": "%s", "data": {"chatId": "%s", "content": "Nope"}}`,
+		messageprocessor.SAVE_DRAFT_REQUEST, uuid.New()))
+	assert.Equal(t, messageprocessor.ErrNotChatMember.Error(), readMessage(t, conn2).Error)
+
+	// Sending a message uses up the draft of the chat on every session
+	sendMessageSuccess(t, laptop, chatID, "Fully typed")
+	event = readDraftUpdated(phone)
+	assert.Equal(t, "", event.Content)
+	assert.Nil(t, event.ParentMessageID)
+	sent := readMessage(t, phone)
+	assert.Equal(t, messageprocessor.SEND_MESSAGE_RESPONSE, sent.Type)
+	assert.True(t, sent.Unsolicited)
+	readMessage(t, conn2) // SendMessageResponse
+
+	drafts = getDrafts(laptop)
+	assert.Len(t, drafts, 1)
+	assert.Equal(t, "Thread reply", drafts[0].Content)
+
+	// Saving a blank draft deletes it
+	saved = saveDraft(phone, root.MessageID, " ")
+	assert.Empty(t, saved.Error)
+	event = readDraftUpdated(laptop)
+	assert.Equal(t, "", event.Content)
+	assert.Empty(t, getDrafts(laptop))
+}
+
+// TestSavedMessages tests saving messages for later, and saved messages
+// turning into tombstones when their message is deleted or the chat is left
+func TestSavedMessages(t *testing.T) {
+	cleanDB(t, db)
+
+	cleanup, server, _ := setupTestAppWithServerAndUsers(t)
+	defer cleanup()
+	defer server.Close()
+
+	conn1 := getWebSocketConnectionWithAccessToken(t, server, loginUserAndGetAccessToken(t, server, testUser1Email, testPassword))
+	defer conn1.Close()
+	conn2 := getWebSocketConnectionWithAccessToken(t, server, loginUserAndGetAccessToken(t, server, testUser2Email, testPassword))
+	defer conn2.Close()
+
+	time.Sleep(100 * time.Millisecond)
+
+	chatID := createChatSuccess(t, conn1)
+	readMessage(t, conn2) // CreateChatResponse
+	first := sendMessageSuccess(t, conn2, chatID, "Remember this")
+	readMessage(t, conn1) // SendMessageResponse
+	second := sendMessageSuccess(t, conn2, chatID, "And this")
+	readMessage(t, conn1) // SendMessageResponse
+
+	saveMessage := func(messageID string, note string, tags string) messageprocessor.Response {
+		t.Helper()
+		writeMessage(t, conn1, fmt.Sprintf(`{"type": "%s", "data": {"messageId": "%s", "note": "%s", "tags": %s}}`,
+			messageprocessor.SAVE_MESSAGE_REQUEST, messageID, note, tags))
+		return readMessage(t, conn1)
+	}
+	listSaved := func(filter string) []messageprocessor.SavedMessage {
+		t.Helper()
+		writeMessage(t, conn1, fmt.Sprintf(`{"type": "%s", "data": {%s}}`, messageprocessor.LIST_SAVED_MESSAGES_REQUEST, filter))
+		response := readMessage(t, conn1)
+		assert.Equal(t, messageprocessor.LIST_SAVED_MESSAGES_RESPONSE, response.Type)
+		assert.Empty(t, response.Error)
+		var responseData messageprocessor.ListSavedMessagesResponse
+		json.Unmarshal(response.Data, &responseData)
+		return responseData.SavedMessages
+	}
+
+	// Tags are normalized
+	response := saveMessage(first.MessageID, "For the retro", `["#Work", "work", "later"]`)
+	assert.Equal(t, messageprocessor.SAVE_MESSAGE_RESPONSE, response.Type)
+	assert.Empty(t, response.Error)
+	var saved messageprocessor.SaveMessageResponse
+	json.Unmarshal(response.Data, &saved)
+	assert.Equal(t, []string{"work", "later"}, saved.Tags)
+	assert.Equal(t, "For the retro", saved.Note)
+	assert.Equal(t, "Remember this", saved.Message.Content)
+	assert.Empty(t, saved.Tombstone)
+
+	response = saveMessage(second.MessageID, "", `["later"]`)
+	assert.Empty(t, response.Error)
+	response = saveMessage(second.MessageID, "", `["has space"]`)
+	assert.Equal(t, messageprocessor.ErrInvalidSavedMessageTags.Error(), response.Error)
+	response = saveMessage(uuid.New().String(), "", `[]`)
+	assert.Equal(t, messageprocessor.ErrMessageNotFound.Error(), response.Error)
+
+	savedMessages := listSaved(``)
+	assert.Len(t, savedMessages, 2)
+	assert.Equal(t, second.MessageID, *savedMessages[0].MessageID)
+	assert.Equal(t, first.MessageID, *savedMessages[1].MessageID)
+	savedMessages = listSaved(`"tag": "WORK"`)
+	assert.Len(t, savedMessages, 1)
+	assert.Equal(t, saved.SavedID, savedMessages[0].SavedID)
+
+	// A message deleted for everyone becomes a tombstone
+	writeMessage(t, conn2, fmt.Sprintf(`{"type": "%s", "data": {"messageId": "%s", "scope": "%s"}}`,
+		messageprocessor.DELETE_MESSAGE_REQUEST, second.MessageID, messageprocessor.DELETE_SCOPE_EVERYONE))
+	readMessage(t, conn2) // DeleteMessageResponse
+	readMessage(t, conn1) // DeleteMessageResponse
+	savedMessages = listSaved(``)
+	assert.Equal(t, messageprocessor.SAVED_TOMBSTONE_DELETED, savedMessages[0].Tombstone)
+	assert.Nil(t, savedMessages[0].Message)
+	assert.Empty(t, savedMessages[1].Tombstone)
+
+	// So does every message of a chat the user left, keeping note and tags
+	writeMessage(t, conn1, fmt.Sprintf(`{"type": "%s", "data": {"chatId": "%s", "content": "/leave"}}`,
+		messageprocessor.SEND_MESSAGE_REQUEST, chatID))
+	response = readMessage(t, conn1)
+	assert.Equal(t, messageprocessor.COMMAND_RESPONSE, response.Type)
+	assert.Empty(t, response.Error)
+	readMessage(t, conn2) // ChatUpdatedEvent
+	savedMessages = listSaved(``)
+	assert.Equal(t, messageprocessor.SAVED_TOMBSTONE_NOT_MEMBER, savedMessages[1].Tombstone)
+	assert.Nil(t, savedMessages[1].Message)
+	assert.Equal(t, "For the retro", savedMessages[1].Note)
+	response = saveMessage(first.MessageID, "", `[]`)
+	assert.Equal(t, messageprocessor.ErrMessageNotFound.Error(), response.Error)
+
+	// Tombstones can be removed
+	writeMessage(t, conn1, fmt.Sprintf(`{"type": "%s", "data": {"savedId": "%s"}}`,
+		messageprocessor.UNSAVE_MESSAGE_REQUEST, savedMessages[0].SavedID))
+	response = readMessage(t, conn1)
+	assert.Equal(t, messageprocessor.UNSAVE_MESSAGE_RESPONSE, response.Type)
+	assert.Empty(t, response.Error)
+	assert.Len(t, listSaved(``), 1)
+}
+
+func TestMessageReceipts(t *testing.T) {
+	cleanDB(t, db)
+
+	cleanup, server, _ := setupTestAppWithServerAndUsers(t)
+	defer cleanup()
+	defer server.Close()
+
+	conn1 := getWebSocketConnectionWithAccessToken(t, server, loginUserAndGetAccessToken(t, server, testUser1Email, testPassword))
+	defer conn1.Close()
+	conn2 := getWebSocketConnectionWithAccessToken(t, server, loginUserAndGetAccessToken(t, server, testUser2Email, testPassword))
+	defer conn2.Close()
+
+	time.Sleep(100 * time.Millisecond)
+
+	chatID := createChatSuccess(t, conn1)
+	var chat messageprocessor.CreateChatResponse
+	json.Unmarshal(readMessage(t, conn2).Data, &chat)
+	user2ID := chat.UserInfos[1].ID
+	var sent []messageprocessor.SendMessageResponse
+	for _, content := range []string{"One", "Two", "Three"} {
+		sent = append(sent, sendMessageSuccess(t, conn1, chatID, content))
+		readMessage(t, conn2) // SendMessageResponse
+	}
+
+	getReceipts := func(conn *gorilla.Conn, messageID string) messageprocessor.Response {
+		t.Helper()
+		writeMessage(t, conn, fmt.Sprintf(`{"type": "%s", "data": {"messageId": "%s"}}`,
+			messageprocessor.GET_MESSAGE_RECEIPTS_REQUEST, messageID))
+		return readMessage(t, conn)
+	}
+	readStatusEvent := func() messageprocessor.MessageStatusEvent {
+		t.Helper()
+		event := readMessage(t, conn1)
+		assert.Equal(t, messageprocessor.MESSAGE_STATUS_EVENT, event.Type)
+		assert.True(t, event.Unsolicited)
+		var eventData messageprocessor.MessageStatusEvent
+		json.Unmarshal(event.Data, &eventData)
+		assert.Equal(t, chatID, eventData.ChatID)
+		assert.Equal(t, user2ID, eventData.UserID)
+		return eventData
+	}
+
+	// Nothing has been confirmed yet
+	response := getReceipts(conn1, sent[0].MessageID)
+	assert.Equal(t, messageprocessor.GET_MESSAGE_RECEIPTS_RESPONSE, response.Type)
+	assert.Empty(t, response.Error)
+	var receipts messageprocessor.GetMessageReceiptsResponse
+	json.Unmarshal(response.Data, &receipts)
+	assert.Len(t, receipts.Receipts, 1)
+	assert.Equal(t, user2ID, receipts.Receipts[0].UserID)
+	assert.Equal(t, messageprocessor.MESSAGE_STATUS_SENT, receipts.Receipts[0].Status)
+
+	// Only the sender can see the receipts
+	response = getReceipts(conn2, sent[0].MessageID)
+	assert.Equal(t, messageprocessor.ErrNotReceiptsOwner.Error(), response.Error)
+
+	// Acknowledging messages marks them delivered for their sender
+	ackRequest := `{"type": "%s", "data": {"messageIds": ["%s", "%s"]}}`
+	writeMessage(t, conn2, fmt.Sprintf(ackRequest, messageprocessor.ACK_MESSAGES_REQUEST, sent[0].MessageID, sent[1].MessageID))
+	statusEvent := readStatusEvent()
+	assert.Equal(t, messageprocessor.MESSAGE_STATUS_DELIVERED, statusEvent.Status)
+	assert.ElementsMatch(t, []string{sent[0].MessageID, sent[1].MessageID}, statusEvent.MessageIDs)
+
+	// Repeated acks and acks of one's own messages change nothing
+	writeMessage(t, conn2, fmt.Sprintf(ackRequest, messageprocessor.ACK_MESSAGES_REQUEST, sent[0].MessageID, sent[1].MessageID))
+	writeMessage(t, conn1, fmt.Sprintf(ackRequest, messageprocessor.ACK_MESSAGES_REQUEST, sent[0].MessageID, sent[2].MessageID))
+
+	// Moving the read marker marks the messages up to it read
+	markReadRequest := `{"type": "%s", "data": {"chatId": "%s", "messageId": "%s"}}`
+	writeMessage(t, conn2, fmt.Sprintf(markReadRequest, messageprocessor.MARK_READ_REQUEST, chatID, sent[1].MessageID))
+	assert.Equal(t, messageprocessor.MARK_READ_RESPONSE, readMessage(t, conn2).Type)
+	assert.Equal(t, messageprocessor.MARK_READ_RESPONSE, readMessage(t, conn1).Type)
+	statusEvent = readStatusEvent()
+	assert.Equal(t, messageprocessor.MESSAGE_STATUS_READ, statusEvent.Status)
+	assert.ElementsMatch(t, []string{sent[0].MessageID, sent[1].MessageID}, statusEvent.MessageIDs)
+
+	// Only the messages after the previous marker are read again, and reading
+	// a message that was never acknowledged also delivers it
+	writeMessage(t, conn2, fmt.Sprintf(markReadRequest, messageprocessor.MARK_READ_REQUEST, chatID, sent[2].MessageID))
+	assert.Equal(t, messageprocessor.MARK_READ_RESPONSE, readMessage(t, conn2).Type)
+	assert.Equal(t, messageprocessor.MARK_READ_RESPONSE, readMessage(t, conn1).Type)
+	statusEvent = readStatusEvent()
+	assert.Equal(t, messageprocessor.MESSAGE_STATUS_READ, statusEvent.Status)
+	assert.Equal(t, []string{sent[2].MessageID}, statusEvent.MessageIDs)
+
+	response = getReceipts(conn1, sent[2].MessageID)
+	assert.Empty(t, response.Error)
+	json.Unmarshal(response.Data, &receipts)
+	assert.Len(t, receipts.Receipts, 1)
+	assert.Equal(t, messageprocessor.MESSAGE_STATUS_READ, receipts.Receipts[0].Status)
+	assert.NotNil(t, receipts.Receipts[0].DeliveredAt)
+	assert.NotNil(t, receipts.Receipts[0].ReadAt)
+
+	// Invalid acks are answered with an error
+	writeMessage(t, conn2, fmt.Sprintf(`{"type": "%s", "data": {"messageIds": ["not-a-uuid"]}}`, messageprocessor.ACK_MESSAGES_REQUEST))
+	response = readMessage(t, conn2)
+	assert.Equal(t, messageprocessor.ACK_MESSAGES_RESPONSE, response.Type)
+	assert.Equal(t, messageprocessor.ErrInvalidMessageID.Error(), response.Error)
+}
+
+func TestResumeMissedEvents(t *testing.T) {
+	cleanDB(t, db)
+
+	cleanup, server, app := setupTestAppWithServerAndUsers(t)
+	defer cleanup()
+	defer server.Close()
+
+	conn1 := getWebSocketConnectionWithAccessToken(t, server, loginUserAndGetAccessToken(t, server, testUser1Email, testPassword))
+	defer conn1.Close()
+	user2Token := loginUserAndGetAccessToken(t, server, testUser2Email, testPassword)
+	conn2 := getWebSocketConnectionWithAccessToken(t, server, user2Token)
+
+	time.Sleep(100 * time.Millisecond)
+
+	chatID := createChatSuccess(t, conn1)
+	created := readMessage(t, conn2)
+	assert.Equal(t, messageprocessor.CREATE_CHAT_RESPONSE, created.Type)
+	assert.Equal(t, int64(1), created.Seq)
+
+	resume := func(conn *gorilla.Conn, lastSeq string) messageprocessor.Response {
+		t.Helper()
+		writeMessage(t, conn, fmt.Sprintf(`{"type": "%s", "data": {%s}}`, messageprocessor.RESUME_REQUEST, lastSeq))
+		response := readMessage(t, conn)
+		assert.Equal(t, messageprocessor.RESUME_RESPONSE, response.Type)
+		return response
+	}
+	resumeSuccess := func(conn *gorilla.Conn, lastSeq string) messageprocessor.ResumeResponse {
+		t.Helper()
+		response := resume(conn, lastSeq)
+		assert.Empty(t, response.Error)
+		assert.Zero(t, response.Seq)
+		var responseData messageprocessor.ResumeResponse
+		json.Unmarshal(response.Data, &responseData)
+		return responseData
+	}
+
+	// Without a lastSeq only the current position is returned
+	resumed := resumeSuccess(conn2, ``)
+	assert.Equal(t, int64(1), resumed.LastSeq)
+	assert.Empty(t, resumed.Events)
+
+	// User 2 misses events while disconnected
+	conn2.Close()
+	time.Sleep(100 * time.Millisecond)
+
+	first := sendMessageSuccess(t, conn1, chatID, "one")
+	second := sendMessageSuccess(t, conn1, chatID, "two")
+	writeMessage(t, conn1, fmt.Sprintf(`{"type": "%s", "data": {"messageId": "%s", "content": "%s"}}`,
+		messageprocessor.EDIT_MESSAGE_REQUEST, second.MessageID, "two, edited"))
+	edited := readMessage(t, conn1)
+	assert.Equal(t, messageprocessor.EDIT_MESSAGE_RESPONSE, edited.Type)
+	assert.NotZero(t, edited.Seq)
+	writeMessage(t, conn1, fmt.Sprintf(`{"type": "%s", "data": {"messageId": "%s", "scope": "%s"}}`,
+		messageprocessor.DELETE_MESSAGE_REQUEST, first.MessageID, messageprocessor.DELETE_SCOPE_EVERYONE))
+	deleted := readMessage(t, conn1)
+	assert.Equal(t, messageprocessor.DELETE_MESSAGE_RESPONSE, deleted.Type)
+	assert.Empty(t, deleted.Error)
+
+	conn2 = getWebSocketConnectionWithAccessToken(t, server, user2Token)
+	defer conn2.Close()
+	time.Sleep(100 * time.Millisecond)
+
+	// Edited and deleted messages aren't replayed with their old content
+	resumed = resumeSuccess(conn2, `"lastSeq": 1`)
+	assert.False(t, resumed.Reset)
+	assert.False(t, resumed.HasMore)
+	assert.Equal(t, int64(5), resumed.LastSeq)
+	if assert.Len(t, resumed.Events, 2) {
+		assert.Equal(t, messageprocessor.EDIT_MESSAGE_RESPONSE, resumed.Events[0].Type)
+		assert.Equal(t, int64(4), resumed.Events[0].Seq)
+		assert.Equal(t, messageprocessor.DELETE_MESSAGE_RESPONSE, resumed.Events[1].Type)
+		assert.Equal(t, int64(5), resumed.Events[1].Seq)
+		for _, event := range resumed.Events {
+			assert.True(t, event.Unsolicited)
+			assert.NotContains(t, string(event.Data), `"one"`)
+			assert.NotContains(t, string(event.Data), `"two"`)
+		}
+		var message messageprocessor.EditMessageResponse
+		json.Unmarshal(resumed.Events[0].Data, &message)
+		assert.Equal(t, "two, edited", message.Content)
+	}
+
+	// Nothing was missed since the last event
+	resumed = resumeSuccess(conn2, `"lastSeq": 5`)
+	assert.Empty(t, resumed.Events)
+	assert.False(t, resumed.Reset)
+
+	// Typing indicators aren't logged, new events continue the sequence
+	writeMessage(t, conn1, fmt.Sprintf(`{"type": "%s", "data": {"chatId": "%s"}}`, messageprocessor.TYPING_START_REQUEST, chatID))
+	typing := readMessage(t, conn2)
+	assert.Equal(t, messageprocessor.TYPING_EVENT, typing.Type)
+	assert.Zero(t, typing.Seq)
+	sendMessageSuccess(t, conn1, chatID, "three")
+	for {
+		response := readMessage(t, conn2)
+		if response.Type == messageprocessor.SEND_MESSAGE_RESPONSE {
+			assert.Equal(t, int64(6), response.Seq)
+			break
+		}
+	}
+
+	// A sequence number the server never assigned requires a reset
+	resumed = resumeSuccess(conn2, `"lastSeq": 99`)
+	assert.True(t, resumed.Reset)
+	assert.Equal(t, int64(6), resumed.LastSeq)
+
+	// So do events that were pruned from the log
+	_, err := app.users.PruneEvents(time.Now().Add(time.Hour), 100)
+	if err != nil {
+		t.Fatalf("Failed to prune events: %v", err)
+	}
+	resumed = resumeSuccess(conn2, `"lastSeq": 1`)
+	assert.True(t, resumed.Reset)
+	assert.Empty(t, resumed.Events)
+	assert.Equal(t, int64(6), resumed.LastSeq)
+
+	response := resume(conn2, `"lastSeq": -1`)
+	assert.Equal(t, messageprocessor.ErrInvalidEventSeq.Error(), response.Error)
+}
+
+func TestMessageSeq(t *testing.T) {
+	cleanDB(t, db)
+
+	cleanup, server, _ := setupTestAppWithServerAndUsers(t)
+	defer cleanup()
+	defer server.Close()
+
+	accessToken := loginUserAndGetAccessToken(t, server, testUser1Email, testPassword)
+	conn := getWebSocketConnectionWithAccessToken(t, server, accessToken)
+	defer conn.Close()
+
+	time.Sleep(100 * time.Millisecond)
+
+	chatID := createChatSuccess(t, conn)
+	otherChatID := createChatSuccess(t, conn)
+
+	// Messages are numbered per chat, thread replies included
+	first := sendMessageSuccess(t, conn, chatID, "one")
+	assert.Equal(t, int64(1), first.Seq)
+	writeMessage(t, conn, fmt.Sprintf(`{"type": "%s", "data": {"chatId": "%s", "content": "reply", "parentMessageId": "%s"}}`,
+		messageprocessor.SEND_MESSAGE_REQUEST, chatID, first.MessageID))
+	response := readMessage(t, conn)
+	assert.Equal(t, messageprocessor.SEND_MESSAGE_RESPONSE, response.Type)
+	var reply messageprocessor.SendMessageResponse
+	json.Unmarshal(response.Data, &reply)
+	assert.Equal(t, int64(2), reply.Seq)
+	assert.Equal(t, int64(3), sendMessageSuccess(t, conn, chatID, "two").Seq)
+	assert.Equal(t, int64(4), sendMessageSuccess(t, conn, chatID, "three").Seq)
+	assert.Equal(t, int64(1), sendMessageSuccess(t, conn, otherChatID, "elsewhere").Seq)
+
+	// History is ordered by seq and paginated with it
+	getHistory := func(cursor string) messageprocessor.GetChatHistoryResponse {
+		t.Helper()
+		writeMessage(t, conn, fmt.Sprintf(`{"type": "%s", "data": {"chatID": "%s", "limit": 2%s}}`,
+			messageprocessor.GET_CHAT_HISTORY_REQUEST, chatID, cursor))
+		response := readMessage(t, conn)
+		assert.Equal(t, messageprocessor.GET_CHAT_HISTORY_RESPONSE, response.Type)
+		assert.Empty(t, response.Error)
+		var responseData messageprocessor.GetChatHistoryResponse
+		json.Unmarshal(response.Data, &responseData)
+		return responseData
+	}
+	history := getHistory(``)
+	assert.True(t, history.HasMore)
+	if !assert.Len(t, history.Messages, 2) {
+		return
+	}
+	assert.Equal(t, int64(4), history.Messages[0].Seq)
+	assert.Equal(t, int64(3), history.Messages[1].Seq)
+	lastMessageID := history.Messages[1].MessageID
+	history = getHistory(`, "cursorSeq": 3`)
+	assert.False(t, history.HasMore)
+	if assert.Len(t, history.Messages, 1) {
+		assert.Equal(t, "one", history.Messages[0].Content)
+		assert.Equal(t, int64(1), history.Messages[0].Seq)
+	}
+
+	// the messageId of the last message received is a cursor on its own
+	history = getHistory(fmt.Sprintf(`, "cursorMessageID": "%s"`, lastMessageID))
+	assert.False(t, history.HasMore)
+	if assert.Len(t, history.Messages, 1) {
+		assert.Equal(t, "one", history.Messages[0].Content)
+	}
+
+	writeMessage(t, conn, fmt.Sprintf(`{"type": "%s", "data": {"chatID": "%s", "cursorSeq": 0}}`,
+		messageprocessor.GET_CHAT_HISTORY_REQUEST, chatID))
+	response = readMessage(t, conn)
+	assert.Equal(t, messageprocessor.ErrInvalidCursor.Error(), response.Error)
+}
+
 // TestWebSocketConnection_MultipleCLients tests multiple concurrent connections
 func TestWebSocketConnection_MultipleClients(t *testing.T) {
 	cleanDB(t, db)
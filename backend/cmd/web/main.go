@@ -1,6 +1,7 @@
 package main
 
 import (
+	"context"
 	"database/sql"
 
 	_ "github.com/lib/pq"
@@ -10,9 +11,12 @@ import (
 	"log"
 	"net/http"
 	"os"
+	"time"
 
+	"chatty.mtran.io/internal/bots"
 	messageprocessor "chatty.mtran.io/internal/message_processor"
 	"chatty.mtran.io/internal/models"
+	"chatty.mtran.io/internal/unfurl"
 	"chatty.mtran.io/internal/websocket"
 	"github.com/go-playground/form/v4"
 
@@ -32,6 +36,7 @@ type application struct {
 	messages    *models.MessageModel
 	formDecoder *form.Decoder
 	hub         *websocket.Hub
+	callbacks   *bots.CallbackSender
 }
 
 func main() {
@@ -64,9 +69,16 @@ func main() {
 
 	messageProcessor := messageprocessor.NewMessageProcessor(chatModel, userModel, messageModel)
 	hub := websocket.NewHub(messageProcessor)
-	messageProcessor.SetMessageSender(hub)
+	callbacks := bots.NewCallbackSender(hub, userModel, bots.Options{})
+	messageProcessor.SetMessageSender(callbacks)
+	messageProcessor.SetUnfurler(unfurl.NewHTTPUnfurler(unfurl.Options{}))
 
 	go hub.Run() // Start the hub in a goroutine
+	go callbacks.Run(context.Background())
+	go messageProcessor.RunEventWriter(context.Background())
+	go messageProcessor.RunScheduledMessageDispatcher(context.Background(), time.Second)
+	go messageProcessor.RunMessageReaper(context.Background(), 10*time.Second)
+	go messageProcessor.RunEventLogPruner(context.Background(), time.Hour)
 
 	app := &application{
 		errorLog:    errorLog,
@@ -76,6 +88,7 @@ func main() {
 		messages:    messageModel,
 		formDecoder: formDecoder,
 		hub:         hub,
+		callbacks:   callbacks,
 	}
 
 	srv := &http.Server{
@@ -30,8 +30,15 @@ func (app *application) routes() http.Handler {
 
 	protected := dynamic.Append(auth.AuthMiddleware)
 	router.Handler(http.MethodPost, "/user/logout", protected.ThenFunc(app.userLogout))
+	router.Handler(http.MethodPost, "/bots", protected.ThenFunc(app.botCreate))
+	router.Handler(http.MethodPost, "/bots/:id/token", protected.ThenFunc(app.botRotateToken))
+
+	// bots use the same WebSocket endpoint as users, or post their requests
+	botProtected := dynamic.Append(auth.BotAuthMiddleware(app.authenticateBot))
+	router.Handler(http.MethodPost, "/bot/requests", botProtected.ThenFunc(app.botRequest))
+	botOrUserProtected := dynamic.Append(auth.BotOrUserAuthMiddleware(app.authenticateBot))
 	// Create WebSocket handler
-	router.Handler(http.MethodGet, "/ws", protected.ThenFunc(wsHandler.Handle))
+	router.Handler(http.MethodGet, "/ws", botOrUserProtected.ThenFunc(wsHandler.Handle))
 
 	return withCORS(clientOrigin)(router)
 }
@@ -1,6 +1,7 @@
 package main
 
 import (
+	"context"
 	"database/sql"
 	"encoding/json"
 	"fmt"
@@ -13,6 +14,7 @@ import (
 	"time"
 
 	"chatty.mtran.io/internal/auth"
+	"chatty.mtran.io/internal/bots"
 	messageprocessor "chatty.mtran.io/internal/message_processor"
 	"chatty.mtran.io/internal/models"
 	ws "chatty.mtran.io/internal/websocket"
@@ -150,8 +152,15 @@ func setupTestApp(t *testing.T) (*application, func()) {
 	// Initialize WebSocket hub
 	messageProcessor := messageprocessor.NewMessageProcessor(chatModel, userModel, messageModel)
 	hub := ws.NewHub(messageProcessor)
-	messageProcessor.SetMessageSender(hub)
+	callbacks := bots.NewCallbackSender(hub, userModel, bots.Options{AllowPrivateNetworks: true})
+	messageProcessor.SetMessageSender(callbacks)
 	go hub.Run()
+	workerCtx, stopWorkers := context.WithCancel(context.Background())
+	go callbacks.Run(workerCtx)
+	go messageProcessor.RunEventWriter(workerCtx)
+	go messageProcessor.RunScheduledMessageDispatcher(workerCtx, 100*time.Millisecond)
+	go messageProcessor.RunMessageReaper(workerCtx, 100*time.Millisecond)
+	go messageProcessor.RunEventLogPruner(workerCtx, 100*time.Millisecond)
 
 	app := &application{
 		errorLog:    errorLog,
@@ -161,14 +170,18 @@ func setupTestApp(t *testing.T) (*application, func()) {
 		messages:    messageModel,
 		formDecoder: form.NewDecoder(),
 		hub:         hub,
+		callbacks:   callbacks,
 	}
 
 	// Cleanup function to prevent goroutine leaks
 	cleanup := func() {
+		stopWorkers()
 		// Close all client connections in the hub
-		for _, client := range hub.UserClients {
-			if client.Conn != nil {
-				client.Conn.Close()
+		for _, clients := range hub.UserClients {
+			for client := range clients {
+				if client.Conn != nil {
+					client.Conn.Close()
+				}
 			}
 		}
 		// Give the hub time to process unregistrations
@@ -245,6 +258,53 @@ func createChatSuccess(t *testing.T, conn *gorilla.Conn) string {
 	return responseData.Id
 }
 
+// sendMessageSuccess sends a message to a chat and returns the sender's copy of the response
+func sendMessageSuccess(t *testing.T, conn *gorilla.Conn, chatID string, content string) messageprocessor.SendMessageResponse {
+	t.Helper()
+
+	sendMessageRequest := fmt.Sprintf(`{
+		"type": "%s",
+		"data": {
+			"chatId": "%s",
+			"content": "%s"
+		}
+	}`, messageprocessor.SEND_MESSAGE_REQUEST, chatID, content)
+	writeMessage(t, conn, sendMessageRequest)
+
+	response := readMessage(t, conn)
+	assert.Equal(t, messageprocessor.SEND_MESSAGE_RESPONSE, response.Type)
+	assert.Empty(t, response.Error)
+
+	var responseData messageprocessor.SendMessageResponse
+	err := json.Unmarshal(response.Data, &responseData)
+	if err != nil {
+		t.Fatalf("Failed to unmarshal response data: %v", err)
+	}
+	assert.Equal(t, chatID, responseData.ChatID)
+	assert.Equal(t, content, responseData.Content)
+	return responseData
+}
+
+// getChatHistorySuccess fetches the first page of a chat's history
+func getChatHistorySuccess(t *testing.T, conn *gorilla.Conn, chatID string) messageprocessor.GetChatHistoryResponse {
+	t.Helper()
+
+	request := fmt.Sprintf(`{"type": "%s", "data": {"chatID": "%s", "limit": 50}}`,
+		messageprocessor.GET_CHAT_HISTORY_REQUEST, chatID)
+	writeMessage(t, conn, request)
+
+	response := readMessage(t, conn)
+	assert.Equal(t, messageprocessor.GET_CHAT_HISTORY_RESPONSE, response.Type)
+	assert.Empty(t, response.Error)
+
+	var responseData messageprocessor.GetChatHistoryResponse
+	err := json.Unmarshal(response.Data, &responseData)
+	if err != nil {
+		t.Fatalf("Failed to unmarshal response data: %v", err)
+	}
+	return responseData
+}
+
 func setupTestAppFull(t *testing.T) (func(), *httptest.Server, *gorilla.Conn, string) {
 	t.Helper()
 
@@ -14,7 +14,10 @@ import (
 
 type WebSocketHandler struct {
 	upgrader gorilla.Upgrader
-	app      *application
+	// botUpgrader accepts any origin. Bots aren't browsers and authenticate
+	// with a token rather than cookies, so there is nothing to forge.
+	botUpgrader gorilla.Upgrader
+	app         *application
 }
 
 func NewWebSocketHandler(clientOrigin string, app *application) *WebSocketHandler {
@@ -25,6 +28,11 @@ func NewWebSocketHandler(clientOrigin string, app *application) *WebSocketHandle
 				return r.Header.Get("Origin") == clientOrigin
 			},
 		},
+		botUpgrader: gorilla.Upgrader{
+			CheckOrigin: func(r *http.Request) bool {
+				return true
+			},
+		},
 		app: app,
 	}
 }
@@ -37,8 +45,13 @@ func (h *WebSocketHandler) Handle(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
+	upgrader := &h.upgrader
+	if isBot, _ := r.Context().Value(auth.IsBotKey).(bool); isBot {
+		upgrader = &h.botUpgrader
+	}
+
 	// Upgrade to WebSocket
-	conn, err := h.upgrader.Upgrade(w, r, nil)
+	conn, err := upgrader.Upgrade(w, r, nil)
 	if err != nil {
 		fmt.Println("Upgrade error:", err)
 		return
@@ -0,0 +1,39 @@
+package auth
+
+import (
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+)
+
+// botTokenPrefix makes bot tokens recognisable, e.g. by secret scanners
+const botTokenPrefix = "chatty_bot_"
+
+// GenerateBotToken returns a new random bot token. Bot tokens don't expire,
+// they are revoked by rotating them. Only their hash is stored, see
+// HashBotToken.
+func GenerateBotToken() (string, error) {
+	token := make([]byte, 32)
+	if _, err := rand.Read(token); err != nil {
+		return "", err
+	}
+	return botTokenPrefix + base64.RawURLEncoding.EncodeToString(token), nil
+}
+
+// HashBotToken returns the hex encoded SHA-256 hash of a bot token. Bot
+// tokens are random enough that they don't need a slow hash like passwords.
+func HashBotToken(token string) string {
+	hash := sha256.Sum256([]byte(token))
+	return hex.EncodeToString(hash[:])
+}
+
+// GenerateCallbackSecret returns a new random secret for signing the HTTP
+// callbacks of a bot
+func GenerateCallbackSecret() (string, error) {
+	secret := make([]byte, 32)
+	if _, err := rand.Read(secret); err != nil {
+		return "", err
+	}
+	return hex.EncodeToString(secret), nil
+}
@@ -3,6 +3,7 @@ package auth
 import (
 	"context"
 	"net/http"
+	"strings"
 
 	"github.com/golang-jwt/jwt/v5"
 )
@@ -13,6 +14,9 @@ type contextKey string
 const (
 	// UserIDKey is the context key for storing user ID
 	UserIDKey contextKey = "userID"
+	// IsBotKey is the context key for whether the user authenticated with a
+	// bot token
+	IsBotKey contextKey = "isBot"
 )
 
 func AuthMiddleware(next http.Handler) http.Handler {
@@ -30,3 +34,57 @@ func AuthMiddleware(next http.Handler) http.Handler {
 		next.ServeHTTP(w, r.WithContext(ctx))
 	})
 }
+
+// BotAuthenticator returns the user ID of the bot a bot token belongs to
+type BotAuthenticator func(token string) (string, error)
+
+// BotAuthMiddleware authenticates bots by the token in the
+// "Authorization: Bearer <token>" header. Bot tokens don't expire, so they
+// aren't accepted in the URL, where proxies and access logs would keep them.
+func BotAuthMiddleware(authenticate BotAuthenticator) func(http.Handler) http.Handler {
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			botToken := getBotToken(r)
+			if botToken == "" {
+				http.Error(w, "Invalid token", http.StatusUnauthorized)
+				return
+			}
+			serveBot(authenticate, botToken, next, w, r)
+		})
+	}
+}
+
+// BotOrUserAuthMiddleware authenticates bots like BotAuthMiddleware and
+// everyone else like AuthMiddleware
+func BotOrUserAuthMiddleware(authenticate BotAuthenticator) func(http.Handler) http.Handler {
+	return func(next http.Handler) http.Handler {
+		userAuth := AuthMiddleware(next)
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			botToken := getBotToken(r)
+			if botToken == "" {
+				userAuth.ServeHTTP(w, r)
+				return
+			}
+			serveBot(authenticate, botToken, next, w, r)
+		})
+	}
+}
+
+func serveBot(authenticate BotAuthenticator, botToken string, next http.Handler, w http.ResponseWriter, r *http.Request) {
+	botID, err := authenticate(botToken)
+	if err != nil {
+		http.Error(w, "Invalid token", http.StatusUnauthorized)
+		return
+	}
+
+	ctx := context.WithValue(r.Context(), UserIDKey, botID)
+	ctx = context.WithValue(ctx, IsBotKey, true)
+	next.ServeHTTP(w, r.WithContext(ctx))
+}
+
+func getBotToken(r *http.Request) string {
+	if scheme, token, found := strings.Cut(r.Header.Get("Authorization"), " "); found && strings.EqualFold(scheme, "Bearer") {
+		return strings.TrimSpace(token)
+	}
+	return ""
+}
@@ -0,0 +1,335 @@
+// Package bots delivers the events of bots that registered a callback URL
+// over HTTP, so that bots don't need to keep a WebSocket connection open.
+package bots
+
+import (
+	"bytes"
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"net"
+	"net/http"
+	"strconv"
+	"sync"
+	"time"
+
+	messageprocessor "chatty.mtran.io/internal/message_processor"
+	"chatty.mtran.io/internal/models"
+	"chatty.mtran.io/internal/netguard"
+	"github.com/google/uuid"
+)
+
+const (
+	// DefaultTimeout bounds a single callback
+	DefaultTimeout = 5 * time.Second
+	// DefaultQueueSize is how many callbacks can wait for a worker. Callbacks
+	// are dropped when the queue is full, like messages to a slow WebSocket
+	// client.
+	DefaultQueueSize = 1024
+	// DefaultWorkers is how many callbacks are made at the same time
+	DefaultWorkers = 4
+	// DefaultCacheTTL is how long the callback of a user, or that it has none,
+	// is remembered
+	DefaultCacheTTL = time.Minute
+	userAgent       = "ChattyBot/1.0 (callback)"
+
+	// SignatureHeader carries "sha256=" and the hex encoded HMAC-SHA256, keyed
+	// with the callback secret of the bot, of the timestamp, a "." and the
+	// body of a callback
+	SignatureHeader = "X-Chatty-Signature"
+	// TimestampHeader carries the Unix time a callback was signed at, so that
+	// bots can reject replayed callbacks
+	TimestampHeader = "X-Chatty-Timestamp"
+)
+
+// Options configure a CallbackSender. Zero values select the defaults.
+type Options struct {
+	Timeout   time.Duration
+	QueueSize int
+	Workers   int
+	CacheTTL  time.Duration
+	// AllowPrivateNetworks permits callbacks to loopback and private
+	// addresses. It must only be set in tests.
+	AllowPrivateNetworks bool
+}
+
+// delivery is a queued callback. A delivery without a callback is for a
+// user whose callback wasn't cached yet, the worker looks it up.
+type delivery struct {
+	userID   uuid.UUID
+	callback *models.BotCallback
+	body     []byte
+}
+
+type cachedCallback struct {
+	callback  *models.BotCallback
+	expiresAt time.Time
+}
+
+// CallbackSender sends responses to the next sender, usually the hub, and
+// also posts them to the callback URL of the recipient if it is a bot with
+// one
+type CallbackSender struct {
+	next     messageprocessor.ResponseSender
+	users    *models.UserModel
+	client   *http.Client
+	queue    chan delivery
+	workers  int
+	cacheTTL time.Duration
+
+	mu        sync.Mutex
+	callbacks map[uuid.UUID]cachedCallback
+	// botIDs are the bots with a callback URL. Only they are queued, so
+	// responses to humans never take up room in the queue.
+	botIDs map[uuid.UUID]bool
+}
+
+func NewCallbackSender(next messageprocessor.ResponseSender, users *models.UserModel, options Options) *CallbackSender {
+	if options.Timeout <= 0 {
+		options.Timeout = DefaultTimeout
+	}
+	if options.QueueSize <= 0 {
+		options.QueueSize = DefaultQueueSize
+	}
+	if options.Workers <= 0 {
+		options.Workers = DefaultWorkers
+	}
+	if options.CacheTTL <= 0 {
+		options.CacheTTL = DefaultCacheTTL
+	}
+
+	dialer := &net.Dialer{Timeout: options.Timeout}
+	if !options.AllowPrivateNetworks {
+		dialer.Control = netguard.Control
+	}
+
+	transport := &http.Transport{
+		DialContext:           dialer.DialContext,
+		TLSHandshakeTimeout:   options.Timeout,
+		ResponseHeaderTimeout: options.Timeout,
+		MaxIdleConns:          10,
+		IdleConnTimeout:       30 * time.Second,
+	}
+
+	return &CallbackSender{
+		next:  next,
+		users: users,
+		client: &http.Client{
+			Transport: transport,
+			Timeout:   options.Timeout,
+			// a redirect would resend the event somewhere the bot's owner
+			// didn't register
+			CheckRedirect: func(req *http.Request, via []*http.Request) error {
+				return http.ErrUseLastResponse
+			},
+		},
+		queue:     make(chan delivery, options.QueueSize),
+		workers:   options.Workers,
+		cacheTTL:  options.CacheTTL,
+		callbacks: make(map[uuid.UUID]cachedCallback),
+		botIDs:    make(map[uuid.UUID]bool),
+	}
+}
+
+// AddBot makes responses to a bot that was just created with a callback URL
+// be posted to it, without waiting for the next refresh of the bots
+func (s *CallbackSender) AddBot(botID uuid.UUID) {
+	s.mu.Lock()
+	s.botIDs[botID] = true
+	s.mu.Unlock()
+}
+
+// SendToUser sends a response to the next sender and queues a callback if
+// userID is a bot with a callback URL. It is safe to call from any goroutine
+// and doesn't block on the database, so it can be called from the hub.
+func (s *CallbackSender) SendToUser(userID uuid.UUID, response *messageprocessor.Response) {
+	s.next.SendToUser(userID, response)
+	s.queueCallback(userID, response)
+}
+
+// RequestSession asks the next sender for the session of the current
+// request
+func (s *CallbackSender) RequestSession() any {
+	if next, ok := s.next.(messageprocessor.SessionSender); ok {
+		return next.RequestSession()
+	}
+	return nil
+}
+
+// SendToOtherSessions passes the response on to the next sender. Callbacks
+// already receive the replies to requests, so none is made.
+func (s *CallbackSender) SendToOtherSessions(userID uuid.UUID, response *messageprocessor.Response) {
+	if next, ok := s.next.(messageprocessor.SessionSender); ok {
+		next.SendToOtherSessions(userID, response)
+	}
+}
+
+// queueCallback queues a callback if userID is a bot with a callback URL.
+// Bots whose callback isn't cached are queued too, and looked up by a worker.
+func (s *CallbackSender) queueCallback(userID uuid.UUID, response *messageprocessor.Response) {
+	s.mu.Lock()
+	isBot := s.botIDs[userID]
+	s.mu.Unlock()
+	if !isBot {
+		return
+	}
+	callback, cached := s.getCachedCallback(userID)
+	if cached && callback == nil {
+		return
+	}
+	body, err := json.Marshal(response)
+	if err != nil {
+		log.Printf("Error serializing callback: %v", err)
+		return
+	}
+	select {
+	case s.queue <- delivery{userID: userID, callback: callback, body: body}:
+	default:
+		log.Printf("Failed to send callback to bot %s: queue full", userID)
+	}
+}
+
+// Run makes the queued callbacks until ctx is done. The bots with a callback
+// URL are loaded first, and refreshed every cache TTL.
+func (s *CallbackSender) Run(ctx context.Context) {
+	s.loadBots()
+
+	var wg sync.WaitGroup
+	for range s.workers {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for {
+				select {
+				case <-ctx.Done():
+					return
+				case d := <-s.queue:
+					if d.callback == nil {
+						if d.callback = s.getCallback(d.userID); d.callback == nil {
+							continue
+						}
+					}
+					if err := s.post(ctx, d); err != nil {
+						log.Printf("Error sending callback to %s: %v", d.callback.URL, err)
+					}
+				}
+			}
+		}()
+	}
+
+	ticker := time.NewTicker(s.cacheTTL)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			wg.Wait()
+			return
+		case <-ticker.C:
+			s.pruneCache()
+			s.loadBots()
+		}
+	}
+}
+
+// getCachedCallback returns the cached callback of a user, and whether there
+// is an unexpired one. The callback is nil if the user isn't a bot with one.
+func (s *CallbackSender) getCachedCallback(userID uuid.UUID) (*models.BotCallback, bool) {
+	s.mu.Lock()
+	cached, ok := s.callbacks[userID]
+	s.mu.Unlock()
+	if ok && time.Now().Before(cached.expiresAt) {
+		return cached.callback, true
+	}
+	return nil, false
+}
+
+// getCallback returns the callback of a user, or nil if the user isn't a bot
+// with one. Users that aren't cached are looked up in the database.
+func (s *CallbackSender) getCallback(userID uuid.UUID) *models.BotCallback {
+	if callback, ok := s.getCachedCallback(userID); ok {
+		return callback
+	}
+
+	callback, err := s.users.GetBotCallback(userID)
+	if err != nil {
+		if !errors.Is(err, models.ErrNoRecord) {
+			// don't remember failures, the next response tries again
+			log.Printf("Error getting bot callback: %v", err)
+			return nil
+		}
+		callback = nil
+	}
+
+	s.mu.Lock()
+	s.callbacks[userID] = cachedCallback{
+		callback:  callback,
+		expiresAt: time.Now().Add(s.cacheTTL),
+	}
+	s.mu.Unlock()
+	return callback
+}
+
+// loadBots adds the bots with a callback URL to botIDs. Callback URLs can't
+// be removed, so bots are never taken out.
+func (s *CallbackSender) loadBots() {
+	botIDs, err := s.users.GetCallbackBotIDs()
+	if err != nil {
+		log.Printf("Error getting bots: %v", err)
+		return
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	for _, botID := range botIDs {
+		s.botIDs[botID] = true
+	}
+}
+
+func (s *CallbackSender) pruneCache() {
+	now := time.Now()
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	for userID, cached := range s.callbacks {
+		if !now.Before(cached.expiresAt) {
+			delete(s.callbacks, userID)
+		}
+	}
+}
+
+func (s *CallbackSender) post(ctx context.Context, d delivery) error {
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, d.callback.URL, bytes.NewReader(d.body))
+	if err != nil {
+		return err
+	}
+	timestamp := strconv.FormatInt(time.Now().Unix(), 10)
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set("User-Agent", userAgent)
+	req.Header.Set(TimestampHeader, timestamp)
+	req.Header.Set(SignatureHeader, Sign(d.callback.Secret, timestamp, d.body))
+
+	resp, err := s.client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	io.Copy(io.Discard, io.LimitReader(resp.Body, 4<<10))
+
+	if resp.StatusCode < 200 || resp.StatusCode > 299 {
+		return fmt.Errorf("bots: unexpected status %d", resp.StatusCode)
+	}
+	return nil
+}
+
+// Sign returns the value of the SignatureHeader of a callback
+func Sign(secret, timestamp string, body []byte) string {
+	mac := hmac.New(sha256.New, []byte(secret))
+	mac.Write([]byte(timestamp))
+	mac.Write([]byte("."))
+	mac.Write(body)
+	return "sha256=" + hex.EncodeToString(mac.Sum(nil))
+}
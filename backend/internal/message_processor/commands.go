@@ -0,0 +1,329 @@
+package messageprocessor
+
+import (
+	"errors"
+	"fmt"
+	"log"
+	"sort"
+	"strings"
+	"time"
+	"unicode"
+
+	"chatty.mtran.io/internal/models"
+	"chatty.mtran.io/internal/validator"
+	"github.com/google/uuid"
+)
+
+const (
+	// maxChatNameLength matches chats.name
+	maxChatNameLength = 255
+	// maxChatTopicLength matches chats.topic
+	maxChatTopicLength = 255
+	// maxMuteDuration is the longest mute with an end, longer mutes are
+	// meant to have none
+	maxMuteDuration = 365 * 24 * time.Hour
+)
+
+// CommandHandler runs a slash command. The returned text is replied to the
+// sender only; a returned error is replied to the sender as the error of a
+// CommandResponse, so it should be one of the errors of this package.
+type CommandHandler func(mp *MessageProcessor, invocation *CommandInvocation) (string, error)
+
+// CommandInvocation is a slash command sent to a chat by one of its members.
+// Args is the text after the command name.
+type CommandInvocation struct {
+	Name     string
+	Args     string
+	SenderID uuid.UUID
+	ChatID   uuid.UUID
+}
+
+type command struct {
+	usage       string
+	description string
+	handler     CommandHandler
+}
+
+// RegisterCommand adds a slash command, replacing the command of the same
+// name. usage describes the arguments of the command for /help.
+func (mp *MessageProcessor) RegisterCommand(name, usage, description string, handler CommandHandler) {
+	mp.commands[strings.ToLower(name)] = &command{
+		usage:       usage,
+		description: description,
+		handler:     handler,
+	}
+}
+
+func builtinCommands() map[string]*command {
+	return map[string]*command{
+		"help": {
+			description: "List the available commands",
+			handler:     (*MessageProcessor).helpCommand,
+		},
+		"invite": {
+			usage:       "<email>...",
+			description: "Add users to the chat",
+			handler:     (*MessageProcessor).inviteCommand,
+		},
+		"rename": {
+			usage:       "<name>",
+			description: "Rename the chat",
+			handler:     (*MessageProcessor).renameCommand,
+		},
+		"leave": {
+			description: "Leave the chat",
+			handler:     (*MessageProcessor).leaveCommand,
+		},
+		"topic": {
+			usage:       "[topic]",
+			description: "Set the topic of the chat, or clear it",
+			handler:     (*MessageProcessor).topicCommand,
+		},
+		"mute": {
+			usage:       "[duration]",
+			description: "Stop mention notifications from the chat, e.g. for 8h",
+			handler:     (*MessageProcessor).muteCommand,
+		},
+		"unmute": {
+			description: "Resume mention notifications from the chat",
+			handler:     (*MessageProcessor).unmuteCommand,
+		},
+	}
+}
+
+// runCommand runs the slash command in content, which starts with "/", and
+// replies to the sender with its result
+func (mp *MessageProcessor) runCommand(senderId uuid.UUID, requestId string, chatId uuid.UUID, content string) {
+	name, args := strings.TrimPrefix(content, "/"), ""
+	if i := strings.IndexFunc(name, unicode.IsSpace); i >= 0 {
+		name, args = name[:i], name[i:]
+	}
+	invocation := &CommandInvocation{
+		Name:     strings.ToLower(name),
+		Args:     strings.TrimSpace(args),
+		SenderID: senderId,
+		ChatID:   chatId,
+	}
+
+	cmd, ok := mp.commands[invocation.Name]
+	if !ok {
+		mp.sendError(senderId, requestId, COMMAND_RESPONSE, ErrUnknownCommand)
+		return
+	}
+
+	isMember, err := mp.ChatModel.IsChatMember(chatId, senderId)
+	if err != nil {
+		log.Printf("Error checking chat membership: %v", err)
+		mp.sendError(senderId, requestId, COMMAND_RESPONSE, ErrCannotRunCommand)
+		return
+	}
+	if !isMember {
+		mp.sendError(senderId, requestId, COMMAND_RESPONSE, ErrNotChatMember)
+		return
+	}
+
+	text, err := cmd.handler(mp, invocation)
+	if err != nil {
+		mp.sendError(senderId, requestId, COMMAND_RESPONSE, err)
+		return
+	}
+
+	responseData := CommandResponse{
+		ChatID:  chatId.String(),
+		Command: invocation.Name,
+		Text:    text,
+	}
+	responseMessage := &Response{
+		Type:  COMMAND_RESPONSE,
+		Data:  getJsonRawMessage(responseData),
+		Error: "",
+	}
+	mp.reply(senderId, requestId, responseMessage)
+}
+
+// sendChatUpdatedEvent tells the members of a chat about its current name,
+// topic and members
+func (mp *MessageProcessor) sendChatUpdatedEvent(chatId, updatedBy uuid.UUID) {
+	chat, err := mp.ChatModel.GetChat(chatId)
+	if err != nil || chat == nil {
+		log.Printf("Error getting chat: %v", err)
+		return
+	}
+	members, err := mp.ChatModel.GetChatMembers(chatId)
+	if err != nil {
+		log.Printf("Error getting chat members: %v", err)
+		return
+	}
+
+	responseData := ChatUpdatedEvent{
+		ChatID:    chatId.String(),
+		Name:      chat.Name,
+		Topic:     chat.Topic,
+		UserInfos: make([]UserInfo, 0, len(members)),
+		UpdatedBy: updatedBy.String(),
+	}
+	memberIds := make([]uuid.UUID, 0, len(members))
+	for _, member := range members {
+		memberIds = append(memberIds, member.ID)
+		responseData.UserInfos = append(responseData.UserInfos, UserInfo{
+			ID:    member.ID.String(),
+			Email: member.Email,
+			Name:  member.Name,
+		})
+	}
+	responseMessage := &Response{
+		Type:  CHAT_UPDATED_EVENT,
+		Data:  getJsonRawMessage(responseData),
+		Error: "",
+	}
+	mp.sendEvent(memberIds, responseMessage)
+}
+
+func (mp *MessageProcessor) helpCommand(invocation *CommandInvocation) (string, error) {
+	names := make([]string, 0, len(mp.commands))
+	for name := range mp.commands {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	lines := make([]string, 0, len(names))
+	for _, name := range names {
+		cmd := mp.commands[name]
+		usage := "/" + name
+		if cmd.usage != "" {
+			usage += " " + cmd.usage
+		}
+		lines = append(lines, fmt.Sprintf("%s - %s", usage, cmd.description))
+	}
+	return strings.Join(lines, "\n"), nil
+}
+
+func (mp *MessageProcessor) inviteCommand(invocation *CommandInvocation) (string, error) {
+	emails := strings.FieldsFunc(invocation.Args, func(r rune) bool {
+		return r == ' ' || r == ','
+	})
+	if len(emails) == 0 {
+		return "", ErrInvalidCommandArgs
+	}
+	seen := make(map[string]bool, len(emails))
+	uniqueEmails := make([]string, 0, len(emails))
+	for _, email := range emails {
+		if !seen[email] {
+			seen[email] = true
+			uniqueEmails = append(uniqueEmails, email)
+		}
+	}
+
+	userInfos, err := mp.UserModel.UserInfosByEmails(uniqueEmails)
+	if errors.Is(err, models.ErrUserDoesNotExist) {
+		return "", ErrUserDoesNotExist
+	}
+	if err != nil {
+		log.Printf("Error getting user infos: %v", err)
+		return "", ErrCannotAddParticipantsToChat
+	}
+	userIds := make([]uuid.UUID, 0, len(userInfos))
+	names := make([]string, 0, len(userInfos))
+	for _, userInfo := range userInfos {
+		userIds = append(userIds, userInfo.ID)
+		names = append(names, userInfo.Name)
+	}
+
+	err = mp.ChatModel.AddUsersToChat(invocation.ChatID, userIds)
+	if err != nil {
+		log.Printf("Error adding users to chat: %v", err)
+		return "", ErrCannotAddParticipantsToChat
+	}
+	mp.forgetChatMembers(invocation.ChatID)
+
+	mp.sendChatUpdatedEvent(invocation.ChatID, invocation.SenderID)
+	return "Invited " + strings.Join(names, ", "), nil
+}
+
+func (mp *MessageProcessor) renameCommand(invocation *CommandInvocation) (string, error) {
+	name := invocation.Args
+	if !validator.NotBlank(name) || !validator.MaxChars(name, maxChatNameLength) {
+		return "", ErrInvalidChatName
+	}
+
+	err := mp.ChatModel.RenameChat(invocation.ChatID, name)
+	if err != nil {
+		log.Printf("Error renaming chat: %v", err)
+		return "", ErrCannotRunCommand
+	}
+
+	mp.sendChatUpdatedEvent(invocation.ChatID, invocation.SenderID)
+	return "Renamed the chat to " + name, nil
+}
+
+func (mp *MessageProcessor) leaveCommand(invocation *CommandInvocation) (string, error) {
+	if invocation.Args != "" {
+		return "", ErrInvalidCommandArgs
+	}
+
+	_, err := mp.ChatModel.RemoveUserFromChat(invocation.ChatID, invocation.SenderID)
+	if err != nil {
+		log.Printf("Error removing user from chat: %v", err)
+		return "", ErrCannotRunCommand
+	}
+	mp.forgetChatMembers(invocation.ChatID)
+	mp.stopTyping(invocation.ChatID, invocation.SenderID)
+
+	mp.sendChatUpdatedEvent(invocation.ChatID, invocation.SenderID)
+	return "You left the chat", nil
+}
+
+func (mp *MessageProcessor) topicCommand(invocation *CommandInvocation) (string, error) {
+	topic := invocation.Args
+	if !validator.MaxChars(topic, maxChatTopicLength) {
+		return "", ErrInvalidChatTopic
+	}
+
+	err := mp.ChatModel.SetChatTopic(invocation.ChatID, topic)
+	if err != nil {
+		log.Printf("Error setting chat topic: %v", err)
+		return "", ErrCannotRunCommand
+	}
+
+	mp.sendChatUpdatedEvent(invocation.ChatID, invocation.SenderID)
+	if topic == "" {
+		return "Cleared the topic", nil
+	}
+	return "Set the topic to " + topic, nil
+}
+
+func (mp *MessageProcessor) muteCommand(invocation *CommandInvocation) (string, error) {
+	var until *time.Time
+	if invocation.Args != "" {
+		duration, err := time.ParseDuration(invocation.Args)
+		if err != nil || duration <= 0 || duration > maxMuteDuration {
+			return "", ErrInvalidCommandArgs
+		}
+		mutedUntil := time.Now().UTC().Add(duration)
+		until = &mutedUntil
+	}
+
+	err := mp.ChatModel.SetChatMuted(invocation.ChatID, invocation.SenderID, true, until)
+	if err != nil {
+		log.Printf("Error muting chat: %v", err)
+		return "", ErrCannotRunCommand
+	}
+
+	if until == nil {
+		return "Muted the chat until you unmute it", nil
+	}
+	return "Muted the chat until " + formatTimestamp(*until), nil
+}
+
+func (mp *MessageProcessor) unmuteCommand(invocation *CommandInvocation) (string, error) {
+	if invocation.Args != "" {
+		return "", ErrInvalidCommandArgs
+	}
+
+	err := mp.ChatModel.SetChatMuted(invocation.ChatID, invocation.SenderID, false, nil)
+	if err != nil {
+		log.Printf("Error unmuting chat: %v", err)
+		return "", ErrCannotRunCommand
+	}
+	return "Unmuted the chat", nil
+}
@@ -0,0 +1,148 @@
+package messageprocessor
+
+import (
+	"bytes"
+	"encoding/json"
+	"html"
+	"regexp"
+	"strings"
+
+	"chatty.mtran.io/internal/validator"
+)
+
+// maxCodeLanguageLength is the longest language name accepted in code content
+const maxCodeLanguageLength = 32
+
+// allowedMarkdownTags are the inline HTML tags that survive Markdown
+// sanitization. Their attributes are always dropped.
+var allowedMarkdownTags = map[string]bool{
+	"b": true, "br": true, "code": true, "del": true, "em": true, "i": true,
+	"kbd": true, "s": true, "strong": true, "sub": true, "sup": true,
+}
+
+// allowedURLSchemes are the schemes links and images may point to.
+// Links without a scheme are relative and always allowed.
+var allowedURLSchemes = map[string]bool{
+	"http": true, "https": true, "mailto": true,
+}
+
+var (
+	// the destination of an inline link or image, e.g. [text](destination),
+	// and of a link reference definition, e.g. [id]: destination. Neither is
+	// anchored to the start of a line, so that definitions in blockquotes and
+	// list items are caught, and destinations may follow on the next line,
+	// after the blockquote markers of that line.
+	markdownLinkRX           = regexp.MustCompile(`(\]\([\s>]*)(<[^<>\n]*>|[^\s()<>]+)`)
+	markdownLinkDefinitionRX = regexp.MustCompile(`(\]:[\s>]*)(<[^<>\n]*>|\S+)`)
+	markdownAutolinkRX       = regexp.MustCompile(`<([a-zA-Z][a-zA-Z0-9+.-]*:[^\s<>]*)>`)
+	markdownTagRX            = regexp.MustCompile(`</?([a-zA-Z][a-zA-Z0-9-]*)(?:\s[^<>]*)?/?>`)
+	// comments, processing instructions, declarations and CDATA sections
+	markdownSpecialTagRX = regexp.MustCompile(`<[!?]`)
+	urlSchemeRX          = regexp.MustCompile(`^([a-zA-Z][a-zA-Z0-9+.-]*):`)
+)
+
+// normalizeContent validates the content of a message of the given type and
+// returns it in the form it is stored in
+func normalizeContent(contentType, content string) (string, error) {
+	switch contentType {
+	case CONTENT_TYPE_PLAIN:
+		return content, nil
+	case CONTENT_TYPE_MARKDOWN:
+		return sanitizeMarkdown(content), nil
+	case CONTENT_TYPE_CODE:
+		return normalizeCodeContent(content)
+	default:
+		return "", ErrInvalidContentType
+	}
+}
+
+// normalizeCodeContent checks that content is a CodeContent document and
+// returns it compacted
+func normalizeCodeContent(content string) (string, error) {
+	var code CodeContent
+
+	decoder := json.NewDecoder(strings.NewReader(content))
+	decoder.DisallowUnknownFields()
+	if err := decoder.Decode(&code); err != nil || decoder.More() {
+		return "", ErrInvalidCodeContent
+	}
+	if !validator.NotBlank(code.Code) || !validator.MaxChars(code.Language, maxCodeLanguageLength) {
+		return "", ErrInvalidCodeContent
+	}
+
+	var buf bytes.Buffer
+	encoder := json.NewEncoder(&buf)
+	encoder.SetEscapeHTML(false)
+	if err := encoder.Encode(code); err != nil {
+		return "", ErrInvalidCodeContent
+	}
+	return strings.TrimSuffix(buf.String(), "\n"), nil
+}
+
+// sanitizeMarkdown neutralises raw HTML and dangerous links in Markdown.
+// HTML tags outside of allowedMarkdownTags are escaped so that they are shown
+// as text, and link destinations with a scheme outside of allowedURLSchemes
+// are removed.
+//
+// Code blocks and code spans are sanitized like the rest of the content.
+// Raw HTML takes precedence over code spans, and whether a line opens a fence
+// depends on the blocks around it, so code can't be told apart reliably
+// without a full CommonMark parser. Tags in code are shown escaped; code
+// should be sent as CONTENT_TYPE_CODE. For the same reason destinations are
+// looked for wherever they might be, and text that merely looks like one
+// loses a disallowed URL too.
+func sanitizeMarkdown(text string) string {
+	text = markdownLinkRX.ReplaceAllStringFunc(text, func(link string) string {
+		match := markdownLinkRX.FindStringSubmatch(link)
+		if allowedURL(match[2]) {
+			return link
+		}
+		return match[1]
+	})
+	text = markdownLinkDefinitionRX.ReplaceAllStringFunc(text, func(definition string) string {
+		match := markdownLinkDefinitionRX.FindStringSubmatch(definition)
+		if allowedURL(match[2]) {
+			return definition
+		}
+		return match[1]
+	})
+	text = markdownAutolinkRX.ReplaceAllStringFunc(text, func(autolink string) string {
+		if allowedURL(autolink) {
+			return autolink
+		}
+		return "&lt;" + autolink[1:]
+	})
+	text = markdownTagRX.ReplaceAllStringFunc(text, func(tag string) string {
+		name := strings.ToLower(markdownTagRX.FindStringSubmatch(tag)[1])
+		if !allowedMarkdownTags[name] {
+			return "&lt;" + tag[1:]
+		}
+		if strings.HasPrefix(tag, "</") {
+			return "</" + name + ">"
+		}
+		return "<" + name + ">"
+	})
+	return markdownSpecialTagRX.ReplaceAllStringFunc(text, func(tag string) string {
+		return "&lt;" + tag[1:]
+	})
+}
+
+// allowedURL reports whether a link destination is relative or uses one of
+// allowedURLSchemes. The destination is normalised the way browsers and
+// Markdown renderers do it, so that e.g. entity encoded schemes are caught.
+func allowedURL(destination string) bool {
+	destination = strings.TrimSuffix(strings.TrimPrefix(destination, "<"), ">")
+	destination = html.UnescapeString(strings.ReplaceAll(destination, `\`, ""))
+	destination = strings.Map(func(r rune) rune {
+		if r <= ' ' || r == 0x7f {
+			return -1
+		}
+		return r
+	}, destination)
+
+	match := urlSchemeRX.FindStringSubmatch(destination)
+	if match == nil {
+		return true
+	}
+	return allowedURLSchemes[strings.ToLower(match[1])]
+}
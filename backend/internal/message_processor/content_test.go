@@ -0,0 +1,71 @@
+package messageprocessor
+
+import "testing"
+
+func TestSanitizeMarkdown(t *testing.T) {
+	tests := []struct {
+		name    string
+		content string
+		want    string
+	}{
+		{
+			name:    "allowed tags lose their attributes",
+			content: `**hi** <b class="x">there</b>`,
+			want:    `**hi** <b>there</b>`,
+		},
+		{
+			name:    "other tags are escaped",
+			content: `<script>alert(1)</script>`,
+			want:    `&lt;script>alert(1)&lt;/script>`,
+		},
+		{
+			name:    "dangerous link destinations are removed",
+			content: `[a](javascript:alert) [b](https://example.com)`,
+			want:    `[a]() [b](https://example.com)`,
+		},
+		{
+			// raw HTML takes precedence over the code span inside it
+			name:    "tag containing a code span",
+			content: "<img src=\"`x`\" onerror=alert(1)>",
+			want:    "&lt;img src=\"`x`\" onerror=alert(1)>",
+		},
+		{
+			// a backtick in the info string means this isn't a fence
+			name:    "fence with a backtick in its info string",
+			content: "```foo`bar\n<img src=x onerror=alert(1)>",
+			want:    "```foo`bar\n&lt;img src=x onerror=alert(1)>",
+		},
+		{
+			name:    "link definition in a blockquote",
+			content: "> [x]: javascript:alert(1)\n> [a][x]",
+			want:    "> [x]: \n> [a][x]",
+		},
+		{
+			name:    "link definition in a list item",
+			content: "- [x]: javascript:alert(1)\n[a][x]",
+			want:    "- [x]: \n[a][x]",
+		},
+		{
+			name:    "link definition with the destination on the next line",
+			content: "[x]:\njavascript:alert(1)\n\n[a][x]",
+			want:    "[x]:\n\n\n[a][x]",
+		},
+		{
+			name:    "inline link continued in a blockquote",
+			content: "> [a](\n> javascript:alert(1))",
+			want:    "> [a](\n> (1))",
+		},
+		{
+			name:    "tags in code are escaped too",
+			content: "```\n<div>\n```\n`<i>` `<em class=\"x\">`",
+			want:    "```\n&lt;div>\n```\n`<i>` `<em>`",
+		},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			if got := sanitizeMarkdown(test.content); got != test.want {
+				t.Errorf("sanitizeMarkdown(%q) = %q, want %q", test.content, got, test.want)
+			}
+		})
+	}
+}
@@ -0,0 +1,119 @@
+package messageprocessor
+
+import (
+	"context"
+	"log"
+	"time"
+
+	"chatty.mtran.io/internal/models"
+	"github.com/google/uuid"
+)
+
+const (
+	// maxMessageTTL is the longest message TTL a chat can have, one year
+	maxMessageTTL = 365 * 24 * 60 * 60
+	// reaperBatchSize is how many expired messages are deleted at once
+	reaperBatchSize = 500
+)
+
+func (mp *MessageProcessor) handleSetMessageTTLRequest(senderId uuid.UUID, requestId string, reqData SetMessageTTLRequest) {
+	chatId, err := uuid.Parse(reqData.ChatID)
+	if err != nil {
+		mp.sendError(senderId, requestId, SET_MESSAGE_TTL_RESPONSE, ErrInvalidChatID)
+		return
+	}
+	ttl := reqData.MessageTTL
+	if ttl != nil && *ttl == 0 {
+		ttl = nil
+	}
+	if ttl != nil && (*ttl < 0 || *ttl > maxMessageTTL) {
+		mp.sendError(senderId, requestId, SET_MESSAGE_TTL_RESPONSE, ErrInvalidMessageTTL)
+		return
+	}
+
+	isMember, err := mp.ChatModel.IsChatMember(chatId, senderId)
+	if err != nil {
+		log.Printf("Error checking chat membership: %v", err)
+		mp.sendError(senderId, requestId, SET_MESSAGE_TTL_RESPONSE, ErrCannotSetMessageTTL)
+		return
+	}
+	if !isMember {
+		mp.sendError(senderId, requestId, SET_MESSAGE_TTL_RESPONSE, ErrNotChatMember)
+		return
+	}
+
+	err = mp.ChatModel.SetMessageTTL(chatId, ttl)
+	if err != nil {
+		log.Printf("Error setting message ttl: %v", err)
+		mp.sendError(senderId, requestId, SET_MESSAGE_TTL_RESPONSE, ErrCannotSetMessageTTL)
+		return
+	}
+
+	responseData := SetMessageTTLResponse{
+		ChatID:     chatId.String(),
+		MessageTTL: ttl,
+		UpdatedBy:  senderId.String(),
+	}
+	responseMessage := &Response{
+		Type:  SET_MESSAGE_TTL_RESPONSE,
+		Data:  getJsonRawMessage(responseData),
+		Error: "",
+	}
+	mp.sendToChatMembers(chatId, senderId, requestId, responseMessage)
+}
+
+// RunMessageReaper permanently deletes messages that outlived the message
+// TTL of their chat. It looks for expired messages every interval until ctx
+// is done.
+func (mp *MessageProcessor) RunMessageReaper(ctx context.Context, interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			mp.reapExpiredMessages()
+		}
+	}
+}
+
+// reapExpiredMessages deletes all expired messages and tells the members of
+// their chats which messages are gone
+func (mp *MessageProcessor) reapExpiredMessages() {
+	for {
+		messages, err := mp.MessageModel.DeleteExpiredMessages(reaperBatchSize)
+		if err != nil {
+			log.Printf("Error deleting expired messages: %v", err)
+			return
+		}
+		if len(messages) == 0 {
+			return
+		}
+
+		chatToMessages := make(map[uuid.UUID][]*models.Message)
+		for _, message := range messages {
+			chatToMessages[message.ChatID] = append(chatToMessages[message.ChatID], message)
+		}
+		for chatId, chatMessages := range chatToMessages {
+			responseData := MessagesExpiredEvent{
+				ChatID:     chatId.String(),
+				MessageIDs: make([]string, 0, len(chatMessages)),
+			}
+			for _, message := range chatMessages {
+				responseData.MessageIDs = append(responseData.MessageIDs, message.ID.String())
+			}
+			responseMessage := &Response{
+				Type:  MESSAGES_EXPIRED_EVENT,
+				Data:  getJsonRawMessage(responseData),
+				Error: "",
+			}
+			mp.sendEventToChatMembers(chatId, responseMessage)
+		}
+
+		if len(messages) < reaperBatchSize {
+			return
+		}
+	}
+}
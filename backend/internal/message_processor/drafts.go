@@ -0,0 +1,143 @@
+package messageprocessor
+
+import (
+	"log"
+	"time"
+
+	"chatty.mtran.io/internal/models"
+	"chatty.mtran.io/internal/validator"
+	"github.com/google/uuid"
+)
+
+// maxDraftLength is the longest draft accepted
+const maxDraftLength = 10000
+
+func (mp *MessageProcessor) handleSaveDraftRequest(senderId uuid.UUID, requestId string, reqData SaveDraftRequest) {
+	chatId, err := uuid.Parse(reqData.ChatID)
+	if err != nil {
+		mp.sendError(senderId, requestId, SAVE_DRAFT_RESPONSE, ErrInvalidChatID)
+		return
+	}
+	if !validator.MaxChars(reqData.Content, maxDraftLength) {
+		mp.sendError(senderId, requestId, SAVE_DRAFT_RESPONSE, ErrDraftTooLong)
+		return
+	}
+
+	isMember, err := mp.ChatModel.IsChatMember(chatId, senderId)
+	if err != nil {
+		log.Printf("Error checking chat membership: %v", err)
+		mp.sendError(senderId, requestId, SAVE_DRAFT_RESPONSE, ErrCannotSaveDraft)
+		return
+	}
+	if !isMember {
+		mp.sendError(senderId, requestId, SAVE_DRAFT_RESPONSE, ErrNotChatMember)
+		return
+	}
+
+	var parentId *uuid.UUID
+	if reqData.ParentMessageID != nil {
+		parentId, err = mp.resolveThreadRoot(chatId, *reqData.ParentMessageID)
+		if err != nil {
+			mp.sendError(senderId, requestId, SAVE_DRAFT_RESPONSE, err)
+			return
+		}
+	}
+
+	draft := &models.Draft{
+		ChatID:          chatId,
+		ParentMessageID: parentId,
+	}
+	if validator.NotBlank(reqData.Content) {
+		draft.Content = reqData.Content
+		err = mp.MessageModel.SaveDraft(senderId, draft)
+	} else {
+		draft.UpdatedAt = time.Now().UTC()
+		_, err = mp.MessageModel.DeleteDraft(senderId, chatId, parentId)
+	}
+	if err != nil {
+		log.Printf("Error saving draft: %v", err)
+		mp.sendError(senderId, requestId, SAVE_DRAFT_RESPONSE, ErrCannotSaveDraft)
+		return
+	}
+
+	responseMessage := &Response{
+		Type:  SAVE_DRAFT_RESPONSE,
+		Data:  getJsonRawMessage(SaveDraftResponse(draftConvert(*draft))),
+		Error: "",
+	}
+	mp.reply(senderId, requestId, responseMessage)
+	mp.sendDraftUpdatedEvent(senderId, *draft)
+}
+
+func (mp *MessageProcessor) handleGetDraftsRequest(senderId uuid.UUID, requestId string, reqData GetDraftsRequest) {
+	var chatId *uuid.UUID
+	if reqData.ChatID != nil {
+		id, err := uuid.Parse(*reqData.ChatID)
+		if err != nil {
+			mp.sendError(senderId, requestId, GET_DRAFTS_RESPONSE, ErrInvalidChatID)
+			return
+		}
+		chatId = &id
+	}
+
+	modelDrafts, err := mp.MessageModel.GetDrafts(senderId, chatId)
+	if err != nil {
+		log.Printf("Error getting drafts: %v", err)
+		mp.sendError(senderId, requestId, GET_DRAFTS_RESPONSE, ErrCannotGetDrafts)
+		return
+	}
+
+	responseData := GetDraftsResponse{
+		Drafts: make([]Draft, 0, len(modelDrafts)),
+	}
+	for _, draft := range modelDrafts {
+		responseData.Drafts = append(responseData.Drafts, draftConvert(*draft))
+	}
+	responseMessage := &Response{
+		Type:  GET_DRAFTS_RESPONSE,
+		Data:  getJsonRawMessage(responseData),
+		Error: "",
+	}
+	mp.reply(senderId, requestId, responseMessage)
+}
+
+// deleteDraft deletes the draft of a chat or thread once the user sent a
+// message there, and tells the user's other sessions
+func (mp *MessageProcessor) deleteDraft(userId, chatId uuid.UUID, parentId *uuid.UUID) {
+	deleted, err := mp.MessageModel.DeleteDraft(userId, chatId, parentId)
+	if err != nil {
+		log.Printf("Error deleting draft: %v", err)
+		return
+	}
+	if deleted {
+		mp.sendDraftUpdatedEvent(userId, models.Draft{
+			ChatID:          chatId,
+			ParentMessageID: parentId,
+			UpdatedAt:       time.Now().UTC(),
+		})
+	}
+}
+
+// sendDraftUpdatedEvent sends a changed draft to the user's sessions other
+// than the one that changed it
+func (mp *MessageProcessor) sendDraftUpdatedEvent(userId uuid.UUID, draft models.Draft) {
+	responseMessage := &Response{
+		Type:  DRAFT_UPDATED_EVENT,
+		Data:  getJsonRawMessage(DraftUpdatedEvent(draftConvert(draft))),
+		Error: "",
+	}
+	mp.sendToOtherSessions(userId, responseMessage)
+}
+
+func draftConvert(draft models.Draft) Draft {
+	convertedDraft := Draft{
+		ChatID:    draft.ChatID.String(),
+		Content:   draft.Content,
+		UpdatedAt: formatTimestamp(draft.UpdatedAt),
+	}
+	if draft.ParentMessageID != nil {
+		parentMessageId := draft.ParentMessageID.String()
+		convertedDraft.ParentMessageID = &parentMessageId
+	}
+	return convertedDraft
+}
@@ -6,10 +6,78 @@ import (
 
 var (
 	ErrUnknownRequestType = errors.New("messageprocessor: unknown request type")
+	ErrInvalidRequestData = errors.New("messageprocessor: invalid request data")
 	ErrCannotCreateChatWithLessThan2Participants = errors.New("messageprocessor: cannot create a chat with less than 2 participants")
 	ErrUserDoesNotExist = errors.New("messageprocessor: user does not exist")
 	ErrCannotCreateChat = errors.New("messageprocessor: cannot create chat")
 	ErrCannotAddParticipantsToChat = errors.New("messageprocessor: cannot add participants to chat")
 	ErrCannotGetChat = errors.New("messageprocessor: cannot get chat")
 	ErrCannotSaveMessage = errors.New("messageprocessor: cannot save message")
+	ErrInvalidChatID = errors.New("messageprocessor: invalid chat id")
+	ErrInvalidCursor = errors.New("messageprocessor: invalid cursor")
+	ErrNotChatMember = errors.New("messageprocessor: user is not a member of this chat")
+	ErrCannotGetChatHistory = errors.New("messageprocessor: cannot get chat history")
+	ErrInvalidMessageID = errors.New("messageprocessor: invalid message id")
+	ErrEmptyMessageContent = errors.New("messageprocessor: message content cannot be empty")
+	ErrMessageNotFound = errors.New("messageprocessor: message not found")
+	ErrNotMessageSender = errors.New("messageprocessor: only the sender can change this message")
+	ErrCannotEditMessage = errors.New("messageprocessor: cannot edit message")
+	ErrInvalidDeleteScope = errors.New("messageprocessor: invalid delete scope")
+	ErrCannotDeleteMessage = errors.New("messageprocessor: cannot delete message")
+	ErrCannotGetThread = errors.New("messageprocessor: cannot get thread")
+	ErrInvalidEmoji = errors.New("messageprocessor: invalid emoji")
+	ErrCannotUpdateReaction = errors.New("messageprocessor: cannot update reaction")
+	ErrCannotMarkRead = errors.New("messageprocessor: cannot mark messages as read")
+	ErrInvalidClientMessageID = errors.New("messageprocessor: invalid client message id")
+	ErrCannotPinMessage = errors.New("messageprocessor: cannot pin message")
+	ErrCannotUnpinMessage = errors.New("messageprocessor: cannot unpin message")
+	ErrMessageNotPinned = errors.New("messageprocessor: message is not pinned")
+	ErrCannotGetPinnedMessages = errors.New("messageprocessor: cannot get pinned messages")
+	ErrCannotGetMentions = errors.New("messageprocessor: cannot get mentions")
+	ErrInvalidContentType = errors.New("messageprocessor: invalid content type")
+	ErrInvalidCodeContent = errors.New("messageprocessor: invalid code content")
+	ErrInvalidScheduledMessageID = errors.New("messageprocessor: invalid scheduled message id")
+	ErrInvalidSendAt = errors.New("messageprocessor: send time must be in the future")
+	ErrInvalidRecurrence = errors.New("messageprocessor: invalid recurrence")
+	ErrScheduledMessageNotFound = errors.New("messageprocessor: scheduled message not found")
+	ErrCannotScheduleMessage = errors.New("messageprocessor: cannot schedule message")
+	ErrCannotGetScheduledMessages = errors.New("messageprocessor: cannot get scheduled messages")
+	ErrInvalidMessageTTL = errors.New("messageprocessor: invalid message ttl")
+	ErrCannotSetMessageTTL = errors.New("messageprocessor: cannot set message ttl")
+	ErrTooManyMessagesToForward = errors.New("messageprocessor: too many messages to forward")
+	ErrCannotForwardDeletedMessage = errors.New("messageprocessor: cannot forward a deleted message")
+	ErrCannotForwardMessage = errors.New("messageprocessor: cannot forward message")
+	ErrCannotForwardPoll = errors.New("messageprocessor: polls cannot be forwarded")
+	ErrInvalidPollQuestion = errors.New("messageprocessor: invalid poll question")
+	ErrInvalidPollOptions = errors.New("messageprocessor: a poll needs 2 to 10 distinct options")
+	ErrInvalidPollClosesAt = errors.New("messageprocessor: poll close time must be in the future")
+	ErrCannotCreatePoll = errors.New("messageprocessor: cannot create poll")
+	ErrNotAPoll = errors.New("messageprocessor: message is not a poll")
+	ErrPollClosed = errors.New("messageprocessor: poll is closed")
+	ErrInvalidPollVote = errors.New("messageprocessor: invalid poll vote")
+	ErrCannotVote = errors.New("messageprocessor: cannot vote")
+	ErrInvalidSearchQuery = errors.New("messageprocessor: invalid search query")
+	ErrCannotSearchMessages = errors.New("messageprocessor: cannot search messages")
+	ErrUnknownCommand = errors.New("messageprocessor: unknown command, see /help")
+	ErrInvalidCommandArgs = errors.New("messageprocessor: invalid command arguments")
+	ErrCannotRunCommand = errors.New("messageprocessor: cannot run command")
+	ErrInvalidChatName = errors.New("messageprocessor: invalid chat name")
+	ErrInvalidChatTopic = errors.New("messageprocessor: invalid chat topic")
+	ErrDraftTooLong = errors.New("messageprocessor: draft is too long")
+	ErrCannotSaveDraft = errors.New("messageprocessor: cannot save draft")
+	ErrCannotGetDrafts = errors.New("messageprocessor: cannot get drafts")
+	ErrInvalidSavedMessageNote = errors.New("messageprocessor: invalid saved message note")
+	ErrInvalidSavedMessageTags = errors.New("messageprocessor: invalid saved message tags")
+	ErrInvalidSavedMessageID = errors.New("messageprocessor: invalid saved message id")
+	ErrSavedMessageNotFound = errors.New("messageprocessor: saved message not found")
+	ErrCannotSaveMessageForLater = errors.New("messageprocessor: cannot save message for later")
+	ErrCannotRemoveSavedMessage = errors.New("messageprocessor: cannot remove saved message")
+	ErrCannotGetSavedMessages = errors.New("messageprocessor: cannot get saved messages")
+	ErrTooManyMessagesToAck = errors.New("messageprocessor: too many messages to acknowledge")
+	ErrCannotAckMessages = errors.New("messageprocessor: cannot acknowledge messages")
+	ErrNotReceiptsOwner = errors.New("messageprocessor: only the sender can see the receipts of this message")
+	ErrCannotGetMessageReceipts = errors.New("messageprocessor: cannot get message receipts")
+	ErrInvalidEventSeq = errors.New("messageprocessor: invalid event sequence number")
+	ErrCannotResume = errors.New("messageprocessor: cannot resume")
+	ErrCannotForwardDisappearingMessage = errors.New("messageprocessor: messages of chats with disappearing messages cannot be forwarded")
 )
@@ -0,0 +1,250 @@
+package messageprocessor
+
+import (
+	"context"
+	"encoding/json"
+	"log"
+	"time"
+
+	"github.com/google/uuid"
+)
+
+const (
+	// eventLogRetention is how long events stay in the event log. Clients
+	// that come back later have to reload their state.
+	eventLogRetention = 7 * 24 * time.Hour
+	// maxResumeEvents bounds the events replayed by a single ResumeRequest
+	maxResumeEvents = 500
+	// pruneBatchSize is how many events are removed from the log at once
+	pruneBatchSize = 1000
+	// eventQueueSize is how many responses can wait for the event writer
+	// before senders block
+	eventQueueSize = 4096
+)
+
+// loggedEventTypes are the responses and events that change the state of a
+// client. They are written to the event log of each recipient, so that they
+// can be replayed after a reconnect. Answers to queries, errors and typing
+// indicators aren't logged.
+var loggedEventTypes = map[string]bool{
+	CREATE_CHAT_RESPONSE:              true,
+	SEND_MESSAGE_RESPONSE:             true,
+	EDIT_MESSAGE_RESPONSE:             true,
+	DELETE_MESSAGE_RESPONSE:           true,
+	ADD_REACTION_RESPONSE:             true,
+	REMOVE_REACTION_RESPONSE:          true,
+	MARK_READ_RESPONSE:                true,
+	PIN_MESSAGE_RESPONSE:              true,
+	UNPIN_MESSAGE_RESPONSE:            true,
+	SCHEDULE_MESSAGE_RESPONSE:         true,
+	EDIT_SCHEDULED_MESSAGE_RESPONSE:   true,
+	CANCEL_SCHEDULED_MESSAGE_RESPONSE: true,
+	SET_MESSAGE_TTL_RESPONSE:          true,
+	FORWARD_MESSAGE_RESPONSE:          true,
+	CREATE_POLL_RESPONSE:              true,
+	VOTE_POLL_RESPONSE:                true,
+	SAVE_DRAFT_RESPONSE:               true,
+	SAVE_MESSAGE_RESPONSE:             true,
+	UNSAVE_MESSAGE_RESPONSE:           true,
+	MENTION_EVENT:                     true,
+	MESSAGE_PREVIEW_UPDATED_EVENT:     true,
+	MESSAGES_EXPIRED_EVENT:            true,
+	CHAT_UPDATED_EVENT:                true,
+	DRAFT_UPDATED_EVENT:               true,
+	MESSAGE_STATUS_EVENT:              true,
+}
+
+// supersedingEventTypes are the responses that edit or delete the messages
+// they embed. Logged events that carry the previous content of the messages
+// are removed when they are appended.
+var supersedingEventTypes = map[string]bool{
+	EDIT_MESSAGE_RESPONSE:   true,
+	DELETE_MESSAGE_RESPONSE: true,
+}
+
+// queuedResponse is a response waiting for the event writer. deliver sends
+// it with the numbers it got in the event sequences of userIds.
+type queuedResponse struct {
+	userIds  []uuid.UUID
+	response *Response
+	deliver  func(seqs map[uuid.UUID]int64)
+}
+
+// queueResponse hands a response to the event writer. Every response goes
+// through it, so that responses reach each user in the order they were sent
+// and in the order of their numbers.
+func (mp *MessageProcessor) queueResponse(userIds []uuid.UUID, response *Response, deliver func(seqs map[uuid.UUID]int64)) {
+	mp.responses <- queuedResponse{userIds: userIds, response: response, deliver: deliver}
+}
+
+// RunEventWriter appends the queued responses to the event logs of their
+// recipients and delivers them, one at a time, until ctx is done. It keeps
+// the database writes off the hub and is the only writer of the event logs,
+// so appends to the logs of the same users never run concurrently.
+func (mp *MessageProcessor) RunEventWriter(ctx context.Context) {
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case queued := <-mp.responses:
+			queued.deliver(mp.appendEvents(queued.userIds, queued.response))
+		}
+	}
+}
+
+// appendEvents appends a response to the event logs of userIds and returns
+// the number it got in each of them. Responses that aren't logged get no
+// numbers.
+func (mp *MessageProcessor) appendEvents(userIds []uuid.UUID, response *Response) map[uuid.UUID]int64 {
+	if len(userIds) == 0 || !loggedEventTypes[response.Type] || response.Error != "" {
+		return nil
+	}
+	messageIds := embeddedMessageIds(response.Data)
+	var supersededIds []uuid.UUID
+	if supersedingEventTypes[response.Type] {
+		supersededIds = messageIds
+	}
+	seqs, err := mp.UserModel.AppendEvents(userIds, response.Type, response.Data, messageIds, supersededIds)
+	if err != nil {
+		// the event is still sent, but can't be replayed
+		log.Printf("Error appending %s to the event logs: %v", response.Type, err)
+	}
+	return seqs
+}
+
+// embeddedMessageIds collects the ids of the messages a response embeds,
+// from every "messageId" and "messageIds" at any depth of its data. The
+// original of a forwarded message isn't embedded, only referenced.
+func embeddedMessageIds(data json.RawMessage) []uuid.UUID {
+	var document any
+	if json.Unmarshal(data, &document) != nil {
+		return nil
+	}
+
+	var messageIds []uuid.UUID
+	addId := func(value any) {
+		if rawId, ok := value.(string); ok {
+			if messageId, err := uuid.Parse(rawId); err == nil {
+				messageIds = append(messageIds, messageId)
+			}
+		}
+	}
+	var walk func(value any)
+	walk = func(value any) {
+		switch value := value.(type) {
+		case map[string]any:
+			for key, child := range value {
+				switch key {
+				case "messageId":
+					addId(child)
+				case "messageIds":
+					if ids, ok := child.([]any); ok {
+						for _, id := range ids {
+							addId(id)
+						}
+					}
+				case "forwardedFrom":
+				default:
+					walk(child)
+				}
+			}
+		case []any:
+			for _, child := range value {
+				walk(child)
+			}
+		}
+	}
+	walk(document)
+	return messageIds
+}
+
+func (mp *MessageProcessor) handleResumeRequest(senderId uuid.UUID, requestId string, reqData ResumeRequest) {
+	if reqData.LastSeq != nil && *reqData.LastSeq < 0 {
+		mp.sendError(senderId, requestId, RESUME_RESPONSE, ErrInvalidEventSeq)
+		return
+	}
+
+	// the log is read by the event writer, once the responses queued before
+	// the request have been appended to it
+	response := &Response{
+		Type:      RESUME_RESPONSE,
+		RequestID: requestId,
+		Session:   mp.requestSession(),
+	}
+	mp.queueResponse(nil, response, func(map[uuid.UUID]int64) {
+		response.Data, response.Error = mp.resume(senderId, reqData.LastSeq)
+		mp.MessageSender.SendToUser(senderId, response)
+	})
+}
+
+// resume returns the ResumeResponse for a user that last saw lastSeq, or the
+// error to reply with
+func (mp *MessageProcessor) resume(userId uuid.UUID, lastSeq *int64) (json.RawMessage, string) {
+	eventSeq, err := mp.UserModel.GetEventSeq(userId)
+	if err != nil {
+		log.Printf("Error getting event sequence: %v", err)
+		return nil, ErrCannotResume.Error()
+	}
+
+	responseData := ResumeResponse{
+		Events:  []Response{},
+		LastSeq: eventSeq.LastSeq,
+	}
+	switch {
+	case lastSeq == nil:
+		// a new client only learns where the sequence is
+	case *lastSeq > eventSeq.LastSeq || *lastSeq < eventSeq.PrunedSeq:
+		responseData.Reset = true
+	default:
+		// fetch one extra event to find out whether there are more
+		events, err := mp.UserModel.GetEventsAfter(userId, *lastSeq, maxResumeEvents+1)
+		if err != nil {
+			log.Printf("Error getting events: %v", err)
+			return nil, ErrCannotResume.Error()
+		}
+		responseData.HasMore = len(events) > maxResumeEvents
+		if responseData.HasMore {
+			events = events[:maxResumeEvents]
+			responseData.LastSeq = events[len(events)-1].Seq
+		}
+		for _, event := range events {
+			responseData.Events = append(responseData.Events, Response{
+				Type:        event.Type,
+				Data:        event.Data,
+				Unsolicited: true,
+				Seq:         event.Seq,
+			})
+		}
+	}
+	return getJsonRawMessage(responseData), ""
+}
+
+// RunEventLogPruner removes events older than the retention period from the
+// event logs every interval until ctx is done
+func (mp *MessageProcessor) RunEventLogPruner(ctx context.Context, interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			mp.pruneEventLogs()
+		}
+	}
+}
+
+func (mp *MessageProcessor) pruneEventLogs() {
+	before := time.Now().Add(-eventLogRetention)
+	for {
+		count, err := mp.UserModel.PruneEvents(before, pruneBatchSize)
+		if err != nil {
+			log.Printf("Error pruning event logs: %v", err)
+			return
+		}
+		if count < pruneBatchSize {
+			return
+		}
+	}
+}
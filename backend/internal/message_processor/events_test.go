@@ -0,0 +1,48 @@
+package messageprocessor
+
+import (
+	"encoding/json"
+	"slices"
+	"testing"
+
+	"github.com/google/uuid"
+)
+
+func TestEmbeddedMessageIds(t *testing.T) {
+	mention, forwarded, original, pinned, deleted := uuid.New(), uuid.New(), uuid.New(), uuid.New(), uuid.New()
+	tests := []struct {
+		name string
+		data string
+		want []uuid.UUID
+	}{
+		{
+			name: "nested message",
+			data: `{"chatId":"` + uuid.NewString() + `","message":{"messageId":"` + mention.String() + `"}}`,
+			want: []uuid.UUID{mention},
+		},
+		{
+			name: "forwarded message without its original",
+			data: `{"messages":[{"messageId":"` + forwarded.String() + `","forwardedFrom":{"messageId":"` + original.String() + `"}}]}`,
+			want: []uuid.UUID{forwarded},
+		},
+		{
+			name: "id lists",
+			data: `{"messageIds":["` + pinned.String() + `","` + deleted.String() + `"]}`,
+			want: []uuid.UUID{pinned, deleted},
+		},
+		{
+			name: "no messages",
+			data: `{"userId":"` + uuid.NewString() + `"}`,
+		},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			got := embeddedMessageIds(json.RawMessage(test.data))
+			slices.SortFunc(got, func(a, b uuid.UUID) int { return slices.Compare(a[:], b[:]) })
+			slices.SortFunc(test.want, func(a, b uuid.UUID) int { return slices.Compare(a[:], b[:]) })
+			if !slices.Equal(got, test.want) {
+				t.Errorf("embeddedMessageIds(%s) = %v, want %v", test.data, got, test.want)
+			}
+		})
+	}
+}
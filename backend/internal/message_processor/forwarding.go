@@ -0,0 +1,195 @@
+package messageprocessor
+
+import (
+	"errors"
+	"log"
+	"sort"
+
+	"chatty.mtran.io/internal/models"
+	"github.com/google/uuid"
+)
+
+// maxForwardMessages is how many messages a single request may forward
+const maxForwardMessages = 50
+
+func (mp *MessageProcessor) handleForwardMessageRequest(senderId uuid.UUID, requestId string, reqData ForwardMessageRequest) {
+	targetChatId, err := uuid.Parse(reqData.TargetChatID)
+	if err != nil {
+		mp.sendError(senderId, requestId, FORWARD_MESSAGE_RESPONSE, ErrInvalidChatID)
+		return
+	}
+	if len(reqData.MessageIDs) == 0 {
+		mp.sendError(senderId, requestId, FORWARD_MESSAGE_RESPONSE, ErrInvalidRequestData)
+		return
+	}
+	if len(reqData.MessageIDs) > maxForwardMessages {
+		mp.sendError(senderId, requestId, FORWARD_MESSAGE_RESPONSE, ErrTooManyMessagesToForward)
+		return
+	}
+
+	members, err := mp.ChatModel.GetChatMembers(targetChatId)
+	if err != nil {
+		log.Printf("Error getting chat members: %v", err)
+		mp.sendError(senderId, requestId, FORWARD_MESSAGE_RESPONSE, ErrCannotGetChat)
+		return
+	}
+	memberIds := make([]uuid.UUID, 0, len(members))
+	var senderName string
+	for _, member := range members {
+		memberIds = append(memberIds, member.ID)
+		if member.ID == senderId {
+			senderName = member.Name
+		}
+	}
+	if senderName == "" {
+		mp.sendError(senderId, requestId, FORWARD_MESSAGE_RESPONSE, ErrNotChatMember)
+		return
+	}
+
+	// load the originals, the sender must be able to read each of them.
+	// Messages of chats with a message TTL aren't forwarded, since the copies
+	// would outlive them.
+	seen := make(map[uuid.UUID]bool, len(reqData.MessageIDs))
+	disappearing := make(map[uuid.UUID]bool)
+	originals := make([]*models.Message, 0, len(reqData.MessageIDs))
+	for _, rawId := range reqData.MessageIDs {
+		messageId, err := uuid.Parse(rawId)
+		if err != nil {
+			mp.sendError(senderId, requestId, FORWARD_MESSAGE_RESPONSE, ErrInvalidMessageID)
+			return
+		}
+		if seen[messageId] {
+			continue
+		}
+		seen[messageId] = true
+
+		original, err := mp.getMemberMessage(senderId, messageId)
+		if errors.Is(err, models.ErrNoRecord) {
+			mp.sendError(senderId, requestId, FORWARD_MESSAGE_RESPONSE, ErrMessageNotFound)
+			return
+		}
+		if err != nil {
+			log.Printf("Error getting message: %v", err)
+			mp.sendError(senderId, requestId, FORWARD_MESSAGE_RESPONSE, ErrCannotForwardMessage)
+			return
+		}
+		if original.DeletedAt != nil {
+			mp.sendError(senderId, requestId, FORWARD_MESSAGE_RESPONSE, ErrCannotForwardDeletedMessage)
+			return
+		}
+		if original.ContentType == CONTENT_TYPE_POLL {
+			mp.sendError(senderId, requestId, FORWARD_MESSAGE_RESPONSE, ErrCannotForwardPoll)
+			return
+		}
+		isDisappearing, ok := disappearing[original.ChatID]
+		if !ok {
+			chat, err := mp.ChatModel.GetChat(original.ChatID)
+			if err != nil {
+				log.Printf("Error getting chat: %v", err)
+				mp.sendError(senderId, requestId, FORWARD_MESSAGE_RESPONSE, ErrCannotForwardMessage)
+				return
+			}
+			isDisappearing = chat.MessageTTL != nil
+			disappearing[original.ChatID] = isDisappearing
+		}
+		if isDisappearing {
+			mp.sendError(senderId, requestId, FORWARD_MESSAGE_RESPONSE, ErrCannotForwardDisappearingMessage)
+			return
+		}
+		originals = append(originals, original)
+	}
+	sort.SliceStable(originals, func(i, j int) bool {
+		return originals[i].CreatedAt.Before(originals[j].CreatedAt)
+	})
+
+	copies := make([]*models.Message, 0, len(originals))
+	for _, original := range originals {
+		copies = append(copies, forwardedCopy(original, senderId, senderName, targetChatId))
+	}
+	err = mp.MessageModel.InsertMessages(copies)
+	if err != nil {
+		log.Printf("Error saving forwarded messages: %v", err)
+		mp.sendError(senderId, requestId, FORWARD_MESSAGE_RESPONSE, ErrCannotForwardMessage)
+		return
+	}
+
+	// forwarding a message ends the sender's typing indicator
+	mp.stopTyping(targetChatId, senderId)
+
+	responseData := ForwardMessageResponse{
+		ChatID:   targetChatId.String(),
+		Messages: make([]ChatHistoryMessage, 0, len(copies)),
+	}
+	for _, message := range copies {
+		responseData.Messages = append(responseData.Messages, chatHistoryMessageConvert(*message))
+	}
+	responseMessage := &Response{
+		Type:  FORWARD_MESSAGE_RESPONSE,
+		Data:  getJsonRawMessage(responseData),
+		Error: "",
+	}
+	mp.replyAndBroadcast(senderId, requestId, memberIds, responseMessage)
+
+	for _, message := range copies {
+		go mp.unfurlLinks(*message)
+	}
+}
+
+// forwardedCopy returns a new message of senderId in chatId with the content
+// of original, attributed to the message original was first forwarded from
+// or to original itself
+func forwardedCopy(original *models.Message, senderId uuid.UUID, senderName string, chatId uuid.UUID) *models.Message {
+	message := &models.Message{
+		SenderID:    senderId,
+		ChatID:      chatId,
+		Content:     original.Content,
+		ContentType: original.ContentType,
+		SenderName:  senderName,
+	}
+
+	if original.ForwardedFromCreatedAt != nil {
+		message.ForwardedFromMessageID = original.ForwardedFromMessageID
+		message.ForwardedFromSenderID = original.ForwardedFromSenderID
+		message.ForwardedFromSenderName = original.ForwardedFromSenderName
+		message.ForwardedFromChatID = original.ForwardedFromChatID
+		message.ForwardedFromCreatedAt = original.ForwardedFromCreatedAt
+		return message
+	}
+
+	originalId := original.ID
+	originalSenderId := original.SenderID
+	originalSenderName := original.SenderName
+	originalChatId := original.ChatID
+	originalCreatedAt := original.CreatedAt.UTC()
+	message.ForwardedFromMessageID = &originalId
+	message.ForwardedFromSenderID = &originalSenderId
+	message.ForwardedFromSenderName = &originalSenderName
+	message.ForwardedFromChatID = &originalChatId
+	message.ForwardedFromCreatedAt = &originalCreatedAt
+	return message
+}
+
+// forwardedFromConvert returns the attribution of a forwarded message, or
+// nil if message wasn't forwarded
+func forwardedFromConvert(message models.Message) *ForwardedFrom {
+	if message.ForwardedFromCreatedAt == nil {
+		return nil
+	}
+	forwardedFrom := &ForwardedFrom{
+		SenderName: message.ForwardedFromSenderName,
+		Timestamp:  formatTimestamp(*message.ForwardedFromCreatedAt),
+	}
+	if message.ForwardedFromMessageID != nil {
+		messageId := message.ForwardedFromMessageID.String()
+		forwardedFrom.MessageID = &messageId
+	}
+	if message.ForwardedFromSenderID != nil {
+		senderId := message.ForwardedFromSenderID.String()
+		forwardedFrom.SenderID = &senderId
+	}
+	if message.ForwardedFromChatID != nil {
+		chatId := message.ForwardedFromChatID.String()
+		forwardedFrom.ChatID = &chatId
+	}
+	return forwardedFrom
+}
@@ -0,0 +1,120 @@
+package messageprocessor
+
+import (
+	"log"
+	"regexp"
+	"strings"
+
+	"chatty.mtran.io/internal/models"
+	"github.com/google/uuid"
+)
+
+// mentionPattern matches @email and @handle tokens. A handle is the local part
+// of a member's email address. The token must not follow a word character so
+// that email addresses in the text aren't read as mentions.
+var mentionPattern = regexp.MustCompile(`(?:^|[^\w.@])@([\w.+-]+(?:@[\w-]+(?:\.[\w-]+)+)?)`)
+
+// parseMentions returns the members of a chat mentioned in content, in order
+// of first mention. Mentions of the sender and of non-members are ignored.
+func parseMentions(content string, members []*models.UserInfo, senderId uuid.UUID) []uuid.UUID {
+	var mentions []uuid.UUID
+	seen := make(map[uuid.UUID]bool)
+
+	for _, match := range mentionPattern.FindAllStringSubmatch(content, -1) {
+		// a mention at the end of a sentence
+		token := strings.ToLower(strings.TrimRight(match[1], "."))
+		if token == "" {
+			continue
+		}
+		for _, member := range members {
+			if member.ID == senderId || seen[member.ID] {
+				continue
+			}
+			email := strings.ToLower(member.Email)
+			handle, _, _ := strings.Cut(email, "@")
+			if token == email || token == handle {
+				seen[member.ID] = true
+				mentions = append(mentions, member.ID)
+			}
+		}
+	}
+	return mentions
+}
+
+// sendMentionEvents notifies the users mentioned in a newly sent message
+func (mp *MessageProcessor) sendMentionEvents(message models.Message) {
+	if len(message.Mentions) == 0 {
+		return
+	}
+
+	// members that muted the chat aren't notified
+	mutedIds, err := mp.ChatModel.GetMutedMemberIDs(message.ChatID)
+	if err != nil {
+		log.Printf("Error getting muted chat members: %v", err)
+		return
+	}
+	muted := make(map[uuid.UUID]bool, len(mutedIds))
+	for _, userId := range mutedIds {
+		muted[userId] = true
+	}
+	userIds := make([]uuid.UUID, 0, len(message.Mentions))
+	for _, userId := range message.Mentions {
+		if !muted[userId] {
+			userIds = append(userIds, userId)
+		}
+	}
+	if len(userIds) == 0 {
+		return
+	}
+
+	responseData := MentionEvent{
+		ChatID:  message.ChatID.String(),
+		Message: chatHistoryMessageConvert(message),
+	}
+	responseMessage := &Response{
+		Type:  MENTION_EVENT,
+		Data:  getJsonRawMessage(responseData),
+		Error: "",
+	}
+	mp.sendEvent(userIds, responseMessage)
+}
+
+func (mp *MessageProcessor) handleGetMentionsRequest(senderId uuid.UUID, requestId string, reqData GetMentionsRequest) {
+	cursorId, err := parseCursor(reqData.CursorCreatedAt, reqData.CursorMessageID)
+	if err != nil {
+		mp.sendError(senderId, requestId, GET_MENTIONS_RESPONSE, err)
+		return
+	}
+
+	// fetch one extra row to find out whether there is another page
+	limit := pageLimit(reqData.Limit)
+	modelMessages, err := mp.MessageModel.GetMentions(senderId, reqData.CursorCreatedAt, cursorId, limit+1)
+	if err != nil {
+		log.Printf("Error getting mentions: %v", err)
+		mp.sendError(senderId, requestId, GET_MENTIONS_RESPONSE, ErrCannotGetMentions)
+		return
+	}
+	hasMore := len(modelMessages) > limit
+	if hasMore {
+		modelMessages = modelMessages[:limit]
+	}
+
+	mentions := make([]Mention, 0, len(modelMessages))
+	for _, modelMessage := range modelMessages {
+		mentions = append(mentions, Mention{
+			ChatID:  modelMessage.ChatID.String(),
+			Message: chatHistoryMessageConvert(*modelMessage),
+		})
+	}
+
+	responseData := GetMentionsResponse{
+		Mentions: mentions,
+		HasMore:  hasMore,
+	}
+	responseMessage := &Response{
+		Type:  GET_MENTIONS_RESPONSE,
+		Data:  getJsonRawMessage(responseData),
+		Error: "",
+	}
+	mp.reply(senderId, requestId, responseMessage)
+}
@@ -2,9 +2,13 @@ package messageprocessor
 
 import (
 	"encoding/json"
+	"errors"
 	"log"
+	"strings"
+	"time"
 
 	"chatty.mtran.io/internal/models"
+	"chatty.mtran.io/internal/validator"
 	"github.com/google/uuid"
 )
 
@@ -13,18 +17,46 @@ type MessageProcessor struct {
 	UserModel     *models.UserModel
 	MessageModel  *models.MessageModel
 	MessageSender ResponseSender
+
+	typing    *typingTracker
+	responses chan queuedResponse
+	unfurler  Unfurler
+	commands  map[string]*command
 }
 
+const (
+	// maxClientMessageIDLength matches messages.client_message_id
+	maxClientMessageIDLength = 64
+	// maxEmojiLength is the longest emoji sequence accepted as a reaction
+	maxEmojiLength = 32
+	// defaultPageLimit is used when a paginated request does not specify a limit
+	defaultPageLimit = 50
+	// maxPageLimit caps the page size a client can ask for
+	maxPageLimit = 100
+)
+
 type ResponseSender interface {
 	SendToUser(userID uuid.UUID, response *Response)
 }
 
+// SessionSender is implemented by senders that tell the sessions of a user
+// apart, e.g. a laptop and a phone. RequestSession returns the session whose
+// request is being processed, if any. Replies only go to the Session they
+// carry, and SendToOtherSessions sends to the user's sessions other than it.
+type SessionSender interface {
+	RequestSession() any
+	SendToOtherSessions(userID uuid.UUID, response *Response)
+}
+
 // constructors and setters
 func NewMessageProcessor(chatModel *models.ChatModel, userModel *models.UserModel, messageModel *models.MessageModel) *MessageProcessor {
 	return &MessageProcessor{
 		ChatModel:    chatModel,
 		UserModel:    userModel,
 		MessageModel: messageModel,
+		typing:       newTypingTracker(),
+		responses:    make(chan queuedResponse, eventQueueSize),
+		commands:     builtinCommands(),
 	}
 }
 
@@ -32,6 +64,11 @@ func (mp *MessageProcessor) SetMessageSender(messageSender ResponseSender) {
 	mp.MessageSender = messageSender
 }
 
+// SetUnfurler enables link previews. Without an unfurler links aren't unfurled.
+func (mp *MessageProcessor) SetUnfurler(unfurler Unfurler) {
+	mp.unfurler = unfurler
+}
+
 // functions
 func (mp *MessageProcessor) ProcessMessage(senderId uuid.UUID, message []byte) {
 	// request, err := unmarshalRawMessage(rawMessage)
@@ -40,12 +77,7 @@ func (mp *MessageProcessor) ProcessMessage(senderId uuid.UUID, message []byte) {
 	if err != nil {
 		log.Printf("Error unmarshalling message: %v", err)
 		log.Printf("senderId: %s", senderId)
-		responseMessage := &Response{
-			Type:  "",
-			Data:  nil,
-			Error: ErrUnknownRequestType.Error(),
-		}
-		mp.MessageSender.SendToUser(senderId, responseMessage)
+		mp.sendError(senderId, "", "", ErrUnknownRequestType)
 		return
 	}
 
@@ -54,64 +86,277 @@ func (mp *MessageProcessor) ProcessMessage(senderId uuid.UUID, message []byte) {
 		var reqData CreateChatRequest
 		if err := json.Unmarshal(request.Data, &reqData); err != nil {
 			log.Printf("Error unmarshalling create chat data: %v", err)
+			mp.sendError(senderId, request.RequestID, CREATE_CHAT_RESPONSE, ErrInvalidRequestData)
 			return
 		}
-		mp.handleCreateChatRequest(senderId, reqData)
+		mp.handleCreateChatRequest(senderId, request.RequestID, reqData)
 	case SEND_MESSAGE_REQUEST:
 		var reqData SendMessageRequest
 		if err := json.Unmarshal(request.Data, &reqData); err != nil {
 			log.Printf("Error unmarshalling send message data: %v", err)
+			mp.sendError(senderId, request.RequestID, SEND_MESSAGE_RESPONSE, ErrInvalidRequestData)
 			return
 		}
-		mp.handleSendMessageRequest(senderId, reqData)
+		mp.handleSendMessageRequest(senderId, request.RequestID, reqData)
 	case GET_CHAT_HISTORY_REQUEST:
 		var reqData GetChatHistoryRequest
 		if err := json.Unmarshal(request.Data, &reqData); err != nil {
 			log.Printf("Error unmarshalling get chat history data: %v", err)
+			mp.sendError(senderId, request.RequestID, GET_CHAT_HISTORY_RESPONSE, ErrInvalidRequestData)
 			return
 		}
-		mp.handleGetChatHistoryRequest(senderId, reqData)
+		mp.handleGetChatHistoryRequest(senderId, request.RequestID, reqData)
 	case GET_CHATS_REQUEST:
 		var reqData GetChatsRequest
 		if err := json.Unmarshal(request.Data, &reqData); err != nil {
 			log.Printf("Error unmarshalling get chats data: %v", err)
+			mp.sendError(senderId, request.RequestID, GET_CHATS_RESPONSE, ErrInvalidRequestData)
+			return
+		}
+		mp.handleGetChatsRequest(senderId, request.RequestID, reqData)
+	case EDIT_MESSAGE_REQUEST:
+		var reqData EditMessageRequest
+		if err := json.Unmarshal(request.Data, &reqData); err != nil {
+			log.Printf("Error unmarshalling edit message data: %v", err)
+			mp.sendError(senderId, request.RequestID, EDIT_MESSAGE_RESPONSE, ErrInvalidRequestData)
+			return
+		}
+		mp.handleEditMessageRequest(senderId, request.RequestID, reqData)
+	case DELETE_MESSAGE_REQUEST:
+		var reqData DeleteMessageRequest
+		if err := json.Unmarshal(request.Data, &reqData); err != nil {
+			log.Printf("Error unmarshalling delete message data: %v", err)
+			mp.sendError(senderId, request.RequestID, DELETE_MESSAGE_RESPONSE, ErrInvalidRequestData)
+			return
+		}
+		mp.handleDeleteMessageRequest(senderId, request.RequestID, reqData)
+	case GET_THREAD_REQUEST:
+		var reqData GetThreadRequest
+		if err := json.Unmarshal(request.Data, &reqData); err != nil {
+			log.Printf("Error unmarshalling get thread data: %v", err)
+			mp.sendError(senderId, request.RequestID, GET_THREAD_RESPONSE, ErrInvalidRequestData)
+			return
+		}
+		mp.handleGetThreadRequest(senderId, request.RequestID, reqData)
+	case ADD_REACTION_REQUEST:
+		var reqData AddReactionRequest
+		if err := json.Unmarshal(request.Data, &reqData); err != nil {
+			log.Printf("Error unmarshalling add reaction data: %v", err)
+			mp.sendError(senderId, request.RequestID, ADD_REACTION_RESPONSE, ErrInvalidRequestData)
+			return
+		}
+		mp.handleReactionRequest(senderId, request.RequestID, ReactionRequest(reqData), ADD_REACTION_RESPONSE)
+	case REMOVE_REACTION_REQUEST:
+		var reqData RemoveReactionRequest
+		if err := json.Unmarshal(request.Data, &reqData); err != nil {
+			log.Printf("Error unmarshalling remove reaction data: %v", err)
+			mp.sendError(senderId, request.RequestID, REMOVE_REACTION_RESPONSE, ErrInvalidRequestData)
+			return
+		}
+		mp.handleReactionRequest(senderId, request.RequestID, ReactionRequest(reqData), REMOVE_REACTION_RESPONSE)
+	case MARK_READ_REQUEST:
+		var reqData MarkReadRequest
+		if err := json.Unmarshal(request.Data, &reqData); err != nil {
+			log.Printf("Error unmarshalling mark read data: %v", err)
+			mp.sendError(senderId, request.RequestID, MARK_READ_RESPONSE, ErrInvalidRequestData)
+			return
+		}
+		mp.handleMarkReadRequest(senderId, request.RequestID, reqData)
+	case TYPING_START_REQUEST:
+		var reqData TypingStartRequest
+		if err := json.Unmarshal(request.Data, &reqData); err != nil {
+			log.Printf("Error unmarshalling typing start data: %v", err)
+			mp.sendError(senderId, request.RequestID, TYPING_EVENT, ErrInvalidRequestData)
+			return
+		}
+		mp.handleTypingStartRequest(senderId, request.RequestID, TypingRequest(reqData))
+	case TYPING_STOP_REQUEST:
+		var reqData TypingStopRequest
+		if err := json.Unmarshal(request.Data, &reqData); err != nil {
+			log.Printf("Error unmarshalling typing stop data: %v", err)
+			mp.sendError(senderId, request.RequestID, TYPING_EVENT, ErrInvalidRequestData)
+			return
+		}
+		mp.handleTypingStopRequest(senderId, request.RequestID, TypingRequest(reqData))
+	case PIN_MESSAGE_REQUEST:
+		var reqData PinMessageRequest
+		if err := json.Unmarshal(request.Data, &reqData); err != nil {
+			log.Printf("Error unmarshalling pin message data: %v", err)
+			mp.sendError(senderId, request.RequestID, PIN_MESSAGE_RESPONSE, ErrInvalidRequestData)
+			return
+		}
+		mp.handlePinMessageRequest(senderId, request.RequestID, reqData)
+	case UNPIN_MESSAGE_REQUEST:
+		var reqData UnpinMessageRequest
+		if err := json.Unmarshal(request.Data, &reqData); err != nil {
+			log.Printf("Error unmarshalling unpin message data: %v", err)
+			mp.sendError(senderId, request.RequestID, UNPIN_MESSAGE_RESPONSE, ErrInvalidRequestData)
+			return
+		}
+		mp.handleUnpinMessageRequest(senderId, request.RequestID, reqData)
+	case GET_PINNED_MESSAGES_REQUEST:
+		var reqData GetPinnedMessagesRequest
+		if err := json.Unmarshal(request.Data, &reqData); err != nil {
+			log.Printf("Error unmarshalling get pinned messages data: %v", err)
+			mp.sendError(senderId, request.RequestID, GET_PINNED_MESSAGES_RESPONSE, ErrInvalidRequestData)
+			return
+		}
+		mp.handleGetPinnedMessagesRequest(senderId, request.RequestID, reqData)
+	case GET_MENTIONS_REQUEST:
+		var reqData GetMentionsRequest
+		if err := json.Unmarshal(request.Data, &reqData); err != nil {
+			log.Printf("Error unmarshalling get mentions data: %v", err)
+			mp.sendError(senderId, request.RequestID, GET_MENTIONS_RESPONSE, ErrInvalidRequestData)
+			return
+		}
+		mp.handleGetMentionsRequest(senderId, request.RequestID, reqData)
+	case LIST_SCHEDULED_MESSAGES_REQUEST:
+		var reqData ListScheduledMessagesRequest
+		if err := json.Unmarshal(request.Data, &reqData); err != nil {
+			log.Printf("Error unmarshalling list scheduled messages data: %v", err)
+			mp.sendError(senderId, request.RequestID, LIST_SCHEDULED_MESSAGES_RESPONSE, ErrInvalidRequestData)
+			return
+		}
+		mp.handleListScheduledMessagesRequest(senderId, request.RequestID, reqData)
+	case EDIT_SCHEDULED_MESSAGE_REQUEST:
+		var reqData EditScheduledMessageRequest
+		if err := json.Unmarshal(request.Data, &reqData); err != nil {
+			log.Printf("Error unmarshalling edit scheduled message data: %v", err)
+			mp.sendError(senderId, request.RequestID, EDIT_SCHEDULED_MESSAGE_RESPONSE, ErrInvalidRequestData)
+			return
+		}
+		mp.handleEditScheduledMessageRequest(senderId, request.RequestID, reqData)
+	case CANCEL_SCHEDULED_MESSAGE_REQUEST:
+		var reqData CancelScheduledMessageRequest
+		if err := json.Unmarshal(request.Data, &reqData); err != nil {
+			log.Printf("Error unmarshalling cancel scheduled message data: %v", err)
+			mp.sendError(senderId, request.RequestID, CANCEL_SCHEDULED_MESSAGE_RESPONSE, ErrInvalidRequestData)
+			return
+		}
+		mp.handleCancelScheduledMessageRequest(senderId, request.RequestID, reqData)
+	case SET_MESSAGE_TTL_REQUEST:
+		var reqData SetMessageTTLRequest
+		if err := json.Unmarshal(request.Data, &reqData); err != nil {
+			log.Printf("Error unmarshalling set message ttl data: %v", err)
+			mp.sendError(senderId, request.RequestID, SET_MESSAGE_TTL_RESPONSE, ErrInvalidRequestData)
+			return
+		}
+		mp.handleSetMessageTTLRequest(senderId, request.RequestID, reqData)
+	case FORWARD_MESSAGE_REQUEST:
+		var reqData ForwardMessageRequest
+		if err := json.Unmarshal(request.Data, &reqData); err != nil {
+			log.Printf("Error unmarshalling forward message data: %v", err)
+			mp.sendError(senderId, request.RequestID, FORWARD_MESSAGE_RESPONSE, ErrInvalidRequestData)
+			return
+		}
+		mp.handleForwardMessageRequest(senderId, request.RequestID, reqData)
+	case CREATE_POLL_REQUEST:
+		var reqData CreatePollRequest
+		if err := json.Unmarshal(request.Data, &reqData); err != nil {
+			log.Printf("Error unmarshalling create poll data: %v", err)
+			mp.sendError(senderId, request.RequestID, CREATE_POLL_RESPONSE, ErrInvalidRequestData)
+			return
+		}
+		mp.handleCreatePollRequest(senderId, request.RequestID, reqData)
+	case VOTE_POLL_REQUEST:
+		var reqData VotePollRequest
+		if err := json.Unmarshal(request.Data, &reqData); err != nil {
+			log.Printf("Error unmarshalling vote poll data: %v", err)
+			mp.sendError(senderId, request.RequestID, VOTE_POLL_RESPONSE, ErrInvalidRequestData)
+			return
+		}
+		mp.handleVotePollRequest(senderId, request.RequestID, reqData)
+	case SEARCH_MESSAGES_REQUEST:
+		var reqData SearchMessagesRequest
+		if err := json.Unmarshal(request.Data, &reqData); err != nil {
+			log.Printf("Error unmarshalling search messages data: %v", err)
+			mp.sendError(senderId, request.RequestID, SEARCH_MESSAGES_RESPONSE, ErrInvalidRequestData)
+			return
+		}
+		mp.handleSearchMessagesRequest(senderId, request.RequestID, reqData)
+	case SAVE_DRAFT_REQUEST:
+		var reqData SaveDraftRequest
+		if err := json.Unmarshal(request.Data, &reqData); err != nil {
+			log.Printf("Error unmarshalling save draft data: %v", err)
+			mp.sendError(senderId, request.RequestID, SAVE_DRAFT_RESPONSE, ErrInvalidRequestData)
+			return
+		}
+		mp.handleSaveDraftRequest(senderId, request.RequestID, reqData)
+	case GET_DRAFTS_REQUEST:
+		var reqData GetDraftsRequest
+		if err := json.Unmarshal(request.Data, &reqData); err != nil {
+			log.Printf("Error unmarshalling get drafts data: %v", err)
+			mp.sendError(senderId, request.RequestID, GET_DRAFTS_RESPONSE, ErrInvalidRequestData)
+			return
+		}
+		mp.handleGetDraftsRequest(senderId, request.RequestID, reqData)
+	case SAVE_MESSAGE_REQUEST:
+		var reqData SaveMessageRequest
+		if err := json.Unmarshal(request.Data, &reqData); err != nil {
+			log.Printf("Error unmarshalling save message data: %v", err)
+			mp.sendError(senderId, request.RequestID, SAVE_MESSAGE_RESPONSE, ErrInvalidRequestData)
+			return
+		}
+		mp.handleSaveMessageRequest(senderId, request.RequestID, reqData)
+	case UNSAVE_MESSAGE_REQUEST:
+		var reqData UnsaveMessageRequest
+		if err := json.Unmarshal(request.Data, &reqData); err != nil {
+			log.Printf("Error unmarshalling unsave message data: %v", err)
+			mp.sendError(senderId, request.RequestID, UNSAVE_MESSAGE_RESPONSE, ErrInvalidRequestData)
+			return
+		}
+		mp.handleUnsaveMessageRequest(senderId, request.RequestID, reqData)
+	case LIST_SAVED_MESSAGES_REQUEST:
+		var reqData ListSavedMessagesRequest
+		if err := json.Unmarshal(request.Data, &reqData); err != nil {
+			log.Printf("Error unmarshalling list saved messages data: %v", err)
+			mp.sendError(senderId, request.RequestID, LIST_SAVED_MESSAGES_RESPONSE, ErrInvalidRequestData)
+			return
+		}
+		mp.handleListSavedMessagesRequest(senderId, request.RequestID, reqData)
+	case ACK_MESSAGES_REQUEST:
+		var reqData AckMessagesRequest
+		if err := json.Unmarshal(request.Data, &reqData); err != nil {
+			log.Printf("Error unmarshalling ack messages data: %v", err)
+			mp.sendError(senderId, request.RequestID, ACK_MESSAGES_RESPONSE, ErrInvalidRequestData)
+			return
+		}
+		mp.handleAckMessagesRequest(senderId, request.RequestID, reqData)
+	case GET_MESSAGE_RECEIPTS_REQUEST:
+		var reqData GetMessageReceiptsRequest
+		if err := json.Unmarshal(request.Data, &reqData); err != nil {
+			log.Printf("Error unmarshalling get message receipts data: %v", err)
+			mp.sendError(senderId, request.RequestID, GET_MESSAGE_RECEIPTS_RESPONSE, ErrInvalidRequestData)
+			return
+		}
+		mp.handleGetMessageReceiptsRequest(senderId, request.RequestID, reqData)
+	case RESUME_REQUEST:
+		var reqData ResumeRequest
+		if err := json.Unmarshal(request.Data, &reqData); err != nil {
+			log.Printf("Error unmarshalling resume data: %v", err)
+			mp.sendError(senderId, request.RequestID, RESUME_RESPONSE, ErrInvalidRequestData)
 			return
 		}
-		mp.handleGetChatsRequest(senderId, reqData)
+		mp.handleResumeRequest(senderId, request.RequestID, reqData)
 	default:
 		log.Printf("unknown message type: %s", request.Type)
-		responseMessage := &Response{
-			Type:  "",
-			Data:  nil,
-			Error: ErrUnknownRequestType.Error(),
-		}
-		mp.MessageSender.SendToUser(senderId, responseMessage)
+		mp.sendError(senderId, request.RequestID, "", ErrUnknownRequestType)
 		return
 	}
 }
 
-func (mp *MessageProcessor) handleCreateChatRequest(senderId uuid.UUID, reqData CreateChatRequest) {
+func (mp *MessageProcessor) handleCreateChatRequest(senderId uuid.UUID, requestId string, reqData CreateChatRequest) {
 	// check if participantIDs are valid
 	if len(reqData.ParticipantEmails) < 2 {
 		log.Printf("Cannot create a chat with less than 2 participants")
-		responseMessage := &Response{
-			Type:  CREATE_CHAT_RESPONSE,
-			Data:  nil,
-			Error: ErrCannotCreateChatWithLessThan2Participants.Error(),
-		}
-		mp.MessageSender.SendToUser(senderId, responseMessage)
+		mp.sendError(senderId, requestId, CREATE_CHAT_RESPONSE, ErrCannotCreateChatWithLessThan2Participants)
 		return
 	}
 
 	userInfos, err := mp.UserModel.UserInfosByEmails(reqData.ParticipantEmails)
 	if err != nil {
 		log.Printf("Error checking if user emails exist: %v", err)
-		responseMessage := &Response{
-			Type:  CREATE_CHAT_RESPONSE,
-			Data:  nil,
-			Error: ErrUserDoesNotExist.Error(),
-		}
-		mp.MessageSender.SendToUser(senderId, responseMessage)
+		mp.sendError(senderId, requestId, CREATE_CHAT_RESPONSE, ErrUserDoesNotExist)
 		return
 	}
 
@@ -121,12 +366,7 @@ func (mp *MessageProcessor) handleCreateChatRequest(senderId uuid.UUID, reqData
 	chat, err := mp.ChatModel.InsertChat(reqData.Name)
 	if err != nil {
 		log.Printf("Error creating chat: %v", err)
-		responseMessage := &Response{
-			Type:  CREATE_CHAT_RESPONSE,
-			Data:  nil,
-			Error: ErrCannotCreateChat.Error(),
-		}
-		mp.MessageSender.SendToUser(senderId, responseMessage)
+		mp.sendError(senderId, requestId, CREATE_CHAT_RESPONSE, ErrCannotCreateChat)
 		return
 	}
 
@@ -138,14 +378,10 @@ func (mp *MessageProcessor) handleCreateChatRequest(senderId uuid.UUID, reqData
 	err = mp.ChatModel.AddUsersToChat(chat.Id, userIDs)
 	if err != nil {
 		log.Printf("Error adding participants to chat: %v", err)
-		responseMessage := &Response{
-			Type:  CREATE_CHAT_RESPONSE,
-			Data:  nil,
-			Error: ErrCannotAddParticipantsToChat.Error(),
-		}
-		mp.MessageSender.SendToUser(senderId, responseMessage)
+		mp.sendError(senderId, requestId, CREATE_CHAT_RESPONSE, ErrCannotAddParticipantsToChat)
 		return
 	}
+	mp.forgetChatMembers(chat.Id)
 
 	log.Printf("Created chat: %s with ID: %s", chat.Name, chat.Id)
 
@@ -159,10 +395,11 @@ func (mp *MessageProcessor) handleCreateChatRequest(senderId uuid.UUID, reqData
 		})
 	}
 	responseData := CreateChatResponse{
-		Id:        chat.Id.String(),
-		Name:      chat.Name,
-		UpdatedAt: chat.UpdatedAt,
-		UserInfos: wsUserInfos,
+		Id:          chat.Id.String(),
+		Name:        chat.Name,
+		UpdatedAt:   chat.UpdatedAt,
+		UserInfos:   wsUserInfos,
+		ReadMarkers: []ReadMarker{},
 	}
 
 	responseMessage := &Response{
@@ -171,9 +408,7 @@ func (mp *MessageProcessor) handleCreateChatRequest(senderId uuid.UUID, reqData
 		Error: "",
 	}
 
-	for _, userID := range userIDs {
-		mp.MessageSender.SendToUser(userID, responseMessage)
-	}
+	mp.replyAndBroadcast(senderId, requestId, userIDs, responseMessage)
 }
 
 func getJsonRawMessage(data any) json.RawMessage {
@@ -185,107 +420,1055 @@ func getJsonRawMessage(data any) json.RawMessage {
 	return json.RawMessage(jsonData)
 }
 
-func (mp *MessageProcessor) handleSendMessageRequest(senderId uuid.UUID, reqData SendMessageRequest) {
-	chatId := uuid.MustParse(reqData.ChatID)
-	content := reqData.Content
-
-	// get chat by id
-	_, err := mp.ChatModel.GetChat(chatId)
+func (mp *MessageProcessor) handleSendMessageRequest(senderId uuid.UUID, requestId string, reqData SendMessageRequest) {
+	chatId, err := uuid.Parse(reqData.ChatID)
 	if err != nil {
-		log.Printf("Error getting chat: %v", err)
-		responseMessage := &Response{
-			Type:  SEND_MESSAGE_RESPONSE,
-			Data:  nil,
-			Error: ErrCannotGetChat.Error(),
+		mp.sendError(senderId, requestId, SEND_MESSAGE_RESPONSE, ErrInvalidChatID)
+		return
+	}
+	contentType := reqData.ContentType
+	if contentType == "" {
+		contentType = CONTENT_TYPE_PLAIN
+	}
+	rawContent := reqData.Content
+	if contentType != CONTENT_TYPE_CODE && strings.HasPrefix(rawContent, "/") {
+		if !strings.HasPrefix(rawContent, "//") {
+			mp.runCommand(senderId, requestId, chatId, rawContent)
+			return
 		}
-		mp.MessageSender.SendToUser(senderId, responseMessage)
+		rawContent = rawContent[1:]
+	}
+	content, err := normalizeContent(contentType, rawContent)
+	if err != nil {
+		mp.sendError(senderId, requestId, SEND_MESSAGE_RESPONSE, err)
 		return
 	}
 
-	// Save message to database
-	message, err := mp.MessageModel.InsertMessage(senderId, chatId, content)
+	// get chat by id
+	_, err = mp.ChatModel.GetChat(chatId)
 	if err != nil {
-		log.Printf("Error saving message: %v", err)
-		responseMessage := &Response{
-			Type:  SEND_MESSAGE_RESPONSE,
-			Data:  nil,
-			Error: ErrCannotSaveMessage.Error(),
-		}
-		mp.MessageSender.SendToUser(senderId, responseMessage)
+		log.Printf("Error getting chat: %v", err)
+		mp.sendError(senderId, requestId, SEND_MESSAGE_RESPONSE, ErrCannotGetChat)
 		return
 	}
 
-	// response to chat members
 	members, err := mp.ChatModel.GetChatMembers(chatId)
 	if err != nil {
 		log.Printf("Error getting chat members: %v", err)
+		mp.sendError(senderId, requestId, SEND_MESSAGE_RESPONSE, ErrCannotGetChat)
 		return
 	}
 	memberIds := make([]uuid.UUID, 0, len(members))
+	var senderName string
 	for _, member := range members {
 		memberIds = append(memberIds, member.ID)
+		if member.ID == senderId {
+			senderName = member.Name
+		}
 	}
-	responseData := SendMessageResponse{
-		ChatID:    chatId.String(),
-		SenderID:  message.SenderID.String(),
-		Content:   message.Content,
-		Timestamp: message.CreatedAt.String(),
-		MessageID: message.ID.String(),
+	if senderName == "" {
+		mp.sendError(senderId, requestId, SEND_MESSAGE_RESPONSE, ErrNotChatMember)
+		return
+	}
+
+	if reqData.ClientMessageID != nil {
+		if !validator.NotBlank(*reqData.ClientMessageID) || !validator.MaxChars(*reqData.ClientMessageID, maxClientMessageIDLength) {
+			mp.sendError(senderId, requestId, SEND_MESSAGE_RESPONSE, ErrInvalidClientMessageID)
+			return
+		}
+	}
+
+	// resolve the thread the message belongs to, if any
+	var parentId *uuid.UUID
+	if reqData.ParentMessageID != nil {
+		parentId, err = mp.resolveThreadRoot(chatId, *reqData.ParentMessageID)
+		if err != nil {
+			mp.sendError(senderId, requestId, SEND_MESSAGE_RESPONSE, err)
+			return
+		}
+	}
+
+	// messages for later are stored until the dispatcher sends them
+	if reqData.Recurrence != nil || (reqData.SendAt != nil && reqData.SendAt.After(time.Now())) {
+		scheduled := &models.ScheduledMessage{
+			SenderID:        senderId,
+			ChatID:          chatId,
+			Content:         content,
+			ContentType:     contentType,
+			ParentMessageID: parentId,
+			ClientMessageID: reqData.ClientMessageID,
+			SendAt:          time.Now(),
+		}
+		if reqData.SendAt != nil {
+			scheduled.SendAt = *reqData.SendAt
+		}
+		mp.scheduleMessage(senderId, requestId, scheduled, reqData.Recurrence)
+		return
+	}
+
+	// Save message to database
+	message := &models.Message{
+		SenderID:        senderId,
+		ChatID:          chatId,
+		Content:         content,
+		ContentType:     contentType,
+		ParentMessageID: parentId,
+		ClientMessageID: reqData.ClientMessageID,
+		Mentions:        parseMentions(content, members, senderId),
+		SenderName:      senderName,
+	}
+	err = mp.MessageModel.InsertMessage(message)
+	if errors.Is(err, models.ErrDuplicateMessage) {
+		// a retry of a message that was already stored, only the sender
+		// needs the original response again
+		mp.resendMessage(senderId, requestId, *reqData.ClientMessageID)
+		return
+	}
+	if err != nil {
+		log.Printf("Error saving message: %v", err)
+		mp.sendError(senderId, requestId, SEND_MESSAGE_RESPONSE, ErrCannotSaveMessage)
+		return
 	}
+
+	// sending a message ends the sender's typing indicator and uses up the
+	// draft it was composed in
+	mp.stopTyping(chatId, senderId)
+	mp.deleteDraft(senderId, chatId, parentId)
+
+	// response to chat members
 	responseMessage := &Response{
 		Type:  SEND_MESSAGE_RESPONSE,
-		Data:  getJsonRawMessage(responseData),
+		Data:  getJsonRawMessage(sendMessageResponseConvert(*message)),
+		Error: "",
+	}
+
+	mp.replyAndBroadcast(senderId, requestId, memberIds, responseMessage)
+	mp.sendMentionEvents(*message)
+	go mp.unfurlLinks(*message)
+}
+
+// resendMessage sends the response of an already stored message back to its sender
+func (mp *MessageProcessor) resendMessage(senderId uuid.UUID, requestId string, clientMessageID string) {
+	message, err := mp.MessageModel.GetMessageByClientID(senderId, clientMessageID)
+	if err != nil {
+		log.Printf("Error getting message by client message id: %v", err)
+		mp.sendError(senderId, requestId, SEND_MESSAGE_RESPONSE, ErrCannotSaveMessage)
+		return
+	}
+	if message.DeletedAt != nil {
+		message.Content = ""
+	}
+
+	responseMessage := &Response{
+		Type:  SEND_MESSAGE_RESPONSE,
+		Data:  getJsonRawMessage(sendMessageResponseConvert(*message)),
 		Error: "",
 	}
+	mp.reply(senderId, requestId, responseMessage)
+}
 
-	for _, memberId := range memberIds {
-		mp.MessageSender.SendToUser(memberId, responseMessage)
+func sendMessageResponseConvert(message models.Message) SendMessageResponse {
+	responseData := SendMessageResponse{
+		ChatID:          message.ChatID.String(),
+		SenderID:        message.SenderID.String(),
+		Content:         message.Content,
+		ContentType:     message.ContentType,
+		Timestamp:       formatTimestamp(message.CreatedAt),
+		MessageID:       message.ID.String(),
+		Seq:             message.Seq,
+		ClientMessageID: message.ClientMessageID,
+	}
+	if message.ParentMessageID != nil {
+		parentMessageId := message.ParentMessageID.String()
+		responseData.ParentMessageID = &parentMessageId
 	}
+	return responseData
 }
 
-func (mp *MessageProcessor) handleGetChatHistoryRequest(senderId uuid.UUID, reqData GetChatHistoryRequest) {
-	// messageData, ok := message.Data.(*ClientGetChatHistoryRequest)
-	// if !ok {
-	// 	log.Printf("Invalid message data")
-	// 	return
-	// }
+// resolveThreadRoot finds the root message of the thread a new message in
+// chatId replies to. Replying to a reply continues the root's thread.
+func (mp *MessageProcessor) resolveThreadRoot(chatId uuid.UUID, parentMessageID string) (*uuid.UUID, error) {
+	parentId, err := uuid.Parse(parentMessageID)
+	if err != nil {
+		return nil, ErrInvalidMessageID
+	}
+
+	parent, err := mp.MessageModel.GetMessage(parentId)
+	if err != nil {
+		if !errors.Is(err, models.ErrNoRecord) {
+			log.Printf("Error getting parent message: %v", err)
+		}
+		return nil, ErrMessageNotFound
+	}
+	if parent.ChatID != chatId || parent.DeletedAt != nil {
+		return nil, ErrMessageNotFound
+	}
+
+	if parent.ParentMessageID != nil {
+		return parent.ParentMessageID, nil
+	}
+	return &parent.ID, nil
 }
 
-func (mp *MessageProcessor) handleGetChatsRequest(senderId uuid.UUID, reqData GetChatsRequest) {
-	modelChats, err := mp.ChatModel.GetChatsByUserID(senderId, reqData.CursorUpdatedAt, reqData.ChatID, reqData.Limit)
+func (mp *MessageProcessor) handleEditMessageRequest(senderId uuid.UUID, requestId string, reqData EditMessageRequest) {
+	messageId, err := uuid.Parse(reqData.MessageID)
 	if err != nil {
-		log.Printf("Error getting chats: %v", err)
+		mp.sendError(senderId, requestId, EDIT_MESSAGE_RESPONSE, ErrInvalidMessageID)
 		return
 	}
-	chats := make([]ChatInfo, 0, len(modelChats))
-	for _, modelChat := range modelChats {
-		chats = append(chats, chatConvert(*modelChat))
+	if !validator.NotBlank(reqData.Content) {
+		mp.sendError(senderId, requestId, EDIT_MESSAGE_RESPONSE, ErrEmptyMessageContent)
+		return
 	}
 
-	responseData := GetChatsResponse{
-		Chats: chats,
+	message, err := mp.MessageModel.GetMessage(messageId)
+	if err != nil {
+		if errors.Is(err, models.ErrNoRecord) {
+			mp.sendError(senderId, requestId, EDIT_MESSAGE_RESPONSE, ErrMessageNotFound)
+			return
+		}
+		log.Printf("Error getting message: %v", err)
+		mp.sendError(senderId, requestId, EDIT_MESSAGE_RESPONSE, ErrCannotEditMessage)
+		return
+	}
+	content, err := normalizeContent(message.ContentType, reqData.Content)
+	if err != nil {
+		mp.sendError(senderId, requestId, EDIT_MESSAGE_RESPONSE, err)
+		return
+	}
+
+	message, err = mp.MessageModel.EditMessage(messageId, senderId, content)
+	if err != nil {
+		switch {
+		case errors.Is(err, models.ErrNoRecord):
+			mp.sendError(senderId, requestId, EDIT_MESSAGE_RESPONSE, ErrMessageNotFound)
+		case errors.Is(err, models.ErrNotMessageSender):
+			mp.sendError(senderId, requestId, EDIT_MESSAGE_RESPONSE, ErrNotMessageSender)
+		default:
+			log.Printf("Error editing message: %v", err)
+			mp.sendError(senderId, requestId, EDIT_MESSAGE_RESPONSE, ErrCannotEditMessage)
+		}
+		return
+	}
+
+	responseData := EditMessageResponse{
+		ChatID:      message.ChatID.String(),
+		MessageID:   message.ID.String(),
+		Content:     message.Content,
+		ContentType: message.ContentType,
+		EditedAt:    formatTimestamp(*message.EditedAt),
 	}
 	responseMessage := &Response{
-		Type:  GET_CHATS_RESPONSE,
+		Type:  EDIT_MESSAGE_RESPONSE,
 		Data:  getJsonRawMessage(responseData),
 		Error: "",
 	}
-	mp.MessageSender.SendToUser(senderId, responseMessage)
+	mp.sendToChatMembers(message.ChatID, senderId, requestId, responseMessage)
 }
 
-func chatConvert(chat models.Chat) ChatInfo {
-	userInfos := make([]UserInfo, 0, len(chat.UserInfos))
-	for _, userInfo := range chat.UserInfos {
-		userInfos = append(userInfos, UserInfo{
-			ID:    userInfo.ID.String(),
-			Email: userInfo.Email,
-			Name:  userInfo.Name,
-		})
+func (mp *MessageProcessor) handleDeleteMessageRequest(senderId uuid.UUID, requestId string, reqData DeleteMessageRequest) {
+	messageId, err := uuid.Parse(reqData.MessageID)
+	if err != nil {
+		mp.sendError(senderId, requestId, DELETE_MESSAGE_RESPONSE, ErrInvalidMessageID)
+		return
+	}
+
+	switch reqData.Scope {
+	case DELETE_SCOPE_EVERYONE:
+		message, err := mp.MessageModel.DeleteMessage(messageId, senderId)
+		if err != nil {
+			switch {
+			case errors.Is(err, models.ErrNoRecord):
+				mp.sendError(senderId, requestId, DELETE_MESSAGE_RESPONSE, ErrMessageNotFound)
+			case errors.Is(err, models.ErrNotMessageSender):
+				mp.sendError(senderId, requestId, DELETE_MESSAGE_RESPONSE, ErrNotMessageSender)
+			default:
+				log.Printf("Error deleting message: %v", err)
+				mp.sendError(senderId, requestId, DELETE_MESSAGE_RESPONSE, ErrCannotDeleteMessage)
+			}
+			return
+		}
+
+		responseData := DeleteMessageResponse{
+			ChatID:    message.ChatID.String(),
+			MessageID: message.ID.String(),
+			Scope:     DELETE_SCOPE_EVERYONE,
+			DeletedAt: formatTimestamp(*message.DeletedAt),
+		}
+		responseMessage := &Response{
+			Type:  DELETE_MESSAGE_RESPONSE,
+			Data:  getJsonRawMessage(responseData),
+			Error: "",
+		}
+		mp.sendToChatMembers(message.ChatID, senderId, requestId, responseMessage)
+
+	case DELETE_SCOPE_ME:
+		message, err := mp.getMemberMessage(senderId, messageId)
+		if err != nil {
+			if errors.Is(err, models.ErrNoRecord) {
+				mp.sendError(senderId, requestId, DELETE_MESSAGE_RESPONSE, ErrMessageNotFound)
+				return
+			}
+			log.Printf("Error getting message: %v", err)
+			mp.sendError(senderId, requestId, DELETE_MESSAGE_RESPONSE, ErrCannotDeleteMessage)
+			return
+		}
+
+		if err := mp.MessageModel.HideMessage(messageId, senderId); err != nil {
+			log.Printf("Error hiding message: %v", err)
+			mp.sendError(senderId, requestId, DELETE_MESSAGE_RESPONSE, ErrCannotDeleteMessage)
+			return
+		}
+
+		responseData := DeleteMessageResponse{
+			ChatID:    message.ChatID.String(),
+			MessageID: message.ID.String(),
+			Scope:     DELETE_SCOPE_ME,
+			DeletedAt: formatTimestamp(time.Now()),
+		}
+		responseMessage := &Response{
+			Type:  DELETE_MESSAGE_RESPONSE,
+			Data:  getJsonRawMessage(responseData),
+			Error: "",
+		}
+		mp.reply(senderId, requestId, responseMessage)
+
+	default:
+		mp.sendError(senderId, requestId, DELETE_MESSAGE_RESPONSE, ErrInvalidDeleteScope)
+	}
+}
+
+// handleReactionRequest adds or removes a reaction depending on responseType
+// and tells every chat member about the new count
+func (mp *MessageProcessor) handleReactionRequest(senderId uuid.UUID, requestId string, reqData ReactionRequest, responseType string) {
+	messageId, err := uuid.Parse(reqData.MessageID)
+	if err != nil {
+		mp.sendError(senderId, requestId, responseType, ErrInvalidMessageID)
+		return
+	}
+	emoji := strings.TrimSpace(reqData.Emoji)
+	if !validator.NotBlank(emoji) || !validator.MaxChars(emoji, maxEmojiLength) {
+		mp.sendError(senderId, requestId, responseType, ErrInvalidEmoji)
+		return
+	}
+
+	message, err := mp.getMemberMessage(senderId, messageId)
+	if err != nil {
+		if errors.Is(err, models.ErrNoRecord) {
+			mp.sendError(senderId, requestId, responseType, ErrMessageNotFound)
+			return
+		}
+		log.Printf("Error getting message: %v", err)
+		mp.sendError(senderId, requestId, responseType, ErrCannotUpdateReaction)
+		return
+	}
+	if message.DeletedAt != nil {
+		mp.sendError(senderId, requestId, responseType, ErrMessageNotFound)
+		return
+	}
+
+	if responseType == ADD_REACTION_RESPONSE {
+		_, err = mp.MessageModel.AddReaction(messageId, senderId, emoji)
+	} else {
+		_, err = mp.MessageModel.RemoveReaction(messageId, senderId, emoji)
+	}
+	if err != nil {
+		log.Printf("Error updating reaction: %v", err)
+		mp.sendError(senderId, requestId, responseType, ErrCannotUpdateReaction)
+		return
 	}
-	return ChatInfo{
-		Id:        chat.Id.String(),
-		Name:      chat.Name,
-		UpdatedAt: chat.UpdatedAt,
-		UserInfos: userInfos,
+
+	count, err := mp.MessageModel.CountReactions(messageId, emoji)
+	if err != nil {
+		log.Printf("Error counting reactions: %v", err)
+		mp.sendError(senderId, requestId, responseType, ErrCannotUpdateReaction)
+		return
+	}
+
+	responseData := ReactionResponse{
+		ChatID:    message.ChatID.String(),
+		MessageID: message.ID.String(),
+		UserID:    senderId.String(),
+		Emoji:     emoji,
+		Count:     count,
 	}
+	responseMessage := &Response{
+		Type:  responseType,
+		Data:  getJsonRawMessage(responseData),
+		Error: "",
+	}
+	mp.sendToChatMembers(message.ChatID, senderId, requestId, responseMessage)
+}
+
+func (mp *MessageProcessor) handleMarkReadRequest(senderId uuid.UUID, requestId string, reqData MarkReadRequest) {
+	chatId, err := uuid.Parse(reqData.ChatID)
+	if err != nil {
+		mp.sendError(senderId, requestId, MARK_READ_RESPONSE, ErrInvalidChatID)
+		return
+	}
+	messageId, err := uuid.Parse(reqData.MessageID)
+	if err != nil {
+		mp.sendError(senderId, requestId, MARK_READ_RESPONSE, ErrInvalidMessageID)
+		return
+	}
+
+	message, err := mp.getMemberMessage(senderId, messageId)
+	if err != nil {
+		if errors.Is(err, models.ErrNoRecord) {
+			mp.sendError(senderId, requestId, MARK_READ_RESPONSE, ErrMessageNotFound)
+			return
+		}
+		log.Printf("Error getting message: %v", err)
+		mp.sendError(senderId, requestId, MARK_READ_RESPONSE, ErrCannotMarkRead)
+		return
+	}
+	if message.ChatID != chatId {
+		mp.sendError(senderId, requestId, MARK_READ_RESPONSE, ErrMessageNotFound)
+		return
+	}
+
+	previous, err := mp.ChatModel.GetReadMarker(chatId, senderId)
+	if err != nil {
+		log.Printf("Error getting read marker: %v", err)
+		mp.sendError(senderId, requestId, MARK_READ_RESPONSE, ErrCannotMarkRead)
+		return
+	}
+	readMarker, moved, err := mp.ChatModel.MarkRead(chatId, senderId, messageId)
+	if err != nil {
+		log.Printf("Error marking messages as read: %v", err)
+		mp.sendError(senderId, requestId, MARK_READ_RESPONSE, ErrCannotMarkRead)
+		return
+	}
+
+	responseData := MarkReadResponse{
+		ChatID:     chatId.String(),
+		ReadMarker: readMarkerConvert(*readMarker),
+	}
+	responseMessage := &Response{
+		Type:  MARK_READ_RESPONSE,
+		Data:  getJsonRawMessage(responseData),
+		Error: "",
+	}
+	if !moved {
+		mp.reply(senderId, requestId, responseMessage)
+		return
+	}
+	mp.sendToChatMembers(chatId, senderId, requestId, responseMessage)
+	mp.markMessagesRead(chatId, senderId, previous.LastReadMessageID, messageId)
+}
+
+func (mp *MessageProcessor) handlePinMessageRequest(senderId uuid.UUID, requestId string, reqData PinMessageRequest) {
+	messageId, err := uuid.Parse(reqData.MessageID)
+	if err != nil {
+		mp.sendError(senderId, requestId, PIN_MESSAGE_RESPONSE, ErrInvalidMessageID)
+		return
+	}
+
+	message, err := mp.getMemberMessage(senderId, messageId)
+	if err != nil {
+		if errors.Is(err, models.ErrNoRecord) {
+			mp.sendError(senderId, requestId, PIN_MESSAGE_RESPONSE, ErrMessageNotFound)
+			return
+		}
+		log.Printf("Error getting message: %v", err)
+		mp.sendError(senderId, requestId, PIN_MESSAGE_RESPONSE, ErrCannotPinMessage)
+		return
+	}
+	if message.DeletedAt != nil {
+		mp.sendError(senderId, requestId, PIN_MESSAGE_RESPONSE, ErrMessageNotFound)
+		return
+	}
+
+	pin, created, err := mp.ChatModel.PinMessage(message.ChatID, messageId, senderId)
+	if err != nil {
+		log.Printf("Error pinning message: %v", err)
+		mp.sendError(senderId, requestId, PIN_MESSAGE_RESPONSE, ErrCannotPinMessage)
+		return
+	}
+
+	responseData := PinMessageResponse{
+		ChatID:    pin.ChatID.String(),
+		MessageID: pin.MessageID.String(),
+		PinnedBy:  pin.PinnedBy.String(),
+		PinnedAt:  formatTimestamp(pin.PinnedAt),
+	}
+	responseMessage := &Response{
+		Type:  PIN_MESSAGE_RESPONSE,
+		Data:  getJsonRawMessage(responseData),
+		Error: "",
+	}
+	if !created {
+		// already pinned, nothing changed for the other members
+		mp.reply(senderId, requestId, responseMessage)
+		return
+	}
+	mp.sendToChatMembers(pin.ChatID, senderId, requestId, responseMessage)
+}
+
+func (mp *MessageProcessor) handleUnpinMessageRequest(senderId uuid.UUID, requestId string, reqData UnpinMessageRequest) {
+	messageId, err := uuid.Parse(reqData.MessageID)
+	if err != nil {
+		mp.sendError(senderId, requestId, UNPIN_MESSAGE_RESPONSE, ErrInvalidMessageID)
+		return
+	}
+
+	message, err := mp.getMemberMessage(senderId, messageId)
+	if err != nil {
+		if errors.Is(err, models.ErrNoRecord) {
+			mp.sendError(senderId, requestId, UNPIN_MESSAGE_RESPONSE, ErrMessageNotFound)
+			return
+		}
+		log.Printf("Error getting message: %v", err)
+		mp.sendError(senderId, requestId, UNPIN_MESSAGE_RESPONSE, ErrCannotUnpinMessage)
+		return
+	}
+
+	removed, err := mp.ChatModel.UnpinMessage(message.ChatID, messageId)
+	if err != nil {
+		log.Printf("Error unpinning message: %v", err)
+		mp.sendError(senderId, requestId, UNPIN_MESSAGE_RESPONSE, ErrCannotUnpinMessage)
+		return
+	}
+	if !removed {
+		mp.sendError(senderId, requestId, UNPIN_MESSAGE_RESPONSE, ErrMessageNotPinned)
+		return
+	}
+
+	responseData := UnpinMessageResponse{
+		ChatID:     message.ChatID.String(),
+		MessageID:  messageId.String(),
+		UnpinnedBy: senderId.String(),
+	}
+	responseMessage := &Response{
+		Type:  UNPIN_MESSAGE_RESPONSE,
+		Data:  getJsonRawMessage(responseData),
+		Error: "",
+	}
+	mp.sendToChatMembers(message.ChatID, senderId, requestId, responseMessage)
+}
+
+func (mp *MessageProcessor) handleGetPinnedMessagesRequest(senderId uuid.UUID, requestId string, reqData GetPinnedMessagesRequest) {
+	chatId, err := uuid.Parse(reqData.ChatID)
+	if err != nil {
+		mp.sendError(senderId, requestId, GET_PINNED_MESSAGES_RESPONSE, ErrInvalidChatID)
+		return
+	}
+
+	isMember, err := mp.ChatModel.IsChatMember(chatId, senderId)
+	if err != nil {
+		log.Printf("Error checking chat membership: %v", err)
+		mp.sendError(senderId, requestId, GET_PINNED_MESSAGES_RESPONSE, ErrCannotGetPinnedMessages)
+		return
+	}
+	if !isMember {
+		mp.sendError(senderId, requestId, GET_PINNED_MESSAGES_RESPONSE, ErrNotChatMember)
+		return
+	}
+
+	pins, err := mp.ChatModel.GetPinnedMessages(chatId, senderId)
+	if err != nil {
+		log.Printf("Error getting pinned messages: %v", err)
+		mp.sendError(senderId, requestId, GET_PINNED_MESSAGES_RESPONSE, ErrCannotGetPinnedMessages)
+		return
+	}
+
+	pinnedMessages := make([]PinnedMessage, 0, len(pins))
+	for _, pin := range pins {
+		pinnedMessages = append(pinnedMessages, PinnedMessage{
+			Message:  chatHistoryMessageConvert(*pin.Message),
+			PinnedBy: pin.PinnedBy.String(),
+			PinnedAt: formatTimestamp(pin.PinnedAt),
+		})
+	}
+
+	responseData := GetPinnedMessagesResponse{
+		ChatID: chatId.String(),
+		Pins:   pinnedMessages,
+	}
+	responseMessage := &Response{
+		Type:  GET_PINNED_MESSAGES_RESPONSE,
+		Data:  getJsonRawMessage(responseData),
+		Error: "",
+	}
+	mp.reply(senderId, requestId, responseMessage)
+}
+
+func (mp *MessageProcessor) handleGetChatHistoryRequest(senderId uuid.UUID, requestId string, reqData GetChatHistoryRequest) {
+	chatId, err := uuid.Parse(reqData.ChatID)
+	if err != nil {
+		mp.sendError(senderId, requestId, GET_CHAT_HISTORY_RESPONSE, ErrInvalidChatID)
+		return
+	}
+
+	cursorId, err := parseCursorMessageID(reqData.CursorMessageID)
+	if err != nil {
+		mp.sendError(senderId, requestId, GET_CHAT_HISTORY_RESPONSE, err)
+		return
+	}
+
+	isMember, err := mp.ChatModel.IsChatMember(chatId, senderId)
+	if err != nil {
+		log.Printf("Error checking chat membership: %v", err)
+		mp.sendError(senderId, requestId, GET_CHAT_HISTORY_RESPONSE, ErrCannotGetChatHistory)
+		return
+	}
+	if !isMember {
+		mp.sendError(senderId, requestId, GET_CHAT_HISTORY_RESPONSE, ErrNotChatMember)
+		return
+	}
+
+	cursorSeq, err := mp.resolveSeqCursor(chatId, reqData.CursorSeq, cursorId)
+	if err != nil {
+		if errors.Is(err, ErrInvalidCursor) {
+			mp.sendError(senderId, requestId, GET_CHAT_HISTORY_RESPONSE, err)
+			return
+		}
+		log.Printf("Error getting cursor message: %v", err)
+		mp.sendError(senderId, requestId, GET_CHAT_HISTORY_RESPONSE, ErrCannotGetChatHistory)
+		return
+	}
+
+	// fetch one extra row to find out whether there is another page
+	limit := pageLimit(reqData.Limit)
+	modelMessages, err := mp.MessageModel.GetMessagesByChat(chatId, senderId, cursorSeq, limit+1)
+	if err != nil {
+		log.Printf("Error getting chat history: %v", err)
+		mp.sendError(senderId, requestId, GET_CHAT_HISTORY_RESPONSE, ErrCannotGetChatHistory)
+		return
+	}
+	hasMore := len(modelMessages) > limit
+	if hasMore {
+		modelMessages = modelMessages[:limit]
+	}
+
+	messages := make([]ChatHistoryMessage, 0, len(modelMessages))
+	for _, modelMessage := range modelMessages {
+		messages = append(messages, chatHistoryMessageConvert(*modelMessage))
+	}
+
+	responseData := GetChatHistoryResponse{
+		ChatID:   chatId.String(),
+		Messages: messages,
+		HasMore:  hasMore,
+	}
+	responseMessage := &Response{
+		Type:  GET_CHAT_HISTORY_RESPONSE,
+		Data:  getJsonRawMessage(responseData),
+		Error: "",
+	}
+	mp.reply(senderId, requestId, responseMessage)
+}
+
+func (mp *MessageProcessor) handleGetThreadRequest(senderId uuid.UUID, requestId string, reqData GetThreadRequest) {
+	parentId, err := uuid.Parse(reqData.ParentMessageID)
+	if err != nil {
+		mp.sendError(senderId, requestId, GET_THREAD_RESPONSE, ErrInvalidMessageID)
+		return
+	}
+
+	cursorId, err := parseCursorMessageID(reqData.CursorMessageID)
+	if err != nil {
+		mp.sendError(senderId, requestId, GET_THREAD_RESPONSE, err)
+		return
+	}
+
+	parent, err := mp.MessageModel.GetMessage(parentId)
+	if err != nil {
+		if errors.Is(err, models.ErrNoRecord) {
+			mp.sendError(senderId, requestId, GET_THREAD_RESPONSE, ErrMessageNotFound)
+			return
+		}
+		log.Printf("Error getting thread root: %v", err)
+		mp.sendError(senderId, requestId, GET_THREAD_RESPONSE, ErrCannotGetThread)
+		return
+	}
+
+	isMember, err := mp.ChatModel.IsChatMember(parent.ChatID, senderId)
+	if err != nil {
+		log.Printf("Error checking chat membership: %v", err)
+		mp.sendError(senderId, requestId, GET_THREAD_RESPONSE, ErrCannotGetThread)
+		return
+	}
+	if !isMember {
+		mp.sendError(senderId, requestId, GET_THREAD_RESPONSE, ErrNotChatMember)
+		return
+	}
+
+	cursorSeq, err := mp.resolveSeqCursor(parent.ChatID, reqData.CursorSeq, cursorId)
+	if err != nil {
+		if errors.Is(err, ErrInvalidCursor) {
+			mp.sendError(senderId, requestId, GET_THREAD_RESPONSE, err)
+			return
+		}
+		log.Printf("Error getting cursor message: %v", err)
+		mp.sendError(senderId, requestId, GET_THREAD_RESPONSE, ErrCannotGetThread)
+		return
+	}
+
+	// fetch one extra row to find out whether there is another page
+	limit := pageLimit(reqData.Limit)
+	modelMessages, err := mp.MessageModel.GetThreadReplies(parentId, senderId, cursorSeq, limit+1)
+	if err != nil {
+		log.Printf("Error getting thread replies: %v", err)
+		mp.sendError(senderId, requestId, GET_THREAD_RESPONSE, ErrCannotGetThread)
+		return
+	}
+	hasMore := len(modelMessages) > limit
+	if hasMore {
+		modelMessages = modelMessages[:limit]
+	}
+
+	messages := make([]ChatHistoryMessage, 0, len(modelMessages))
+	for _, modelMessage := range modelMessages {
+		messages = append(messages, chatHistoryMessageConvert(*modelMessage))
+	}
+
+	responseData := GetThreadResponse{
+		ChatID:          parent.ChatID.String(),
+		ParentMessageID: parent.ID.String(),
+		Messages:        messages,
+		HasMore:         hasMore,
+	}
+	responseMessage := &Response{
+		Type:  GET_THREAD_RESPONSE,
+		Data:  getJsonRawMessage(responseData),
+		Error: "",
+	}
+	mp.reply(senderId, requestId, responseMessage)
+}
+
+func (mp *MessageProcessor) handleGetChatsRequest(senderId uuid.UUID, requestId string, reqData GetChatsRequest) {
+	modelChats, err := mp.ChatModel.GetChatsByUserID(senderId, reqData.CursorUpdatedAt, reqData.ChatID, reqData.Limit)
+	if err != nil {
+		log.Printf("Error getting chats: %v", err)
+		return
+	}
+	chats := make([]ChatInfo, 0, len(modelChats))
+	for _, modelChat := range modelChats {
+		chats = append(chats, chatConvert(*modelChat))
+	}
+
+	responseData := GetChatsResponse{
+		Chats: chats,
+	}
+	responseMessage := &Response{
+		Type:  GET_CHATS_RESPONSE,
+		Data:  getJsonRawMessage(responseData),
+		Error: "",
+	}
+	mp.reply(senderId, requestId, responseMessage)
+}
+
+func chatConvert(chat models.Chat) ChatInfo {
+	userInfos := make([]UserInfo, 0, len(chat.UserInfos))
+	for _, userInfo := range chat.UserInfos {
+		userInfos = append(userInfos, UserInfo{
+			ID:    userInfo.ID.String(),
+			Email: userInfo.Email,
+			Name:  userInfo.Name,
+		})
+	}
+	readMarkers := make([]ReadMarker, 0, len(chat.ReadMarkers))
+	for _, readMarker := range chat.ReadMarkers {
+		readMarkers = append(readMarkers, readMarkerConvert(*readMarker))
+	}
+	chatInfo := ChatInfo{
+		Id:          chat.Id.String(),
+		Name:        chat.Name,
+		Topic:       chat.Topic,
+		UpdatedAt:   chat.UpdatedAt,
+		UserInfos:   userInfos,
+		MessageTTL:  chat.MessageTTL,
+		UnreadCount: chat.UnreadCount,
+		ReadMarkers: readMarkers,
+		Muted:       chat.Muted,
+	}
+	if chat.MutedUntil != nil {
+		mutedUntil := formatTimestamp(*chat.MutedUntil)
+		chatInfo.MutedUntil = &mutedUntil
+	}
+	return chatInfo
+}
+
+func readMarkerConvert(readMarker models.ReadMarker) ReadMarker {
+	converted := ReadMarker{
+		UserID: readMarker.UserID.String(),
+	}
+	if readMarker.LastReadMessageID != nil {
+		lastReadMessageId := readMarker.LastReadMessageID.String()
+		converted.LastReadMessageID = &lastReadMessageId
+	}
+	if readMarker.LastReadAt != nil {
+		lastReadAt := formatTimestamp(*readMarker.LastReadAt)
+		converted.LastReadAt = &lastReadAt
+	}
+	return converted
+}
+
+func chatHistoryMessageConvert(message models.Message) ChatHistoryMessage {
+	historyMessage := ChatHistoryMessage{
+		MessageID:   message.ID.String(),
+		SenderID:    message.SenderID.String(),
+		SenderName:  message.SenderName,
+		Content:     message.Content,
+		ContentType: message.ContentType,
+		Seq:         message.Seq,
+		Timestamp:   formatTimestamp(message.CreatedAt),
+		ReplyCount:  message.ReplyCount,
+	}
+	if message.EditedAt != nil {
+		editedAt := formatTimestamp(*message.EditedAt)
+		historyMessage.EditedAt = &editedAt
+	}
+	if message.DeletedAt != nil {
+		deletedAt := formatTimestamp(*message.DeletedAt)
+		historyMessage.DeletedAt = &deletedAt
+	}
+	if message.ParentMessageID != nil {
+		parentMessageId := message.ParentMessageID.String()
+		historyMessage.ParentMessageID = &parentMessageId
+	}
+	if message.LastReplyAt != nil {
+		lastReplyAt := formatTimestamp(*message.LastReplyAt)
+		historyMessage.LastReplyAt = &lastReplyAt
+	}
+	historyMessage.Reactions = make([]Reaction, 0, len(message.Reactions))
+	for _, reaction := range message.Reactions {
+		historyMessage.Reactions = append(historyMessage.Reactions, Reaction{
+			Emoji:       reaction.Emoji,
+			Count:       reaction.Count,
+			ReactedByMe: reaction.ReactedByMe,
+		})
+	}
+	historyMessage.ForwardedFrom = forwardedFromConvert(message)
+	if message.Poll != nil {
+		poll := pollConvert(*message.Poll)
+		historyMessage.Poll = &poll
+	}
+	historyMessage.LinkPreviews = linkPreviewsConvert(message.LinkPreviews)
+	return historyMessage
+}
+
+// getMemberMessage loads a message that userId is allowed to read. Messages
+// of chats the user is not a member of are reported as models.ErrNoRecord so
+// that their existence isn't revealed.
+func (mp *MessageProcessor) getMemberMessage(userId, messageId uuid.UUID) (*models.Message, error) {
+	message, err := mp.MessageModel.GetMessage(messageId)
+	if err != nil {
+		return nil, err
+	}
+	isMember, err := mp.ChatModel.IsChatMember(message.ChatID, userId)
+	if err != nil {
+		return nil, err
+	}
+	if !isMember {
+		return nil, models.ErrNoRecord
+	}
+	return message, nil
+}
+
+// reply sends a response to the user whose request it answers
+func (mp *MessageProcessor) reply(userId uuid.UUID, requestId string, response *Response) {
+	reply := *response
+	reply.RequestID = requestId
+	reply.Unsolicited = false
+	reply.Session = mp.requestSession()
+	mp.queueResponse([]uuid.UUID{userId}, &reply, func(seqs map[uuid.UUID]int64) {
+		reply.Seq = seqs[userId]
+		mp.MessageSender.SendToUser(userId, &reply)
+	})
+}
+
+// sendEvent sends a response that doesn't answer any of their requests to
+// each of the given users
+func (mp *MessageProcessor) sendEvent(userIds []uuid.UUID, response *Response) {
+	event := *response
+	event.RequestID = ""
+	event.Unsolicited = true
+	event.Session = nil
+	mp.queueResponse(userIds, &event, func(seqs map[uuid.UUID]int64) {
+		for _, userId := range userIds {
+			userEvent := event
+			userEvent.Seq = seqs[userId]
+			mp.MessageSender.SendToUser(userId, &userEvent)
+		}
+	})
+}
+
+// replyAndBroadcast sends a response to the requesting user as the reply to
+// their request, to their other sessions and to every other user in userIds
+// as an unsolicited event. The sessions of the requesting user share the
+// number the response gets in their event sequence.
+func (mp *MessageProcessor) replyAndBroadcast(senderId uuid.UUID, requestId string, userIds []uuid.UUID, response *Response) {
+	recipients := make([]uuid.UUID, 0, len(userIds)+1)
+	recipients = append(recipients, senderId)
+	for _, userId := range userIds {
+		if userId != senderId {
+			recipients = append(recipients, userId)
+		}
+	}
+
+	reply := *response
+	reply.RequestID = requestId
+	reply.Unsolicited = false
+	reply.Session = mp.requestSession()
+	mp.queueResponse(recipients, &reply, func(seqs map[uuid.UUID]int64) {
+		reply.Seq = seqs[senderId]
+		mp.MessageSender.SendToUser(senderId, &reply)
+
+		event := reply
+		event.RequestID = ""
+		event.Unsolicited = true
+		if sessionSender, ok := mp.MessageSender.(SessionSender); ok {
+			sessionEvent := event
+			sessionSender.SendToOtherSessions(senderId, &sessionEvent)
+		}
+		event.Session = nil
+		for _, userId := range recipients[1:] {
+			userEvent := event
+			userEvent.Seq = seqs[userId]
+			mp.MessageSender.SendToUser(userId, &userEvent)
+		}
+	})
+}
+
+// sendToOtherSessions sends a response as an unsolicited event to the
+// sessions of the requesting user other than the one that made the request
+func (mp *MessageProcessor) sendToOtherSessions(userId uuid.UUID, response *Response) {
+	sessionSender, ok := mp.MessageSender.(SessionSender)
+	if !ok {
+		return
+	}
+	event := *response
+	event.RequestID = ""
+	event.Unsolicited = true
+	event.Session = sessionSender.RequestSession()
+	mp.queueResponse([]uuid.UUID{userId}, &event, func(seqs map[uuid.UUID]int64) {
+		event.Seq = seqs[userId]
+		sessionSender.SendToOtherSessions(userId, &event)
+	})
+}
+
+// requestSession returns the session whose request is being processed, if
+// the sender tells sessions apart
+func (mp *MessageProcessor) requestSession() any {
+	if sessionSender, ok := mp.MessageSender.(SessionSender); ok {
+		return sessionSender.RequestSession()
+	}
+	return nil
+}
+
+// sendToChatMembers sends a response to every member of a chat. The
+// requesting user receives it as the reply to their request.
+func (mp *MessageProcessor) sendToChatMembers(chatId uuid.UUID, senderId uuid.UUID, requestId string, response *Response) {
+	memberIds, err := mp.getChatMemberIds(chatId)
+	if err != nil {
+		log.Printf("Error getting chat members: %v", err)
+		return
+	}
+	mp.replyAndBroadcast(senderId, requestId, memberIds, response)
+}
+
+// sendEventToChatMembers sends an unsolicited response to every member of a chat
+func (mp *MessageProcessor) sendEventToChatMembers(chatId uuid.UUID, response *Response) {
+	memberIds, err := mp.getChatMemberIds(chatId)
+	if err != nil {
+		log.Printf("Error getting chat members: %v", err)
+		return
+	}
+	mp.sendEvent(memberIds, response)
+}
+
+func (mp *MessageProcessor) getChatMemberIds(chatId uuid.UUID) ([]uuid.UUID, error) {
+	members, err := mp.ChatModel.GetChatMembers(chatId)
+	if err != nil {
+		return nil, err
+	}
+	memberIds := make([]uuid.UUID, 0, len(members))
+	for _, member := range members {
+		memberIds = append(memberIds, member.ID)
+	}
+	return memberIds, nil
+}
+
+// sendError sends an error response of the given type to a single user
+func (mp *MessageProcessor) sendError(userId uuid.UUID, requestId string, responseType string, err error) {
+	responseMessage := &Response{
+		Type:  responseType,
+		Data:  nil,
+		Error: err.Error(),
+	}
+	mp.reply(userId, requestId, responseMessage)
+}
+
+// parseCursor validates a (created_at, id) pagination cursor. Both parts must
+// be given together; a missing cursor requests the first page.
+func parseCursor(cursorCreatedAt *time.Time, cursorMessageID *string) (*uuid.UUID, error) {
+	if cursorCreatedAt == nil && cursorMessageID == nil {
+		return nil, nil
+	}
+	if cursorCreatedAt == nil || cursorMessageID == nil {
+		return nil, ErrInvalidCursor
+	}
+	cursorId, err := uuid.Parse(*cursorMessageID)
+	if err != nil {
+		return nil, ErrInvalidCursor
+	}
+	return &cursorId, nil
+}
+
+// parseCursorMessageID validates the message id of a seq paginated page,
+// which continues after that message. A missing id requests the first page.
+func parseCursorMessageID(cursorMessageID *string) (*uuid.UUID, error) {
+	if cursorMessageID == nil {
+		return nil, nil
+	}
+	cursorId, err := uuid.Parse(*cursorMessageID)
+	if err != nil {
+		return nil, ErrInvalidCursor
+	}
+	return &cursorId, nil
+}
+
+// resolveSeqCursor returns the seq pagination cursor of a page of chatId.
+// A message id cursor, if cursorSeq isn't given, continues after the message
+// with id cursorId.
+func (mp *MessageProcessor) resolveSeqCursor(chatId uuid.UUID, cursorSeq *int64, cursorId *uuid.UUID) (*int64, error) {
+	if cursorSeq != nil {
+		if *cursorSeq < 1 {
+			return nil, ErrInvalidCursor
+		}
+		return cursorSeq, nil
+	}
+	if cursorId == nil {
+		return nil, nil
+	}
+	message, err := mp.MessageModel.GetMessage(*cursorId)
+	if err != nil {
+		if errors.Is(err, models.ErrNoRecord) {
+			return nil, ErrInvalidCursor
+		}
+		return nil, err
+	}
+	if message.ChatID != chatId {
+		return nil, ErrInvalidCursor
+	}
+	return &message.Seq, nil
+}
+
+// pageLimit clamps a client supplied page size to a sane range
+func pageLimit(limit int) int {
+	if limit <= 0 {
+		return defaultPageLimit
+	}
+	if limit > maxPageLimit {
+		return maxPageLimit
+	}
+	return limit
+}
+
+// formatTimestamp renders a timestamp so that clients can send it back
+// unchanged as a pagination cursor
+func formatTimestamp(t time.Time) string {
+	return t.UTC().Format(time.RFC3339Nano)
 }
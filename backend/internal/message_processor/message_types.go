@@ -7,32 +7,142 @@ import (
 
 // Message types that are read from client (incoming messages)
 const (
-	CREATE_CHAT_REQUEST      = "CreateChatRequest"
-	SEND_MESSAGE_REQUEST     = "SendMessageRequest"
-	GET_CHAT_HISTORY_REQUEST = "GetChatHistoryRequest"
-	GET_CHATS_REQUEST        = "GetChatsRequest"
+	CREATE_CHAT_REQUEST              = "CreateChatRequest"
+	SEND_MESSAGE_REQUEST             = "SendMessageRequest"
+	GET_CHAT_HISTORY_REQUEST         = "GetChatHistoryRequest"
+	GET_CHATS_REQUEST                = "GetChatsRequest"
+	EDIT_MESSAGE_REQUEST             = "EditMessageRequest"
+	DELETE_MESSAGE_REQUEST           = "DeleteMessageRequest"
+	GET_THREAD_REQUEST               = "GetThreadRequest"
+	ADD_REACTION_REQUEST             = "AddReactionRequest"
+	REMOVE_REACTION_REQUEST          = "RemoveReactionRequest"
+	MARK_READ_REQUEST                = "MarkReadRequest"
+	TYPING_START_REQUEST             = "TypingStartRequest"
+	TYPING_STOP_REQUEST              = "TypingStopRequest"
+	PIN_MESSAGE_REQUEST              = "PinMessageRequest"
+	UNPIN_MESSAGE_REQUEST            = "UnpinMessageRequest"
+	GET_PINNED_MESSAGES_REQUEST      = "GetPinnedMessagesRequest"
+	GET_MENTIONS_REQUEST             = "GetMentionsRequest"
+	LIST_SCHEDULED_MESSAGES_REQUEST  = "ListScheduledMessagesRequest"
+	EDIT_SCHEDULED_MESSAGE_REQUEST   = "EditScheduledMessageRequest"
+	CANCEL_SCHEDULED_MESSAGE_REQUEST = "CancelScheduledMessageRequest"
+	SET_MESSAGE_TTL_REQUEST          = "SetMessageTTLRequest"
+	FORWARD_MESSAGE_REQUEST          = "ForwardMessageRequest"
+	CREATE_POLL_REQUEST              = "CreatePollRequest"
+	VOTE_POLL_REQUEST                = "VotePollRequest"
+	SEARCH_MESSAGES_REQUEST          = "SearchMessagesRequest"
+	SAVE_DRAFT_REQUEST               = "SaveDraftRequest"
+	GET_DRAFTS_REQUEST               = "GetDraftsRequest"
+	SAVE_MESSAGE_REQUEST             = "SaveMessageRequest"
+	UNSAVE_MESSAGE_REQUEST           = "UnsaveMessageRequest"
+	LIST_SAVED_MESSAGES_REQUEST      = "ListSavedMessagesRequest"
+	ACK_MESSAGES_REQUEST             = "AckMessagesRequest"
+	GET_MESSAGE_RECEIPTS_REQUEST     = "GetMessageReceiptsRequest"
+	RESUME_REQUEST                   = "ResumeRequest"
 )
 
 // Message types that are written to client (outgoing messages)
 const (
-	CREATE_CHAT_RESPONSE      = "CreateChatResponse"
-	SEND_MESSAGE_RESPONSE     = "SendMessageResponse"
-	GET_CHAT_HISTORY_RESPONSE = "GetChatHistoryResponse"
-	GET_CHATS_RESPONSE        = "GetChatsResponse"
+	CREATE_CHAT_RESPONSE              = "CreateChatResponse"
+	SEND_MESSAGE_RESPONSE             = "SendMessageResponse"
+	GET_CHAT_HISTORY_RESPONSE         = "GetChatHistoryResponse"
+	GET_CHATS_RESPONSE                = "GetChatsResponse"
+	EDIT_MESSAGE_RESPONSE             = "EditMessageResponse"
+	DELETE_MESSAGE_RESPONSE           = "DeleteMessageResponse"
+	GET_THREAD_RESPONSE               = "GetThreadResponse"
+	ADD_REACTION_RESPONSE             = "AddReactionResponse"
+	REMOVE_REACTION_RESPONSE          = "RemoveReactionResponse"
+	MARK_READ_RESPONSE                = "MarkReadResponse"
+	PIN_MESSAGE_RESPONSE              = "PinMessageResponse"
+	UNPIN_MESSAGE_RESPONSE            = "UnpinMessageResponse"
+	GET_PINNED_MESSAGES_RESPONSE      = "GetPinnedMessagesResponse"
+	GET_MENTIONS_RESPONSE             = "GetMentionsResponse"
+	SCHEDULE_MESSAGE_RESPONSE         = "ScheduleMessageResponse"
+	LIST_SCHEDULED_MESSAGES_RESPONSE  = "ListScheduledMessagesResponse"
+	EDIT_SCHEDULED_MESSAGE_RESPONSE   = "EditScheduledMessageResponse"
+	CANCEL_SCHEDULED_MESSAGE_RESPONSE = "CancelScheduledMessageResponse"
+	SET_MESSAGE_TTL_RESPONSE          = "SetMessageTTLResponse"
+	FORWARD_MESSAGE_RESPONSE          = "ForwardMessageResponse"
+	CREATE_POLL_RESPONSE              = "CreatePollResponse"
+	VOTE_POLL_RESPONSE                = "VotePollResponse"
+	SEARCH_MESSAGES_RESPONSE          = "SearchMessagesResponse"
+	COMMAND_RESPONSE                  = "CommandResponse"
+	SAVE_DRAFT_RESPONSE               = "SaveDraftResponse"
+	GET_DRAFTS_RESPONSE               = "GetDraftsResponse"
+	SAVE_MESSAGE_RESPONSE             = "SaveMessageResponse"
+	UNSAVE_MESSAGE_RESPONSE           = "UnsaveMessageResponse"
+	LIST_SAVED_MESSAGES_RESPONSE      = "ListSavedMessagesResponse"
+	ACK_MESSAGES_RESPONSE             = "AckMessagesResponse" // only sent for failed acks
+	GET_MESSAGE_RECEIPTS_RESPONSE     = "GetMessageReceiptsResponse"
+	RESUME_RESPONSE                   = "ResumeResponse"
+)
+
+// Events that are pushed to clients without a matching request
+const (
+	TYPING_EVENT                  = "TypingEvent"
+	MENTION_EVENT                 = "MentionEvent"
+	MESSAGE_PREVIEW_UPDATED_EVENT = "MessagePreviewUpdatedEvent"
+	MESSAGES_EXPIRED_EVENT        = "MessagesExpiredEvent"
+	CHAT_UPDATED_EVENT            = "ChatUpdatedEvent"
+	DRAFT_UPDATED_EVENT           = "DraftUpdatedEvent"
+	MESSAGE_STATUS_EVENT          = "MessageStatusEvent"
+)
+
+// Scopes of a DeleteMessageRequest
+const (
+	DELETE_SCOPE_EVERYONE = "everyone"
+	DELETE_SCOPE_ME       = "me"
+)
+
+// Reasons a saved message is a tombstone
+const (
+	SAVED_TOMBSTONE_DELETED    = "deleted"
+	SAVED_TOMBSTONE_NOT_MEMBER = "notMember"
+)
+
+// Delivery states of a message for one of its recipients
+const (
+	MESSAGE_STATUS_SENT      = "sent"
+	MESSAGE_STATUS_DELIVERED = "delivered"
+	MESSAGE_STATUS_READ      = "read"
+)
+
+// Content types of a message
+const (
+	CONTENT_TYPE_PLAIN    = "text/plain"
+	CONTENT_TYPE_MARKDOWN = "text/markdown"
+	CONTENT_TYPE_CODE     = "application/vnd.chatty.code+json"
+	// CONTENT_TYPE_POLL marks the messages of polls, their content is the
+	// poll question. Polls are created with a CreatePollRequest.
+	CONTENT_TYPE_POLL = "application/vnd.chatty.poll"
 )
 
 // Base types
 // Base request structure
+// RequestID is optional and chosen by the client. It is echoed in the
+// response to the request, including error responses.
 type Request struct {
-	Type string          `json:"type"`
-	Data json.RawMessage `json:"data"`
+	Type      string          `json:"type"`
+	Data      json.RawMessage `json:"data"`
+	RequestID string          `json:"requestId,omitempty"`
 }
 
 // Base response structure
+// Unsolicited is set on responses that don't answer one of the receiving
+// client's requests, e.g. a message sent to a chat by another member.
 type Response struct {
-	Type  string          `json:"type"`
-	Data  json.RawMessage `json:"data"`
-	Error string          `json:"error"`
+	Type        string          `json:"type"`
+	Data        json.RawMessage `json:"data"`
+	Error       string          `json:"error"`
+	RequestID   string          `json:"requestId,omitempty"`
+	Unsolicited bool            `json:"unsolicited,omitempty"`
+	// Seq is the number of the event in the event sequence of the recipient.
+	// Responses that aren't logged have none.
+	Seq int64 `json:"seq,omitempty"`
+	// Session is the session of the request a reply answers, as reported by
+	// the SessionSender when the reply was made. Responses are delivered after
+	// their request was processed, so senders can't look it up then.
+	Session any `json:"-"`
 }
 
 type UserInfo struct {
@@ -44,31 +154,123 @@ type UserInfo struct {
 type ChatInfo struct {
 	Id        string     `json:"id"`
 	Name      string     `json:"name"`
+	Topic     string     `json:"topic"`
 	UpdatedAt time.Time  `json:"updatedAt"`
 	UserInfos []UserInfo `json:"userInfos"`
+
+	// MessageTTL is how many seconds messages are kept, see SetMessageTTLRequest
+	MessageTTL *int `json:"messageTtl,omitempty"`
+
+	// UnreadCount and ReadMarkers are relative to the requesting user;
+	// ReadMarkers holds the read positions of the other members
+	UnreadCount int          `json:"unreadCount"`
+	ReadMarkers []ReadMarker `json:"readMarkers"`
+
+	// Muted is set while the requesting user has the chat muted, until
+	// MutedUntil or until they unmute it if MutedUntil is null
+	Muted      bool    `json:"muted"`
+	MutedUntil *string `json:"mutedUntil,omitempty"`
+}
+
+type ReadMarker struct {
+	UserID            string  `json:"userId"`
+	LastReadMessageID *string `json:"lastReadMessageId"`
+	LastReadAt        *string `json:"lastReadAt"`
 }
 
 type ChatHistoryMessage struct {
-	MessageID  string `json:"messageId"`
-	SenderName string `json:"senderName"`
-	Content    string `json:"content"`
-	Timestamp  string `json:"timestamp"`
+	MessageID   string `json:"messageId"`
+	SenderID    string `json:"senderId"`
+	SenderName  string `json:"senderName"`
+	Content     string `json:"content"`
+	ContentType string `json:"contentType"`
+	// Seq numbers the messages of a chat, thread replies included, in the
+	// order they were sent. Gaps only appear where messages expired.
+	Seq       int64   `json:"seq"`
+	Timestamp string  `json:"timestamp"`
+	EditedAt  *string `json:"editedAt,omitempty"`
+	DeletedAt *string `json:"deletedAt,omitempty"`
+
+	// Replies carry the id of their thread's root message,
+	// roots carry statistics about their thread
+	ParentMessageID *string `json:"parentMessageId,omitempty"`
+	ReplyCount      int     `json:"replyCount"`
+	LastReplyAt     *string `json:"lastReplyAt,omitempty"`
+
+	// ForwardedFrom is set on copies made by a ForwardMessageRequest
+	ForwardedFrom *ForwardedFrom `json:"forwardedFrom,omitempty"`
+
+	// Poll is set on messages of the CONTENT_TYPE_POLL content type
+	Poll *Poll `json:"poll,omitempty"`
+
+	Reactions    []Reaction    `json:"reactions"`
+	LinkPreviews []LinkPreview `json:"linkPreviews"`
+}
+
+// ForwardedFrom attributes a forwarded message to its original. The ids are
+// null once the original message, sender or chat no longer exists.
+type ForwardedFrom struct {
+	MessageID  *string `json:"messageId"`
+	SenderID   *string `json:"senderId"`
+	SenderName *string `json:"senderName"`
+	ChatID     *string `json:"chatId"`
+	Timestamp  string  `json:"timestamp"`
+}
+
+type LinkPreview struct {
+	URL         string `json:"url"`
+	Title       string `json:"title"`
+	Description string `json:"description"`
+	ImageURL    string `json:"imageUrl"`
+	SiteName    string `json:"siteName"`
+}
+
+type Reaction struct {
+	Emoji       string `json:"emoji"`
+	Count       int    `json:"count"`
+	ReactedByMe bool   `json:"reactedByMe"`
 }
 
 // Client APIs
 //
 // Send Message
+// Set ParentMessageID to reply in the thread of that message.
+// ClientMessageID makes the request safe to retry: resending a message with
+// the same ClientMessageID returns the original SendMessageResponse to the
+// sender instead of storing the message again.
+// ContentType defaults to CONTENT_TYPE_PLAIN. Markdown is sanitized before it
+// is stored: raw HTML other than a few formatting tags is escaped and links
+// may only use http, https and mailto. Code content is a CodeContent document.
+// Set SendAt to a future time, or set Recurrence, to schedule the message
+// instead of sending it now; see Scheduled Messages.
 type SendMessageRequest struct {
-	ChatID  string `json:"chatId"`
-	Content string `json:"content"`
+	ChatID          string  `json:"chatId"`
+	Content         string  `json:"content"`
+	ContentType     string  `json:"contentType,omitempty"`
+	ParentMessageID *string `json:"parentMessageId,omitempty"`
+	ClientMessageID *string `json:"clientMessageId,omitempty"`
+
+	SendAt     *time.Time `json:"sendAt,omitempty"`
+	Recurrence *string    `json:"recurrence,omitempty"`
 }
 
 type SendMessageResponse struct {
-	ChatID    string `json:"chatId"`
-	SenderID  string `json:"senderId"`
-	Content   string `json:"content"`
-	Timestamp string `json:"timestamp"`
-	MessageID string `json:"messageId"`
+	ChatID      string `json:"chatId"`
+	SenderID    string `json:"senderId"`
+	Content     string `json:"content"`
+	ContentType string `json:"contentType"`
+	Timestamp   string `json:"timestamp"`
+	MessageID   string `json:"messageId"`
+	// Seq numbers the messages of the chat, see ChatHistoryMessage
+	Seq             int64   `json:"seq"`
+	ParentMessageID *string `json:"parentMessageId,omitempty"`
+	ClientMessageID *string `json:"clientMessageId,omitempty"`
+}
+
+// CodeContent is the content of a CONTENT_TYPE_CODE message
+type CodeContent struct {
+	Language string `json:"language"`
+	Code     string `json:"code"`
 }
 
 // Create Chat
@@ -80,10 +282,17 @@ type CreateChatRequest struct {
 type CreateChatResponse ChatInfo
 
 // Get Chat History
+// Messages are returned newest first, in the order of their seq. To fetch the
+// next (older) page, pass the seq of the last message received as the cursor.
+// Passing only the messageId of the last message is still accepted.
 type GetChatHistoryRequest struct {
-	ChatID string `json:"chatID"`
-	Offset int    `json:"offset"`
-	Limit  int    `json:"limit"`
+	ChatID    string `json:"chatID"`
+	CursorSeq *int64 `json:"cursorSeq,omitempty"`
+	// Deprecated: CursorCreatedAt is ignored, CursorMessageID alone
+	// identifies the last message
+	CursorCreatedAt *time.Time `json:"cursorCreatedAt,omitempty"`
+	CursorMessageID *string    `json:"cursorMessageID,omitempty"`
+	Limit           int        `json:"limit"`
 }
 
 type GetChatHistoryResponse struct {
@@ -92,6 +301,524 @@ type GetChatHistoryResponse struct {
 	HasMore  bool                 `json:"hasMore"`
 }
 
+// Get Thread
+// Replies are returned newest first and paginated like GetChatHistoryRequest.
+type GetThreadRequest struct {
+	ParentMessageID string `json:"parentMessageId"`
+	CursorSeq       *int64 `json:"cursorSeq,omitempty"`
+	// Deprecated: CursorCreatedAt is ignored, CursorMessageID alone
+	// identifies the last reply
+	CursorCreatedAt *time.Time `json:"cursorCreatedAt,omitempty"`
+	CursorMessageID *string    `json:"cursorMessageID,omitempty"`
+	Limit           int        `json:"limit"`
+}
+
+type GetThreadResponse struct {
+	ChatID          string               `json:"chatID"`
+	ParentMessageID string               `json:"parentMessageId"`
+	Messages        []ChatHistoryMessage `json:"messages"`
+	HasMore         bool                 `json:"hasMore"`
+}
+
+// Edit Message
+// The new content must be valid for the message's content type, which can't
+// be changed.
+type EditMessageRequest struct {
+	MessageID string `json:"messageId"`
+	Content   string `json:"content"`
+}
+
+type EditMessageResponse struct {
+	ChatID      string `json:"chatId"`
+	MessageID   string `json:"messageId"`
+	Content     string `json:"content"`
+	ContentType string `json:"contentType"`
+	EditedAt    string `json:"editedAt"`
+}
+
+// Delete Message
+// A message deleted for everyone is sent to every chat member as a tombstone,
+// a message deleted for me only disappears from the requesting user's history.
+type DeleteMessageRequest struct {
+	MessageID string `json:"messageId"`
+	Scope     string `json:"scope"`
+}
+
+type DeleteMessageResponse struct {
+	ChatID    string `json:"chatId"`
+	MessageID string `json:"messageId"`
+	Scope     string `json:"scope"`
+	DeletedAt string `json:"deletedAt"`
+}
+
+// Add / Remove Reaction
+// Count is the number of users that reacted with the emoji after the change.
+type ReactionRequest struct {
+	MessageID string `json:"messageId"`
+	Emoji     string `json:"emoji"`
+}
+
+type ReactionResponse struct {
+	ChatID    string `json:"chatId"`
+	MessageID string `json:"messageId"`
+	UserID    string `json:"userId"`
+	Emoji     string `json:"emoji"`
+	Count     int    `json:"count"`
+}
+
+type AddReactionRequest ReactionRequest
+type AddReactionResponse ReactionResponse
+type RemoveReactionRequest ReactionRequest
+type RemoveReactionResponse ReactionResponse
+
+// Mark Read
+// Moves the sender's read marker forward to MessageID. The new position is
+// sent to every chat member; if the marker didn't move only the sender gets
+// its current position back.
+type MarkReadRequest struct {
+	ChatID    string `json:"chatId"`
+	MessageID string `json:"messageId"`
+}
+
+type MarkReadResponse struct {
+	ChatID string `json:"chatId"`
+	ReadMarker
+}
+
+// Typing Start / Stop
+// Other chat members receive a TypingEvent. An indicator expires on the
+// server unless it is refreshed by another TypingStartRequest.
+type TypingRequest struct {
+	ChatID string `json:"chatId"`
+}
+
+type TypingStartRequest TypingRequest
+type TypingStopRequest TypingRequest
+
+type TypingEvent struct {
+	ChatID string `json:"chatId"`
+	UserID string `json:"userId"`
+	Typing bool   `json:"typing"`
+}
+
+// Pin / Unpin Message
+// Pins are shared by all chat members, so every member is notified when a
+// message is pinned or unpinned.
+type PinMessageRequest struct {
+	MessageID string `json:"messageId"`
+}
+
+type PinMessageResponse struct {
+	ChatID    string `json:"chatId"`
+	MessageID string `json:"messageId"`
+	PinnedBy  string `json:"pinnedBy"`
+	PinnedAt  string `json:"pinnedAt"`
+}
+
+type UnpinMessageRequest struct {
+	MessageID string `json:"messageId"`
+}
+
+type UnpinMessageResponse struct {
+	ChatID     string `json:"chatId"`
+	MessageID  string `json:"messageId"`
+	UnpinnedBy string `json:"unpinnedBy"`
+}
+
+// Get Pinned Messages
+// Pins are returned most recently pinned first.
+type GetPinnedMessagesRequest struct {
+	ChatID string `json:"chatId"`
+}
+
+type GetPinnedMessagesResponse struct {
+	ChatID string          `json:"chatId"`
+	Pins   []PinnedMessage `json:"pins"`
+}
+
+type PinnedMessage struct {
+	Message  ChatHistoryMessage `json:"message"`
+	PinnedBy string             `json:"pinnedBy"`
+	PinnedAt string             `json:"pinnedAt"`
+}
+
+// Get Mentions
+// Messages that mention the requesting user, across all of their chats, are
+// returned newest first. To fetch the next page, pass the timestamp and
+// messageId of the last message received as the cursor. A mentioned
+// user is also sent a MentionEvent when the message is sent.
+type GetMentionsRequest struct {
+	CursorCreatedAt *time.Time `json:"cursorCreatedAt,omitempty"`
+	CursorMessageID *string    `json:"cursorMessageID,omitempty"`
+	Limit           int        `json:"limit"`
+}
+
+type GetMentionsResponse struct {
+	Mentions []Mention `json:"mentions"`
+	HasMore  bool      `json:"hasMore"`
+}
+
+type Mention struct {
+	ChatID  string             `json:"chatId"`
+	Message ChatHistoryMessage `json:"message"`
+}
+
+type MentionEvent Mention
+
+// Message Preview Updated
+// Links in a new message are unfurled in the background. Once their previews
+// are ready they are sent to every chat member in a MessagePreviewUpdatedEvent
+// and included in the message's history from then on.
+type MessagePreviewUpdatedEvent struct {
+	ChatID       string        `json:"chatId"`
+	MessageID    string        `json:"messageId"`
+	LinkPreviews []LinkPreview `json:"linkPreviews"`
+}
+
+// Scheduled Messages
+// A SendMessageRequest with a future SendAt is answered with a
+// ScheduleMessageResponse and sent to the chat as a regular message once it is
+// due. Recurrence is one of "daily", "weekdays" or "weekly" (in UTC); a
+// recurring message is sent at SendAt and then at every following occurrence
+// until it is cancelled. ClientMessageID is ignored for scheduled messages.
+// Scheduled messages are only visible to their sender.
+type ScheduledMessage struct {
+	ScheduledMessageID string  `json:"scheduledMessageId"`
+	ChatID             string  `json:"chatId"`
+	Content            string  `json:"content"`
+	ContentType        string  `json:"contentType"`
+	ParentMessageID    *string `json:"parentMessageId,omitempty"`
+	Recurrence         *string `json:"recurrence,omitempty"`
+	ClientMessageID    *string `json:"clientMessageId,omitempty"`
+	SendAt             string  `json:"sendAt"`
+	// Attempts counts the failed dispatches of the message. A message that
+	// failed too often has FailedAt set and is only sent again once it is
+	// rescheduled.
+	Attempts int     `json:"attempts,omitempty"`
+	FailedAt *string `json:"failedAt,omitempty"`
+}
+
+type ScheduleMessageResponse ScheduledMessage
+
+// ChatID optionally limits the list to one chat
+type ListScheduledMessagesRequest struct {
+	ChatID *string `json:"chatId,omitempty"`
+}
+
+type ListScheduledMessagesResponse struct {
+	ScheduledMessages []ScheduledMessage `json:"scheduledMessages"`
+}
+
+// Fields that are left out stay unchanged. An empty Recurrence turns a
+// recurring message into a one-off.
+type EditScheduledMessageRequest struct {
+	ScheduledMessageID string     `json:"scheduledMessageId"`
+	Content            *string    `json:"content,omitempty"`
+	SendAt             *time.Time `json:"sendAt,omitempty"`
+	Recurrence         *string    `json:"recurrence,omitempty"`
+}
+
+type EditScheduledMessageResponse ScheduledMessage
+
+type CancelScheduledMessageRequest struct {
+	ScheduledMessageID string `json:"scheduledMessageId"`
+}
+
+type CancelScheduledMessageResponse struct {
+	ScheduledMessageID string `json:"scheduledMessageId"`
+}
+
+// Set Message TTL
+// Messages of a chat with a message TTL are permanently deleted once they are
+// older than MessageTTL seconds, and the chat members are sent a
+// MessagesExpiredEvent listing them. A null or zero MessageTTL keeps messages
+// forever. Every chat member is told about the new setting.
+type SetMessageTTLRequest struct {
+	ChatID     string `json:"chatId"`
+	MessageTTL *int   `json:"messageTtl"`
+}
+
+type SetMessageTTLResponse struct {
+	ChatID     string `json:"chatId"`
+	MessageTTL *int   `json:"messageTtl"`
+	UpdatedBy  string `json:"updatedBy"`
+}
+
+// MessageIDs includes the replies of expired thread roots
+type MessagesExpiredEvent struct {
+	ChatID     string   `json:"chatId"`
+	MessageIDs []string `json:"messageIds"`
+}
+
+// Forward Message
+// The messages are copied into the target chat in the order they were
+// originally sent, attributed to their original sender, chat and time.
+// Forwarding a forwarded message keeps the original attribution. The sender
+// must be able to read every message and be a member of the target chat.
+// Every member of the target chat is sent the response.
+type ForwardMessageRequest struct {
+	MessageIDs   []string `json:"messageIds"`
+	TargetChatID string   `json:"targetChatId"`
+}
+
+type ForwardMessageResponse struct {
+	ChatID   string               `json:"chatId"`
+	Messages []ChatHistoryMessage `json:"messages"`
+}
+
+// Create Poll
+// Creates a poll message in a chat. The question is the content of the
+// message. A poll takes votes until ClosesAt, or forever if it is not set.
+// Every chat member is sent the response.
+type CreatePollRequest struct {
+	ChatID         string     `json:"chatId"`
+	Question       string     `json:"question"`
+	Options        []string   `json:"options"`
+	MultipleChoice bool       `json:"multipleChoice"`
+	ClosesAt       *time.Time `json:"closesAt,omitempty"`
+}
+
+type CreatePollResponse struct {
+	ChatID  string             `json:"chatId"`
+	Message ChatHistoryMessage `json:"message"`
+}
+
+// VotedByMe is relative to the requesting user
+type Poll struct {
+	MultipleChoice bool         `json:"multipleChoice"`
+	ClosesAt       *string      `json:"closesAt"`
+	Closed         bool         `json:"closed"`
+	TotalVoters    int          `json:"totalVoters"`
+	Options        []PollOption `json:"options"`
+}
+
+type PollOption struct {
+	OptionID  string `json:"optionId"`
+	Text      string `json:"text"`
+	VoteCount int    `json:"voteCount"`
+	VotedByMe bool   `json:"votedByMe"`
+}
+
+// Vote Poll
+// Replaces the sender's votes in a poll with OptionIDs; an empty OptionIDs
+// withdraws them. Single choice polls take at most one option. Every chat
+// member is sent the response with the new tallies, OptionIDs are the
+// choices of the voter UserID.
+type VotePollRequest struct {
+	MessageID string   `json:"messageId"`
+	OptionIDs []string `json:"optionIds"`
+}
+
+type VotePollResponse struct {
+	ChatID      string            `json:"chatId"`
+	MessageID   string            `json:"messageId"`
+	UserID      string            `json:"userId"`
+	OptionIDs   []string          `json:"optionIds"`
+	TotalVoters int               `json:"totalVoters"`
+	Tallies     []PollOptionTally `json:"tallies"`
+}
+
+type PollOptionTally struct {
+	OptionID  string `json:"optionId"`
+	VoteCount int    `json:"voteCount"`
+}
+
+// Search Messages
+// Searches the messages of all chats the requesting user is a member of. The
+// query holds search terms and filters separated by spaces:
+//
+//	from:<email>|me   messages sent by a user
+//	in:<chatId>       messages of a chat
+//	before:<date>     messages sent before a date, YYYY-MM-DD or RFC 3339
+//	after:<date>      messages sent on or after a date
+//	has:link          messages containing a link
+//
+// Terms support quoted phrases, "or" and "-" to exclude a word. Results are
+// ranked best match first; without terms they are returned newest first. To
+// fetch the next page, pass the rank, timestamp and messageId of the last
+// result received as the cursor. In Snippet the matches are wrapped in
+// <mark> tags and everything else is HTML escaped.
+type SearchMessagesRequest struct {
+	Query           string     `json:"query"`
+	CursorRank      *float32   `json:"cursorRank,omitempty"`
+	CursorCreatedAt *time.Time `json:"cursorCreatedAt,omitempty"`
+	CursorMessageID *string    `json:"cursorMessageID,omitempty"`
+	Limit           int        `json:"limit"`
+}
+
+type SearchMessagesResponse struct {
+	Results []SearchResult `json:"results"`
+	HasMore bool           `json:"hasMore"`
+}
+
+type SearchResult struct {
+	ChatID  string             `json:"chatId"`
+	Message ChatHistoryMessage `json:"message"`
+	Rank    float32            `json:"rank"`
+	Snippet string             `json:"snippet"`
+}
+
+// Slash Commands
+// A SendMessageRequest whose content starts with "/" runs a command instead of
+// sending a message, e.g. "/rename Release planning". Content starting with
+// "//" is sent as a message without its first slash. "/help" lists the
+// available commands. The sender is replied to with a CommandResponse that
+// no one else receives, or with an error response of that type.
+type CommandResponse struct {
+	ChatID  string `json:"chatId"`
+	Command string `json:"command"`
+	Text    string `json:"text"`
+}
+
+// ChatUpdatedEvent is sent to the members of a chat when a command changes
+// its name, topic or members. Members that left the chat aren't sent it.
+type ChatUpdatedEvent struct {
+	ChatID    string     `json:"chatId"`
+	Name      string     `json:"name"`
+	Topic     string     `json:"topic"`
+	UserInfos []UserInfo `json:"userInfos"`
+	UpdatedBy string     `json:"updatedBy"`
+}
+
+// Drafts
+// A user has one draft per chat, and one per thread of a chat, which is
+// selected with ParentMessageID like in a SendMessageRequest. Saving blank
+// content deletes the draft. Sending a message to a chat or thread deletes
+// its draft.
+type SaveDraftRequest struct {
+	ChatID          string  `json:"chatId"`
+	ParentMessageID *string `json:"parentMessageId,omitempty"`
+	Content         string  `json:"content"`
+}
+
+type SaveDraftResponse Draft
+
+// GetDraftsRequest returns the drafts of every chat of the user, most
+// recently updated first, or only the drafts of ChatID
+type GetDraftsRequest struct {
+	ChatID *string `json:"chatId,omitempty"`
+}
+
+type GetDraftsResponse struct {
+	Drafts []Draft `json:"drafts"`
+}
+
+// DraftUpdatedEvent is sent to a user's other sessions, e.g. their phone,
+// when a draft is saved or deleted. A deleted draft has empty Content.
+type DraftUpdatedEvent Draft
+
+type Draft struct {
+	ChatID          string  `json:"chatId"`
+	ParentMessageID *string `json:"parentMessageId,omitempty"`
+	Content         string  `json:"content"`
+	UpdatedAt       string  `json:"updatedAt"`
+}
+
+// Saved Messages
+// Users bookmark messages they can read into a private list, with an
+// optional note and tags. Saving a message again replaces its note and tags.
+// A saved message whose message was deleted, or whose chat the user left,
+// is listed as a tombstone: its Message is left out and Tombstone is one of
+// the SAVED_TOMBSTONE_ reasons.
+type SaveMessageRequest struct {
+	MessageID string   `json:"messageId"`
+	Note      string   `json:"note"`
+	Tags      []string `json:"tags"`
+}
+
+type SaveMessageResponse SavedMessage
+
+type UnsaveMessageRequest struct {
+	SavedID string `json:"savedId"`
+}
+
+type UnsaveMessageResponse struct {
+	SavedID string `json:"savedId"`
+}
+
+// ListSavedMessagesRequest returns the saved messages of the user, most
+// recently saved first, or only those tagged Tag. To fetch the next page,
+// pass the savedAt and savedId of the last saved message received as the
+// cursor.
+type ListSavedMessagesRequest struct {
+	Tag           *string    `json:"tag,omitempty"`
+	CursorSavedAt *time.Time `json:"cursorSavedAt,omitempty"`
+	CursorSavedID *string    `json:"cursorSavedId,omitempty"`
+	Limit         int        `json:"limit"`
+}
+
+type ListSavedMessagesResponse struct {
+	SavedMessages []SavedMessage `json:"savedMessages"`
+	HasMore       bool           `json:"hasMore"`
+}
+
+type SavedMessage struct {
+	SavedID   string              `json:"savedId"`
+	ChatID    *string             `json:"chatId,omitempty"`
+	MessageID *string             `json:"messageId,omitempty"`
+	Note      string              `json:"note"`
+	Tags      []string            `json:"tags"`
+	SavedAt   string              `json:"savedAt"`
+	Message   *ChatHistoryMessage `json:"message,omitempty"`
+	Tombstone string              `json:"tombstone,omitempty"`
+}
+
+// Message Receipts
+// Clients acknowledge the messages they receive with an AckMessagesRequest,
+// which marks them delivered; moving a read marker marks the messages up to
+// it read. The sender of a message receives a MessageStatusEvent whenever
+// one of its recipients confirms it. A recipient that never acknowledges a
+// message, e.g. because it was dropped on a slow connection, stays "sent".
+type AckMessagesRequest struct {
+	MessageIDs []string `json:"messageIds"`
+}
+
+type MessageStatusEvent struct {
+	ChatID string `json:"chatId"`
+	// UserID is the recipient whose receipts changed
+	UserID     string   `json:"userId"`
+	Status     string   `json:"status"`
+	MessageIDs []string `json:"messageIds"`
+	Timestamp  string   `json:"timestamp"`
+}
+
+// GetMessageReceiptsRequest returns the status of a message for each of its
+// recipients. Only the sender of the message can request it.
+type GetMessageReceiptsRequest struct {
+	MessageID string `json:"messageId"`
+}
+
+type GetMessageReceiptsResponse struct {
+	MessageID string           `json:"messageId"`
+	Receipts  []MessageReceipt `json:"receipts"`
+}
+
+type MessageReceipt struct {
+	UserID      string  `json:"userId"`
+	Status      string  `json:"status"`
+	DeliveredAt *string `json:"deliveredAt,omitempty"`
+	ReadAt      *string `json:"readAt,omitempty"`
+}
+
+// Resume
+// Events that change the state of a client carry the next number of the
+// recipient's event sequence as the seq of the response. After a reconnect,
+// a client sends the last seq it processed and receives the events it missed,
+// oldest first; while HasMore is set it asks again with the returned LastSeq.
+// Without a LastSeq only the current LastSeq is returned. Reset means that
+// the missed events are no longer available: the client has to reload its
+// chats and continue from the returned LastSeq.
+type ResumeRequest struct {
+	LastSeq *int64 `json:"lastSeq,omitempty"`
+}
+
+type ResumeResponse struct {
+	Events  []Response `json:"events"`
+	LastSeq int64      `json:"lastSeq"`
+	HasMore bool       `json:"hasMore"`
+	Reset   bool       `json:"reset"`
+}
+
 // Get Chats
 type GetChatsRequest struct {
 	CursorUpdatedAt *time.Time `json:"cursorUpdatedAt,omitempty"`
@@ -0,0 +1,233 @@
+package messageprocessor
+
+import (
+	"errors"
+	"log"
+	"strings"
+	"time"
+
+	"chatty.mtran.io/internal/models"
+	"chatty.mtran.io/internal/validator"
+	"github.com/google/uuid"
+)
+
+const (
+	// maxPollQuestionLength is the longest poll question accepted
+	maxPollQuestionLength = 300
+	// maxPollOptionLength matches poll_options.text
+	maxPollOptionLength = 100
+	minPollOptions      = 2
+	maxPollOptions      = 10
+)
+
+func (mp *MessageProcessor) handleCreatePollRequest(senderId uuid.UUID, requestId string, reqData CreatePollRequest) {
+	chatId, err := uuid.Parse(reqData.ChatID)
+	if err != nil {
+		mp.sendError(senderId, requestId, CREATE_POLL_RESPONSE, ErrInvalidChatID)
+		return
+	}
+	question := strings.TrimSpace(reqData.Question)
+	if !validator.NotBlank(question) || !validator.MaxChars(question, maxPollQuestionLength) {
+		mp.sendError(senderId, requestId, CREATE_POLL_RESPONSE, ErrInvalidPollQuestion)
+		return
+	}
+	options, ok := normalizePollOptions(reqData.Options)
+	if !ok {
+		mp.sendError(senderId, requestId, CREATE_POLL_RESPONSE, ErrInvalidPollOptions)
+		return
+	}
+	var closesAt *time.Time
+	if reqData.ClosesAt != nil {
+		if !reqData.ClosesAt.After(time.Now()) {
+			mp.sendError(senderId, requestId, CREATE_POLL_RESPONSE, ErrInvalidPollClosesAt)
+			return
+		}
+		utc := reqData.ClosesAt.UTC()
+		closesAt = &utc
+	}
+
+	members, err := mp.ChatModel.GetChatMembers(chatId)
+	if err != nil {
+		log.Printf("Error getting chat members: %v", err)
+		mp.sendError(senderId, requestId, CREATE_POLL_RESPONSE, ErrCannotGetChat)
+		return
+	}
+	memberIds := make([]uuid.UUID, 0, len(members))
+	var senderName string
+	for _, member := range members {
+		memberIds = append(memberIds, member.ID)
+		if member.ID == senderId {
+			senderName = member.Name
+		}
+	}
+	if senderName == "" {
+		mp.sendError(senderId, requestId, CREATE_POLL_RESPONSE, ErrNotChatMember)
+		return
+	}
+
+	message := &models.Message{
+		SenderID:    senderId,
+		ChatID:      chatId,
+		Content:     question,
+		ContentType: CONTENT_TYPE_POLL,
+		SenderName:  senderName,
+	}
+	poll := &models.Poll{
+		MultipleChoice: reqData.MultipleChoice,
+		ClosesAt:       closesAt,
+		Options:        make([]*models.PollOption, 0, len(options)),
+	}
+	for _, option := range options {
+		poll.Options = append(poll.Options, &models.PollOption{Text: option})
+	}
+	err = mp.MessageModel.InsertPoll(message, poll)
+	if err != nil {
+		log.Printf("Error saving poll: %v", err)
+		mp.sendError(senderId, requestId, CREATE_POLL_RESPONSE, ErrCannotCreatePoll)
+		return
+	}
+	message.Poll = poll
+
+	// creating a poll ends the sender's typing indicator
+	mp.stopTyping(chatId, senderId)
+
+	responseData := CreatePollResponse{
+		ChatID:  chatId.String(),
+		Message: chatHistoryMessageConvert(*message),
+	}
+	responseMessage := &Response{
+		Type:  CREATE_POLL_RESPONSE,
+		Data:  getJsonRawMessage(responseData),
+		Error: "",
+	}
+	mp.replyAndBroadcast(senderId, requestId, memberIds, responseMessage)
+}
+
+func (mp *MessageProcessor) handleVotePollRequest(senderId uuid.UUID, requestId string, reqData VotePollRequest) {
+	messageId, err := uuid.Parse(reqData.MessageID)
+	if err != nil {
+		mp.sendError(senderId, requestId, VOTE_POLL_RESPONSE, ErrInvalidMessageID)
+		return
+	}
+	optionIds := make([]uuid.UUID, 0, len(reqData.OptionIDs))
+	seen := make(map[uuid.UUID]bool, len(reqData.OptionIDs))
+	for _, rawId := range reqData.OptionIDs {
+		optionId, err := uuid.Parse(rawId)
+		if err != nil {
+			mp.sendError(senderId, requestId, VOTE_POLL_RESPONSE, ErrInvalidPollVote)
+			return
+		}
+		if !seen[optionId] {
+			seen[optionId] = true
+			optionIds = append(optionIds, optionId)
+		}
+	}
+
+	message, err := mp.getMemberMessage(senderId, messageId)
+	if errors.Is(err, models.ErrNoRecord) {
+		mp.sendError(senderId, requestId, VOTE_POLL_RESPONSE, ErrMessageNotFound)
+		return
+	}
+	if err != nil {
+		log.Printf("Error getting message: %v", err)
+		mp.sendError(senderId, requestId, VOTE_POLL_RESPONSE, ErrCannotVote)
+		return
+	}
+	if message.DeletedAt != nil {
+		mp.sendError(senderId, requestId, VOTE_POLL_RESPONSE, ErrMessageNotFound)
+		return
+	}
+	if message.ContentType != CONTENT_TYPE_POLL {
+		mp.sendError(senderId, requestId, VOTE_POLL_RESPONSE, ErrNotAPoll)
+		return
+	}
+
+	err = mp.MessageModel.Vote(messageId, senderId, optionIds)
+	switch {
+	case errors.Is(err, models.ErrNoRecord):
+		mp.sendError(senderId, requestId, VOTE_POLL_RESPONSE, ErrNotAPoll)
+		return
+	case errors.Is(err, models.ErrPollClosed):
+		mp.sendError(senderId, requestId, VOTE_POLL_RESPONSE, ErrPollClosed)
+		return
+	case errors.Is(err, models.ErrInvalidPollVote):
+		mp.sendError(senderId, requestId, VOTE_POLL_RESPONSE, ErrInvalidPollVote)
+		return
+	case err != nil:
+		log.Printf("Error saving poll vote: %v", err)
+		mp.sendError(senderId, requestId, VOTE_POLL_RESPONSE, ErrCannotVote)
+		return
+	}
+
+	poll, err := mp.MessageModel.GetPoll(messageId, senderId)
+	if err != nil {
+		log.Printf("Error getting poll: %v", err)
+		mp.sendError(senderId, requestId, VOTE_POLL_RESPONSE, ErrCannotVote)
+		return
+	}
+
+	responseData := VotePollResponse{
+		ChatID:      message.ChatID.String(),
+		MessageID:   messageId.String(),
+		UserID:      senderId.String(),
+		OptionIDs:   make([]string, 0, len(optionIds)),
+		TotalVoters: poll.TotalVoters,
+		Tallies:     make([]PollOptionTally, 0, len(poll.Options)),
+	}
+	for _, option := range poll.Options {
+		if option.VotedByMe {
+			responseData.OptionIDs = append(responseData.OptionIDs, option.ID.String())
+		}
+		responseData.Tallies = append(responseData.Tallies, PollOptionTally{
+			OptionID:  option.ID.String(),
+			VoteCount: option.VoteCount,
+		})
+	}
+	responseMessage := &Response{
+		Type:  VOTE_POLL_RESPONSE,
+		Data:  getJsonRawMessage(responseData),
+		Error: "",
+	}
+	mp.sendToChatMembers(message.ChatID, senderId, requestId, responseMessage)
+}
+
+// normalizePollOptions trims the options of a new poll and checks that there
+// are enough of them and that they are distinct
+func normalizePollOptions(rawOptions []string) ([]string, bool) {
+	if len(rawOptions) < minPollOptions || len(rawOptions) > maxPollOptions {
+		return nil, false
+	}
+	options := make([]string, 0, len(rawOptions))
+	seen := make(map[string]bool, len(rawOptions))
+	for _, rawOption := range rawOptions {
+		option := strings.TrimSpace(rawOption)
+		if !validator.NotBlank(option) || !validator.MaxChars(option, maxPollOptionLength) || seen[option] {
+			return nil, false
+		}
+		seen[option] = true
+		options = append(options, option)
+	}
+	return options, true
+}
+
+func pollConvert(poll models.Poll) Poll {
+	convertedPoll := Poll{
+		MultipleChoice: poll.MultipleChoice,
+		Closed:         poll.Closed,
+		TotalVoters:    poll.TotalVoters,
+		Options:        make([]PollOption, 0, len(poll.Options)),
+	}
+	if poll.ClosesAt != nil {
+		closesAt := formatTimestamp(*poll.ClosesAt)
+		convertedPoll.ClosesAt = &closesAt
+	}
+	for _, option := range poll.Options {
+		convertedPoll.Options = append(convertedPoll.Options, PollOption{
+			OptionID:  option.ID.String(),
+			Text:      option.Text,
+			VoteCount: option.VoteCount,
+			VotedByMe: option.VotedByMe,
+		})
+	}
+	return convertedPoll
+}
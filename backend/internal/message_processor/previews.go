@@ -0,0 +1,143 @@
+package messageprocessor
+
+import (
+	"context"
+	"errors"
+	"log"
+	"net/url"
+	"regexp"
+	"strings"
+	"time"
+
+	"chatty.mtran.io/internal/models"
+)
+
+const (
+	// maxLinkPreviews is the number of links of a message that are unfurled
+	maxLinkPreviews = 3
+	// maxLinkLength is the longest URL that is unfurled
+	maxLinkLength = 2048
+	// linkPreviewTTL is how long a cached preview is reused before the link
+	// is unfurled again
+	linkPreviewTTL = 24 * time.Hour
+	// unfurlTimeout bounds the unfurling of a single link
+	unfurlTimeout = 10 * time.Second
+)
+
+var linkRX = regexp.MustCompile("https?://[^\\s<>\"'`]+")
+
+// Unfurler fetches the preview of a link. Implementations must bound the
+// time and the amount of data they spend on a link.
+type Unfurler interface {
+	Unfurl(ctx context.Context, url string) (*models.LinkPreview, error)
+}
+
+// extractLinks returns the distinct http(s) links in content, in order
+func extractLinks(content string) []string {
+	var links []string
+	seen := make(map[string]bool)
+
+	for _, link := range linkRX.FindAllString(content, -1) {
+		// punctuation that ends the surrounding sentence
+		link = strings.TrimRight(link, ".,;:!?)]}*_~")
+		if seen[link] || len(link) > maxLinkLength {
+			continue
+		}
+		parsed, err := url.Parse(link)
+		if err != nil || parsed.Host == "" {
+			continue
+		}
+		seen[link] = true
+		links = append(links, link)
+		if len(links) == maxLinkPreviews {
+			break
+		}
+	}
+	return links
+}
+
+// unfurlLinks attaches previews of the links in a new message to it and sends
+// them to the chat members. It blocks while links are fetched, so it is
+// meant to run in its own goroutine.
+func (mp *MessageProcessor) unfurlLinks(message models.Message) {
+	if mp.unfurler == nil || message.ContentType == CONTENT_TYPE_CODE {
+		return
+	}
+	links := extractLinks(message.Content)
+	if len(links) == 0 {
+		return
+	}
+
+	var previews []*models.LinkPreview
+	for _, link := range links {
+		preview, err := mp.getLinkPreview(link)
+		if err != nil {
+			log.Printf("Error unfurling %s: %v", link, err)
+			continue
+		}
+		previews = append(previews, preview)
+	}
+	if len(previews) == 0 {
+		return
+	}
+
+	urls := make([]string, 0, len(previews))
+	for _, preview := range previews {
+		urls = append(urls, preview.URL)
+	}
+	if err := mp.MessageModel.AttachLinkPreviews(message.ID, urls); err != nil {
+		log.Printf("Error attaching link previews: %v", err)
+		return
+	}
+
+	responseData := MessagePreviewUpdatedEvent{
+		ChatID:       message.ChatID.String(),
+		MessageID:    message.ID.String(),
+		LinkPreviews: linkPreviewsConvert(previews),
+	}
+	responseMessage := &Response{
+		Type:  MESSAGE_PREVIEW_UPDATED_EVENT,
+		Data:  getJsonRawMessage(responseData),
+		Error: "",
+	}
+	mp.sendEventToChatMembers(message.ChatID, responseMessage)
+}
+
+// getLinkPreview returns the cached preview of a link, unfurling it if it
+// isn't cached or the cached preview is stale
+func (mp *MessageProcessor) getLinkPreview(link string) (*models.LinkPreview, error) {
+	preview, err := mp.MessageModel.GetLinkPreview(link)
+	if err == nil && time.Since(preview.FetchedAt) < linkPreviewTTL {
+		return preview, nil
+	}
+	if err != nil && !errors.Is(err, models.ErrNoRecord) {
+		return nil, err
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), unfurlTimeout)
+	defer cancel()
+	preview, err = mp.unfurler.Unfurl(ctx, link)
+	if err != nil {
+		return nil, err
+	}
+	preview.URL = link
+
+	if err = mp.MessageModel.SaveLinkPreview(preview); err != nil {
+		return nil, err
+	}
+	return preview, nil
+}
+
+func linkPreviewsConvert(previews []*models.LinkPreview) []LinkPreview {
+	linkPreviews := make([]LinkPreview, 0, len(previews))
+	for _, preview := range previews {
+		linkPreviews = append(linkPreviews, LinkPreview{
+			URL:         preview.URL,
+			Title:       preview.Title,
+			Description: preview.Description,
+			ImageURL:    preview.ImageURL,
+			SiteName:    preview.SiteName,
+		})
+	}
+	return linkPreviews
+}
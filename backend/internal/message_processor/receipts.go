@@ -0,0 +1,171 @@
+package messageprocessor
+
+import (
+	"errors"
+	"log"
+
+	"chatty.mtran.io/internal/models"
+	"github.com/google/uuid"
+)
+
+// maxAckMessages bounds the messages a single AckMessagesRequest confirms
+const maxAckMessages = 100
+
+// handleAckMessagesRequest records that the messages a client received were
+// delivered. Acks aren't answered unless they fail, with an
+// AckMessagesResponse; the senders of the messages receive a
+// MessageStatusEvent.
+func (mp *MessageProcessor) handleAckMessagesRequest(senderId uuid.UUID, requestId string, reqData AckMessagesRequest) {
+	if len(reqData.MessageIDs) == 0 {
+		return
+	}
+	if len(reqData.MessageIDs) > maxAckMessages {
+		mp.sendError(senderId, requestId, ACK_MESSAGES_RESPONSE, ErrTooManyMessagesToAck)
+		return
+	}
+	messageIds := make([]uuid.UUID, 0, len(reqData.MessageIDs))
+	for _, rawId := range reqData.MessageIDs {
+		messageId, err := uuid.Parse(rawId)
+		if err != nil {
+			mp.sendError(senderId, requestId, ACK_MESSAGES_RESPONSE, ErrInvalidMessageID)
+			return
+		}
+		messageIds = append(messageIds, messageId)
+	}
+
+	receipts, err := mp.MessageModel.MarkDelivered(senderId, messageIds)
+	if err != nil {
+		log.Printf("Error marking messages as delivered: %v", err)
+		mp.sendError(senderId, requestId, ACK_MESSAGES_RESPONSE, ErrCannotAckMessages)
+		return
+	}
+	mp.sendMessageStatusEvents(receipts, MESSAGE_STATUS_DELIVERED)
+}
+
+// markMessagesRead records that a user read the messages of a chat after
+// the previous position of their read marker, up to messageId
+func (mp *MessageProcessor) markMessagesRead(chatId, userId uuid.UUID, previous *uuid.UUID, messageId uuid.UUID) {
+	receipts, err := mp.MessageModel.MarkReadUpTo(chatId, userId, previous, messageId)
+	if err != nil {
+		log.Printf("Error marking read receipts: %v", err)
+		return
+	}
+	mp.sendMessageStatusEvents(receipts, MESSAGE_STATUS_READ)
+}
+
+// sendMessageStatusEvents tells the senders of messages that their status
+// changed, with one event per chat and sender
+func (mp *MessageProcessor) sendMessageStatusEvents(receipts []*models.MessageReceipt, status string) {
+	type eventKey struct {
+		chatId   uuid.UUID
+		senderId uuid.UUID
+	}
+	events := make(map[eventKey]*MessageStatusEvent)
+	var keys []eventKey
+	for _, receipt := range receipts {
+		key := eventKey{chatId: receipt.ChatID, senderId: receipt.SenderID}
+		event, ok := events[key]
+		if !ok {
+			timestamp := receipt.DeliveredAt
+			if status == MESSAGE_STATUS_READ && receipt.ReadAt != nil {
+				timestamp = *receipt.ReadAt
+			}
+			event = &MessageStatusEvent{
+				ChatID:    receipt.ChatID.String(),
+				UserID:    receipt.UserID.String(),
+				Status:    status,
+				Timestamp: formatTimestamp(timestamp),
+			}
+			events[key] = event
+			keys = append(keys, key)
+		}
+		event.MessageIDs = append(event.MessageIDs, receipt.MessageID.String())
+	}
+
+	for _, key := range keys {
+		mp.sendEvent([]uuid.UUID{key.senderId}, &Response{
+			Type:  MESSAGE_STATUS_EVENT,
+			Data:  getJsonRawMessage(*events[key]),
+			Error: "",
+		})
+	}
+}
+
+func (mp *MessageProcessor) handleGetMessageReceiptsRequest(senderId uuid.UUID, requestId string, reqData GetMessageReceiptsRequest) {
+	messageId, err := uuid.Parse(reqData.MessageID)
+	if err != nil {
+		mp.sendError(senderId, requestId, GET_MESSAGE_RECEIPTS_RESPONSE, ErrInvalidMessageID)
+		return
+	}
+
+	message, err := mp.getMemberMessage(senderId, messageId)
+	if err != nil {
+		if errors.Is(err, models.ErrNoRecord) {
+			mp.sendError(senderId, requestId, GET_MESSAGE_RECEIPTS_RESPONSE, ErrMessageNotFound)
+			return
+		}
+		log.Printf("Error getting message: %v", err)
+		mp.sendError(senderId, requestId, GET_MESSAGE_RECEIPTS_RESPONSE, ErrCannotGetMessageReceipts)
+		return
+	}
+	if message.SenderID != senderId {
+		mp.sendError(senderId, requestId, GET_MESSAGE_RECEIPTS_RESPONSE, ErrNotReceiptsOwner)
+		return
+	}
+
+	modelReceipts, err := mp.MessageModel.GetReceipts(messageId)
+	if err != nil {
+		log.Printf("Error getting message receipts: %v", err)
+		mp.sendError(senderId, requestId, GET_MESSAGE_RECEIPTS_RESPONSE, ErrCannotGetMessageReceipts)
+		return
+	}
+	memberIds, err := mp.getChatMemberIds(message.ChatID)
+	if err != nil {
+		log.Printf("Error getting chat members: %v", err)
+		mp.sendError(senderId, requestId, GET_MESSAGE_RECEIPTS_RESPONSE, ErrCannotGetMessageReceipts)
+		return
+	}
+
+	// members without a receipt haven't confirmed the message yet. Receipts
+	// of users who have since left the chat are kept.
+	responseData := GetMessageReceiptsResponse{
+		MessageID: messageId.String(),
+		Receipts:  make([]MessageReceipt, 0, len(memberIds)),
+	}
+	hasReceipt := make(map[uuid.UUID]bool, len(modelReceipts))
+	for _, receipt := range modelReceipts {
+		hasReceipt[receipt.UserID] = true
+		responseData.Receipts = append(responseData.Receipts, messageReceiptConvert(*receipt))
+	}
+	for _, memberId := range memberIds {
+		if memberId == senderId || hasReceipt[memberId] {
+			continue
+		}
+		responseData.Receipts = append(responseData.Receipts, MessageReceipt{
+			UserID: memberId.String(),
+			Status: MESSAGE_STATUS_SENT,
+		})
+	}
+
+	responseMessage := &Response{
+		Type:  GET_MESSAGE_RECEIPTS_RESPONSE,
+		Data:  getJsonRawMessage(responseData),
+		Error: "",
+	}
+	mp.reply(senderId, requestId, responseMessage)
+}
+
+func messageReceiptConvert(receipt models.MessageReceipt) MessageReceipt {
+	deliveredAt := formatTimestamp(receipt.DeliveredAt)
+	converted := MessageReceipt{
+		UserID:      receipt.UserID.String(),
+		Status:      MESSAGE_STATUS_DELIVERED,
+		DeliveredAt: &deliveredAt,
+	}
+	if receipt.ReadAt != nil {
+		readAt := formatTimestamp(*receipt.ReadAt)
+		converted.Status = MESSAGE_STATUS_READ
+		converted.ReadAt = &readAt
+	}
+	return converted
+}
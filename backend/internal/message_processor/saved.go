@@ -0,0 +1,186 @@
+package messageprocessor
+
+import (
+	"errors"
+	"log"
+	"strings"
+	"unicode"
+
+	"chatty.mtran.io/internal/models"
+	"chatty.mtran.io/internal/validator"
+	"github.com/google/uuid"
+)
+
+const (
+	// maxSavedMessageNoteLength matches saved_messages.note
+	maxSavedMessageNoteLength = 1000
+	maxSavedMessageTags       = 10
+	maxSavedMessageTagLength  = 32
+)
+
+func (mp *MessageProcessor) handleSaveMessageRequest(senderId uuid.UUID, requestId string, reqData SaveMessageRequest) {
+	messageId, err := uuid.Parse(reqData.MessageID)
+	if err != nil {
+		mp.sendError(senderId, requestId, SAVE_MESSAGE_RESPONSE, ErrInvalidMessageID)
+		return
+	}
+	note := strings.TrimSpace(reqData.Note)
+	if !validator.MaxChars(note, maxSavedMessageNoteLength) {
+		mp.sendError(senderId, requestId, SAVE_MESSAGE_RESPONSE, ErrInvalidSavedMessageNote)
+		return
+	}
+	tags, ok := normalizeTags(reqData.Tags)
+	if !ok {
+		mp.sendError(senderId, requestId, SAVE_MESSAGE_RESPONSE, ErrInvalidSavedMessageTags)
+		return
+	}
+
+	saved, err := mp.MessageModel.SaveMessage(senderId, messageId, note, tags)
+	if errors.Is(err, models.ErrNoRecord) {
+		mp.sendError(senderId, requestId, SAVE_MESSAGE_RESPONSE, ErrMessageNotFound)
+		return
+	}
+	if err != nil {
+		log.Printf("Error saving message for later: %v", err)
+		mp.sendError(senderId, requestId, SAVE_MESSAGE_RESPONSE, ErrCannotSaveMessageForLater)
+		return
+	}
+
+	responseMessage := &Response{
+		Type:  SAVE_MESSAGE_RESPONSE,
+		Data:  getJsonRawMessage(SaveMessageResponse(savedMessageConvert(*saved))),
+		Error: "",
+	}
+	mp.replyAndBroadcast(senderId, requestId, nil, responseMessage)
+}
+
+func (mp *MessageProcessor) handleUnsaveMessageRequest(senderId uuid.UUID, requestId string, reqData UnsaveMessageRequest) {
+	savedId, err := uuid.Parse(reqData.SavedID)
+	if err != nil {
+		mp.sendError(senderId, requestId, UNSAVE_MESSAGE_RESPONSE, ErrInvalidSavedMessageID)
+		return
+	}
+
+	removed, err := mp.MessageModel.UnsaveMessage(senderId, savedId)
+	if err != nil {
+		log.Printf("Error removing saved message: %v", err)
+		mp.sendError(senderId, requestId, UNSAVE_MESSAGE_RESPONSE, ErrCannotRemoveSavedMessage)
+		return
+	}
+	if !removed {
+		mp.sendError(senderId, requestId, UNSAVE_MESSAGE_RESPONSE, ErrSavedMessageNotFound)
+		return
+	}
+
+	responseMessage := &Response{
+		Type:  UNSAVE_MESSAGE_RESPONSE,
+		Data:  getJsonRawMessage(UnsaveMessageResponse{SavedID: savedId.String()}),
+		Error: "",
+	}
+	mp.replyAndBroadcast(senderId, requestId, nil, responseMessage)
+}
+
+func (mp *MessageProcessor) handleListSavedMessagesRequest(senderId uuid.UUID, requestId string, reqData ListSavedMessagesRequest) {
+	cursorId, err := parseCursor(reqData.CursorSavedAt, reqData.CursorSavedID)
+	if err != nil {
+		mp.sendError(senderId, requestId, LIST_SAVED_MESSAGES_RESPONSE, err)
+		return
+	}
+	var tag *string
+	if reqData.Tag != nil {
+		normalized, ok := normalizeTag(*reqData.Tag)
+		if !ok {
+			mp.sendError(senderId, requestId, LIST_SAVED_MESSAGES_RESPONSE, ErrInvalidSavedMessageTags)
+			return
+		}
+		tag = &normalized
+	}
+
+	// fetch one extra row to find out whether there is another page
+	limit := pageLimit(reqData.Limit)
+	modelSaved, err := mp.MessageModel.GetSavedMessages(senderId, tag, reqData.CursorSavedAt, cursorId, limit+1)
+	if err != nil {
+		log.Printf("Error getting saved messages: %v", err)
+		mp.sendError(senderId, requestId, LIST_SAVED_MESSAGES_RESPONSE, ErrCannotGetSavedMessages)
+		return
+	}
+	hasMore := len(modelSaved) > limit
+	if hasMore {
+		modelSaved = modelSaved[:limit]
+	}
+
+	responseData := ListSavedMessagesResponse{
+		SavedMessages: make([]SavedMessage, 0, len(modelSaved)),
+		HasMore:       hasMore,
+	}
+	for _, saved := range modelSaved {
+		responseData.SavedMessages = append(responseData.SavedMessages, savedMessageConvert(*saved))
+	}
+	responseMessage := &Response{
+		Type:  LIST_SAVED_MESSAGES_RESPONSE,
+		Data:  getJsonRawMessage(responseData),
+		Error: "",
+	}
+	mp.reply(senderId, requestId, responseMessage)
+}
+
+// normalizeTags lowercases the tags of a saved message and drops duplicates
+func normalizeTags(rawTags []string) ([]string, bool) {
+	if len(rawTags) > maxSavedMessageTags {
+		return nil, false
+	}
+	tags := make([]string, 0, len(rawTags))
+	seen := make(map[string]bool, len(rawTags))
+	for _, rawTag := range rawTags {
+		tag, ok := normalizeTag(rawTag)
+		if !ok {
+			return nil, false
+		}
+		if !seen[tag] {
+			seen[tag] = true
+			tags = append(tags, tag)
+		}
+	}
+	return tags, true
+}
+
+// normalizeTag accepts tags with or without a leading "#". Tags can't
+// contain spaces.
+func normalizeTag(rawTag string) (string, bool) {
+	tag := strings.ToLower(strings.TrimPrefix(strings.TrimSpace(rawTag), "#"))
+	if !validator.NotBlank(tag) || !validator.MaxChars(tag, maxSavedMessageTagLength) || strings.IndexFunc(tag, unicode.IsSpace) >= 0 {
+		return "", false
+	}
+	return tag, true
+}
+
+func savedMessageConvert(saved models.SavedMessage) SavedMessage {
+	convertedSaved := SavedMessage{
+		SavedID: saved.ID.String(),
+		Note:    saved.Note,
+		Tags:    saved.Tags,
+		SavedAt: formatTimestamp(saved.SavedAt),
+	}
+	if convertedSaved.Tags == nil {
+		convertedSaved.Tags = []string{}
+	}
+	if saved.ChatID != nil {
+		chatId := saved.ChatID.String()
+		convertedSaved.ChatID = &chatId
+	}
+	if saved.MessageID != nil {
+		messageId := saved.MessageID.String()
+		convertedSaved.MessageID = &messageId
+	}
+
+	switch {
+	case saved.Tombstone == models.SavedMessageNotMember:
+		convertedSaved.Tombstone = SAVED_TOMBSTONE_NOT_MEMBER
+	case saved.Tombstone != "" || saved.Message == nil:
+		convertedSaved.Tombstone = SAVED_TOMBSTONE_DELETED
+	default:
+		message := chatHistoryMessageConvert(*saved.Message)
+		convertedSaved.Message = &message
+	}
+	return convertedSaved
+}
@@ -0,0 +1,310 @@
+package messageprocessor
+
+import (
+	"context"
+	"errors"
+	"log"
+	"time"
+
+	"chatty.mtran.io/internal/models"
+	"chatty.mtran.io/internal/validator"
+	"github.com/google/uuid"
+)
+
+// scheduleMessage stores a message that the dispatcher sends later instead of
+// sending it right away
+func (mp *MessageProcessor) scheduleMessage(senderId uuid.UUID, requestId string, scheduled *models.ScheduledMessage, recurrence *string) {
+	if recurrence != nil && *recurrence != "" {
+		if !models.ValidRecurrence(*recurrence) {
+			mp.sendError(senderId, requestId, SCHEDULE_MESSAGE_RESPONSE, ErrInvalidRecurrence)
+			return
+		}
+		scheduled.Recurrence = recurrence
+	}
+
+	if scheduled.ClientMessageID != nil {
+		// a retry of a one-off message that was sent in the meantime
+		_, err := mp.MessageModel.GetMessageByClientID(senderId, *scheduled.ClientMessageID)
+		if err == nil {
+			mp.resendMessage(senderId, requestId, *scheduled.ClientMessageID)
+			return
+		}
+		if !errors.Is(err, models.ErrNoRecord) {
+			log.Printf("Error getting message by client message id: %v", err)
+			mp.sendError(senderId, requestId, SCHEDULE_MESSAGE_RESPONSE, ErrCannotScheduleMessage)
+			return
+		}
+	}
+
+	err := mp.MessageModel.InsertScheduledMessage(scheduled)
+	if errors.Is(err, models.ErrDuplicateMessage) {
+		// a retry of a message that was already scheduled, only the sender
+		// needs the original response again
+		mp.rescheduleMessage(senderId, requestId, *scheduled.ClientMessageID)
+		return
+	}
+	if err != nil {
+		log.Printf("Error scheduling message: %v", err)
+		mp.sendError(senderId, requestId, SCHEDULE_MESSAGE_RESPONSE, ErrCannotScheduleMessage)
+		return
+	}
+
+	responseMessage := &Response{
+		Type:  SCHEDULE_MESSAGE_RESPONSE,
+		Data:  getJsonRawMessage(ScheduleMessageResponse(scheduledMessageConvert(*scheduled))),
+		Error: "",
+	}
+	mp.reply(senderId, requestId, responseMessage)
+}
+
+// rescheduleMessage replies to a retried request with the message it
+// scheduled
+func (mp *MessageProcessor) rescheduleMessage(senderId uuid.UUID, requestId string, clientMessageID string) {
+	scheduled, err := mp.MessageModel.GetScheduledMessageByClientID(senderId, clientMessageID)
+	if errors.Is(err, models.ErrNoRecord) {
+		// it was sent since
+		mp.resendMessage(senderId, requestId, clientMessageID)
+		return
+	}
+	if err != nil {
+		log.Printf("Error getting scheduled message by client message id: %v", err)
+		mp.sendError(senderId, requestId, SCHEDULE_MESSAGE_RESPONSE, ErrCannotScheduleMessage)
+		return
+	}
+
+	responseMessage := &Response{
+		Type:  SCHEDULE_MESSAGE_RESPONSE,
+		Data:  getJsonRawMessage(ScheduleMessageResponse(scheduledMessageConvert(*scheduled))),
+		Error: "",
+	}
+	mp.reply(senderId, requestId, responseMessage)
+}
+
+func (mp *MessageProcessor) handleListScheduledMessagesRequest(senderId uuid.UUID, requestId string, reqData ListScheduledMessagesRequest) {
+	var chatId *uuid.UUID
+	if reqData.ChatID != nil {
+		parsed, err := uuid.Parse(*reqData.ChatID)
+		if err != nil {
+			mp.sendError(senderId, requestId, LIST_SCHEDULED_MESSAGES_RESPONSE, ErrInvalidChatID)
+			return
+		}
+		chatId = &parsed
+	}
+
+	scheduledMessages, err := mp.MessageModel.GetScheduledMessagesBySender(senderId, chatId)
+	if err != nil {
+		log.Printf("Error getting scheduled messages: %v", err)
+		mp.sendError(senderId, requestId, LIST_SCHEDULED_MESSAGES_RESPONSE, ErrCannotGetScheduledMessages)
+		return
+	}
+
+	responseData := ListScheduledMessagesResponse{
+		ScheduledMessages: make([]ScheduledMessage, 0, len(scheduledMessages)),
+	}
+	for _, scheduled := range scheduledMessages {
+		responseData.ScheduledMessages = append(responseData.ScheduledMessages, scheduledMessageConvert(*scheduled))
+	}
+	responseMessage := &Response{
+		Type:  LIST_SCHEDULED_MESSAGES_RESPONSE,
+		Data:  getJsonRawMessage(responseData),
+		Error: "",
+	}
+	mp.reply(senderId, requestId, responseMessage)
+}
+
+func (mp *MessageProcessor) handleEditScheduledMessageRequest(senderId uuid.UUID, requestId string, reqData EditScheduledMessageRequest) {
+	scheduledId, err := uuid.Parse(reqData.ScheduledMessageID)
+	if err != nil {
+		mp.sendError(senderId, requestId, EDIT_SCHEDULED_MESSAGE_RESPONSE, ErrInvalidScheduledMessageID)
+		return
+	}
+	if reqData.SendAt != nil && !reqData.SendAt.After(time.Now()) {
+		mp.sendError(senderId, requestId, EDIT_SCHEDULED_MESSAGE_RESPONSE, ErrInvalidSendAt)
+		return
+	}
+	if reqData.Recurrence != nil && *reqData.Recurrence != "" && !models.ValidRecurrence(*reqData.Recurrence) {
+		mp.sendError(senderId, requestId, EDIT_SCHEDULED_MESSAGE_RESPONSE, ErrInvalidRecurrence)
+		return
+	}
+
+	scheduled, err := mp.MessageModel.GetScheduledMessage(scheduledId, senderId)
+	if err != nil {
+		if errors.Is(err, models.ErrNoRecord) {
+			mp.sendError(senderId, requestId, EDIT_SCHEDULED_MESSAGE_RESPONSE, ErrScheduledMessageNotFound)
+			return
+		}
+		log.Printf("Error getting scheduled message: %v", err)
+		mp.sendError(senderId, requestId, EDIT_SCHEDULED_MESSAGE_RESPONSE, ErrCannotScheduleMessage)
+		return
+	}
+
+	var content *string
+	if reqData.Content != nil {
+		if !validator.NotBlank(*reqData.Content) {
+			mp.sendError(senderId, requestId, EDIT_SCHEDULED_MESSAGE_RESPONSE, ErrEmptyMessageContent)
+			return
+		}
+		normalized, err := normalizeContent(scheduled.ContentType, *reqData.Content)
+		if err != nil {
+			mp.sendError(senderId, requestId, EDIT_SCHEDULED_MESSAGE_RESPONSE, err)
+			return
+		}
+		content = &normalized
+	}
+
+	scheduled, err = mp.MessageModel.UpdateScheduledMessage(scheduledId, senderId, content, reqData.SendAt, reqData.Recurrence)
+	if err != nil {
+		if errors.Is(err, models.ErrNoRecord) {
+			// sent and removed in the meantime
+			mp.sendError(senderId, requestId, EDIT_SCHEDULED_MESSAGE_RESPONSE, ErrScheduledMessageNotFound)
+			return
+		}
+		log.Printf("Error editing scheduled message: %v", err)
+		mp.sendError(senderId, requestId, EDIT_SCHEDULED_MESSAGE_RESPONSE, ErrCannotScheduleMessage)
+		return
+	}
+
+	responseMessage := &Response{
+		Type:  EDIT_SCHEDULED_MESSAGE_RESPONSE,
+		Data:  getJsonRawMessage(EditScheduledMessageResponse(scheduledMessageConvert(*scheduled))),
+		Error: "",
+	}
+	mp.reply(senderId, requestId, responseMessage)
+}
+
+func (mp *MessageProcessor) handleCancelScheduledMessageRequest(senderId uuid.UUID, requestId string, reqData CancelScheduledMessageRequest) {
+	scheduledId, err := uuid.Parse(reqData.ScheduledMessageID)
+	if err != nil {
+		mp.sendError(senderId, requestId, CANCEL_SCHEDULED_MESSAGE_RESPONSE, ErrInvalidScheduledMessageID)
+		return
+	}
+
+	err = mp.MessageModel.DeleteScheduledMessage(scheduledId, senderId)
+	if err != nil {
+		if errors.Is(err, models.ErrNoRecord) {
+			mp.sendError(senderId, requestId, CANCEL_SCHEDULED_MESSAGE_RESPONSE, ErrScheduledMessageNotFound)
+			return
+		}
+		log.Printf("Error cancelling scheduled message: %v", err)
+		mp.sendError(senderId, requestId, CANCEL_SCHEDULED_MESSAGE_RESPONSE, ErrCannotScheduleMessage)
+		return
+	}
+
+	responseData := CancelScheduledMessageResponse{
+		ScheduledMessageID: scheduledId.String(),
+	}
+	responseMessage := &Response{
+		Type:  CANCEL_SCHEDULED_MESSAGE_RESPONSE,
+		Data:  getJsonRawMessage(responseData),
+		Error: "",
+	}
+	mp.reply(senderId, requestId, responseMessage)
+}
+
+// RunScheduledMessageDispatcher sends scheduled messages once they are due.
+// It checks for due messages every interval until ctx is done.
+func (mp *MessageProcessor) RunScheduledMessageDispatcher(ctx context.Context, interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			mp.dispatchScheduledMessages()
+		}
+	}
+}
+
+// dispatchScheduledMessages sends every scheduled message that is due. The
+// messages go through the same fanout as messages sent by the client.
+func (mp *MessageProcessor) dispatchScheduledMessages() {
+	for {
+		var memberIds []uuid.UUID
+		message, found, err := mp.MessageModel.DispatchScheduledMessage(time.Now(), func(scheduled *models.ScheduledMessage) (*models.Message, error) {
+			members, err := mp.ChatModel.GetChatMembers(scheduled.ChatID)
+			if err != nil {
+				return nil, err
+			}
+			memberIds = make([]uuid.UUID, 0, len(members))
+			var senderName string
+			for _, member := range members {
+				memberIds = append(memberIds, member.ID)
+				if member.ID == scheduled.SenderID {
+					senderName = member.Name
+				}
+			}
+			if senderName == "" {
+				// the sender left the chat since scheduling the message
+				return nil, nil
+			}
+			return &models.Message{
+				SenderID:        scheduled.SenderID,
+				ChatID:          scheduled.ChatID,
+				Content:         scheduled.Content,
+				ContentType:     scheduled.ContentType,
+				ParentMessageID: scheduled.ParentMessageID,
+				ClientMessageID: oneOffClientMessageID(scheduled),
+				Mentions:        parseMentions(scheduled.Content, members, scheduled.SenderID),
+				SenderName:      senderName,
+			}, nil
+		})
+		if err != nil {
+			log.Printf("Error dispatching scheduled message: %v", err)
+			if found {
+				// the message is retried later, go on with the next one
+				continue
+			}
+			return
+		}
+		if !found {
+			return
+		}
+		if message == nil {
+			continue
+		}
+
+		responseMessage := &Response{
+			Type:  SEND_MESSAGE_RESPONSE,
+			Data:  getJsonRawMessage(sendMessageResponseConvert(*message)),
+			Error: "",
+		}
+		mp.sendEvent(memberIds, responseMessage)
+		mp.sendMentionEvents(*message)
+		go mp.unfurlLinks(*message)
+	}
+}
+
+// oneOffClientMessageID returns the client message id a scheduled message is
+// sent with. A one-off message keeps it, so that retries of the request that
+// scheduled it are recognised after it was sent. The occurrences of a
+// recurring message are told apart by their schedule instead.
+func oneOffClientMessageID(scheduled *models.ScheduledMessage) *string {
+	if scheduled.Recurrence != nil {
+		return nil
+	}
+	return scheduled.ClientMessageID
+}
+
+func scheduledMessageConvert(scheduled models.ScheduledMessage) ScheduledMessage {
+	scheduledMessage := ScheduledMessage{
+		ScheduledMessageID: scheduled.ID.String(),
+		ChatID:             scheduled.ChatID.String(),
+		Content:            scheduled.Content,
+		ContentType:        scheduled.ContentType,
+		Recurrence:         scheduled.Recurrence,
+		ClientMessageID:    scheduled.ClientMessageID,
+		SendAt:             formatTimestamp(scheduled.SendAt),
+		Attempts:           scheduled.Attempts,
+	}
+	if scheduled.FailedAt != nil {
+		failedAt := formatTimestamp(*scheduled.FailedAt)
+		scheduledMessage.FailedAt = &failedAt
+	}
+	if scheduled.ParentMessageID != nil {
+		parentMessageId := scheduled.ParentMessageID.String()
+		scheduledMessage.ParentMessageID = &parentMessageId
+	}
+	return scheduledMessage
+}
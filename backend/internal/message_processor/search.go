@@ -0,0 +1,170 @@
+package messageprocessor
+
+import (
+	"errors"
+	"html"
+	"log"
+	"strings"
+	"time"
+
+	"chatty.mtran.io/internal/models"
+	"chatty.mtran.io/internal/validator"
+	"github.com/google/uuid"
+)
+
+// maxSearchQueryLength is the longest search query accepted
+const maxSearchQueryLength = 256
+
+func (mp *MessageProcessor) handleSearchMessagesRequest(senderId uuid.UUID, requestId string, reqData SearchMessagesRequest) {
+	if !validator.NotBlank(reqData.Query) || !validator.MaxChars(reqData.Query, maxSearchQueryLength) {
+		mp.sendError(senderId, requestId, SEARCH_MESSAGES_RESPONSE, ErrInvalidSearchQuery)
+		return
+	}
+	cursorId, err := parseCursor(reqData.CursorCreatedAt, reqData.CursorMessageID)
+	if err != nil {
+		mp.sendError(senderId, requestId, SEARCH_MESSAGES_RESPONSE, err)
+		return
+	}
+	var cursor *models.SearchCursor
+	if cursorId != nil || reqData.CursorRank != nil {
+		if cursorId == nil || reqData.CursorRank == nil {
+			mp.sendError(senderId, requestId, SEARCH_MESSAGES_RESPONSE, ErrInvalidCursor)
+			return
+		}
+		cursor = &models.SearchCursor{
+			Rank:      *reqData.CursorRank,
+			CreatedAt: *reqData.CursorCreatedAt,
+			MessageID: *cursorId,
+		}
+	}
+
+	search, err := mp.parseSearchQuery(senderId, reqData.Query)
+	if err != nil {
+		mp.sendError(senderId, requestId, SEARCH_MESSAGES_RESPONSE, err)
+		return
+	}
+
+	// fetch one extra row to find out whether there is another page
+	limit := pageLimit(reqData.Limit)
+	modelResults, err := mp.MessageModel.SearchMessages(senderId, search, cursor, limit+1)
+	if err != nil {
+		log.Printf("Error searching messages: %v", err)
+		mp.sendError(senderId, requestId, SEARCH_MESSAGES_RESPONSE, ErrCannotSearchMessages)
+		return
+	}
+	hasMore := len(modelResults) > limit
+	if hasMore {
+		modelResults = modelResults[:limit]
+	}
+
+	results := make([]SearchResult, 0, len(modelResults))
+	for _, modelResult := range modelResults {
+		results = append(results, SearchResult{
+			ChatID:  modelResult.Message.ChatID.String(),
+			Message: chatHistoryMessageConvert(*modelResult.Message),
+			Rank:    modelResult.Rank,
+			Snippet: highlightSnippet(modelResult.Snippet),
+		})
+	}
+
+	responseData := SearchMessagesResponse{
+		Results: results,
+		HasMore: hasMore,
+	}
+	responseMessage := &Response{
+		Type:  SEARCH_MESSAGES_RESPONSE,
+		Data:  getJsonRawMessage(responseData),
+		Error: "",
+	}
+	mp.reply(senderId, requestId, responseMessage)
+}
+
+// parseSearchQuery splits a search query into its filters and search terms.
+// Words that look like filters but aren't known ones are kept as terms.
+func (mp *MessageProcessor) parseSearchQuery(senderId uuid.UUID, query string) (*models.MessageSearch, error) {
+	search := &models.MessageSearch{}
+	var terms []string
+
+	for _, word := range strings.Fields(query) {
+		name, value, found := strings.Cut(word, ":")
+		if !found {
+			terms = append(terms, word)
+			continue
+		}
+
+		switch strings.ToLower(name) {
+		case "from":
+			fromId, err := mp.parseSearchSender(senderId, value)
+			if err != nil {
+				return nil, err
+			}
+			search.SenderID = &fromId
+		case "in":
+			chatId, err := uuid.Parse(value)
+			if err != nil {
+				return nil, ErrInvalidSearchQuery
+			}
+			search.ChatID = &chatId
+		case "before":
+			before, err := parseSearchDate(value)
+			if err != nil {
+				return nil, ErrInvalidSearchQuery
+			}
+			search.Before = &before
+		case "after":
+			after, err := parseSearchDate(value)
+			if err != nil {
+				return nil, ErrInvalidSearchQuery
+			}
+			search.After = &after
+		case "has":
+			if strings.ToLower(value) != "link" {
+				return nil, ErrInvalidSearchQuery
+			}
+			search.HasLink = true
+		default:
+			terms = append(terms, word)
+		}
+	}
+
+	search.Terms = strings.Join(terms, " ")
+	return search, nil
+}
+
+// parseSearchSender resolves the user of a from: filter, given by email or
+// as "me"
+func (mp *MessageProcessor) parseSearchSender(senderId uuid.UUID, value string) (uuid.UUID, error) {
+	if strings.ToLower(value) == "me" {
+		return senderId, nil
+	}
+	userInfos, err := mp.UserModel.UserInfosByEmails([]string{value})
+	if errors.Is(err, models.ErrUserDoesNotExist) {
+		return uuid.Nil, ErrUserDoesNotExist
+	}
+	if err != nil {
+		log.Printf("Error getting user infos: %v", err)
+		return uuid.Nil, ErrCannotSearchMessages
+	}
+	return userInfos[0].ID, nil
+}
+
+// parseSearchDate accepts a date, which is taken as midnight UTC, or an RFC
+// 3339 timestamp
+func parseSearchDate(value string) (time.Time, error) {
+	if date, err := time.Parse(time.DateOnly, value); err == nil {
+		return date, nil
+	}
+	timestamp, err := time.Parse(time.RFC3339, value)
+	if err != nil {
+		return time.Time{}, err
+	}
+	return timestamp.UTC(), nil
+}
+
+// highlightSnippet HTML escapes the snippet of a search result and wraps its
+// matches in <mark> tags
+func highlightSnippet(snippet string) string {
+	snippet = html.EscapeString(snippet)
+	snippet = strings.ReplaceAll(snippet, models.SnippetStartSel, "<mark>")
+	return strings.ReplaceAll(snippet, models.SnippetStopSel, "</mark>")
+}
@@ -0,0 +1,198 @@
+package messageprocessor
+
+import (
+	"log"
+	"sync"
+	"time"
+
+	"github.com/google/uuid"
+)
+
+// typingTimeout is how long a typing indicator lasts if the client neither
+// refreshes it with another TypingStartRequest nor sends a TypingStopRequest
+const typingTimeout = 5 * time.Second
+
+type typingKey struct {
+	chatId uuid.UUID
+	userId uuid.UUID
+}
+
+type typingState struct {
+	timer     *time.Timer
+	expiresAt time.Time
+	// members of the chat when the user started typing, so that refreshing
+	// and stopping the indicator doesn't need a database round trip
+	memberIds []uuid.UUID
+}
+
+// typingTracker keeps the typing indicators of all users in memory.
+// Typing indicators are never persisted.
+//
+// The members of the chats users type in are cached too, so that typing
+// doesn't touch Postgres. A chat's members are loaded once, by the first
+// TypingStartRequest in it, and forgotten whenever they change.
+type typingTracker struct {
+	mu     sync.Mutex
+	states map[typingKey]*typingState
+	// members are the ids of the members of a chat, the typing user included
+	members map[uuid.UUID][]uuid.UUID
+	// membersVersion changes whenever members are forgotten, so that a load
+	// that raced with a change isn't cached
+	membersVersion uint64
+}
+
+func newTypingTracker() *typingTracker {
+	return &typingTracker{
+		states:  make(map[typingKey]*typingState),
+		members: make(map[uuid.UUID][]uuid.UUID),
+	}
+}
+
+// chatMemberIds returns the ids of the members of a chat, from the cache if
+// possible
+func (mp *MessageProcessor) chatMemberIds(chatId uuid.UUID) ([]uuid.UUID, error) {
+	mp.typing.mu.Lock()
+	memberIds, ok := mp.typing.members[chatId]
+	version := mp.typing.membersVersion
+	mp.typing.mu.Unlock()
+	if ok {
+		return memberIds, nil
+	}
+
+	members, err := mp.ChatModel.GetChatMembers(chatId)
+	if err != nil {
+		return nil, err
+	}
+	memberIds = make([]uuid.UUID, 0, len(members))
+	for _, member := range members {
+		memberIds = append(memberIds, member.ID)
+	}
+
+	mp.typing.mu.Lock()
+	if version == mp.typing.membersVersion {
+		mp.typing.members[chatId] = memberIds
+	}
+	mp.typing.mu.Unlock()
+	return memberIds, nil
+}
+
+// forgetChatMembers drops the cached members of a chat. It must be called
+// whenever users join or leave the chat.
+func (mp *MessageProcessor) forgetChatMembers(chatId uuid.UUID) {
+	mp.typing.mu.Lock()
+	delete(mp.typing.members, chatId)
+	mp.typing.membersVersion++
+	mp.typing.mu.Unlock()
+}
+
+func (mp *MessageProcessor) handleTypingStartRequest(senderId uuid.UUID, requestId string, reqData TypingRequest) {
+	chatId, err := uuid.Parse(reqData.ChatID)
+	if err != nil {
+		mp.sendError(senderId, requestId, TYPING_EVENT, ErrInvalidChatID)
+		return
+	}
+	key := typingKey{chatId: chatId, userId: senderId}
+
+	// refreshing an indicator only pushes its expiry back
+	mp.typing.mu.Lock()
+	if state, ok := mp.typing.states[key]; ok {
+		state.expiresAt = time.Now().Add(typingTimeout)
+		state.timer.Reset(typingTimeout)
+		mp.typing.mu.Unlock()
+		return
+	}
+	mp.typing.mu.Unlock()
+
+	chatMemberIds, err := mp.chatMemberIds(chatId)
+	if err != nil {
+		log.Printf("Error getting chat members: %v", err)
+		return
+	}
+	memberIds := make([]uuid.UUID, 0, len(chatMemberIds))
+	isMember := false
+	for _, memberId := range chatMemberIds {
+		if memberId == senderId {
+			isMember = true
+			continue
+		}
+		memberIds = append(memberIds, memberId)
+	}
+	if !isMember {
+		mp.sendError(senderId, requestId, TYPING_EVENT, ErrNotChatMember)
+		return
+	}
+
+	mp.typing.mu.Lock()
+	if _, ok := mp.typing.states[key]; ok {
+		// another start request won the race
+		mp.typing.mu.Unlock()
+		return
+	}
+	state := &typingState{
+		expiresAt: time.Now().Add(typingTimeout),
+		memberIds: memberIds,
+	}
+	state.timer = time.AfterFunc(typingTimeout, func() {
+		mp.expireTyping(key, state)
+	})
+	mp.typing.states[key] = state
+	mp.typing.mu.Unlock()
+
+	mp.sendTypingEvent(key, memberIds, true)
+}
+
+func (mp *MessageProcessor) handleTypingStopRequest(senderId uuid.UUID, requestId string, reqData TypingRequest) {
+	chatId, err := uuid.Parse(reqData.ChatID)
+	if err != nil {
+		mp.sendError(senderId, requestId, TYPING_EVENT, ErrInvalidChatID)
+		return
+	}
+	mp.stopTyping(chatId, senderId)
+}
+
+// stopTyping clears a user's typing indicator and tells the other members.
+// It does nothing if the user isn't typing.
+func (mp *MessageProcessor) stopTyping(chatId, userId uuid.UUID) {
+	key := typingKey{chatId: chatId, userId: userId}
+
+	mp.typing.mu.Lock()
+	state, ok := mp.typing.states[key]
+	if !ok {
+		mp.typing.mu.Unlock()
+		return
+	}
+	state.timer.Stop()
+	delete(mp.typing.states, key)
+	mp.typing.mu.Unlock()
+
+	mp.sendTypingEvent(key, state.memberIds, false)
+}
+
+// expireTyping runs when the timer of a typing indicator fires
+func (mp *MessageProcessor) expireTyping(key typingKey, state *typingState) {
+	mp.typing.mu.Lock()
+	current, ok := mp.typing.states[key]
+	if !ok || current != state || time.Now().Before(state.expiresAt) {
+		// the indicator was stopped, replaced or refreshed in the meantime
+		mp.typing.mu.Unlock()
+		return
+	}
+	delete(mp.typing.states, key)
+	mp.typing.mu.Unlock()
+
+	mp.sendTypingEvent(key, state.memberIds, false)
+}
+
+func (mp *MessageProcessor) sendTypingEvent(key typingKey, memberIds []uuid.UUID, typing bool) {
+	responseData := TypingEvent{
+		ChatID: key.chatId.String(),
+		UserID: key.userId.String(),
+		Typing: typing,
+	}
+	responseMessage := &Response{
+		Type:  TYPING_EVENT,
+		Data:  getJsonRawMessage(responseData),
+		Error: "",
+	}
+	mp.sendEvent(memberIds, responseMessage)
+}
@@ -0,0 +1,183 @@
+package models
+
+import (
+	"database/sql"
+	"errors"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/lib/pq"
+)
+
+// Kinds of users
+const (
+	UserKindHuman = "human"
+	UserKindBot   = "bot"
+)
+
+// Bot is a user account that is operated by a program. Bots belong to the
+// user that created them and authenticate with bot tokens.
+type Bot struct {
+	UserInfo
+	OwnerID        uuid.UUID `json:"owner_id"`
+	CallbackURL    *string   `json:"callback_url,omitempty"`
+	CallbackSecret string    `json:"-"`
+	CreatedAt      time.Time `json:"created_at"`
+}
+
+// BotCallback is where the events of a bot are delivered over HTTP. The
+// secret signs the deliveries.
+type BotCallback struct {
+	URL    string
+	Secret string
+}
+
+// InsertBot creates a bot account owned by ownerID together with its first
+// token. Only the hash of the token is stored. ErrDuplicateEmail is returned
+// if the email is already in use.
+func (m *UserModel) InsertBot(ownerID uuid.UUID, name, email string, callbackURL *string, callbackSecret, tokenHash string) (*Bot, error) {
+	bot := &Bot{
+		UserInfo: UserInfo{
+			Name:  name,
+			Email: email,
+		},
+		OwnerID:        ownerID,
+		CallbackURL:    callbackURL,
+		CallbackSecret: callbackSecret,
+	}
+
+	tx, err := m.DB.Begin()
+	if err != nil {
+		return nil, err
+	}
+	defer tx.Rollback()
+
+	stmt := `INSERT INTO users (name, email, kind) VALUES ($1, $2, $3) RETURNING id`
+	err = tx.QueryRow(stmt, name, email, UserKindBot).Scan(&bot.ID)
+	if err != nil {
+		var pqErr *pq.Error
+		if errors.As(err, &pqErr) {
+			if pqErr.Code == "23505" && pqErr.Constraint == "users_uc_email" {
+				return nil, ErrDuplicateEmail
+			}
+		}
+		return nil, err
+	}
+
+	// the secret only signs callbacks
+	var secret *string
+	if callbackURL != nil {
+		secret = &callbackSecret
+	}
+	stmt = `INSERT INTO bots (user_id, owner_id, callback_url, callback_secret) VALUES ($1, $2, $3, $4)
+	RETURNING created_at`
+	err = tx.QueryRow(stmt, bot.ID, ownerID, callbackURL, secret).Scan(&bot.CreatedAt)
+	if err != nil {
+		return nil, err
+	}
+
+	stmt = `INSERT INTO bot_tokens (bot_id, token_hash) VALUES ($1, $2)`
+	_, err = tx.Exec(stmt, bot.ID, tokenHash)
+	if err != nil {
+		return nil, err
+	}
+
+	if err = tx.Commit(); err != nil {
+		return nil, err
+	}
+	return bot, nil
+}
+
+// RotateBotToken replaces the tokens of a bot owned by ownerID with a new one.
+// ErrNoRecord is returned if ownerID has no such bot.
+func (m *UserModel) RotateBotToken(botID, ownerID uuid.UUID, tokenHash string) error {
+	tx, err := m.DB.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	var exists bool
+	stmt := `SELECT EXISTS(SELECT 1 FROM bots WHERE user_id = $1 AND owner_id = $2)`
+	err = tx.QueryRow(stmt, botID, ownerID).Scan(&exists)
+	if err != nil {
+		return err
+	}
+	if !exists {
+		return ErrNoRecord
+	}
+
+	stmt = `DELETE FROM bot_tokens WHERE bot_id = $1`
+	_, err = tx.Exec(stmt, botID)
+	if err != nil {
+		return err
+	}
+
+	stmt = `INSERT INTO bot_tokens (bot_id, token_hash) VALUES ($1, $2)`
+	_, err = tx.Exec(stmt, botID, tokenHash)
+	if err != nil {
+		return err
+	}
+
+	return tx.Commit()
+}
+
+// AuthenticateBotToken returns the id of the bot a token belongs to, given the
+// hash of the token. ErrInvalidCredentials is returned for unknown tokens.
+func (m *UserModel) AuthenticateBotToken(tokenHash string) (uuid.UUID, error) {
+	var botID uuid.UUID
+
+	stmt := `UPDATE bot_tokens SET last_used_at = CURRENT_TIMESTAMP
+	WHERE token_hash = $1
+	RETURNING bot_id`
+
+	err := m.DB.QueryRow(stmt, tokenHash).Scan(&botID)
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return uuid.Nil, ErrInvalidCredentials
+		}
+		return uuid.Nil, err
+	}
+	return botID, nil
+}
+
+// GetBotCallback retrieves where the events of a user are delivered over
+// HTTP. ErrNoRecord is returned for users that aren't bots with a callback.
+func (m *UserModel) GetBotCallback(userID uuid.UUID) (*BotCallback, error) {
+	callback := &BotCallback{}
+
+	stmt := `SELECT callback_url, callback_secret FROM bots WHERE user_id = $1 AND callback_url IS NOT NULL`
+
+	err := m.DB.QueryRow(stmt, userID).Scan(&callback.URL, &callback.Secret)
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return nil, ErrNoRecord
+		}
+		return nil, err
+	}
+	return callback, nil
+}
+
+// GetCallbackBotIDs returns the ids of the bots that have a callback URL
+func (m *UserModel) GetCallbackBotIDs() ([]uuid.UUID, error) {
+	stmt := `SELECT user_id FROM bots WHERE callback_url IS NOT NULL`
+
+	rows, err := m.DB.Query(stmt)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var botIDs []uuid.UUID
+	for rows.Next() {
+		var botID uuid.UUID
+		if err := rows.Scan(&botID); err != nil {
+			return nil, err
+		}
+		botIDs = append(botIDs, botID)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+	return botIDs, nil
+}
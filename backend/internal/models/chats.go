@@ -2,6 +2,7 @@ package models
 
 import (
 	"database/sql"
+	"errors"
 	"fmt"
 	"strings"
 	"time"
@@ -14,10 +15,47 @@ import (
 type Chat struct {
 	Id        uuid.UUID   `json:"id"`
 	Name      string      `json:"name"`
+	Topic     string      `json:"topic"`
 	UpdatedAt time.Time   `json:"updated_at"`
 	UserInfos []*UserInfo `json:"user_infos"`
+
+	// MessageTTL is how many seconds messages are kept, nil keeps them forever
+	MessageTTL *int `json:"message_ttl,omitempty"`
+
+	// Per-user view of the chat, filled in by GetChatsByUserID. Muted is only
+	// set while a mute lasts; MutedUntil is nil for a mute without an end.
+	UnreadCount int           `json:"unread_count"`
+	ReadMarkers []*ReadMarker `json:"read_markers,omitempty"`
+	Muted       bool          `json:"muted"`
+	MutedUntil  *time.Time    `json:"muted_until,omitempty"`
+}
+
+// ReadMarker is the read position of one chat member
+type ReadMarker struct {
+	UserID            uuid.UUID  `json:"user_id"`
+	LastReadMessageID *uuid.UUID `json:"last_read_message_id,omitempty"`
+	LastReadAt        *time.Time `json:"last_read_at,omitempty"`
 }
 
+// unreadCountColumn counts the root messages of chat c that member cu hasn't
+// read yet. Messages are compared with the member's last read message; if that
+// message is gone the time of the last read is used instead.
+const unreadCountColumn = `(SELECT COUNT(*)
+		FROM messages m
+		LEFT JOIN messages lrm ON lrm.id = cu.last_read_message_id
+		WHERE m.chat_id = c.id
+		AND m.parent_message_id IS NULL
+		AND m.deleted_at IS NULL
+		AND m.sender_id <> cu.user_id
+		AND NOT EXISTS (SELECT 1 FROM hidden_messages h WHERE h.user_id = cu.user_id AND h.message_id = m.id)
+		AND (
+			(lrm.id IS NOT NULL AND m.seq > lrm.seq)
+			OR (lrm.id IS NULL AND (cu.last_read_at IS NULL OR m.created_at > cu.last_read_at))
+		))`
+
+// mutedCondition holds while the mute of member cu lasts
+const mutedCondition = `cu.muted AND (cu.muted_until IS NULL OR cu.muted_until > CURRENT_TIMESTAMP)`
+
 // ChatModel wraps a database connection pool for chat operations
 type ChatModel struct {
 	DB *sql.DB
@@ -43,9 +81,9 @@ func (m *ChatModel) InsertChat(name string) (*Chat, error) {
 func (m *ChatModel) GetChat(id uuid.UUID) (*Chat, error) {
 	chat := &Chat{}
 
-	stmt := `SELECT id, name, updated_at FROM chats WHERE id = $1`
+	stmt := `SELECT id, name, topic, updated_at, message_ttl FROM chats WHERE id = $1`
 
-	err := m.DB.QueryRow(stmt, id).Scan(&chat.Id, &chat.Name, &chat.UpdatedAt)
+	err := m.DB.QueryRow(stmt, id).Scan(&chat.Id, &chat.Name, &chat.Topic, &chat.UpdatedAt, &chat.MessageTTL)
 	if err != nil {
 		if err == sql.ErrNoRows {
 			return nil, nil
@@ -62,7 +100,8 @@ func (m *ChatModel) GetChatsByUserID(userID uuid.UUID, cursorUpdatedAt *time.Tim
 	var err error
 
 	if cursorUpdatedAt == nil {
-		stmt := `SELECT c.id, c.name, c.updated_at 
+		stmt := `SELECT c.id, c.name, c.topic, c.updated_at, c.message_ttl, ` + unreadCountColumn + `,
+			` + mutedCondition + `, CASE WHEN ` + mutedCondition + ` THEN cu.muted_until END
 		FROM chats c 
 		INNER JOIN chat_users cu ON c.id = cu.chat_id 
 		WHERE cu.user_id = $1 
@@ -71,7 +110,8 @@ func (m *ChatModel) GetChatsByUserID(userID uuid.UUID, cursorUpdatedAt *time.Tim
 		rows, err = m.DB.Query(stmt, userID, limit)
 	} else {
 		chatIdUUID := uuid.MustParse(*chatId)
-		stmt := `SELECT c.id, c.name, c.updated_at 
+		stmt := `SELECT c.id, c.name, c.topic, c.updated_at, c.message_ttl, ` + unreadCountColumn + `,
+			` + mutedCondition + `, CASE WHEN ` + mutedCondition + ` THEN cu.muted_until END
 		FROM chats c 
 		INNER JOIN chat_users cu ON c.id = cu.chat_id 
 		WHERE cu.user_id = $1 
@@ -91,7 +131,8 @@ func (m *ChatModel) GetChatsByUserID(userID uuid.UUID, cursorUpdatedAt *time.Tim
 
 	for rows.Next() {
 		chat := &Chat{}
-		err := rows.Scan(&chat.Id, &chat.Name, &chat.UpdatedAt)
+		err := rows.Scan(&chat.Id, &chat.Name, &chat.Topic, &chat.UpdatedAt, &chat.MessageTTL, &chat.UnreadCount,
+			&chat.Muted, &chat.MutedUntil)
 		if err != nil {
 			return nil, err
 		}
@@ -105,8 +146,15 @@ func (m *ChatModel) GetChatsByUserID(userID uuid.UUID, cursorUpdatedAt *time.Tim
 		return nil, err
 	}
 
+	// get the other members' read positions
+	chatToReadMarkers, err := m.getChatReadMarkers(chatIds, userID)
+	if err != nil {
+		return nil, err
+	}
+
 	for _, chat := range chats {
 		chat.UserInfos = chatToUserMap[chat.Id]
+		chat.ReadMarkers = chatToReadMarkers[chat.Id]
 	}
 
 	return chats, nil
@@ -144,6 +192,187 @@ func (m *ChatModel) getChatUserInfos(chatIds []uuid.UUID) (map[uuid.UUID][]*User
 	return chatToUserMap, nil
 }
 
+// getChatReadMarkers retrieves the read positions of the members of several
+// chats, leaving out excludedUserID
+func (m *ChatModel) getChatReadMarkers(chatIds []uuid.UUID, excludedUserID uuid.UUID) (map[uuid.UUID][]*ReadMarker, error) {
+	chatToReadMarkers := make(map[uuid.UUID][]*ReadMarker)
+	stmt := `SELECT chat_id, user_id, last_read_message_id, last_read_at
+	         FROM chat_users
+	         WHERE chat_id = ANY($1) AND user_id <> $2
+	        `
+	rows, err := m.DB.Query(stmt, pq.Array(chatIds), excludedUserID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		readMarker := &ReadMarker{}
+		chatId := uuid.UUID{}
+		err = rows.Scan(&chatId, &readMarker.UserID, &readMarker.LastReadMessageID, &readMarker.LastReadAt)
+		if err != nil {
+			return nil, err
+		}
+		chatToReadMarkers[chatId] = append(chatToReadMarkers[chatId], readMarker)
+	}
+
+	if err = rows.Err(); err != nil {
+		return nil, err
+	}
+	return chatToReadMarkers, nil
+}
+
+// MarkRead moves a member's read marker forward to messageID. The marker never
+// moves backwards; the returned bool reports whether it moved.
+func (m *ChatModel) MarkRead(chatID, userID, messageID uuid.UUID) (*ReadMarker, bool, error) {
+	readMarker := &ReadMarker{UserID: userID}
+
+	stmt := `UPDATE chat_users cu
+	SET last_read_message_id = m.id, last_read_at = CURRENT_TIMESTAMP
+	FROM messages m
+	WHERE cu.chat_id = $1 AND cu.user_id = $2
+	AND m.id = $3 AND m.chat_id = cu.chat_id
+	AND NOT EXISTS (
+		SELECT 1 FROM messages prev
+		WHERE prev.id = cu.last_read_message_id
+		AND prev.seq >= m.seq
+	)
+	RETURNING cu.last_read_message_id, cu.last_read_at`
+
+	err := m.DB.QueryRow(stmt, chatID, userID, messageID).Scan(&readMarker.LastReadMessageID, &readMarker.LastReadAt)
+	if err == nil {
+		return readMarker, true, nil
+	}
+	if !errors.Is(err, sql.ErrNoRows) {
+		return nil, false, err
+	}
+
+	// the member has already read past this message
+	readMarker, err = m.GetReadMarker(chatID, userID)
+	if err != nil {
+		return nil, false, err
+	}
+	return readMarker, false, nil
+}
+
+// GetReadMarker retrieves the read position of a chat member
+func (m *ChatModel) GetReadMarker(chatID, userID uuid.UUID) (*ReadMarker, error) {
+	readMarker := &ReadMarker{UserID: userID}
+
+	stmt := `SELECT last_read_message_id, last_read_at FROM chat_users WHERE chat_id = $1 AND user_id = $2`
+
+	err := m.DB.QueryRow(stmt, chatID, userID).Scan(&readMarker.LastReadMessageID, &readMarker.LastReadAt)
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return nil, ErrNoRecord
+		}
+		return nil, err
+	}
+
+	return readMarker, nil
+}
+
+// SetMessageTTL changes how many seconds the messages of a chat are kept.
+// A nil ttl keeps them forever.
+func (m *ChatModel) SetMessageTTL(chatID uuid.UUID, ttl *int) error {
+	stmt := `UPDATE chats SET message_ttl = $2 WHERE id = $1`
+
+	result, err := m.DB.Exec(stmt, chatID, ttl)
+	if err != nil {
+		return err
+	}
+	affected, err := result.RowsAffected()
+	if err != nil {
+		return err
+	}
+	if affected == 0 {
+		return ErrNoRecord
+	}
+	return nil
+}
+
+// RenameChat changes the name of a chat
+func (m *ChatModel) RenameChat(chatID uuid.UUID, name string) error {
+	stmt := `UPDATE chats SET name = $2 WHERE id = $1`
+
+	result, err := m.DB.Exec(stmt, chatID, name)
+	if err != nil {
+		return err
+	}
+	affected, err := result.RowsAffected()
+	if err != nil {
+		return err
+	}
+	if affected == 0 {
+		return ErrNoRecord
+	}
+	return nil
+}
+
+// SetChatTopic changes the topic of a chat. An empty topic clears it.
+func (m *ChatModel) SetChatTopic(chatID uuid.UUID, topic string) error {
+	stmt := `UPDATE chats SET topic = $2 WHERE id = $1`
+
+	result, err := m.DB.Exec(stmt, chatID, topic)
+	if err != nil {
+		return err
+	}
+	affected, err := result.RowsAffected()
+	if err != nil {
+		return err
+	}
+	if affected == 0 {
+		return ErrNoRecord
+	}
+	return nil
+}
+
+// SetChatMuted mutes or unmutes a chat for one of its members. A mute with a
+// nil until lasts until the member unmutes the chat.
+func (m *ChatModel) SetChatMuted(chatID, userID uuid.UUID, muted bool, until *time.Time) error {
+	if !muted {
+		until = nil
+	}
+	stmt := `UPDATE chat_users SET muted = $3, muted_until = $4 WHERE chat_id = $1 AND user_id = $2`
+
+	result, err := m.DB.Exec(stmt, chatID, userID, muted, until)
+	if err != nil {
+		return err
+	}
+	affected, err := result.RowsAffected()
+	if err != nil {
+		return err
+	}
+	if affected == 0 {
+		return ErrNoRecord
+	}
+	return nil
+}
+
+// GetMutedMemberIDs retrieves the members of a chat whose mute lasts
+func (m *ChatModel) GetMutedMemberIDs(chatID uuid.UUID) ([]uuid.UUID, error) {
+	stmt := `SELECT cu.user_id FROM chat_users cu WHERE cu.chat_id = $1 AND ` + mutedCondition
+
+	rows, err := m.DB.Query(stmt, chatID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var userIDs []uuid.UUID
+	for rows.Next() {
+		var userID uuid.UUID
+		if err = rows.Scan(&userID); err != nil {
+			return nil, err
+		}
+		userIDs = append(userIDs, userID)
+	}
+	if err = rows.Err(); err != nil {
+		return nil, err
+	}
+	return userIDs, nil
+}
+
 // GetChatMembers retrieves all members of a specific chat
 func (m *ChatModel) GetChatMembers(chatID uuid.UUID) ([]*UserInfo, error) {
 	stmt := `SELECT u.id, u.name, u.email 
@@ -176,6 +405,14 @@ func (m *ChatModel) GetChatMembers(chatID uuid.UUID) ([]*UserInfo, error) {
 	return members, nil
 }
 
+// IsChatMember reports whether a user is a member of a chat
+func (m *ChatModel) IsChatMember(chatID, userID uuid.UUID) (bool, error) {
+	var exists bool
+	stmt := `SELECT EXISTS(SELECT 1 FROM chat_users WHERE chat_id = $1 AND user_id = $2)`
+	err := m.DB.QueryRow(stmt, chatID, userID).Scan(&exists)
+	return exists, err
+}
+
 // AddUserToChat adds a user to a chat
 func (m *ChatModel) AddUserToChat(chatID, userID uuid.UUID) error {
 	stmt := `INSERT INTO chat_users (chat_id, user_id) VALUES ($1, $2) ON CONFLICT (chat_id, user_id) DO NOTHING`
@@ -184,6 +421,19 @@ func (m *ChatModel) AddUserToChat(chatID, userID uuid.UUID) error {
 	return err
 }
 
+// RemoveUserFromChat removes a user from a chat. It reports whether the user
+// was a member.
+func (m *ChatModel) RemoveUserFromChat(chatID, userID uuid.UUID) (bool, error) {
+	stmt := `DELETE FROM chat_users WHERE chat_id = $1 AND user_id = $2`
+
+	result, err := m.DB.Exec(stmt, chatID, userID)
+	if err != nil {
+		return false, err
+	}
+	affected, err := result.RowsAffected()
+	return affected > 0, err
+}
+
 // AddUsersToChat adds multiple users to a chat
 func (m *ChatModel) AddUsersToChat(chatID uuid.UUID, userIDs []uuid.UUID) error {
 	if len(userIDs) == 0 {
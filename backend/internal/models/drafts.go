@@ -0,0 +1,75 @@
+package models
+
+import (
+	"time"
+
+	"github.com/google/uuid"
+)
+
+// Draft is the unsent content of a user's composer in a chat, or in a thread
+// of the chat if ParentMessageID is set
+type Draft struct {
+	ChatID          uuid.UUID  `json:"chat_id"`
+	ParentMessageID *uuid.UUID `json:"parent_message_id,omitempty"`
+	Content         string     `json:"content"`
+	UpdatedAt       time.Time  `json:"updated_at"`
+}
+
+// SaveDraft stores the draft of userID, replacing the draft of the same chat
+// and thread. UpdatedAt is filled in on success.
+func (m *MessageModel) SaveDraft(userID uuid.UUID, draft *Draft) error {
+	stmt := `INSERT INTO drafts (user_id, chat_id, parent_message_id, content) VALUES ($1, $2, $3, $4)
+	ON CONFLICT ON CONSTRAINT drafts_uc_user_chat_parent
+	DO UPDATE SET content = EXCLUDED.content, updated_at = CURRENT_TIMESTAMP
+	RETURNING updated_at`
+
+	return m.DB.QueryRow(stmt, userID, draft.ChatID, draft.ParentMessageID, draft.Content).Scan(&draft.UpdatedAt)
+}
+
+// DeleteDraft removes the draft of userID in a chat and thread. It reports
+// whether there was one.
+func (m *MessageModel) DeleteDraft(userID, chatID uuid.UUID, parentMessageID *uuid.UUID) (bool, error) {
+	stmt := `DELETE FROM drafts
+	WHERE user_id = $1 AND chat_id = $2 AND parent_message_id IS NOT DISTINCT FROM $3`
+
+	result, err := m.DB.Exec(stmt, userID, chatID, parentMessageID)
+	if err != nil {
+		return false, err
+	}
+	rowsAffected, err := result.RowsAffected()
+	if err != nil {
+		return false, err
+	}
+	return rowsAffected > 0, nil
+}
+
+// GetDrafts retrieves the drafts of userID, most recently updated first.
+// Drafts of chats the user is no longer a member of are left out. If chatID
+// is set only the drafts of that chat are returned.
+func (m *MessageModel) GetDrafts(userID uuid.UUID, chatID *uuid.UUID) ([]*Draft, error) {
+	stmt := `SELECT d.chat_id, d.parent_message_id, d.content, d.updated_at
+	FROM drafts d
+	INNER JOIN chat_users cu ON cu.chat_id = d.chat_id AND cu.user_id = d.user_id
+	WHERE d.user_id = $1 AND ($2::uuid IS NULL OR d.chat_id = $2)
+	ORDER BY d.updated_at DESC`
+
+	rows, err := m.DB.Query(stmt, userID, chatID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	drafts := []*Draft{}
+	for rows.Next() {
+		draft := &Draft{}
+		err = rows.Scan(&draft.ChatID, &draft.ParentMessageID, &draft.Content, &draft.UpdatedAt)
+		if err != nil {
+			return nil, err
+		}
+		drafts = append(drafts, draft)
+	}
+	if err = rows.Err(); err != nil {
+		return nil, err
+	}
+	return drafts, nil
+}
@@ -13,4 +13,8 @@ var (
 	// tries to signup with an email address that's already in use.
 	ErrDuplicateEmail = errors.New("models: duplicate email")
 	ErrUserDoesNotExist = errors.New("models: user does not exist")
+	ErrNotMessageSender = errors.New("models: user is not the sender of the message")
+	ErrDuplicateMessage = errors.New("models: duplicate client message id")
+	ErrPollClosed = errors.New("models: poll is closed")
+	ErrInvalidPollVote = errors.New("models: invalid poll vote")
 )
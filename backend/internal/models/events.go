@@ -0,0 +1,153 @@
+package models
+
+import (
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/lib/pq"
+)
+
+// UserEvent is an event sent to a user, as stored in the user's event log
+type UserEvent struct {
+	Seq       int64           `json:"seq"`
+	Type      string          `json:"type"`
+	Data      json.RawMessage `json:"data"`
+	CreatedAt time.Time       `json:"created_at"`
+}
+
+// EventSeq describes the event log of a user. Events up to and including
+// PrunedSeq are no longer in the log.
+type EventSeq struct {
+	LastSeq   int64
+	PrunedSeq int64
+}
+
+// AppendEvents adds an event to the logs of userIDs in one statement and
+// returns its sequence number for each of them. messageIDs are the messages
+// the event embeds; it is removed from the logs with any of them.
+// supersededIDs are messages the event edits or deletes: the events in the
+// same logs that carry their content are removed, so that the old content
+// can't be replayed.
+func (m *UserModel) AppendEvents(userIDs []uuid.UUID, eventType string, data json.RawMessage, messageIDs, supersededIDs []uuid.UUID) (map[uuid.UUID]int64, error) {
+	// the update locks the users' rows until the events are inserted, which
+	// keeps the sequences free of gaps. They are locked in the order of their
+	// ids, like in PruneEvents, so that statements locking the same users
+	// can't deadlock.
+	stmt := `WITH superseded AS (
+		DELETE FROM user_events e
+		USING user_event_messages l
+		WHERE l.message_id = ANY($5::uuid[]) AND l.user_id = ANY($1)
+		AND e.user_id = l.user_id AND e.seq = l.seq
+		AND jsonb_path_exists(e.data, '$.** ? (@.messageId == $id && exists (@.content))',
+			jsonb_build_object('id', l.message_id))
+	), next AS (
+		UPDATE users SET event_seq = event_seq + 1
+		WHERE id IN (SELECT id FROM users WHERE id = ANY($1) ORDER BY id FOR UPDATE)
+		RETURNING id, event_seq
+	), appended AS (
+		INSERT INTO user_events (user_id, seq, type, data)
+		SELECT id, event_seq, $2, $3::jsonb FROM next
+		RETURNING user_id, seq
+	), linked AS (
+		INSERT INTO user_event_messages (message_id, user_id, seq)
+		SELECT message_id, a.user_id, a.seq
+		FROM appended a, unnest($4::uuid[]) AS message_id
+	)
+	SELECT user_id, seq FROM appended`
+
+	rows, err := m.DB.Query(stmt, pq.Array(userIDs), eventType, string(data), pq.Array(messageIDs), pq.Array(supersededIDs))
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	seqs := make(map[uuid.UUID]int64, len(userIDs))
+	for rows.Next() {
+		var userID uuid.UUID
+		var seq int64
+		if err = rows.Scan(&userID, &seq); err != nil {
+			return nil, err
+		}
+		seqs[userID] = seq
+	}
+	if err = rows.Err(); err != nil {
+		return nil, err
+	}
+	return seqs, nil
+}
+
+// GetEventSeq retrieves the state of the event log of a user
+func (m *UserModel) GetEventSeq(userID uuid.UUID) (*EventSeq, error) {
+	eventSeq := &EventSeq{}
+
+	stmt := `SELECT event_seq, event_pruned_seq FROM users WHERE id = $1`
+
+	err := m.DB.QueryRow(stmt, userID).Scan(&eventSeq.LastSeq, &eventSeq.PrunedSeq)
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return nil, ErrNoRecord
+		}
+		return nil, err
+	}
+	return eventSeq, nil
+}
+
+// GetEventsAfter retrieves up to limit events of userID that follow afterSeq,
+// oldest first
+func (m *UserModel) GetEventsAfter(userID uuid.UUID, afterSeq int64, limit int) ([]*UserEvent, error) {
+	stmt := `SELECT seq, type, data, created_at
+	FROM user_events
+	WHERE user_id = $1 AND seq > $2
+	ORDER BY seq
+	LIMIT $3`
+
+	rows, err := m.DB.Query(stmt, userID, afterSeq, limit)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var events []*UserEvent
+	for rows.Next() {
+		event := &UserEvent{}
+		err = rows.Scan(&event.Seq, &event.Type, &event.Data, &event.CreatedAt)
+		if err != nil {
+			return nil, err
+		}
+		events = append(events, event)
+	}
+	if err = rows.Err(); err != nil {
+		return nil, err
+	}
+	return events, nil
+}
+
+// PruneEvents removes up to limit events created before the given time from
+// the logs of all users and returns how many were removed
+func (m *UserModel) PruneEvents(before time.Time, limit int) (int, error) {
+	stmt := `WITH pruned AS (
+		DELETE FROM user_events
+		WHERE (user_id, seq) IN (
+			SELECT user_id, seq FROM user_events WHERE created_at < $1 LIMIT $2
+		)
+		RETURNING user_id, seq
+	), last_pruned AS (
+		SELECT user_id, MAX(seq) AS seq, COUNT(*) AS count FROM pruned GROUP BY user_id
+	), updated AS (
+		UPDATE users u SET event_pruned_seq = GREATEST(u.event_pruned_seq, p.seq)
+		FROM last_pruned p
+		WHERE u.id = p.user_id
+		AND u.id IN (SELECT id FROM users WHERE id IN (SELECT user_id FROM last_pruned) ORDER BY id FOR UPDATE)
+	)
+	SELECT COALESCE(SUM(count), 0) FROM last_pruned`
+
+	var count int
+	err := m.DB.QueryRow(stmt, before, limit).Scan(&count)
+	if err != nil {
+		return 0, err
+	}
+	return count, nil
+}
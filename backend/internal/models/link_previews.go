@@ -0,0 +1,101 @@
+package models
+
+import (
+	"database/sql"
+	"errors"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/lib/pq"
+)
+
+// LinkPreview is the unfurled metadata of a URL. Previews are cached per URL
+// and shared by every message linking to it.
+type LinkPreview struct {
+	URL         string    `json:"url"`
+	Title       string    `json:"title"`
+	Description string    `json:"description"`
+	ImageURL    string    `json:"image_url"`
+	SiteName    string    `json:"site_name"`
+	FetchedAt   time.Time `json:"fetched_at"`
+}
+
+// GetLinkPreview retrieves the cached preview of a URL
+func (m *MessageModel) GetLinkPreview(url string) (*LinkPreview, error) {
+	preview := &LinkPreview{}
+
+	stmt := `SELECT url, title, description, image_url, site_name, fetched_at FROM link_previews WHERE url = $1`
+
+	err := m.DB.QueryRow(stmt, url).Scan(
+		&preview.URL, &preview.Title, &preview.Description, &preview.ImageURL, &preview.SiteName, &preview.FetchedAt,
+	)
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return nil, ErrNoRecord
+		}
+		return nil, err
+	}
+
+	return preview, nil
+}
+
+// SaveLinkPreview caches the preview of a URL, replacing an older one.
+// FetchedAt is set on success.
+func (m *MessageModel) SaveLinkPreview(preview *LinkPreview) error {
+	stmt := `INSERT INTO link_previews (url, title, description, image_url, site_name)
+	VALUES ($1, $2, $3, $4, $5)
+	ON CONFLICT (url) DO UPDATE SET
+		title = EXCLUDED.title,
+		description = EXCLUDED.description,
+		image_url = EXCLUDED.image_url,
+		site_name = EXCLUDED.site_name,
+		fetched_at = CURRENT_TIMESTAMP
+	RETURNING fetched_at`
+
+	return m.DB.QueryRow(stmt, preview.URL, preview.Title, preview.Description, preview.ImageURL, preview.SiteName).Scan(&preview.FetchedAt)
+}
+
+// AttachLinkPreviews links a message to the cached previews of urls, which
+// are shown in the given order
+func (m *MessageModel) AttachLinkPreviews(messageID uuid.UUID, urls []string) error {
+	stmt := `INSERT INTO message_link_previews (message_id, url, sort_order)
+	SELECT $1, url, sort_order FROM unnest($2::text[]) WITH ORDINALITY AS t(url, sort_order)
+	ON CONFLICT (message_id, url) DO NOTHING`
+
+	_, err := m.DB.Exec(stmt, messageID, pq.Array(urls))
+	return err
+}
+
+// getLinkPreviews retrieves the previews of messages. Messages deleted for
+// everyone have no previews.
+func (m *MessageModel) getLinkPreviews(messageIDs []uuid.UUID) (map[uuid.UUID][]*LinkPreview, error) {
+	messageToPreviews := make(map[uuid.UUID][]*LinkPreview)
+	stmt := `SELECT mlp.message_id, lp.url, lp.title, lp.description, lp.image_url, lp.site_name, lp.fetched_at
+	         FROM message_link_previews mlp
+	         INNER JOIN link_previews lp ON lp.url = mlp.url
+	         INNER JOIN messages m ON m.id = mlp.message_id
+	         WHERE mlp.message_id = ANY($1)
+	         AND m.deleted_at IS NULL
+	         ORDER BY mlp.sort_order
+	        `
+	rows, err := m.DB.Query(stmt, pq.Array(messageIDs))
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		preview := &LinkPreview{}
+		messageID := uuid.UUID{}
+		err = rows.Scan(&messageID, &preview.URL, &preview.Title, &preview.Description, &preview.ImageURL, &preview.SiteName, &preview.FetchedAt)
+		if err != nil {
+			return nil, err
+		}
+		messageToPreviews[messageID] = append(messageToPreviews[messageID], preview)
+	}
+
+	if err = rows.Err(); err != nil {
+		return nil, err
+	}
+	return messageToPreviews, nil
+}
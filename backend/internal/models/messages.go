@@ -2,18 +2,77 @@ package models
 
 import (
 	"database/sql"
+	"errors"
 	"time"
 
 	"github.com/google/uuid"
+	"github.com/lib/pq"
 )
 
 // Message represents a chat message
 type Message struct {
-	ID        uuid.UUID `json:"id"`
-	SenderID  uuid.UUID `json:"sender_id"`
-	ChatID    uuid.UUID `json:"chat_id"`
-	Content   string    `json:"content"`
-	CreatedAt time.Time `json:"created_at"`
+	ID       uuid.UUID `json:"id"`
+	SenderID uuid.UUID `json:"sender_id"`
+	ChatID   uuid.UUID `json:"chat_id"`
+	Content  string    `json:"content"`
+	// ContentType tells clients how to render Content, e.g. text/markdown
+	ContentType string `json:"content_type"`
+	// Seq numbers the messages of a chat, thread replies included, in the
+	// order they were inserted. It has no gaps until messages expire.
+	Seq       int64      `json:"seq"`
+	CreatedAt time.Time  `json:"created_at"`
+	EditedAt  *time.Time `json:"edited_at,omitempty"`
+	DeletedAt *time.Time `json:"deleted_at,omitempty"`
+
+	// ClientMessageID is the sender's own id for the message, used to
+	// recognise retried sends
+	ClientMessageID *string `json:"client_message_id,omitempty"`
+
+	// Thread information. Replies have a parent, roots track their replies.
+	ParentMessageID *uuid.UUID `json:"parent_message_id,omitempty"`
+	ReplyCount      int        `json:"reply_count"`
+	LastReplyAt     *time.Time `json:"last_reply_at,omitempty"`
+
+	// Forward metadata. A forwarded message is a copy attributed to the
+	// message, sender and chat it was forwarded from.
+	ForwardedFromMessageID  *uuid.UUID `json:"forwarded_from_message_id,omitempty"`
+	ForwardedFromSenderID   *uuid.UUID `json:"forwarded_from_sender_id,omitempty"`
+	ForwardedFromSenderName *string    `json:"forwarded_from_sender_name,omitempty"`
+	ForwardedFromChatID     *uuid.UUID `json:"forwarded_from_chat_id,omitempty"`
+	ForwardedFromCreatedAt  *time.Time `json:"forwarded_from_created_at,omitempty"`
+
+	// Mentions holds the users mentioned in the message. It is only set
+	// when inserting a message.
+	Mentions []uuid.UUID `json:"mentions,omitempty"`
+
+	SenderName   string           `json:"sender_name,omitempty"`
+	Reactions    []*ReactionCount `json:"reactions,omitempty"`
+	LinkPreviews []*LinkPreview   `json:"link_previews,omitempty"`
+	Poll         *Poll            `json:"poll,omitempty"`
+}
+
+// messageViewColumns selects a message the way it is shown to chat members.
+// Messages deleted for everyone keep their place but lose their content.
+const messageViewColumns = `m.id, m.sender_id, m.chat_id,
+	CASE WHEN m.deleted_at IS NULL THEN m.content ELSE '' END, m.content_type,
+	m.seq, m.created_at, m.edited_at, m.deleted_at,
+	m.parent_message_id, m.reply_count, m.last_reply_at, u.name,
+	` + forwardColumns
+
+// forwardColumns selects the forward metadata of the message aliased m
+const forwardColumns = `m.forwarded_from_message_id, m.forwarded_from_sender_id,
+	(SELECT fu.name FROM users fu WHERE fu.id = m.forwarded_from_sender_id),
+	m.forwarded_from_chat_id, m.forwarded_from_created_at`
+
+// messageViewDest returns the scan destinations for messageViewColumns
+func messageViewDest(message *Message) []any {
+	return []any{
+		&message.ID, &message.SenderID, &message.ChatID, &message.Content, &message.ContentType,
+		&message.Seq, &message.CreatedAt, &message.EditedAt, &message.DeletedAt,
+		&message.ParentMessageID, &message.ReplyCount, &message.LastReplyAt, &message.SenderName,
+		&message.ForwardedFromMessageID, &message.ForwardedFromSenderID, &message.ForwardedFromSenderName,
+		&message.ForwardedFromChatID, &message.ForwardedFromCreatedAt,
+	}
 }
 
 // MessageModel wraps a database connection pool for message operations
@@ -21,31 +80,236 @@ type MessageModel struct {
 	DB *sql.DB
 }
 
-// InsertMessage creates a new message
-func (m *MessageModel) InsertMessage(senderID, chatID uuid.UUID, content string) (*Message, error) {
-	message := &Message{
-		SenderID: senderID,
-		ChatID:   chatID,
-		Content:  content,
+// InsertMessage saves a new message. SenderID, ChatID, Content, ContentType
+// and optionally ParentMessageID, ClientMessageID and Mentions must be set;
+// the generated fields are filled in on success. If the sender already sent a
+// message with the same ClientMessageID, ErrDuplicateMessage is returned.
+func (m *MessageModel) InsertMessage(message *Message) error {
+	tx, err := m.DB.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	if err = insertMessage(tx, message); err != nil {
+		return err
+	}
+
+	return tx.Commit()
+}
+
+// InsertMessages saves several messages in one transaction, in order. Either
+// all of them are saved or none is.
+func (m *MessageModel) InsertMessages(messages []*Message) error {
+	tx, err := m.DB.Begin()
+	if err != nil {
+		return err
 	}
+	defer tx.Rollback()
 
-	stmt := `INSERT INTO messages (sender_id, chat_id, content) VALUES ($1, $2, $3) RETURNING id, sender_id, chat_id, content, created_at`
+	for _, message := range messages {
+		if err = insertMessage(tx, message); err != nil {
+			return err
+		}
+	}
 
-	err := m.DB.QueryRow(stmt, senderID, chatID, content).Scan(
-		&message.ID, &message.SenderID, &message.ChatID, &message.Content, &message.CreatedAt,
+	return tx.Commit()
+}
+
+// insertMessage does the work of InsertMessage inside tx
+func insertMessage(tx *sql.Tx, message *Message) error {
+	// taking the next number locks the chat's row until tx ends, so messages
+	// of a chat are numbered in the order they are committed, without gaps
+	stmt := `UPDATE chats SET message_seq = message_seq + 1 WHERE id = $1 RETURNING message_seq`
+
+	err := tx.QueryRow(stmt, message.ChatID).Scan(&message.Seq)
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return ErrNoRecord
+		}
+		return err
+	}
+
+	stmt = `INSERT INTO messages (sender_id, chat_id, content, content_type, parent_message_id, client_message_id,
+		forwarded_from_message_id, forwarded_from_sender_id, forwarded_from_chat_id, forwarded_from_created_at, seq)
+	VALUES ($1, $2, $3, $4, $5, $6, $7, $8, $9, $10, $11)
+	RETURNING id, created_at`
+
+	err = tx.QueryRow(stmt, message.SenderID, message.ChatID, message.Content, message.ContentType, message.ParentMessageID, message.ClientMessageID,
+		message.ForwardedFromMessageID, message.ForwardedFromSenderID, message.ForwardedFromChatID, message.ForwardedFromCreatedAt, message.Seq).Scan(
+		&message.ID, &message.CreatedAt,
 	)
 	if err != nil {
+		var pqErr *pq.Error
+		if errors.As(err, &pqErr) {
+			if pqErr.Code == "23505" && pqErr.Constraint == "messages_uc_sender_client_message_id" {
+				return ErrDuplicateMessage
+			}
+		}
+		return err
+	}
+
+	if len(message.Mentions) > 0 {
+		stmt = `INSERT INTO message_mentions (message_id, user_id)
+		SELECT $1, unnest($2::uuid[])
+		ON CONFLICT DO NOTHING`
+		_, err = tx.Exec(stmt, message.ID, pq.Array(message.Mentions))
+		if err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// GetMessageByClientID retrieves the message a sender tagged with clientMessageID
+func (m *MessageModel) GetMessageByClientID(senderID uuid.UUID, clientMessageID string) (*Message, error) {
+	var id uuid.UUID
+
+	stmt := `SELECT id FROM messages WHERE sender_id = $1 AND client_message_id = $2`
+
+	err := m.DB.QueryRow(stmt, senderID, clientMessageID).Scan(&id)
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return nil, ErrNoRecord
+		}
+		return nil, err
+	}
+
+	return m.GetMessage(id)
+}
+
+// EditMessage replaces the content of a message and records the previous
+// content in message_edits. Only the original sender may edit a message.
+func (m *MessageModel) EditMessage(messageID, editorID uuid.UUID, content string) (*Message, error) {
+	tx, err := m.DB.Begin()
+	if err != nil {
+		return nil, err
+	}
+	defer tx.Rollback()
+
+	var senderID uuid.UUID
+	var previousContent string
+	stmt := `SELECT sender_id, content FROM messages WHERE id = $1 AND deleted_at IS NULL FOR UPDATE`
+	err = tx.QueryRow(stmt, messageID).Scan(&senderID, &previousContent)
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return nil, ErrNoRecord
+		}
+		return nil, err
+	}
+	if senderID != editorID {
+		return nil, ErrNotMessageSender
+	}
+
+	stmt = `INSERT INTO message_edits (message_id, editor_id, previous_content, content) VALUES ($1, $2, $3, $4)`
+	_, err = tx.Exec(stmt, messageID, editorID, previousContent, content)
+	if err != nil {
+		return nil, err
+	}
+
+	message := &Message{}
+	stmt = `UPDATE messages SET content = $2, edited_at = CURRENT_TIMESTAMP
+	WHERE id = $1
+	RETURNING id, sender_id, chat_id, content, content_type, seq, created_at, edited_at`
+	err = tx.QueryRow(stmt, messageID, content).Scan(
+		&message.ID, &message.SenderID, &message.ChatID, &message.Content, &message.ContentType, &message.Seq, &message.CreatedAt, &message.EditedAt,
+	)
+	if err != nil {
+		return nil, err
+	}
+
+	if err = tx.Commit(); err != nil {
 		return nil, err
 	}
 
 	return message, nil
 }
 
-// GetMessagesByChat retrieves messages for a specific chat with pagination
-func (m *MessageModel) GetMessagesByChat(chatID uuid.UUID, limit, offset int) ([]*Message, error) {
-	stmt := `SELECT id, sender_id, chat_id, content, created_at FROM messages WHERE chat_id = $1 ORDER BY created_at DESC LIMIT $2 OFFSET $3`
+// GetMessage retrieves a single message by ID, including tombstones
+func (m *MessageModel) GetMessage(id uuid.UUID) (*Message, error) {
+	message := &Message{}
+
+	stmt := `SELECT m.id, m.sender_id, m.chat_id, m.content, m.content_type, m.seq, m.created_at, m.edited_at, m.deleted_at, m.client_message_id,
+		m.parent_message_id, m.reply_count, m.last_reply_at, u.name, ` + forwardColumns + `
+	FROM messages m
+	INNER JOIN users u ON u.id = m.sender_id
+	WHERE m.id = $1`
 
-	rows, err := m.DB.Query(stmt, chatID, limit, offset)
+	err := m.DB.QueryRow(stmt, id).Scan(
+		&message.ID, &message.SenderID, &message.ChatID, &message.Content, &message.ContentType, &message.Seq, &message.CreatedAt, &message.EditedAt, &message.DeletedAt,
+		&message.ClientMessageID, &message.ParentMessageID, &message.ReplyCount, &message.LastReplyAt, &message.SenderName,
+		&message.ForwardedFromMessageID, &message.ForwardedFromSenderID, &message.ForwardedFromSenderName,
+		&message.ForwardedFromChatID, &message.ForwardedFromCreatedAt,
+	)
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return nil, ErrNoRecord
+		}
+		return nil, err
+	}
+
+	return message, nil
+}
+
+// DeleteMessage soft-deletes a message for every chat member. Only the
+// original sender may delete a message for everyone.
+func (m *MessageModel) DeleteMessage(messageID, userID uuid.UUID) (*Message, error) {
+	tx, err := m.DB.Begin()
+	if err != nil {
+		return nil, err
+	}
+	defer tx.Rollback()
+
+	var senderID uuid.UUID
+	stmt := `SELECT sender_id FROM messages WHERE id = $1 AND deleted_at IS NULL FOR UPDATE`
+	err = tx.QueryRow(stmt, messageID).Scan(&senderID)
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return nil, ErrNoRecord
+		}
+		return nil, err
+	}
+	if senderID != userID {
+		return nil, ErrNotMessageSender
+	}
+
+	message := &Message{}
+	stmt = `UPDATE messages SET deleted_at = CURRENT_TIMESTAMP
+	WHERE id = $1
+	RETURNING id, sender_id, chat_id, seq, created_at, deleted_at`
+	err = tx.QueryRow(stmt, messageID).Scan(
+		&message.ID, &message.SenderID, &message.ChatID, &message.Seq, &message.CreatedAt, &message.DeletedAt,
+	)
+	if err != nil {
+		return nil, err
+	}
+
+	if err = tx.Commit(); err != nil {
+		return nil, err
+	}
+
+	return message, nil
+}
+
+// DeleteExpiredMessages permanently deletes up to limit messages that are
+// older than the message TTL of their chat, together with the replies in
+// their threads. It returns the deleted messages with only ID, ChatID and
+// ParentMessageID set.
+func (m *MessageModel) DeleteExpiredMessages(limit int) ([]*Message, error) {
+	stmt := `WITH expired AS (
+		SELECT m.id
+		FROM messages m
+		INNER JOIN chats c ON c.id = m.chat_id
+		WHERE c.message_ttl IS NOT NULL
+		AND m.created_at < CURRENT_TIMESTAMP - make_interval(secs => c.message_ttl)
+		LIMIT $1
+	)
+	DELETE FROM messages
+	WHERE id IN (SELECT id FROM expired) OR parent_message_id IN (SELECT id FROM expired)
+	RETURNING id, chat_id, parent_message_id`
+
+	rows, err := m.DB.Query(stmt, limit)
 	if err != nil {
 		return nil, err
 	}
@@ -55,7 +319,7 @@ func (m *MessageModel) GetMessagesByChat(chatID uuid.UUID, limit, offset int) ([
 
 	for rows.Next() {
 		message := &Message{}
-		err := rows.Scan(&message.ID, &message.SenderID, &message.ChatID, &message.Content, &message.CreatedAt)
+		err := rows.Scan(&message.ID, &message.ChatID, &message.ParentMessageID)
 		if err != nil {
 			return nil, err
 		}
@@ -68,3 +332,149 @@ func (m *MessageModel) GetMessagesByChat(chatID uuid.UUID, limit, offset int) ([
 
 	return messages, nil
 }
+
+// HideMessage hides a message from a single user's view of the chat
+func (m *MessageModel) HideMessage(messageID, userID uuid.UUID) error {
+	stmt := `INSERT INTO hidden_messages (user_id, message_id) VALUES ($1, $2) ON CONFLICT (user_id, message_id) DO NOTHING`
+
+	_, err := m.DB.Exec(stmt, userID, messageID)
+	return err
+}
+
+// GetMessagesByChat retrieves the root messages of a chat as seen by userID,
+// newest first. Thread replies are left out, see GetThreadReplies.
+// Pagination uses the seq of the last message received as the cursor so that
+// deep pages are served straight from messages_uc_chat_seq; pass a nil cursor
+// for the first page.
+func (m *MessageModel) GetMessagesByChat(chatID, userID uuid.UUID, cursorSeq *int64, limit int) ([]*Message, error) {
+	filter := `m.chat_id = $1 AND m.parent_message_id IS NULL`
+	return m.getSeqPage(filter, chatID, userID, cursorSeq, limit)
+}
+
+// GetThreadReplies retrieves the replies to a root message as seen by userID,
+// newest first, using the same seq cursor as GetMessagesByChat.
+func (m *MessageModel) GetThreadReplies(parentID, userID uuid.UUID, cursorSeq *int64, limit int) ([]*Message, error) {
+	filter := `m.parent_message_id = $1`
+	return m.getSeqPage(filter, parentID, userID, cursorSeq, limit)
+}
+
+// GetMentions retrieves the messages that mention userID across all chats the
+// user is still a member of, newest first. Pagination uses a (created_at, id)
+// keyset cursor, as seqs only order the messages of a single chat; pass nil
+// cursors for the first page. Messages deleted for everyone are left out.
+func (m *MessageModel) GetMentions(userID uuid.UUID, cursorCreatedAt *time.Time, cursorID *uuid.UUID, limit int) ([]*Message, error) {
+	filter := `m.id IN (SELECT mm.message_id FROM message_mentions mm WHERE mm.user_id = $1)
+		AND m.deleted_at IS NULL
+		AND EXISTS (SELECT 1 FROM chat_users cu WHERE cu.chat_id = m.chat_id AND cu.user_id = $1)`
+	return m.getMessagePage(filter, userID, userID, cursorCreatedAt, cursorID, limit)
+}
+
+// getMessagePage runs a keyset paginated message query. filter may only
+// reference $1, which is bound to filterArg. Messages the user has hidden are
+// skipped and messages deleted for everyone are returned as tombstones.
+func (m *MessageModel) getMessagePage(filter string, filterArg, userID uuid.UUID, cursorCreatedAt *time.Time, cursorID *uuid.UUID, limit int) ([]*Message, error) {
+	var rows *sql.Rows
+	var err error
+
+	if cursorCreatedAt == nil || cursorID == nil {
+		stmt := `SELECT ` + messageViewColumns + `
+		FROM messages m
+		INNER JOIN users u ON u.id = m.sender_id
+		WHERE ` + filter + `
+		AND NOT EXISTS (SELECT 1 FROM hidden_messages h WHERE h.user_id = $2 AND h.message_id = m.id)
+		ORDER BY m.created_at DESC, m.id DESC
+		LIMIT $3`
+		rows, err = m.DB.Query(stmt, filterArg, userID, limit)
+	} else {
+		stmt := `SELECT ` + messageViewColumns + `
+		FROM messages m
+		INNER JOIN users u ON u.id = m.sender_id
+		WHERE ` + filter + `
+		AND NOT EXISTS (SELECT 1 FROM hidden_messages h WHERE h.user_id = $2 AND h.message_id = m.id)
+		AND (m.created_at, m.id) < ($3, $4)
+		ORDER BY m.created_at DESC, m.id DESC
+		LIMIT $5`
+		rows, err = m.DB.Query(stmt, filterArg, userID, *cursorCreatedAt, *cursorID, limit)
+	}
+	if err != nil {
+		return nil, err
+	}
+	return m.scanMessagePage(rows, userID)
+}
+
+// getSeqPage runs a message query of a single chat paginated by seq. filter
+// is used as in getMessagePage.
+func (m *MessageModel) getSeqPage(filter string, filterArg, userID uuid.UUID, cursorSeq *int64, limit int) ([]*Message, error) {
+	stmt := `SELECT ` + messageViewColumns + `
+	FROM messages m
+	INNER JOIN users u ON u.id = m.sender_id
+	WHERE ` + filter + `
+	AND NOT EXISTS (SELECT 1 FROM hidden_messages h WHERE h.user_id = $2 AND h.message_id = m.id)
+	AND ($3::bigint IS NULL OR m.seq < $3)
+	ORDER BY m.seq DESC
+	LIMIT $4`
+
+	rows, err := m.DB.Query(stmt, filterArg, userID, cursorSeq, limit)
+	if err != nil {
+		return nil, err
+	}
+	return m.scanMessagePage(rows, userID)
+}
+
+// scanMessagePage reads the messageViewColumns of a page of messages and
+// attaches their details as seen by userID. It closes rows.
+func (m *MessageModel) scanMessagePage(rows *sql.Rows, userID uuid.UUID) ([]*Message, error) {
+	defer rows.Close()
+
+	var messages []*Message
+	var messageIDs []uuid.UUID
+
+	for rows.Next() {
+		message := &Message{}
+		err := rows.Scan(messageViewDest(message)...)
+		if err != nil {
+			return nil, err
+		}
+		messages = append(messages, message)
+		messageIDs = append(messageIDs, message.ID)
+	}
+
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+
+	if err := m.attachMessageDetails(messages, messageIDs, userID); err != nil {
+		return nil, err
+	}
+
+	return messages, nil
+}
+
+// attachMessageDetails loads the reactions, link previews and polls of
+// messages as seen by userID. messageIDs holds the ids of messages.
+func (m *MessageModel) attachMessageDetails(messages []*Message, messageIDs []uuid.UUID, userID uuid.UUID) error {
+	// get reactions
+	messageToReactions, err := m.getReactionCounts(messageIDs, userID)
+	if err != nil {
+		return err
+	}
+
+	// get link previews
+	messageToPreviews, err := m.getLinkPreviews(messageIDs)
+	if err != nil {
+		return err
+	}
+
+	// get polls
+	messageToPoll, err := m.getPolls(messageIDs, userID)
+	if err != nil {
+		return err
+	}
+
+	for _, message := range messages {
+		message.Reactions = messageToReactions[message.ID]
+		message.LinkPreviews = messageToPreviews[message.ID]
+		message.Poll = messageToPoll[message.ID]
+	}
+	return nil
+}
@@ -0,0 +1,93 @@
+package models
+
+import (
+	"database/sql"
+	"errors"
+	"time"
+
+	"github.com/google/uuid"
+)
+
+// Pin is a message pinned to a chat
+type Pin struct {
+	ChatID    uuid.UUID `json:"chat_id"`
+	MessageID uuid.UUID `json:"message_id"`
+	PinnedBy  uuid.UUID `json:"pinned_by"`
+	PinnedAt  time.Time `json:"pinned_at"`
+	Message   *Message  `json:"message,omitempty"`
+}
+
+// PinMessage pins a message to its chat. If the message is already pinned the
+// existing pin is returned and the bool is false.
+func (m *ChatModel) PinMessage(chatID, messageID, userID uuid.UUID) (*Pin, bool, error) {
+	pin := &Pin{}
+
+	stmt := `INSERT INTO chat_pins (chat_id, message_id, pinned_by) VALUES ($1, $2, $3)
+	ON CONFLICT (chat_id, message_id) DO NOTHING
+	RETURNING chat_id, message_id, pinned_by, pinned_at`
+
+	err := m.DB.QueryRow(stmt, chatID, messageID, userID).Scan(&pin.ChatID, &pin.MessageID, &pin.PinnedBy, &pin.PinnedAt)
+	if err == nil {
+		return pin, true, nil
+	}
+	if !errors.Is(err, sql.ErrNoRows) {
+		return nil, false, err
+	}
+
+	stmt = `SELECT chat_id, message_id, pinned_by, pinned_at FROM chat_pins WHERE chat_id = $1 AND message_id = $2`
+	err = m.DB.QueryRow(stmt, chatID, messageID).Scan(&pin.ChatID, &pin.MessageID, &pin.PinnedBy, &pin.PinnedAt)
+	if err != nil {
+		return nil, false, err
+	}
+	return pin, false, nil
+}
+
+// UnpinMessage removes a pin. It reports whether the message was pinned.
+func (m *ChatModel) UnpinMessage(chatID, messageID uuid.UUID) (bool, error) {
+	stmt := `DELETE FROM chat_pins WHERE chat_id = $1 AND message_id = $2`
+
+	result, err := m.DB.Exec(stmt, chatID, messageID)
+	if err != nil {
+		return false, err
+	}
+	affected, err := result.RowsAffected()
+	return affected > 0, err
+}
+
+// GetPinnedMessages retrieves the pins of a chat as seen by userID, most
+// recently pinned first. Deleted and hidden messages are left out.
+func (m *ChatModel) GetPinnedMessages(chatID, userID uuid.UUID) ([]*Pin, error) {
+	stmt := `SELECT p.chat_id, p.message_id, p.pinned_by, p.pinned_at, ` + messageViewColumns + `
+	FROM chat_pins p
+	INNER JOIN messages m ON m.id = p.message_id
+	INNER JOIN users u ON u.id = m.sender_id
+	WHERE p.chat_id = $1
+	AND m.deleted_at IS NULL
+	AND NOT EXISTS (SELECT 1 FROM hidden_messages h WHERE h.user_id = $2 AND h.message_id = m.id)
+	ORDER BY p.pinned_at DESC`
+
+	rows, err := m.DB.Query(stmt, chatID, userID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var pins []*Pin
+
+	for rows.Next() {
+		message := &Message{}
+		pin := &Pin{Message: message}
+		dest := append([]any{&pin.ChatID, &pin.MessageID, &pin.PinnedBy, &pin.PinnedAt}, messageViewDest(message)...)
+		err := rows.Scan(dest...)
+		if err != nil {
+			return nil, err
+		}
+		pins = append(pins, pin)
+	}
+
+	if err = rows.Err(); err != nil {
+		return nil, err
+	}
+
+	return pins, nil
+}
@@ -0,0 +1,221 @@
+package models
+
+import (
+	"database/sql"
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/lib/pq"
+)
+
+// Poll is attached to the message that shows it in its chat. The question is
+// the content of the message.
+type Poll struct {
+	MessageID      uuid.UUID     `json:"message_id"`
+	MultipleChoice bool          `json:"multiple_choice"`
+	ClosesAt       *time.Time    `json:"closes_at,omitempty"`
+	Closed         bool          `json:"closed"`
+	TotalVoters    int           `json:"total_voters"`
+	Options        []*PollOption `json:"options"`
+}
+
+// PollOption is one of the answers of a poll with its tally. VotedByMe is
+// relative to the user the poll was loaded for.
+type PollOption struct {
+	ID        uuid.UUID `json:"id"`
+	Text      string    `json:"text"`
+	VoteCount int       `json:"vote_count"`
+	VotedByMe bool      `json:"voted_by_me"`
+}
+
+// InsertPoll saves a poll together with the message that shows it. The
+// message is saved like InsertMessage saves it; poll.Options only need their
+// Text set. The generated fields of both are filled in on success.
+func (m *MessageModel) InsertPoll(message *Message, poll *Poll) error {
+	tx, err := m.DB.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	if err = insertMessage(tx, message); err != nil {
+		return err
+	}
+
+	stmt := `INSERT INTO polls (message_id, multiple_choice, closes_at) VALUES ($1, $2, $3)`
+	_, err = tx.Exec(stmt, message.ID, poll.MultipleChoice, poll.ClosesAt)
+	if err != nil {
+		return err
+	}
+
+	texts := make([]string, 0, len(poll.Options))
+	for _, option := range poll.Options {
+		texts = append(texts, option.Text)
+	}
+	stmt = `INSERT INTO poll_options (message_id, text, sort_order)
+	SELECT $1, t.text, t.sort_order
+	FROM unnest($2::text[]) WITH ORDINALITY AS t(text, sort_order)
+	RETURNING id, sort_order`
+	rows, err := tx.Query(stmt, message.ID, pq.Array(texts))
+	if err != nil {
+		return err
+	}
+	defer rows.Close()
+
+	// RETURNING doesn't guarantee an order, sort_order is the 1-based index
+	// of the option
+	for rows.Next() {
+		var id uuid.UUID
+		var sortOrder int
+		if err = rows.Scan(&id, &sortOrder); err != nil {
+			return err
+		}
+		if sortOrder < 1 || sortOrder > len(poll.Options) {
+			return fmt.Errorf("models: unexpected poll option sort order %d", sortOrder)
+		}
+		poll.Options[sortOrder-1].ID = id
+	}
+	if err = rows.Err(); err != nil {
+		return err
+	}
+	rows.Close()
+
+	poll.MessageID = message.ID
+	return tx.Commit()
+}
+
+// Vote replaces the votes of userID in the poll of messageID with optionIDs.
+// An empty optionIDs withdraws the user's votes. ErrNoRecord is returned if
+// the message has no poll, ErrPollClosed if the poll is closed and
+// ErrInvalidPollVote if an option isn't one of the poll's or more than one
+// option is chosen in a single choice poll.
+func (m *MessageModel) Vote(messageID, userID uuid.UUID, optionIDs []uuid.UUID) error {
+	tx, err := m.DB.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	var multipleChoice, closed bool
+	stmt := `SELECT multiple_choice, closes_at IS NOT NULL AND closes_at <= CURRENT_TIMESTAMP
+	FROM polls WHERE message_id = $1
+	FOR UPDATE`
+	err = tx.QueryRow(stmt, messageID).Scan(&multipleChoice, &closed)
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return ErrNoRecord
+		}
+		return err
+	}
+	if closed {
+		return ErrPollClosed
+	}
+	if !multipleChoice && len(optionIDs) > 1 {
+		return ErrInvalidPollVote
+	}
+
+	if len(optionIDs) > 0 {
+		var count int
+		stmt = `SELECT COUNT(*) FROM poll_options WHERE message_id = $1 AND id = ANY($2)`
+		err = tx.QueryRow(stmt, messageID, pq.Array(optionIDs)).Scan(&count)
+		if err != nil {
+			return err
+		}
+		if count != len(optionIDs) {
+			return ErrInvalidPollVote
+		}
+	}
+
+	stmt = `DELETE FROM poll_votes WHERE message_id = $1 AND user_id = $2`
+	_, err = tx.Exec(stmt, messageID, userID)
+	if err != nil {
+		return err
+	}
+
+	if len(optionIDs) > 0 {
+		stmt = `INSERT INTO poll_votes (option_id, message_id, user_id)
+		SELECT unnest($3::uuid[]), $1, $2`
+		_, err = tx.Exec(stmt, messageID, userID, pq.Array(optionIDs))
+		if err != nil {
+			return err
+		}
+	}
+
+	return tx.Commit()
+}
+
+// GetPoll retrieves the poll of a message with its tallies as seen by userID
+func (m *MessageModel) GetPoll(messageID, userID uuid.UUID) (*Poll, error) {
+	polls, err := m.getPolls([]uuid.UUID{messageID}, userID)
+	if err != nil {
+		return nil, err
+	}
+	poll, ok := polls[messageID]
+	if !ok {
+		return nil, ErrNoRecord
+	}
+	return poll, nil
+}
+
+// getPolls retrieves the polls of several messages as seen by userID.
+// Messages without a poll are left out.
+func (m *MessageModel) getPolls(messageIDs []uuid.UUID, userID uuid.UUID) (map[uuid.UUID]*Poll, error) {
+	messageToPoll := make(map[uuid.UUID]*Poll)
+
+	stmt := `SELECT p.message_id, p.multiple_choice, p.closes_at,
+		p.closes_at IS NOT NULL AND p.closes_at <= CURRENT_TIMESTAMP,
+		(SELECT COUNT(DISTINCT v.user_id) FROM poll_votes v WHERE v.message_id = p.message_id)
+	FROM polls p
+	WHERE p.message_id = ANY($1)`
+	rows, err := m.DB.Query(stmt, pq.Array(messageIDs))
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		poll := &Poll{Options: []*PollOption{}}
+		err = rows.Scan(&poll.MessageID, &poll.MultipleChoice, &poll.ClosesAt, &poll.Closed, &poll.TotalVoters)
+		if err != nil {
+			return nil, err
+		}
+		messageToPoll[poll.MessageID] = poll
+	}
+	if err = rows.Err(); err != nil {
+		return nil, err
+	}
+	if len(messageToPoll) == 0 {
+		return messageToPoll, nil
+	}
+
+	stmt = `SELECT o.message_id, o.id, o.text, COUNT(v.user_id), COALESCE(BOOL_OR(v.user_id = $2), FALSE)
+	FROM poll_options o
+	LEFT JOIN poll_votes v ON v.option_id = o.id
+	WHERE o.message_id = ANY($1)
+	GROUP BY o.message_id, o.id, o.text, o.sort_order
+	ORDER BY o.sort_order`
+	optionRows, err := m.DB.Query(stmt, pq.Array(messageIDs), userID)
+	if err != nil {
+		return nil, err
+	}
+	defer optionRows.Close()
+
+	for optionRows.Next() {
+		option := &PollOption{}
+		var messageID uuid.UUID
+		err = optionRows.Scan(&messageID, &option.ID, &option.Text, &option.VoteCount, &option.VotedByMe)
+		if err != nil {
+			return nil, err
+		}
+		if poll, ok := messageToPoll[messageID]; ok {
+			poll.Options = append(poll.Options, option)
+		}
+	}
+	if err = optionRows.Err(); err != nil {
+		return nil, err
+	}
+
+	return messageToPoll, nil
+}
@@ -0,0 +1,80 @@
+package models
+
+import (
+	"github.com/google/uuid"
+	"github.com/lib/pq"
+)
+
+// ReactionCount aggregates the reactions with one emoji on a message
+type ReactionCount struct {
+	Emoji       string `json:"emoji"`
+	Count       int    `json:"count"`
+	ReactedByMe bool   `json:"reacted_by_me"`
+}
+
+// AddReaction adds a user's emoji reaction to a message. It reports whether
+// the reaction was new.
+func (m *MessageModel) AddReaction(messageID, userID uuid.UUID, emoji string) (bool, error) {
+	stmt := `INSERT INTO message_reactions (message_id, user_id, emoji) VALUES ($1, $2, $3)
+	ON CONFLICT (message_id, user_id, emoji) DO NOTHING`
+
+	result, err := m.DB.Exec(stmt, messageID, userID, emoji)
+	if err != nil {
+		return false, err
+	}
+	affected, err := result.RowsAffected()
+	return affected > 0, err
+}
+
+// RemoveReaction removes a user's emoji reaction from a message. It reports
+// whether there was a reaction to remove.
+func (m *MessageModel) RemoveReaction(messageID, userID uuid.UUID, emoji string) (bool, error) {
+	stmt := `DELETE FROM message_reactions WHERE message_id = $1 AND user_id = $2 AND emoji = $3`
+
+	result, err := m.DB.Exec(stmt, messageID, userID, emoji)
+	if err != nil {
+		return false, err
+	}
+	affected, err := result.RowsAffected()
+	return affected > 0, err
+}
+
+// CountReactions returns how many users reacted to a message with an emoji
+func (m *MessageModel) CountReactions(messageID uuid.UUID, emoji string) (int, error) {
+	var count int
+	stmt := `SELECT COUNT(*) FROM message_reactions WHERE message_id = $1 AND emoji = $2`
+	err := m.DB.QueryRow(stmt, messageID, emoji).Scan(&count)
+	return count, err
+}
+
+// getReactionCounts aggregates the reactions of several messages as seen by
+// userID. Emojis are listed in the order they were first used on a message.
+func (m *MessageModel) getReactionCounts(messageIDs []uuid.UUID, userID uuid.UUID) (map[uuid.UUID][]*ReactionCount, error) {
+	messageToReactions := make(map[uuid.UUID][]*ReactionCount)
+	stmt := `SELECT message_id, emoji, COUNT(*), BOOL_OR(user_id = $2)
+	         FROM message_reactions
+	         WHERE message_id = ANY($1)
+	         GROUP BY message_id, emoji
+	         ORDER BY MIN(created_at)
+	        `
+	rows, err := m.DB.Query(stmt, pq.Array(messageIDs), userID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		reaction := &ReactionCount{}
+		messageID := uuid.UUID{}
+		err = rows.Scan(&messageID, &reaction.Emoji, &reaction.Count, &reaction.ReactedByMe)
+		if err != nil {
+			return nil, err
+		}
+		messageToReactions[messageID] = append(messageToReactions[messageID], reaction)
+	}
+
+	if err = rows.Err(); err != nil {
+		return nil, err
+	}
+	return messageToReactions, nil
+}
@@ -0,0 +1,106 @@
+package models
+
+import (
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/lib/pq"
+)
+
+// maxReadReceipts bounds the receipts written when a read marker moves, so
+// that reading a long chat for the first time doesn't write one for every
+// message in it. Older messages are left without read receipts.
+const maxReadReceipts = 500
+
+// MessageReceipt is the delivery state of a message for one of its
+// recipients. ChatID and SenderID are those of the message.
+type MessageReceipt struct {
+	MessageID   uuid.UUID  `json:"message_id"`
+	ChatID      uuid.UUID  `json:"chat_id"`
+	SenderID    uuid.UUID  `json:"sender_id"`
+	UserID      uuid.UUID  `json:"user_id"`
+	DeliveredAt time.Time  `json:"delivered_at"`
+	ReadAt      *time.Time `json:"read_at,omitempty"`
+}
+
+// MarkDelivered records that userID received messageIDs. Messages the user
+// sent, can't read or already confirmed are skipped; the receipts of the
+// others are returned.
+func (m *MessageModel) MarkDelivered(userID uuid.UUID, messageIDs []uuid.UUID) ([]*MessageReceipt, error) {
+	stmt := `WITH delivered AS (
+		INSERT INTO message_receipts (message_id, user_id)
+		SELECT m.id, $1
+		FROM messages m
+		WHERE m.id = ANY($2) AND m.sender_id <> $1
+		AND EXISTS (SELECT 1 FROM chat_users cu WHERE cu.chat_id = m.chat_id AND cu.user_id = $1)
+		ON CONFLICT (message_id, user_id) DO NOTHING
+		RETURNING message_id, user_id, delivered_at, read_at
+	)
+	SELECT d.message_id, m.chat_id, m.sender_id, d.user_id, d.delivered_at, d.read_at
+	FROM delivered d
+	INNER JOIN messages m ON m.id = d.message_id`
+
+	return m.queryReceipts(stmt, userID, pq.Array(messageIDs))
+}
+
+// MarkReadUpTo records that userID read the messages of a chat that others
+// sent after afterMessageID, if set, up to and including messageID. Messages
+// already read are skipped; the receipts of the others are returned.
+func (m *MessageModel) MarkReadUpTo(chatID, userID uuid.UUID, afterMessageID *uuid.UUID, messageID uuid.UUID) ([]*MessageReceipt, error) {
+	stmt := `WITH unread AS (
+		SELECT m.id
+		FROM messages m, messages upto
+		WHERE upto.id = $3 AND m.chat_id = $1 AND m.sender_id <> $2
+		AND m.deleted_at IS NULL
+		AND m.seq <= upto.seq
+		AND ($4::uuid IS NULL OR m.seq > (SELECT prev.seq FROM messages prev WHERE prev.id = $4))
+		AND NOT EXISTS (
+			SELECT 1 FROM message_receipts r
+			WHERE r.message_id = m.id AND r.user_id = $2 AND r.read_at IS NOT NULL
+		)
+		ORDER BY m.seq DESC
+		LIMIT $5
+	), marked AS (
+		INSERT INTO message_receipts (message_id, user_id, read_at)
+		SELECT id, $2, CURRENT_TIMESTAMP FROM unread
+		ON CONFLICT (message_id, user_id) DO UPDATE SET read_at = EXCLUDED.read_at
+		RETURNING message_id, user_id, delivered_at, read_at
+	)
+	SELECT r.message_id, m.chat_id, m.sender_id, r.user_id, r.delivered_at, r.read_at
+	FROM marked r
+	INNER JOIN messages m ON m.id = r.message_id`
+
+	return m.queryReceipts(stmt, chatID, userID, messageID, afterMessageID, maxReadReceipts)
+}
+
+// GetReceipts retrieves the receipts of a message
+func (m *MessageModel) GetReceipts(messageID uuid.UUID) ([]*MessageReceipt, error) {
+	stmt := `SELECT r.message_id, m.chat_id, m.sender_id, r.user_id, r.delivered_at, r.read_at
+	FROM message_receipts r
+	INNER JOIN messages m ON m.id = r.message_id
+	WHERE r.message_id = $1`
+
+	return m.queryReceipts(stmt, messageID)
+}
+
+func (m *MessageModel) queryReceipts(stmt string, args ...any) ([]*MessageReceipt, error) {
+	rows, err := m.DB.Query(stmt, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var receipts []*MessageReceipt
+	for rows.Next() {
+		receipt := &MessageReceipt{}
+		err = rows.Scan(&receipt.MessageID, &receipt.ChatID, &receipt.SenderID, &receipt.UserID, &receipt.DeliveredAt, &receipt.ReadAt)
+		if err != nil {
+			return nil, err
+		}
+		receipts = append(receipts, receipt)
+	}
+	if err = rows.Err(); err != nil {
+		return nil, err
+	}
+	return receipts, nil
+}
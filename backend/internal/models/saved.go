@@ -0,0 +1,182 @@
+package models
+
+import (
+	"database/sql"
+	"errors"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/lib/pq"
+)
+
+// Reasons a saved message is shown as a tombstone
+const (
+	// SavedMessageDeleted is a message that was deleted, or hidden by the user
+	SavedMessageDeleted = "deleted"
+	// SavedMessageNotMember is a message of a chat the user left
+	SavedMessageNotMember = "not_member"
+)
+
+// SavedMessage is a message a user bookmarked, with their note and tags.
+// Bookmarks are only as readable as their message: Message is nil and
+// Tombstone set when the user can no longer read it.
+type SavedMessage struct {
+	ID        uuid.UUID  `json:"id"`
+	MessageID *uuid.UUID `json:"message_id,omitempty"`
+	ChatID    *uuid.UUID `json:"chat_id,omitempty"`
+	Note      string     `json:"note"`
+	Tags      []string   `json:"tags"`
+	SavedAt   time.Time  `json:"saved_at"`
+	Message   *Message   `json:"message,omitempty"`
+	Tombstone string     `json:"tombstone,omitempty"`
+}
+
+// SaveMessage bookmarks a message for userID, or replaces the note and tags
+// of its bookmark. The generated fields and Message are filled in on
+// success. ErrNoRecord is returned if the user can't read the message.
+func (m *MessageModel) SaveMessage(userID, messageID uuid.UUID, note string, tags []string) (*SavedMessage, error) {
+	if tags == nil {
+		tags = []string{}
+	}
+	saved := &SavedMessage{
+		MessageID: &messageID,
+		Note:      note,
+		Tags:      tags,
+	}
+
+	stmt := `INSERT INTO saved_messages (user_id, message_id, chat_id, note, tags)
+	SELECT $1, m.id, m.chat_id, $3, $4
+	FROM messages m
+	WHERE m.id = $2
+	AND m.deleted_at IS NULL
+	AND EXISTS (SELECT 1 FROM chat_users cu WHERE cu.chat_id = m.chat_id AND cu.user_id = $1)
+	AND NOT EXISTS (SELECT 1 FROM hidden_messages h WHERE h.user_id = $1 AND h.message_id = m.id)
+	ON CONFLICT ON CONSTRAINT saved_messages_uc_user_message
+	DO UPDATE SET note = EXCLUDED.note, tags = EXCLUDED.tags
+	RETURNING id, chat_id, saved_at`
+
+	err := m.DB.QueryRow(stmt, userID, messageID, note, pq.Array(tags)).Scan(&saved.ID, &saved.ChatID, &saved.SavedAt)
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return nil, ErrNoRecord
+		}
+		return nil, err
+	}
+
+	messages, err := m.getMessagesByIDs([]uuid.UUID{messageID}, userID)
+	if err != nil {
+		return nil, err
+	}
+	saved.Message = messages[messageID]
+	return saved, nil
+}
+
+// UnsaveMessage removes a bookmark of userID. It reports whether there was
+// one.
+func (m *MessageModel) UnsaveMessage(userID, savedID uuid.UUID) (bool, error) {
+	stmt := `DELETE FROM saved_messages WHERE id = $1 AND user_id = $2`
+
+	result, err := m.DB.Exec(stmt, savedID, userID)
+	if err != nil {
+		return false, err
+	}
+	affected, err := result.RowsAffected()
+	return affected > 0, err
+}
+
+// GetSavedMessages retrieves the bookmarks of userID, most recently saved
+// first, optionally only those with tag. Pagination uses a (saved_at, id)
+// keyset cursor; pass nil cursors for the first page.
+func (m *MessageModel) GetSavedMessages(userID uuid.UUID, tag *string, cursorSavedAt *time.Time, cursorID *uuid.UUID, limit int) ([]*SavedMessage, error) {
+	stmt := `SELECT s.id, s.message_id, s.chat_id, s.note, s.tags, s.saved_at,
+		CASE
+			WHEN m.id IS NULL OR m.deleted_at IS NOT NULL
+				OR EXISTS (SELECT 1 FROM hidden_messages h WHERE h.user_id = s.user_id AND h.message_id = m.id)
+			THEN '` + SavedMessageDeleted + `'
+			WHEN NOT EXISTS (SELECT 1 FROM chat_users cu WHERE cu.chat_id = m.chat_id AND cu.user_id = s.user_id)
+			THEN '` + SavedMessageNotMember + `'
+			ELSE ''
+		END
+	FROM saved_messages s
+	LEFT JOIN messages m ON m.id = s.message_id
+	WHERE s.user_id = $1
+	AND ($2::text IS NULL OR $2 = ANY(s.tags))
+	AND ($3::timestamp IS NULL OR (s.saved_at, s.id) < ($3, $4::uuid))
+	ORDER BY s.saved_at DESC, s.id DESC
+	LIMIT $5`
+
+	rows, err := m.DB.Query(stmt, userID, tag, cursorSavedAt, cursorID, limit)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var savedMessages []*SavedMessage
+	var messageIDs []uuid.UUID
+
+	for rows.Next() {
+		saved := &SavedMessage{}
+		var tags pq.StringArray
+		err = rows.Scan(&saved.ID, &saved.MessageID, &saved.ChatID, &saved.Note, &tags, &saved.SavedAt, &saved.Tombstone)
+		if err != nil {
+			return nil, err
+		}
+		saved.Tags = tags
+		savedMessages = append(savedMessages, saved)
+		if saved.Tombstone == "" {
+			messageIDs = append(messageIDs, *saved.MessageID)
+		}
+	}
+	if err = rows.Err(); err != nil {
+		return nil, err
+	}
+
+	messages, err := m.getMessagesByIDs(messageIDs, userID)
+	if err != nil {
+		return nil, err
+	}
+	for _, saved := range savedMessages {
+		if saved.Tombstone == "" {
+			saved.Message = messages[*saved.MessageID]
+		}
+	}
+
+	return savedMessages, nil
+}
+
+// getMessagesByIDs retrieves messages as seen by userID, with their details
+func (m *MessageModel) getMessagesByIDs(messageIDs []uuid.UUID, userID uuid.UUID) (map[uuid.UUID]*Message, error) {
+	idToMessage := make(map[uuid.UUID]*Message, len(messageIDs))
+	if len(messageIDs) == 0 {
+		return idToMessage, nil
+	}
+
+	stmt := `SELECT ` + messageViewColumns + `
+	FROM messages m
+	INNER JOIN users u ON u.id = m.sender_id
+	WHERE m.id = ANY($1)`
+
+	rows, err := m.DB.Query(stmt, pq.Array(messageIDs))
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var messages []*Message
+	for rows.Next() {
+		message := &Message{}
+		if err = rows.Scan(messageViewDest(message)...); err != nil {
+			return nil, err
+		}
+		messages = append(messages, message)
+		idToMessage[message.ID] = message
+	}
+	if err = rows.Err(); err != nil {
+		return nil, err
+	}
+
+	if err = m.attachMessageDetails(messages, messageIDs, userID); err != nil {
+		return nil, err
+	}
+	return idToMessage, nil
+}
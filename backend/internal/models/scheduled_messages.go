@@ -0,0 +1,328 @@
+package models
+
+import (
+	"database/sql"
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/lib/pq"
+)
+
+// Recurrence rules of scheduled messages. Occurrences are computed in UTC.
+const (
+	RecurrenceDaily    = "daily"
+	RecurrenceWeekdays = "weekdays"
+	RecurrenceWeekly   = "weekly"
+)
+
+const (
+	// MaxScheduledMessageAttempts is how often a scheduled message is tried
+	// before it is marked failed
+	MaxScheduledMessageAttempts = 5
+	// scheduledMessageRetryDelay is how long the first retry of a failed
+	// scheduled message waits. Each further retry waits twice as long.
+	scheduledMessageRetryDelay = time.Minute
+)
+
+// ScheduledMessage is a message that is sent to a chat at SendAt. Recurring
+// messages move SendAt to their next occurrence each time they are sent.
+// Attempts counts the failed dispatches since, the next of which is made at
+// RetryAt. FailedAt is set once the message is no longer retried.
+type ScheduledMessage struct {
+	ID              uuid.UUID  `json:"id"`
+	SenderID        uuid.UUID  `json:"sender_id"`
+	ChatID          uuid.UUID  `json:"chat_id"`
+	Content         string     `json:"content"`
+	ContentType     string     `json:"content_type"`
+	ParentMessageID *uuid.UUID `json:"parent_message_id,omitempty"`
+	Recurrence      *string    `json:"recurrence,omitempty"`
+	// ClientMessageID is the sender's own id for the message, used to
+	// recognise retried requests. One-off messages keep it once sent.
+	ClientMessageID *string    `json:"client_message_id,omitempty"`
+	SendAt          time.Time  `json:"send_at"`
+	Attempts        int        `json:"attempts"`
+	LastError       *string    `json:"last_error,omitempty"`
+	RetryAt         *time.Time `json:"retry_at,omitempty"`
+	FailedAt        *time.Time `json:"failed_at,omitempty"`
+	CreatedAt       time.Time  `json:"created_at"`
+	UpdatedAt       time.Time  `json:"updated_at"`
+}
+
+const scheduledMessageColumns = `id, sender_id, chat_id, content, content_type, parent_message_id,
+	recurrence, client_message_id, send_at, attempts, last_error, retry_at, failed_at, created_at, updated_at`
+
+func scheduledMessageDest(scheduled *ScheduledMessage) []any {
+	return []any{
+		&scheduled.ID, &scheduled.SenderID, &scheduled.ChatID, &scheduled.Content, &scheduled.ContentType,
+		&scheduled.ParentMessageID, &scheduled.Recurrence, &scheduled.ClientMessageID, &scheduled.SendAt, &scheduled.Attempts, &scheduled.LastError,
+		&scheduled.RetryAt, &scheduled.FailedAt, &scheduled.CreatedAt, &scheduled.UpdatedAt,
+	}
+}
+
+// ValidRecurrence reports whether recurrence is a supported recurrence rule
+func ValidRecurrence(recurrence string) bool {
+	switch recurrence {
+	case RecurrenceDaily, RecurrenceWeekdays, RecurrenceWeekly:
+		return true
+	}
+	return false
+}
+
+// nextOccurrence returns the first occurrence of a recurrence rule that
+// starts at start and lies after after
+func nextOccurrence(recurrence string, start, after time.Time) time.Time {
+	next := start.UTC()
+	for !next.After(after) {
+		switch recurrence {
+		case RecurrenceWeekly:
+			next = next.AddDate(0, 0, 7)
+		case RecurrenceWeekdays:
+			next = next.AddDate(0, 0, 1)
+			for next.Weekday() == time.Saturday || next.Weekday() == time.Sunday {
+				next = next.AddDate(0, 0, 1)
+			}
+		default:
+			next = next.AddDate(0, 0, 1)
+		}
+	}
+	return next
+}
+
+// InsertScheduledMessage saves a new scheduled message. The generated fields
+// are filled in on success. If the sender already scheduled a message with
+// the same ClientMessageID, ErrDuplicateMessage is returned.
+func (m *MessageModel) InsertScheduledMessage(scheduled *ScheduledMessage) error {
+	stmt := `INSERT INTO scheduled_messages (sender_id, chat_id, content, content_type, parent_message_id, recurrence,
+		client_message_id, send_at)
+	VALUES ($1, $2, $3, $4, $5, $6, $7, $8)
+	RETURNING ` + scheduledMessageColumns
+
+	err := m.DB.QueryRow(stmt, scheduled.SenderID, scheduled.ChatID, scheduled.Content, scheduled.ContentType,
+		scheduled.ParentMessageID, scheduled.Recurrence, scheduled.ClientMessageID, scheduled.SendAt.UTC(),
+	).Scan(scheduledMessageDest(scheduled)...)
+	if err != nil {
+		var pqErr *pq.Error
+		if errors.As(err, &pqErr) {
+			if pqErr.Code == "23505" && pqErr.Constraint == "scheduled_messages_uc_sender_client_message_id" {
+				return ErrDuplicateMessage
+			}
+		}
+		return err
+	}
+	return nil
+}
+
+// GetScheduledMessageByClientID retrieves the scheduled message a sender
+// tagged with clientMessageID
+func (m *MessageModel) GetScheduledMessageByClientID(senderID uuid.UUID, clientMessageID string) (*ScheduledMessage, error) {
+	scheduled := &ScheduledMessage{}
+
+	stmt := `SELECT ` + scheduledMessageColumns + ` FROM scheduled_messages WHERE sender_id = $1 AND client_message_id = $2`
+
+	err := m.DB.QueryRow(stmt, senderID, clientMessageID).Scan(scheduledMessageDest(scheduled)...)
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return nil, ErrNoRecord
+		}
+		return nil, err
+	}
+
+	return scheduled, nil
+}
+
+// GetScheduledMessage retrieves a scheduled message of senderID. Scheduled
+// messages of other users are reported as ErrNoRecord.
+func (m *MessageModel) GetScheduledMessage(id, senderID uuid.UUID) (*ScheduledMessage, error) {
+	scheduled := &ScheduledMessage{}
+
+	stmt := `SELECT ` + scheduledMessageColumns + ` FROM scheduled_messages WHERE id = $1 AND sender_id = $2`
+
+	err := m.DB.QueryRow(stmt, id, senderID).Scan(scheduledMessageDest(scheduled)...)
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return nil, ErrNoRecord
+		}
+		return nil, err
+	}
+
+	return scheduled, nil
+}
+
+// GetScheduledMessagesBySender retrieves the scheduled messages of a user,
+// optionally limited to one chat, in the order they are due
+func (m *MessageModel) GetScheduledMessagesBySender(senderID uuid.UUID, chatID *uuid.UUID) ([]*ScheduledMessage, error) {
+	stmt := `SELECT ` + scheduledMessageColumns + ` FROM scheduled_messages
+	WHERE sender_id = $1 AND ($2::uuid IS NULL OR chat_id = $2)
+	ORDER BY send_at, id`
+
+	rows, err := m.DB.Query(stmt, senderID, chatID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var scheduledMessages []*ScheduledMessage
+
+	for rows.Next() {
+		scheduled := &ScheduledMessage{}
+		if err := rows.Scan(scheduledMessageDest(scheduled)...); err != nil {
+			return nil, err
+		}
+		scheduledMessages = append(scheduledMessages, scheduled)
+	}
+
+	if err = rows.Err(); err != nil {
+		return nil, err
+	}
+
+	return scheduledMessages, nil
+}
+
+// UpdateScheduledMessage changes the given fields of a scheduled message of
+// senderID; nil fields are left as they are. An empty recurrence makes the
+// message a one-off. A new sendAt reschedules a failed message.
+func (m *MessageModel) UpdateScheduledMessage(id, senderID uuid.UUID, content *string, sendAt *time.Time, recurrence *string) (*ScheduledMessage, error) {
+	if sendAt != nil {
+		utc := sendAt.UTC()
+		sendAt = &utc
+	}
+
+	stmt := `UPDATE scheduled_messages SET
+		content = COALESCE($3, content),
+		send_at = COALESCE($4, send_at),
+		recurrence = CASE WHEN $5::text IS NULL THEN recurrence ELSE NULLIF($5, '') END,
+		attempts = CASE WHEN $4::timestamp IS NULL THEN attempts ELSE 0 END,
+		last_error = CASE WHEN $4::timestamp IS NULL THEN last_error END,
+		retry_at = CASE WHEN $4::timestamp IS NULL THEN retry_at END,
+		failed_at = CASE WHEN $4::timestamp IS NULL THEN failed_at END,
+		updated_at = CURRENT_TIMESTAMP
+	WHERE id = $1 AND sender_id = $2
+	RETURNING ` + scheduledMessageColumns
+
+	scheduled := &ScheduledMessage{}
+	err := m.DB.QueryRow(stmt, id, senderID, content, sendAt, recurrence).Scan(scheduledMessageDest(scheduled)...)
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return nil, ErrNoRecord
+		}
+		return nil, err
+	}
+
+	return scheduled, nil
+}
+
+// DeleteScheduledMessage cancels a scheduled message of senderID
+func (m *MessageModel) DeleteScheduledMessage(id, senderID uuid.UUID) error {
+	stmt := `DELETE FROM scheduled_messages WHERE id = $1 AND sender_id = $2`
+
+	result, err := m.DB.Exec(stmt, id, senderID)
+	if err != nil {
+		return err
+	}
+	affected, err := result.RowsAffected()
+	if err != nil {
+		return err
+	}
+	if affected == 0 {
+		return ErrNoRecord
+	}
+	return nil
+}
+
+// DispatchScheduledMessage sends one scheduled message that is due at now.
+// prepare turns the scheduled message into the message to insert; returning
+// a nil message drops the scheduled message without sending it. One-off
+// messages are removed once sent, recurring ones move to their next
+// occurrence. The message is inserted in the same transaction that claims
+// the scheduled message, so it is sent exactly once even if several
+// dispatchers run at the same time. The returned bool is false if nothing
+// was due.
+//
+// If the message can't be sent, the failure is recorded and the message is
+// retried later with exponential backoff, so that it doesn't hold up the
+// messages due after it. After MaxScheduledMessageAttempts it is marked
+// failed. The error is returned along with true in that case.
+func (m *MessageModel) DispatchScheduledMessage(now time.Time, prepare func(*ScheduledMessage) (*Message, error)) (*Message, bool, error) {
+	tx, err := m.DB.Begin()
+	if err != nil {
+		return nil, false, err
+	}
+	defer tx.Rollback()
+
+	scheduled := &ScheduledMessage{}
+	stmt := `SELECT ` + scheduledMessageColumns + ` FROM scheduled_messages
+	WHERE send_at <= $1 AND failed_at IS NULL AND (retry_at IS NULL OR retry_at <= $1)
+	ORDER BY send_at
+	LIMIT 1
+	FOR UPDATE SKIP LOCKED`
+	err = tx.QueryRow(stmt, now.UTC()).Scan(scheduledMessageDest(scheduled)...)
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return nil, false, nil
+		}
+		return nil, false, err
+	}
+
+	// a failed insert aborts the transaction, the savepoint keeps the claim
+	// so that the failure can be recorded
+	if _, err = tx.Exec(`SAVEPOINT dispatch`); err != nil {
+		return nil, false, err
+	}
+	message, err := prepare(scheduled)
+	if err == nil && message != nil {
+		err = insertMessage(tx, message)
+	}
+	if err != nil {
+		if recordErr := recordScheduledMessageFailure(tx, scheduled, now, err); recordErr != nil {
+			return nil, false, recordErr
+		}
+		if recordErr := tx.Commit(); recordErr != nil {
+			return nil, false, recordErr
+		}
+		return nil, true, fmt.Errorf("scheduled message %s: %w", scheduled.ID, err)
+	}
+
+	if message != nil && scheduled.Recurrence != nil {
+		stmt = `UPDATE scheduled_messages SET send_at = $2, attempts = 0, last_error = NULL, retry_at = NULL
+		WHERE id = $1`
+		_, err = tx.Exec(stmt, scheduled.ID, nextOccurrence(*scheduled.Recurrence, scheduled.SendAt, now.UTC()))
+	} else {
+		stmt = `DELETE FROM scheduled_messages WHERE id = $1`
+		_, err = tx.Exec(stmt, scheduled.ID)
+	}
+	if err != nil {
+		return nil, false, err
+	}
+
+	if err = tx.Commit(); err != nil {
+		return nil, false, err
+	}
+
+	return message, true, nil
+}
+
+// recordScheduledMessageFailure counts a failed dispatch of a scheduled
+// message claimed in tx, and either moves it to its next retry or marks it
+// failed
+func recordScheduledMessageFailure(tx *sql.Tx, scheduled *ScheduledMessage, now time.Time, cause error) error {
+	if _, err := tx.Exec(`ROLLBACK TO SAVEPOINT dispatch`); err != nil {
+		return err
+	}
+
+	attempts := scheduled.Attempts + 1
+	var retryAt, failedAt *time.Time
+	if attempts >= MaxScheduledMessageAttempts {
+		utc := now.UTC()
+		failedAt = &utc
+	} else {
+		retry := now.UTC().Add(scheduledMessageRetryDelay << (attempts - 1))
+		retryAt = &retry
+	}
+
+	stmt := `UPDATE scheduled_messages SET attempts = $2, last_error = $3, retry_at = $4, failed_at = $5
+	WHERE id = $1`
+	_, err := tx.Exec(stmt, scheduled.ID, attempts, cause.Error(), retryAt, failedAt)
+	return err
+}
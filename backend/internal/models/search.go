@@ -0,0 +1,143 @@
+package models
+
+import (
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/google/uuid"
+)
+
+const (
+	// SnippetStartSel and SnippetStopSel surround the matches in the snippet
+	// of a search result. They are private use characters so that they can't
+	// be confused with the content of a message.
+	SnippetStartSel = "\ue000"
+	SnippetStopSel  = "\ue001"
+
+	// snippetLength is how many characters of a message are used as the
+	// snippet of a search result without search terms
+	snippetLength = 200
+)
+
+// MessageSearch describes which messages a search returns. Terms use the
+// web search syntax of Postgres, e.g. quoted phrases, "or" and "-". Without
+// Terms every message matching the filters is returned.
+type MessageSearch struct {
+	Terms    string
+	SenderID *uuid.UUID
+	ChatID   *uuid.UUID
+	Before   *time.Time
+	After    *time.Time
+	HasLink  bool
+}
+
+// SearchResult is a message found by a search with its rank and a snippet of
+// its content. The matches in the snippet are surrounded by SnippetStartSel
+// and SnippetStopSel.
+type SearchResult struct {
+	Message *Message
+	Rank    float32
+	Snippet string
+}
+
+// SearchCursor is the rank, creation time and id of the last result of the
+// previous page
+type SearchCursor struct {
+	Rank      float32
+	CreatedAt time.Time
+	MessageID uuid.UUID
+}
+
+// SearchMessages finds the messages of the chats userID is a member of that
+// match search, best match first and newest first among equal matches.
+// Messages deleted for everyone or hidden by the user are left out.
+func (m *MessageModel) SearchMessages(userID uuid.UUID, search *MessageSearch, cursor *SearchCursor, limit int) ([]*SearchResult, error) {
+	var args []any
+	arg := func(value any) string {
+		args = append(args, value)
+		return fmt.Sprintf("$%d", len(args))
+	}
+
+	user := arg(userID)
+	conditions := []string{
+		`m.deleted_at IS NULL`,
+		`EXISTS (SELECT 1 FROM chat_users cu WHERE cu.chat_id = m.chat_id AND cu.user_id = ` + user + `)`,
+		`NOT EXISTS (SELECT 1 FROM hidden_messages h WHERE h.user_id = ` + user + ` AND h.message_id = m.id)`,
+	}
+	rank := `0::real`
+	snippet := `left(m.content, ` + arg(snippetLength) + `)`
+	if search.Terms != "" {
+		query := `websearch_to_tsquery('english', ` + arg(search.Terms) + `)`
+		conditions = append(conditions, `m.content_tsv @@ `+query)
+		rank = `ts_rank(m.content_tsv, ` + query + `)`
+		options := fmt.Sprintf(`StartSel="%s", StopSel="%s", MaxFragments=2, MaxWords=20, MinWords=5`,
+			SnippetStartSel, SnippetStopSel)
+		snippet = `ts_headline('english', m.content, ` + query + `, ` + arg(options) + `)`
+	}
+	if search.SenderID != nil {
+		conditions = append(conditions, `m.sender_id = `+arg(*search.SenderID))
+	}
+	if search.ChatID != nil {
+		conditions = append(conditions, `m.chat_id = `+arg(*search.ChatID))
+	}
+	if search.Before != nil {
+		conditions = append(conditions, `m.created_at < `+arg(*search.Before))
+	}
+	if search.After != nil {
+		conditions = append(conditions, `m.created_at >= `+arg(*search.After))
+	}
+	if search.HasLink {
+		conditions = append(conditions, `m.content ~* 'https?://'`)
+	}
+
+	pageCondition := ``
+	if cursor != nil {
+		pageCondition = `WHERE (matches.rank, matches.created_at, matches.id) < (` +
+			arg(cursor.Rank) + `::real, ` + arg(cursor.CreatedAt) + `, ` + arg(cursor.MessageID) + `)`
+	}
+
+	stmt := `WITH matches AS (
+		SELECT m.id, m.created_at, ` + rank + ` AS rank
+		FROM messages m
+		WHERE ` + strings.Join(conditions, `
+		AND `) + `
+	)
+	SELECT ` + messageViewColumns + `, matches.rank, ` + snippet + `
+	FROM matches
+	INNER JOIN messages m ON m.id = matches.id
+	INNER JOIN users u ON u.id = m.sender_id
+	` + pageCondition + `
+	ORDER BY matches.rank DESC, matches.created_at DESC, matches.id DESC
+	LIMIT ` + arg(limit)
+
+	rows, err := m.DB.Query(stmt, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var results []*SearchResult
+	var messages []*Message
+	var messageIDs []uuid.UUID
+
+	for rows.Next() {
+		result := &SearchResult{Message: &Message{}}
+		dest := append(messageViewDest(result.Message), &result.Rank, &result.Snippet)
+		if err = rows.Scan(dest...); err != nil {
+			return nil, err
+		}
+		results = append(results, result)
+		messages = append(messages, result.Message)
+		messageIDs = append(messageIDs, result.Message.ID)
+	}
+	if err = rows.Err(); err != nil {
+		return nil, err
+	}
+
+	if err = m.attachMessageDetails(messages, messageIDs, userID); err != nil {
+		return nil, err
+	}
+
+	return results, nil
+}
@@ -64,7 +64,7 @@ func (m *UserModel) Authenticate(email, password string) (*UserInfo, error) {
 		Email: email,
 	}
 	var hashedPassword []byte
-	stmt := "SELECT id, name, hashed_password FROM users WHERE email = $1"
+	stmt := "SELECT id, name, hashed_password FROM users WHERE email = $1 AND kind = 'human'"
 	err := m.DB.QueryRow(stmt, email).Scan(&userInfo.ID, &userInfo.Name, &hashedPassword)
 	if err != nil {
 		if errors.Is(err, sql.ErrNoRows) {
@@ -0,0 +1,27 @@
+// Package netguard keeps outgoing requests to user supplied URLs, like link
+// previews and bot callbacks, away from the server's own network.
+package netguard
+
+import (
+	"errors"
+	"net"
+	"syscall"
+)
+
+var ErrPrivateAddress = errors.New("netguard: refusing to connect to a private address")
+
+// Control is a net.Dialer Control function that refuses connections to
+// loopback, private, link-local and multicast addresses. It is checked on the
+// resolved address so that DNS can't be used to reach internal services.
+func Control(network, address string, c syscall.RawConn) error {
+	host, _, err := net.SplitHostPort(address)
+	if err != nil {
+		return err
+	}
+	ip := net.ParseIP(host)
+	if ip == nil || ip.IsLoopback() || ip.IsPrivate() || ip.IsUnspecified() ||
+		ip.IsLinkLocalUnicast() || ip.IsLinkLocalMulticast() || ip.IsMulticast() {
+		return ErrPrivateAddress
+	}
+	return nil
+}
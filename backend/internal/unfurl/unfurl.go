@@ -0,0 +1,205 @@
+// Package unfurl fetches the title and OpenGraph metadata of web pages so
+// that links in messages can be shown with a preview.
+package unfurl
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"html"
+	"io"
+	"mime"
+	"net"
+	"net/http"
+	"net/url"
+	"regexp"
+	"strings"
+	"time"
+	"unicode/utf8"
+
+	"chatty.mtran.io/internal/models"
+	"chatty.mtran.io/internal/netguard"
+)
+
+const (
+	// DefaultTimeout bounds a whole fetch, including redirects
+	DefaultTimeout = 5 * time.Second
+	// DefaultMaxBodyBytes is how much of a page is read. OpenGraph tags live in
+	// the head, so the rest of a page is never needed.
+	DefaultMaxBodyBytes  = 512 << 10
+	maxRedirects         = 3
+	maxTitleLength       = 300
+	maxDescriptionLength = 1000
+	userAgent            = "ChattyBot/1.0 (link preview)"
+)
+
+var (
+	ErrUnsupportedURL = errors.New("unfurl: unsupported url")
+	ErrNotHTML        = errors.New("unfurl: response is not an html page")
+	ErrNoMetadata     = errors.New("unfurl: page has no preview metadata")
+	ErrPrivateAddress = netguard.ErrPrivateAddress
+)
+
+var (
+	titleRX     = regexp.MustCompile(`(?is)<title[^>]*>(.*?)</title>`)
+	metaRX      = regexp.MustCompile(`(?is)<meta\s[^>]*>`)
+	attributeRX = regexp.MustCompile(`(?s)([a-zA-Z:_-]+)\s*=\s*(?:"([^"]*)"|'([^']*)'|([^\s"'>]+))`)
+)
+
+// Options configure an HTTPUnfurler. Zero values select the defaults.
+type Options struct {
+	Timeout      time.Duration
+	MaxBodyBytes int64
+	// AllowPrivateNetworks permits fetching from loopback and private
+	// addresses. It must only be set in tests.
+	AllowPrivateNetworks bool
+}
+
+// HTTPUnfurler unfurls links by fetching them over HTTP
+type HTTPUnfurler struct {
+	client       *http.Client
+	maxBodyBytes int64
+}
+
+func NewHTTPUnfurler(options Options) *HTTPUnfurler {
+	if options.Timeout <= 0 {
+		options.Timeout = DefaultTimeout
+	}
+	if options.MaxBodyBytes <= 0 {
+		options.MaxBodyBytes = DefaultMaxBodyBytes
+	}
+
+	dialer := &net.Dialer{Timeout: options.Timeout}
+	if !options.AllowPrivateNetworks {
+		dialer.Control = netguard.Control
+	}
+
+	transport := &http.Transport{
+		DialContext:           dialer.DialContext,
+		TLSHandshakeTimeout:   options.Timeout,
+		ResponseHeaderTimeout: options.Timeout,
+		MaxIdleConns:          10,
+		IdleConnTimeout:       30 * time.Second,
+	}
+
+	return &HTTPUnfurler{
+		client: &http.Client{
+			Transport: transport,
+			Timeout:   options.Timeout,
+			CheckRedirect: func(req *http.Request, via []*http.Request) error {
+				if len(via) >= maxRedirects {
+					return fmt.Errorf("unfurl: stopped after %d redirects", maxRedirects)
+				}
+				if req.URL.Scheme != "http" && req.URL.Scheme != "https" {
+					return ErrUnsupportedURL
+				}
+				return nil
+			},
+		},
+		maxBodyBytes: options.MaxBodyBytes,
+	}
+}
+
+// Unfurl fetches a page and extracts its preview. The returned preview's URL
+// is rawURL, even if the page was reached through redirects.
+func (u *HTTPUnfurler) Unfurl(ctx context.Context, rawURL string) (*models.LinkPreview, error) {
+	pageURL, err := url.Parse(rawURL)
+	if err != nil || (pageURL.Scheme != "http" && pageURL.Scheme != "https") || pageURL.Host == "" {
+		return nil, ErrUnsupportedURL
+	}
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, pageURL.String(), nil)
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("User-Agent", userAgent)
+	req.Header.Set("Accept", "text/html,application/xhtml+xml")
+
+	resp, err := u.client.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("unfurl: unexpected status %d", resp.StatusCode)
+	}
+	mediaType, _, err := mime.ParseMediaType(resp.Header.Get("Content-Type"))
+	if err != nil || (mediaType != "text/html" && mediaType != "application/xhtml+xml") {
+		return nil, ErrNotHTML
+	}
+
+	body, err := io.ReadAll(io.LimitReader(resp.Body, u.maxBodyBytes))
+	if err != nil {
+		return nil, err
+	}
+
+	preview := parsePreview(string(body), resp.Request.URL)
+	if preview.Title == "" && preview.Description == "" {
+		return nil, ErrNoMetadata
+	}
+	preview.URL = rawURL
+	return preview, nil
+}
+
+// parsePreview extracts the preview of a page. OpenGraph tags win over
+// Twitter card tags, which win over the plain title and description.
+func parsePreview(page string, pageURL *url.URL) *models.LinkPreview {
+	meta := make(map[string]string)
+	for _, tag := range metaRX.FindAllString(page, -1) {
+		attributes := make(map[string]string)
+		for _, attribute := range attributeRX.FindAllStringSubmatch(tag, -1) {
+			attributes[strings.ToLower(attribute[1])] = attribute[2] + attribute[3] + attribute[4]
+		}
+		key := attributes["property"]
+		if key == "" {
+			key = attributes["name"]
+		}
+		key = strings.ToLower(key)
+		if _, ok := meta[key]; key != "" && !ok {
+			meta[key] = cleanText(attributes["content"])
+		}
+	}
+
+	first := func(keys ...string) string {
+		for _, key := range keys {
+			if meta[key] != "" {
+				return meta[key]
+			}
+		}
+		return ""
+	}
+
+	preview := &models.LinkPreview{
+		Title:       first("og:title", "twitter:title"),
+		Description: truncate(first("og:description", "twitter:description", "description"), maxDescriptionLength),
+		SiteName:    first("og:site_name"),
+	}
+	if preview.Title == "" {
+		if match := titleRX.FindStringSubmatch(page); match != nil {
+			preview.Title = cleanText(match[1])
+		}
+	}
+	preview.Title = truncate(preview.Title, maxTitleLength)
+
+	if image := first("og:image", "og:image:url", "twitter:image"); image != "" {
+		if imageURL, err := pageURL.Parse(image); err == nil && (imageURL.Scheme == "http" || imageURL.Scheme == "https") {
+			preview.ImageURL = imageURL.String()
+		}
+	}
+	return preview
+}
+
+// cleanText decodes entities and collapses whitespace
+func cleanText(text string) string {
+	return strings.Join(strings.Fields(html.UnescapeString(text)), " ")
+}
+
+// truncate shortens text to at most n characters
+func truncate(text string, n int) string {
+	if utf8.RuneCountInString(text) <= n {
+		return text
+	}
+	runes := []rune(text)
+	return strings.TrimSpace(string(runes[:n-1])) + "…"
+}
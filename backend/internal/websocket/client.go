@@ -83,6 +83,7 @@ func (c *Client) readPump() {
 		c.Hub.Broadcast <- HubMessage{
 			Message:  p,
 			SenderID: c.UserID,
+			Client:   c,
 		}
 	}
 }
@@ -3,29 +3,41 @@ package websocket
 import (
 	"encoding/json"
 	"log"
+	"sync"
 
 	messageprocessor "chatty.mtran.io/internal/message_processor"
 	"github.com/google/uuid"
 )
 
+// HubMessage is a request received from a user. Client is the session it
+// came from, or nil if it didn't come over WebSocket.
 type HubMessage struct {
 	Message  []byte
 	SenderID uuid.UUID
+	Client   *Client
 }
 
-// Hub manages all WebSocket connections
+// Hub manages all WebSocket connections. A user can be connected from several
+// sessions at once, e.g. a laptop and a phone.
 type Hub struct {
-	UserClients      map[uuid.UUID]*Client // user ID -> client (one client per user)
+	UserClients      map[uuid.UUID]map[*Client]bool // user ID -> the user's clients
 	Register         chan *Client
 	Unregister       chan *Client
 	Broadcast        chan HubMessage
 	MessageProcessor *messageprocessor.MessageProcessor
+
+	// requestClient is the session whose request is being processed
+	requestClient *Client
+
+	// mu guards UserClients and requestClient. Messages can be sent from
+	// outside the Run loop, e.g. by timers in the message processor.
+	mu sync.RWMutex
 }
 
 // NewHub creates a new WebSocket hub
 func NewHub(messageProcessor *messageprocessor.MessageProcessor) *Hub {
 	return &Hub{
-		UserClients:      make(map[uuid.UUID]*Client),
+		UserClients:      make(map[uuid.UUID]map[*Client]bool),
 		Register:         make(chan *Client),
 		Unregister:       make(chan *Client),
 		Broadcast:        make(chan HubMessage),
@@ -38,19 +50,31 @@ func (h *Hub) Run() {
 	for {
 		select {
 		case client := <-h.Register:
-			h.UserClients[client.UserID] = client
+			h.mu.Lock()
+			if h.UserClients[client.UserID] == nil {
+				h.UserClients[client.UserID] = make(map[*Client]bool)
+			}
+			h.UserClients[client.UserID][client] = true
+			h.mu.Unlock()
 			log.Printf("Client registered: User %s", client.UserID)
 
 		case client := <-h.Unregister:
-			if _, ok := h.UserClients[client.UserID]; ok {
-				delete(h.UserClients, client.UserID)
+			h.mu.Lock()
+			if clients := h.UserClients[client.UserID]; clients[client] {
+				delete(clients, client)
+				if len(clients) == 0 {
+					delete(h.UserClients, client.UserID)
+				}
 				close(client.Send)
 			}
+			h.mu.Unlock()
 			log.Printf("Client unregistered: User %s", client.UserID)
 
 		case message := <-h.Broadcast:
 			log.Printf("h.Broadcast channel received message")
+			h.setRequestClient(message.Client)
 			h.MessageProcessor.ProcessMessage(message.SenderID, message.Message)
+			h.setRequestClient(nil)
 		}
 	}
 }
@@ -65,20 +89,94 @@ func (h *Hub) serializeResponse(response *messageprocessor.Response) []byte {
 	return data
 }
 
-// GetClientByUserID returns a client by user ID
-func (h *Hub) GetClientByUserID(userID uuid.UUID) (*Client, bool) {
-	client, exists := h.UserClients[userID]
-	return client, exists
+func (h *Hub) setRequestClient(client *Client) {
+	h.mu.Lock()
+	h.requestClient = client
+	h.mu.Unlock()
+}
+
+// GetClientsByUserID returns the clients of a user
+func (h *Hub) GetClientsByUserID(userID uuid.UUID) []*Client {
+	h.mu.RLock()
+	defer h.mu.RUnlock()
+	clients := make([]*Client, 0, len(h.UserClients[userID]))
+	for client := range h.UserClients[userID] {
+		clients = append(clients, client)
+	}
+	return clients
+}
+
+// DisconnectUser closes every session of a user, e.g. when the credentials
+// they were opened with stop being valid
+func (h *Hub) DisconnectUser(userID uuid.UUID) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	for client := range h.UserClients[userID] {
+		close(client.Send)
+	}
+	delete(h.UserClients, userID)
+	log.Printf("Clients disconnected: User %s", userID)
+}
+
+// RequestSession returns the client whose request is being processed, or nil
+// outside of a request
+func (h *Hub) RequestSession() any {
+	h.mu.RLock()
+	defer h.mu.RUnlock()
+	if h.requestClient == nil {
+		return nil
+	}
+	return h.requestClient
 }
 
-// SendToUser sends a message to a specific user
+// SendToUser sends a message to every session of a user. A reply to a
+// request only goes to the session that made the request, and is dropped if
+// that session is gone. It is safe to call from any goroutine.
 func (h *Hub) SendToUser(userID uuid.UUID, response *messageprocessor.Response) {
-	if client, exists := h.UserClients[userID]; exists {
-		serialized := h.serializeResponse(response)
-		select {
-		case client.Send <- serialized:
-		default:
-			log.Printf("Failed to send message to user %s: channel full", userID)
+	h.mu.RLock()
+	defer h.mu.RUnlock()
+	clients := h.UserClients[userID]
+	if len(clients) == 0 {
+		return
+	}
+	serialized := h.serializeResponse(response)
+	requestClient, _ := response.Session.(*Client)
+	if !response.Unsolicited && requestClient != nil && requestClient.UserID == userID {
+		if clients[requestClient] {
+			h.send(requestClient, serialized)
+		}
+		return
+	}
+	for client := range clients {
+		h.send(client, serialized)
+	}
+}
+
+// SendToOtherSessions sends a message to every session of a user except the
+// one that made the request in response.Session. Outside of a request, or
+// when the request didn't come from one of the user's sessions, replies
+// already reach every session of the user and nothing is sent.
+func (h *Hub) SendToOtherSessions(userID uuid.UUID, response *messageprocessor.Response) {
+	h.mu.RLock()
+	defer h.mu.RUnlock()
+	clients := h.UserClients[userID]
+	requestClient, _ := response.Session.(*Client)
+	if !clients[requestClient] || len(clients) < 2 {
+		return
+	}
+	serialized := h.serializeResponse(response)
+	for client := range clients {
+		if client != requestClient {
+			h.send(client, serialized)
 		}
 	}
 }
+
+// send queues a message for a client, dropping it if the client can't keep up
+func (h *Hub) send(client *Client, serialized []byte) {
+	select {
+	case client.Send <- serialized:
+	default:
+		log.Printf("Failed to send message to user %s: channel full", client.UserID)
+	}
+}
@@ -0,0 +1,16 @@
+ALTER TABLE messages ADD COLUMN edited_at TIMESTAMP;
+
+CREATE TABLE
+    message_edits (
+        id UUID PRIMARY KEY DEFAULT gen_random_uuid (),
+        message_id UUID NOT NULL,
+        editor_id UUID NOT NULL,
+        previous_content TEXT NOT NULL,
+        content TEXT NOT NULL,
+        edited_at TIMESTAMP NOT NULL DEFAULT CURRENT_TIMESTAMP,
+        FOREIGN KEY (message_id) REFERENCES messages (id) ON DELETE CASCADE,
+        FOREIGN KEY (editor_id) REFERENCES users (id) ON DELETE CASCADE
+    );
+
+-- Edit history is always read per message in chronological order
+CREATE INDEX idx_message_edits_message_edited ON message_edits (message_id, edited_at);
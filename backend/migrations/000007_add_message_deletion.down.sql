@@ -0,0 +1,5 @@
+DROP INDEX IF EXISTS idx_hidden_messages_message_id;
+
+DROP TABLE IF EXISTS hidden_messages;
+
+ALTER TABLE messages DROP COLUMN IF EXISTS deleted_at;
@@ -0,0 +1,15 @@
+-- Messages deleted for everyone are kept as tombstones
+ALTER TABLE messages ADD COLUMN deleted_at TIMESTAMP;
+
+-- Messages a user has deleted for themselves only
+CREATE TABLE
+    hidden_messages (
+        user_id UUID NOT NULL,
+        message_id UUID NOT NULL,
+        hidden_at TIMESTAMP NOT NULL DEFAULT CURRENT_TIMESTAMP,
+        PRIMARY KEY (user_id, message_id),
+        FOREIGN KEY (user_id) REFERENCES users (id) ON DELETE CASCADE,
+        FOREIGN KEY (message_id) REFERENCES messages (id) ON DELETE CASCADE
+    );
+
+CREATE INDEX idx_hidden_messages_message_id ON hidden_messages (message_id);
@@ -0,0 +1,10 @@
+DROP TRIGGER IF EXISTS trigger_update_thread_reply_stats ON messages;
+
+DROP FUNCTION IF EXISTS update_thread_reply_stats();
+
+DROP INDEX IF EXISTS idx_messages_parent_created;
+
+ALTER TABLE messages
+    DROP COLUMN IF EXISTS last_reply_at,
+    DROP COLUMN IF EXISTS reply_count,
+    DROP COLUMN IF EXISTS parent_message_id;
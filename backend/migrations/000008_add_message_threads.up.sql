@@ -0,0 +1,28 @@
+-- Replies point at the root message of their thread. Roots keep denormalized
+-- thread statistics so that history pages don't have to aggregate replies.
+ALTER TABLE messages
+    ADD COLUMN parent_message_id UUID REFERENCES messages (id) ON DELETE CASCADE,
+    ADD COLUMN reply_count INTEGER NOT NULL DEFAULT 0,
+    ADD COLUMN last_reply_at TIMESTAMP;
+
+CREATE INDEX idx_messages_parent_created ON messages (parent_message_id, created_at, id)
+WHERE parent_message_id IS NOT NULL;
+
+-- Create trigger function to update the thread statistics of the root message
+CREATE OR REPLACE FUNCTION update_thread_reply_stats()
+RETURNS TRIGGER AS $$
+BEGIN
+    UPDATE messages
+    SET reply_count = reply_count + 1,
+        last_reply_at = GREATEST(COALESCE(last_reply_at, NEW.created_at), NEW.created_at)
+    WHERE id = NEW.parent_message_id;
+    RETURN NEW;
+END;
+$$ LANGUAGE plpgsql;
+
+-- Create trigger that fires after each reply insert
+CREATE TRIGGER trigger_update_thread_reply_stats
+    AFTER INSERT ON messages
+    FOR EACH ROW
+    WHEN (NEW.parent_message_id IS NOT NULL)
+    EXECUTE FUNCTION update_thread_reply_stats();
@@ -0,0 +1,3 @@
+DROP INDEX IF EXISTS idx_message_reactions_user_id;
+
+DROP TABLE IF EXISTS message_reactions;
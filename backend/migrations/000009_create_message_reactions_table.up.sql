@@ -0,0 +1,12 @@
+CREATE TABLE
+    message_reactions (
+        message_id UUID NOT NULL,
+        user_id UUID NOT NULL,
+        emoji VARCHAR(64) NOT NULL,
+        created_at TIMESTAMP NOT NULL DEFAULT CURRENT_TIMESTAMP,
+        PRIMARY KEY (message_id, user_id, emoji),
+        FOREIGN KEY (message_id) REFERENCES messages (id) ON DELETE CASCADE,
+        FOREIGN KEY (user_id) REFERENCES users (id) ON DELETE CASCADE
+    );
+
+CREATE INDEX idx_message_reactions_user_id ON message_reactions (user_id);
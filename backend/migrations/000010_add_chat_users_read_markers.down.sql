@@ -0,0 +1,3 @@
+ALTER TABLE chat_users
+    DROP COLUMN IF EXISTS last_read_at,
+    DROP COLUMN IF EXISTS last_read_message_id;
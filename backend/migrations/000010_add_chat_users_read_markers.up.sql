@@ -0,0 +1,4 @@
+-- Read position of each member. last_read_at is when the member caught up.
+ALTER TABLE chat_users
+    ADD COLUMN last_read_message_id UUID REFERENCES messages (id) ON DELETE SET NULL,
+    ADD COLUMN last_read_at TIMESTAMP;
@@ -0,0 +1,3 @@
+ALTER TABLE messages DROP CONSTRAINT IF EXISTS messages_uc_sender_client_message_id;
+
+ALTER TABLE messages DROP COLUMN IF EXISTS client_message_id;
@@ -0,0 +1,5 @@
+-- Clients may tag a message with their own id so that retried sends are
+-- recognised instead of stored twice
+ALTER TABLE messages ADD COLUMN client_message_id VARCHAR(64);
+
+ALTER TABLE messages ADD CONSTRAINT messages_uc_sender_client_message_id UNIQUE (sender_id, client_message_id);
@@ -0,0 +1,3 @@
+DROP INDEX IF EXISTS idx_chat_pins_chat_pinned;
+
+DROP TABLE IF EXISTS chat_pins;
@@ -0,0 +1,13 @@
+CREATE TABLE
+    chat_pins (
+        chat_id UUID NOT NULL,
+        message_id UUID NOT NULL,
+        pinned_by UUID NOT NULL,
+        pinned_at TIMESTAMP NOT NULL DEFAULT CURRENT_TIMESTAMP,
+        PRIMARY KEY (chat_id, message_id),
+        FOREIGN KEY (chat_id) REFERENCES chats (id) ON DELETE CASCADE,
+        FOREIGN KEY (message_id) REFERENCES messages (id) ON DELETE CASCADE,
+        FOREIGN KEY (pinned_by) REFERENCES users (id) ON DELETE CASCADE
+    );
+
+CREATE INDEX idx_chat_pins_chat_pinned ON chat_pins (chat_id, pinned_at);
@@ -0,0 +1,3 @@
+DROP INDEX IF EXISTS idx_message_mentions_user;
+
+DROP TABLE IF EXISTS message_mentions;
@@ -0,0 +1,10 @@
+CREATE TABLE
+    message_mentions (
+        message_id UUID NOT NULL,
+        user_id UUID NOT NULL,
+        PRIMARY KEY (message_id, user_id),
+        FOREIGN KEY (message_id) REFERENCES messages (id) ON DELETE CASCADE,
+        FOREIGN KEY (user_id) REFERENCES users (id) ON DELETE CASCADE
+    );
+
+CREATE INDEX idx_message_mentions_user ON message_mentions (user_id);
@@ -0,0 +1,5 @@
+ALTER TABLE messages
+DROP CONSTRAINT IF EXISTS messages_ck_content_type;
+
+ALTER TABLE messages
+DROP COLUMN IF EXISTS content_type;
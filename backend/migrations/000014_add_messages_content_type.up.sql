@@ -0,0 +1,11 @@
+ALTER TABLE messages
+ADD COLUMN content_type VARCHAR(64) NOT NULL DEFAULT 'text/plain';
+
+ALTER TABLE messages
+ADD CONSTRAINT messages_ck_content_type CHECK (
+    content_type IN (
+        'text/plain',
+        'text/markdown',
+        'application/vnd.chatty.code+json'
+    )
+);
@@ -0,0 +1,3 @@
+DROP TABLE IF EXISTS message_link_previews;
+
+DROP TABLE IF EXISTS link_previews;
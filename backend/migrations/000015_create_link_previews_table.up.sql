@@ -0,0 +1,19 @@
+CREATE TABLE
+    link_previews (
+        url TEXT PRIMARY KEY,
+        title TEXT NOT NULL DEFAULT '',
+        description TEXT NOT NULL DEFAULT '',
+        image_url TEXT NOT NULL DEFAULT '',
+        site_name TEXT NOT NULL DEFAULT '',
+        fetched_at TIMESTAMP NOT NULL DEFAULT CURRENT_TIMESTAMP
+    );
+
+CREATE TABLE
+    message_link_previews (
+        message_id UUID NOT NULL,
+        url TEXT NOT NULL,
+        sort_order INT NOT NULL,
+        PRIMARY KEY (message_id, url),
+        FOREIGN KEY (message_id) REFERENCES messages (id) ON DELETE CASCADE,
+        FOREIGN KEY (url) REFERENCES link_previews (url) ON DELETE CASCADE
+    );
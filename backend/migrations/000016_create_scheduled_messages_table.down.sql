@@ -0,0 +1,5 @@
+DROP INDEX IF EXISTS idx_scheduled_messages_sender;
+
+DROP INDEX IF EXISTS idx_scheduled_messages_send_at;
+
+DROP TABLE IF EXISTS scheduled_messages;
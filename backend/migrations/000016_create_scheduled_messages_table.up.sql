@@ -0,0 +1,23 @@
+CREATE TABLE
+    scheduled_messages (
+        id UUID PRIMARY KEY DEFAULT gen_random_uuid (),
+        sender_id UUID NOT NULL,
+        chat_id UUID NOT NULL,
+        content TEXT NOT NULL,
+        content_type VARCHAR(64) NOT NULL DEFAULT 'text/plain',
+        parent_message_id UUID,
+        recurrence VARCHAR(16),
+        send_at TIMESTAMP NOT NULL,
+        created_at TIMESTAMP NOT NULL DEFAULT CURRENT_TIMESTAMP,
+        updated_at TIMESTAMP NOT NULL DEFAULT CURRENT_TIMESTAMP,
+        FOREIGN KEY (sender_id) REFERENCES users (id) ON DELETE CASCADE,
+        FOREIGN KEY (chat_id) REFERENCES chats (id) ON DELETE CASCADE,
+        FOREIGN KEY (parent_message_id) REFERENCES messages (id) ON DELETE CASCADE,
+        CONSTRAINT scheduled_messages_ck_recurrence CHECK (
+            recurrence IN ('daily', 'weekdays', 'weekly')
+        )
+    );
+
+CREATE INDEX idx_scheduled_messages_send_at ON scheduled_messages (send_at);
+
+CREATE INDEX idx_scheduled_messages_sender ON scheduled_messages (sender_id, send_at);
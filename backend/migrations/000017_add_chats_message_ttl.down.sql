@@ -0,0 +1,3 @@
+ALTER TABLE chats
+DROP CONSTRAINT IF EXISTS chats_ck_message_ttl,
+DROP COLUMN IF EXISTS message_ttl;
@@ -0,0 +1,5 @@
+-- Messages of a chat with a message_ttl (in seconds) are deleted once they
+-- are older than that
+ALTER TABLE chats
+ADD COLUMN message_ttl INTEGER,
+ADD CONSTRAINT chats_ck_message_ttl CHECK (message_ttl > 0);
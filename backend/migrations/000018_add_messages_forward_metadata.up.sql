@@ -0,0 +1,8 @@
+-- Forwarded messages are copies that keep attribution to the message they
+-- were forwarded from. Forwarding a forwarded message keeps the original
+-- attribution.
+ALTER TABLE messages
+ADD COLUMN forwarded_from_message_id UUID REFERENCES messages (id) ON DELETE SET NULL,
+ADD COLUMN forwarded_from_sender_id UUID REFERENCES users (id) ON DELETE SET NULL,
+ADD COLUMN forwarded_from_chat_id UUID REFERENCES chats (id) ON DELETE SET NULL,
+ADD COLUMN forwarded_from_created_at TIMESTAMP;
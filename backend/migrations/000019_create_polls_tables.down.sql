@@ -0,0 +1,17 @@
+DROP TABLE IF EXISTS poll_votes;
+DROP TABLE IF EXISTS poll_options;
+DROP TABLE IF EXISTS polls;
+
+DELETE FROM messages WHERE content_type = 'application/vnd.chatty.poll';
+
+ALTER TABLE messages
+DROP CONSTRAINT IF EXISTS messages_ck_content_type;
+
+ALTER TABLE messages
+ADD CONSTRAINT messages_ck_content_type CHECK (
+    content_type IN (
+        'text/plain',
+        'text/markdown',
+        'application/vnd.chatty.code+json'
+    )
+);
@@ -0,0 +1,44 @@
+ALTER TABLE messages
+DROP CONSTRAINT messages_ck_content_type;
+
+ALTER TABLE messages
+ADD CONSTRAINT messages_ck_content_type CHECK (
+    content_type IN (
+        'text/plain',
+        'text/markdown',
+        'application/vnd.chatty.code+json',
+        'application/vnd.chatty.poll'
+    )
+);
+
+-- A poll is attached to the message that shows it in the chat; the message
+-- content is the poll question.
+CREATE TABLE polls (
+    message_id UUID PRIMARY KEY,
+    multiple_choice BOOLEAN NOT NULL DEFAULT FALSE,
+    closes_at TIMESTAMP,
+    FOREIGN KEY (message_id) REFERENCES messages (id) ON DELETE CASCADE
+);
+
+CREATE TABLE poll_options (
+    id UUID PRIMARY KEY DEFAULT gen_random_uuid(),
+    message_id UUID NOT NULL,
+    text VARCHAR(100) NOT NULL,
+    sort_order INTEGER NOT NULL,
+    FOREIGN KEY (message_id) REFERENCES polls (message_id) ON DELETE CASCADE
+);
+
+CREATE INDEX idx_poll_options_message_id ON poll_options (message_id, sort_order);
+
+CREATE TABLE poll_votes (
+    option_id UUID NOT NULL,
+    message_id UUID NOT NULL,
+    user_id UUID NOT NULL,
+    voted_at TIMESTAMP NOT NULL DEFAULT CURRENT_TIMESTAMP,
+    PRIMARY KEY (option_id, user_id),
+    FOREIGN KEY (option_id) REFERENCES poll_options (id) ON DELETE CASCADE,
+    FOREIGN KEY (message_id) REFERENCES polls (message_id) ON DELETE CASCADE,
+    FOREIGN KEY (user_id) REFERENCES users (id) ON DELETE CASCADE
+);
+
+CREATE INDEX idx_poll_votes_message_id_user_id ON poll_votes (message_id, user_id);
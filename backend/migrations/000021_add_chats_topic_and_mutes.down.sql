@@ -0,0 +1,6 @@
+ALTER TABLE chat_users
+DROP COLUMN IF EXISTS muted_until,
+DROP COLUMN IF EXISTS muted;
+
+ALTER TABLE chats
+DROP COLUMN IF EXISTS topic;
@@ -0,0 +1,8 @@
+ALTER TABLE chats
+ADD COLUMN topic VARCHAR(255) NOT NULL DEFAULT '';
+
+-- A muted member isn't notified about mentions. A mute without muted_until
+-- lasts until the member unmutes the chat.
+ALTER TABLE chat_users
+ADD COLUMN muted BOOLEAN NOT NULL DEFAULT FALSE,
+ADD COLUMN muted_until TIMESTAMP;
@@ -0,0 +1,13 @@
+DROP TABLE IF EXISTS bot_tokens;
+DROP TABLE IF EXISTS bots;
+
+DELETE FROM users WHERE kind = 'bot';
+
+ALTER TABLE users
+ALTER COLUMN hashed_password SET NOT NULL;
+
+ALTER TABLE users
+DROP CONSTRAINT IF EXISTS users_ck_kind;
+
+ALTER TABLE users
+DROP COLUMN IF EXISTS kind;
@@ -0,0 +1,33 @@
+ALTER TABLE users
+ADD COLUMN kind VARCHAR(16) NOT NULL DEFAULT 'human';
+
+ALTER TABLE users
+ADD CONSTRAINT users_ck_kind CHECK (kind IN ('human', 'bot'));
+
+-- bots authenticate with bot tokens instead of passwords
+ALTER TABLE users
+ALTER COLUMN hashed_password DROP NOT NULL;
+
+CREATE TABLE bots (
+    user_id UUID PRIMARY KEY,
+    owner_id UUID NOT NULL,
+    callback_url TEXT,
+    callback_secret CHAR(64),
+    created_at TIMESTAMP NOT NULL DEFAULT CURRENT_TIMESTAMP,
+    FOREIGN KEY (user_id) REFERENCES users (id) ON DELETE CASCADE,
+    FOREIGN KEY (owner_id) REFERENCES users (id) ON DELETE CASCADE
+);
+
+CREATE INDEX idx_bots_owner_id ON bots (owner_id);
+
+-- Only the SHA-256 hash of a token is stored
+CREATE TABLE bot_tokens (
+    id UUID PRIMARY KEY DEFAULT gen_random_uuid(),
+    bot_id UUID NOT NULL,
+    token_hash CHAR(64) NOT NULL,
+    created_at TIMESTAMP NOT NULL DEFAULT CURRENT_TIMESTAMP,
+    last_used_at TIMESTAMP,
+    FOREIGN KEY (bot_id) REFERENCES bots (user_id) ON DELETE CASCADE
+);
+
+ALTER TABLE bot_tokens ADD CONSTRAINT bot_tokens_uc_token_hash UNIQUE (token_hash);
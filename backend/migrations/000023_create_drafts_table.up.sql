@@ -0,0 +1,15 @@
+-- One draft per user and chat, and per thread of the chat. Drafts of the chat
+-- itself have no parent_message_id.
+CREATE TABLE drafts (
+    user_id UUID NOT NULL,
+    chat_id UUID NOT NULL,
+    parent_message_id UUID,
+    content TEXT NOT NULL,
+    updated_at TIMESTAMP NOT NULL DEFAULT CURRENT_TIMESTAMP,
+    FOREIGN KEY (user_id) REFERENCES users (id) ON DELETE CASCADE,
+    FOREIGN KEY (chat_id) REFERENCES chats (id) ON DELETE CASCADE,
+    FOREIGN KEY (parent_message_id) REFERENCES messages (id) ON DELETE CASCADE
+);
+
+ALTER TABLE drafts
+ADD CONSTRAINT drafts_uc_user_chat_parent UNIQUE NULLS NOT DISTINCT (user_id, chat_id, parent_message_id);
@@ -0,0 +1,20 @@
+-- Bookmarks outlive their messages and chats so that they can be shown as
+-- tombstones
+CREATE TABLE saved_messages (
+    id UUID PRIMARY KEY DEFAULT gen_random_uuid(),
+    user_id UUID NOT NULL,
+    message_id UUID,
+    chat_id UUID,
+    note VARCHAR(1000) NOT NULL DEFAULT '',
+    tags TEXT[] NOT NULL DEFAULT '{}',
+    saved_at TIMESTAMP NOT NULL DEFAULT CURRENT_TIMESTAMP,
+    FOREIGN KEY (user_id) REFERENCES users (id) ON DELETE CASCADE,
+    FOREIGN KEY (message_id) REFERENCES messages (id) ON DELETE SET NULL,
+    FOREIGN KEY (chat_id) REFERENCES chats (id) ON DELETE SET NULL
+);
+
+ALTER TABLE saved_messages
+ADD CONSTRAINT saved_messages_uc_user_message UNIQUE (user_id, message_id);
+
+CREATE INDEX idx_saved_messages_user_saved ON saved_messages (user_id, saved_at DESC, id DESC);
+CREATE INDEX idx_saved_messages_tags ON saved_messages USING GIN (tags);
@@ -0,0 +1,13 @@
+-- Delivery state of a message per recipient. A recipient without a receipt
+-- hasn't confirmed the message yet; reading a message implies its delivery.
+CREATE TABLE message_receipts (
+    message_id UUID NOT NULL,
+    user_id UUID NOT NULL,
+    delivered_at TIMESTAMP NOT NULL DEFAULT CURRENT_TIMESTAMP,
+    read_at TIMESTAMP,
+    PRIMARY KEY (message_id, user_id),
+    FOREIGN KEY (message_id) REFERENCES messages (id) ON DELETE CASCADE,
+    FOREIGN KEY (user_id) REFERENCES users (id) ON DELETE CASCADE
+);
+
+CREATE INDEX idx_message_receipts_user_id ON message_receipts (user_id);
@@ -0,0 +1,11 @@
+DROP TRIGGER IF EXISTS trigger_delete_message_events ON messages;
+
+DROP FUNCTION IF EXISTS delete_message_events();
+
+DROP TABLE IF EXISTS user_event_messages;
+
+DROP TABLE IF EXISTS user_events;
+
+ALTER TABLE users
+DROP COLUMN IF EXISTS event_seq,
+DROP COLUMN IF EXISTS event_pruned_seq;
@@ -0,0 +1,53 @@
+-- Every event sent to a user gets the next number of the user's event
+-- sequence, so that clients can ask for what they missed while they were
+-- disconnected. event_pruned_seq is the last number removed from the log.
+ALTER TABLE users
+ADD COLUMN event_seq BIGINT NOT NULL DEFAULT 0,
+ADD COLUMN event_pruned_seq BIGINT NOT NULL DEFAULT 0;
+
+CREATE TABLE user_events (
+    user_id UUID NOT NULL,
+    seq BIGINT NOT NULL,
+    type VARCHAR(64) NOT NULL,
+    data JSONB NOT NULL,
+    created_at TIMESTAMP NOT NULL DEFAULT CURRENT_TIMESTAMP,
+    PRIMARY KEY (user_id, seq),
+    FOREIGN KEY (user_id) REFERENCES users (id) ON DELETE CASCADE
+);
+
+CREATE INDEX idx_user_events_created_at ON user_events (created_at);
+
+-- The messages an event embeds. The event is removed with any of them, so
+-- that the log doesn't keep the content of expired messages. Edits and
+-- deletes for everyone remove the events carrying the old content when they
+-- are logged themselves. There is no
+-- foreign key to messages since the link has to outlive the message until
+-- the trigger below has run.
+CREATE TABLE user_event_messages (
+    message_id UUID NOT NULL,
+    user_id UUID NOT NULL,
+    seq BIGINT NOT NULL,
+    PRIMARY KEY (message_id, user_id, seq),
+    FOREIGN KEY (user_id, seq) REFERENCES user_events (user_id, seq) ON DELETE CASCADE
+);
+
+CREATE INDEX idx_user_event_messages_event ON user_event_messages (user_id, seq);
+
+-- Create trigger function to remove the events that embed deleted messages
+CREATE OR REPLACE FUNCTION delete_message_events()
+RETURNS TRIGGER AS $$
+BEGIN
+    DELETE FROM user_events e
+    USING user_event_messages l, deleted_messages d
+    WHERE l.message_id = d.id AND e.user_id = l.user_id AND e.seq = l.seq;
+    RETURN NULL;
+END;
+$$ LANGUAGE plpgsql;
+
+-- Create trigger that fires once per statement deleting messages, so that the
+-- reaper's batches are handled in one go
+CREATE TRIGGER trigger_delete_message_events
+    AFTER DELETE ON messages
+    REFERENCING OLD TABLE AS deleted_messages
+    FOR EACH STATEMENT
+    EXECUTE FUNCTION delete_message_events();
@@ -0,0 +1,10 @@
+DROP INDEX IF EXISTS idx_messages_parent_seq;
+
+ALTER TABLE messages
+DROP CONSTRAINT IF EXISTS messages_uc_chat_seq;
+
+ALTER TABLE messages
+DROP COLUMN IF EXISTS seq;
+
+ALTER TABLE chats
+DROP COLUMN IF EXISTS message_seq;
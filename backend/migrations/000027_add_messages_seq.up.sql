@@ -0,0 +1,28 @@
+-- Messages are numbered within their chat, thread replies included, in the
+-- order they were inserted. chats.message_seq is the last number assigned.
+ALTER TABLE chats
+ADD COLUMN message_seq BIGINT NOT NULL DEFAULT 0;
+
+ALTER TABLE messages
+ADD COLUMN seq BIGINT;
+
+UPDATE messages m
+SET seq = numbered.seq
+FROM (
+    SELECT id, ROW_NUMBER() OVER (PARTITION BY chat_id ORDER BY created_at, id) AS seq
+    FROM messages
+) numbered
+WHERE m.id = numbered.id;
+
+UPDATE chats c
+SET message_seq = (SELECT COALESCE(MAX(m.seq), 0) FROM messages m WHERE m.chat_id = c.id);
+
+ALTER TABLE messages
+ALTER COLUMN seq SET NOT NULL;
+
+ALTER TABLE messages
+ADD CONSTRAINT messages_uc_chat_seq UNIQUE (chat_id, seq);
+
+-- Thread replies are paged by seq
+CREATE INDEX idx_messages_parent_seq ON messages (parent_message_id, seq)
+WHERE parent_message_id IS NOT NULL;
@@ -0,0 +1,9 @@
+DROP INDEX IF EXISTS idx_scheduled_messages_send_at;
+
+CREATE INDEX idx_scheduled_messages_send_at ON scheduled_messages (send_at);
+
+ALTER TABLE scheduled_messages
+DROP COLUMN IF EXISTS failed_at,
+DROP COLUMN IF EXISTS retry_at,
+DROP COLUMN IF EXISTS last_error,
+DROP COLUMN IF EXISTS attempts;
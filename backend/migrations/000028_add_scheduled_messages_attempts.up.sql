@@ -0,0 +1,13 @@
+-- Failed dispatches are retried at retry_at instead of blocking the messages
+-- due after them. send_at stays the same so that recurring messages keep
+-- their time of day. A message that keeps failing is marked failed and no
+-- longer dispatched until its sender reschedules it.
+ALTER TABLE scheduled_messages
+ADD COLUMN attempts INT NOT NULL DEFAULT 0,
+ADD COLUMN last_error TEXT,
+ADD COLUMN retry_at TIMESTAMP,
+ADD COLUMN failed_at TIMESTAMP;
+
+DROP INDEX idx_scheduled_messages_send_at;
+
+CREATE INDEX idx_scheduled_messages_send_at ON scheduled_messages (send_at) WHERE failed_at IS NULL;
@@ -0,0 +1,3 @@
+ALTER TABLE scheduled_messages DROP CONSTRAINT IF EXISTS scheduled_messages_uc_sender_client_message_id;
+
+ALTER TABLE scheduled_messages DROP COLUMN IF EXISTS client_message_id;
@@ -0,0 +1,5 @@
+-- Like messages, scheduled messages may carry the sender's own id so that a
+-- retried request doesn't schedule them twice
+ALTER TABLE scheduled_messages ADD COLUMN client_message_id VARCHAR(64);
+
+ALTER TABLE scheduled_messages ADD CONSTRAINT scheduled_messages_uc_sender_client_message_id UNIQUE (sender_id, client_message_id);
@@ -0,0 +1,22 @@
+DROP TRIGGER IF EXISTS trigger_update_thread_reply_stats_on_delete ON messages;
+
+DROP TRIGGER IF EXISTS trigger_update_thread_reply_stats_on_delete_for_everyone ON messages;
+
+DROP TRIGGER IF EXISTS trigger_update_thread_reply_stats ON messages;
+
+CREATE OR REPLACE FUNCTION update_thread_reply_stats()
+RETURNS TRIGGER AS $$
+BEGIN
+    UPDATE messages
+    SET reply_count = reply_count + 1,
+        last_reply_at = GREATEST(COALESCE(last_reply_at, NEW.created_at), NEW.created_at)
+    WHERE id = NEW.parent_message_id;
+    RETURN NEW;
+END;
+$$ LANGUAGE plpgsql;
+
+CREATE TRIGGER trigger_update_thread_reply_stats
+    AFTER INSERT ON messages
+    FOR EACH ROW
+    WHEN (NEW.parent_message_id IS NOT NULL)
+    EXECUTE FUNCTION update_thread_reply_stats();
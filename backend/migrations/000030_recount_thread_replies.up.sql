@@ -0,0 +1,59 @@
+-- Thread statistics count the replies that aren't deleted. They are
+-- recomputed whenever a reply is inserted, deleted for everyone or removed,
+-- e.g. when it expires.
+DROP TRIGGER trigger_update_thread_reply_stats ON messages;
+
+CREATE OR REPLACE FUNCTION update_thread_reply_stats()
+RETURNS TRIGGER AS $$
+DECLARE
+    root_id UUID;
+BEGIN
+    IF TG_OP = 'DELETE' THEN
+        root_id := OLD.parent_message_id;
+    ELSE
+        root_id := NEW.parent_message_id;
+    END IF;
+
+    UPDATE messages m
+    SET reply_count = stats.reply_count,
+        last_reply_at = stats.last_reply_at
+    FROM (
+        SELECT COUNT(*) AS reply_count, MAX(created_at) AS last_reply_at
+        FROM messages
+        WHERE parent_message_id = root_id AND deleted_at IS NULL
+    ) stats
+    WHERE m.id = root_id;
+    RETURN NULL;
+END;
+$$ LANGUAGE plpgsql;
+
+CREATE TRIGGER trigger_update_thread_reply_stats
+    AFTER INSERT ON messages
+    FOR EACH ROW
+    WHEN (NEW.parent_message_id IS NOT NULL)
+    EXECUTE FUNCTION update_thread_reply_stats();
+
+CREATE TRIGGER trigger_update_thread_reply_stats_on_delete_for_everyone
+    AFTER UPDATE OF deleted_at ON messages
+    FOR EACH ROW
+    WHEN (NEW.parent_message_id IS NOT NULL AND OLD.deleted_at IS DISTINCT FROM NEW.deleted_at)
+    EXECUTE FUNCTION update_thread_reply_stats();
+
+CREATE TRIGGER trigger_update_thread_reply_stats_on_delete
+    AFTER DELETE ON messages
+    FOR EACH ROW
+    WHEN (OLD.parent_message_id IS NOT NULL)
+    EXECUTE FUNCTION update_thread_reply_stats();
+
+-- Fix the statistics of existing threads, which could only have been too high
+UPDATE messages m
+SET reply_count = stats.reply_count,
+    last_reply_at = stats.last_reply_at
+FROM (
+    SELECT root.id, COUNT(r.id) AS reply_count, MAX(r.created_at) AS last_reply_at
+    FROM messages root
+    LEFT JOIN messages r ON r.parent_message_id = root.id AND r.deleted_at IS NULL
+    WHERE root.reply_count > 0
+    GROUP BY root.id
+) stats
+WHERE m.id = stats.id;